@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sebrandon1/grab/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cleanOlderThan time.Duration
+	cleanDryRun    bool
+)
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean [dir]...",
+	Short: "Remove partial files left by interrupted downloads",
+	Long: `Remove the partial files and state files left by interrupted downloads
+below the given directories, or the current directory, which were not modified
+for the given duration, and report the space which was reclaimed.
+
+Files ending in .grab-part, .grab and .grab-stage are
+considered. Downloaded files and their metadata are never removed. As
+downloads in progress in other processes are only recognized by writing to
+their files, files modified within the last hour, or belonging to a file
+which was, are always kept.`,
+	Example: `  # Remove partial downloads older than a day from the current directory
+  grab clean
+
+  # List partial downloads older than a week without removing them
+  grab clean --older-than 168h --dry-run /data/downloads`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			args = []string{"."}
+		}
+		client := lib.NewClient()
+		code := exitOK
+		var files int
+		var reclaimed int64
+		for _, dir := range args {
+			var partials []lib.PartialFile
+			var err error
+			if cleanDryRun {
+				partials, err = client.FindPartials(dir, cleanOlderThan)
+			} else {
+				partials, err = client.CleanPartials(dir, cleanOlderThan)
+			}
+			for _, p := range partials {
+				fmt.Printf("%s (%d bytes, modified %s)\n", p.Path, p.Size, p.ModTime.Format(time.DateTime))
+				files++
+				reclaimed += p.Size
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Clean failed: %v\n", err)
+				code = exitCode(err)
+			}
+		}
+		verb := "Removed"
+		if cleanDryRun {
+			verb = "Would remove"
+		}
+		fmt.Printf("%s %d files, %d bytes\n", verb, files, reclaimed)
+		os.Exit(code)
+	},
+}
+
+func init() {
+	cleanCmd.Flags().DurationVar(&cleanOlderThan, "older-than", 24*time.Hour, "Only remove files which were not modified for this long (at least 1h)")
+	cleanCmd.Flags().BoolVar(&cleanDryRun, "dry-run", false, "List the files which would be removed without removing them")
+	rootCmd.AddCommand(cleanCmd)
+}
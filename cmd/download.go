@@ -1,8 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -10,7 +16,53 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var verbose bool
+var (
+	verbose        bool
+	skipDownloaded bool
+	headerTimeout  time.Duration
+	stallTimeout   time.Duration
+	userAgent      string
+	archivePath    string
+	resolve        map[string]string
+	noQuarantine   bool
+	http1          bool
+	http2          bool
+	http2Prior     bool
+	outputs        []string
+	createDirs     bool
+	noCreateDirs   bool
+	bufferSize     string
+	noHEAD         bool
+	torrentFiles   bool
+	failFast       bool
+	byteRange      string
+	profileName    string
+	configPath     string
+	linkMirrors    bool
+	sidecarName    string
+	mirrorList     string
+	country        string
+	rankMirrors    bool
+	proxyFlag      string
+	followPages    bool
+	noProxy        []string
+	ipfsGateways   []string
+	retries        int
+	warmStandby    string
+	addExtension   bool
+	resumeState    bool
+	resumeIfMatch  bool
+	unmodified     string
+	rangeStart     int64
+	rangeLength    int64
+	unmodifiedTime time.Time
+	keyringFile    string
+	keyring        lib.OpenPGPKeyring
+	batchDelay     time.Duration
+	hostDelay      time.Duration
+	logFile        string
+	eventLog       *downloadLog
+)
 
 var downloadCmd = &cobra.Command{
 	Use:   "download [url]...",
@@ -18,6 +70,8 @@ var downloadCmd = &cobra.Command{
 	Long: `Download files from one or more URLs to the current directory.
 
 The download command fetches files from HTTP/HTTPS URLs and saves them locally.
+ipfs://CID URLs are fetched through public IPFS gateways, and the payloads of
+data: URLs are written to the output given with -o.
 File names are automatically determined from the Content-Disposition header
 or extracted from the URL path. Files are saved to the current working directory.
 
@@ -35,71 +89,595 @@ Use the --verbose flag to see download progress with a real-time progress bar.`,
   # Download from a GitHub release
   grab download https://github.com/golang/go/archive/refs/tags/go1.21.5.tar.gz
 
+  # Save to a nested path, creating missing directories
+  grab download --create-dirs -o downloads/go/go1.21.5.src.tar.gz https://go.dev/dl/go1.21.5.src.tar.gz
+
+  # Fetch only the first megabyte of a file
+  grab download --range 0-1048575 -o header.bin https://example.com/archive.zip
+
+  # Download from a private repository using a profile of the config file
+  grab download --profile artifactory https://artifactory.example.com/libs/app.jar
+
+  # Bundle several files into a single zip archive
+  grab download --archive bundle.zip https://example.com/a.bin https://example.com/b.bin
+
   # Multiple files with progress tracking
   grab download -v https://go.dev/dl/go1.21.5.src.tar.gz https://go.dev/dl/go1.20.12.src.tar.gz`,
 	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		client := lib.NewClient()
-		failed := 0
-		for _, url := range args {
-			req, err := lib.NewRequest(".", url)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Invalid URL: %s (%v)\n", url, err)
-				failed++
-				continue
+		if len(outputs) > len(args) {
+			fmt.Fprintf(os.Stderr, "Too many outputs: %d given for %d URLs\n", len(outputs), len(args))
+			os.Exit(1)
+		}
+		if byteRange != "" {
+			var err error
+			if rangeStart, rangeLength, err = parseRange(byteRange); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid range: %s (%v)\n", byteRange, err)
+				os.Exit(1)
 			}
-			resp := client.Do(req)
-			if verbose {
-				t := time.NewTicker(100 * time.Millisecond)
-				defer t.Stop()
-				progressDone := make(chan struct{})
-				go func() {
-					for {
-						select {
-						case <-progressDone:
-							return
-						case <-t.C:
-							size := resp.Size()
-							completed := resp.BytesComplete()
-							if size > 0 {
-								percent := float64(completed) / float64(size) * 100
-								barLen := 40
-								filledLen := int(float64(barLen) * float64(completed) / float64(size))
-								bar := "[" + strings.Repeat("=", filledLen) + strings.Repeat(" ", barLen-filledLen) + "]"
-								fmt.Printf("\rDownloading: %s %6.2f%% (%d/%d bytes)", bar, percent, completed, size)
-							} else {
-								fmt.Printf("\rDownloading: %d bytes complete", completed)
-							}
-						}
-					}
-				}()
-				<-resp.Done
-				close(progressDone)
-				fmt.Println() // Newline after progress bar
-			} else {
-				<-resp.Done
-			}
-			if verbose {
-				if err := resp.Err(); err != nil {
-					_, _ = fmt.Fprintf(os.Stderr, "Failed: %s (%v)\n", resp.Filename, err)
-				} else {
-					info := ""
-					if fi, err := os.Stat(resp.Filename); err == nil {
-						size := fi.Size()
-						info += fmt.Sprintf("size: %d bytes", size)
-					}
-					_, _ = fmt.Fprintf(os.Stdout, "Downloaded: %s (%s)\n", resp.Filename, info)
+			// a part of a file must not be saved under the name of the file,
+			// where it would overwrite a complete download
+			for i := range args {
+				if i >= len(outputs) || isDirOutput(outputs[i]) {
+					fmt.Fprintf(os.Stderr, "--range requires a file name given by -o for each URL\n")
+					os.Exit(1)
 				}
 			}
-			if resp.Err() != nil {
-				failed++
+		}
+		if unmodified != "" {
+			var err error
+			if unmodifiedTime, err = time.Parse(time.RFC3339, unmodified); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid time: %s (%v)\n", unmodified, err)
+				os.Exit(1)
 			}
 		}
-		os.Exit(failed)
+		if keyringFile != "" {
+			f, err := os.Open(keyringFile)
+			if err == nil {
+				keyring, err = lib.ReadOpenPGPKeyring(f)
+				_ = f.Close()
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid keyring: %s (%v)\n", keyringFile, err)
+				os.Exit(1)
+			}
+		}
+		// like curl, missing directories are only created with --create-dirs
+		noCreateDirs = noCreateDirs || !createDirs
+		if logFile != "" {
+			var err error
+			if eventLog, err = openDownloadLog(logFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot open log file: %v\n", err)
+				os.Exit(exitDisk)
+			}
+		}
+		client := newDownloadClient(args...)
+		var code int
+		if archivePath != "" {
+			code = downloadArchive(client, archivePath, args)
+		} else {
+			code = downloadURLs(client, args, outputs, skipDownloads())
+		}
+		_ = eventLog.Close()
+		os.Exit(code)
 	},
 }
 
 func init() {
 	downloadCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output with real-time progress bar and download details")
+	downloadCmd.Flags().DurationVar(&headerTimeout, "header-timeout", 0, "Fail if the server does not send response headers within this duration (0 for no limit)")
+	downloadCmd.Flags().DurationVar(&stallTimeout, "stall-timeout", 0, "Abort a transfer which receives no data for this duration (0 for no limit)")
+	downloadCmd.Flags().StringVarP(&userAgent, "user-agent", "A", "", "User-Agent to send; may contain {version}, {os}, {arch} and {url} placeholders")
+	downloadCmd.Flags().StringToStringVar(&resolve, "resolve", nil, "Connect to ADDR instead of HOST, given as HOST=ADDR (may be repeated)")
+	downloadCmd.Flags().StringArrayVarP(&outputs, "output", "o", nil, "Save the next URL to the given path instead of the current directory (may be repeated, once per URL)")
+	downloadCmd.Flags().BoolVar(&createDirs, "create-dirs", false, "Create missing directories in output paths, instead of failing like curl")
+	downloadCmd.Flags().BoolVar(&noCreateDirs, "no-create-dirs", false, "Fail instead of creating missing directories in output paths (default)")
+	downloadCmd.MarkFlagsMutuallyExclusive("create-dirs", "no-create-dirs")
+	downloadCmd.Flags().StringVar(&bufferSize, "buffer-size", "", "Size of the copy buffer, such as 256K or 1M, or \"auto\" to grow it on fast links (default 32K)")
+	downloadCmd.Flags().StringVar(&unmodified, "unmodified-since", "", "Fail if a remote file was modified after the given RFC 3339 time, such as 2024-05-01T12:00:00Z, to fetch exactly an expected snapshot")
+	downloadCmd.Flags().StringVar(&byteRange, "range", "", "Only download the given inclusive byte range of each file, such as 0-1048575, or 1048576- for the rest of the file; requires -o for each URL")
+	downloadCmd.Flags().IntVar(&retries, "retries", 0, "Retry each download up to this many times after transient network errors and 5xx responses, with exponential backoff")
+	downloadCmd.Flags().StringVar(&keyringFile, "verify-signature", "", "Verify each download against its detached OpenPGP signature at the URL with .asc or .sig appended, using the public keys of the given keyring file, and delete files with a bad signature")
+	downloadCmd.Flags().BoolVar(&resumeState, "resume-state", false, "Record the URL, ETag and size of partial downloads in a .grab file, and only resume them if the remote file is unchanged; always enabled for the partial files of single downloads")
+	downloadCmd.Flags().BoolVar(&resumeIfMatch, "resume-if-match", false, "Send the ETag recorded by --resume-state or --sidecar for a partial download in an If-Match header when resuming it, and download the whole file again if the server replies 412 Precondition Failed")
+	downloadCmd.Flags().StringVar(&logFile, "log-file", "", "Append the events of every download, such as retries, redirects and completion with the SHA256 digest, to the given file, as JSON lines if its name ends in .json")
+	downloadCmd.Flags().BoolVar(&addExtension, "add-extension", false, "Append an extension derived from the Content-Type to file names without one, such as .pdf for application/pdf")
+	downloadCmd.Flags().DurationVar(&batchDelay, "delay", 0, "Minimum time between the start of any two downloads (0 for no delay)")
+	downloadCmd.Flags().DurationVar(&hostDelay, "host-delay", 0, "Minimum time between the start of two downloads from the same host, like a robots.txt Crawl-delay (0 for no delay)")
+	downloadCmd.Flags().BoolVar(&failFast, "fail-fast", false, "Stop at the first failed download instead of continuing with the remaining URLs")
+	downloadCmd.Flags().StringVar(&mirrorList, "mirror-list", "", "Fetch a text or JSON list of mirrors from the given URL and download from the best mirror")
+	downloadCmd.Flags().StringVar(&country, "country", "", "Country code used to prefer nearby mirrors of --mirror-list, such as DE")
+	downloadCmd.Flags().BoolVar(&rankMirrors, "rank-mirrors", false, "Probe all mirrors before downloading and use the one with the lowest latency")
+	downloadCmd.Flags().StringVar(&warmStandby, "warm-standby", "", "Keep a warm connection to a second mirror while downloading files of at least this size, such as 1G, to resume from it at once if the transfer fails")
+	downloadCmd.Flags().BoolVar(&linkMirrors, "link-mirrors", false, "Fail over to mirrors advertised by the server in Link headers with rel=duplicate")
+	downloadCmd.Flags().StringVar(&sidecarName, "sidecar", "", "Store the provenance of each file alongside it, in a .grab.json file (json) or extended attributes (xattr)")
+	downloadCmd.Flags().StringVar(&proxyFlag, "proxy", "", "Route requests through the given proxy URL, or \"direct\" to ignore proxy settings, overriding the profile and environment")
+	downloadCmd.Flags().StringSliceVar(&ipfsGateways, "ipfs-gateway", nil, "Fetch ipfs://CID URLs through the given gateways in order, such as https://ipfs.io (comma separated)")
+	downloadCmd.Flags().StringSliceVar(&noProxy, "no-proxy", nil, "Connect directly to the given hosts and their subdomains, overriding all other proxy settings (comma separated)")
+	downloadCmd.Flags().BoolVar(&followPages, "follow-interstitials", false, "Follow the meta refresh redirect of an HTML download page sent in place of the requested file")
+	downloadCmd.Flags().BoolVar(&noHEAD, "no-head", false, "Never send HEAD requests; use ranged GET requests to resume files instead")
+	downloadCmd.Flags().BoolVar(&torrentFiles, "allow-torrent-files", false, "Allow torrent: URLs of local .torrent files")
+	downloadCmd.Flags().BoolVar(&noQuarantine, "no-quarantine", false, "Do not mark downloaded files as originating from the internet (macOS and Windows)")
+	downloadCmd.Flags().BoolVar(&noHistory, "no-history", false, "Do not record downloads in the history database")
+	downloadCmd.Flags().StringVar(&archivePath, "archive", "", "Stream all files into a single .tar or .zip archive instead of saving them individually")
+	downloadCmd.Flags().BoolVar(&skipDownloaded, "skip-downloaded", false, "Skip URLs which the history database shows were already downloaded and still exist locally")
+	downloadCmd.Flags().BoolVar(&http1, "http1.1", false, "Use HTTP/1.1 only")
+	downloadCmd.Flags().BoolVar(&http2, "http2", false, "Use HTTP/2 only, which requires HTTPS")
+	downloadCmd.Flags().BoolVar(&http2Prior, "http2-prior-knowledge", false, "Use HTTP/2 only, without TLS for http:// URLs")
+	downloadCmd.MarkFlagsMutuallyExclusive("http1.1", "http2", "http2-prior-knowledge")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "Apply the named profile of the config file, bundling headers, authentication, proxy, TLS and rate settings")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Path to the config file (default: user config directory)")
 	rootCmd.AddCommand(downloadCmd)
 }
+
+// newDownloadClient returns a Client configured by the download flags for
+// downloading the given URLs.
+func newDownloadClient(urls ...string) *lib.Client {
+	protocol := lib.ProtocolAuto
+	switch {
+	case http1:
+		protocol = lib.ProtocolHTTP1
+	case http2:
+		protocol = lib.ProtocolHTTP2
+	case http2Prior:
+		protocol = lib.ProtocolHTTP2PriorKnowledge
+	}
+	client := lib.NewClient(lib.WithResponseHeaderTimeout(headerTimeout), lib.WithProtocol(protocol))
+	if userAgent != "" {
+		client.UserAgent = userAgent
+	}
+	if profileName != "" {
+		if err := applyProfile(client, urls); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot apply profile %s: %v\n", profileName, err)
+			os.Exit(1)
+		}
+	}
+	if err := overrideProxy(client); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid proxy: %v\n", err)
+		os.Exit(1)
+	}
+	client.AllowedSchemes = allowedSchemes(urls)
+	client.HostOverrides = resolve
+	client.NoHEAD = noHEAD
+	client.TorrentFiles = torrentFiles
+	client.FollowInterstitials = followPages
+	client.LinkMirrors = linkMirrors
+	client.Country = country
+	client.IPFSGateways = ipfsGateways
+	client.RankMirrorsByLatency = rankMirrors
+	client.ResumeState = resumeState
+	client.StallTimeout = stallTimeout
+	if addExtension {
+		client.ContentTypeExtensions = lib.DefaultContentTypeExtensions
+	}
+	if retries > 0 {
+		client.RetryPolicy = &lib.RetryPolicy{MaxAttempts: retries + 1, Jitter: 0.2}
+	}
+	if sidecarName != "" {
+		sidecar, ok := lib.LookupSidecar(sidecarName)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown sidecar: %s (use %s)\n", sidecarName, strings.Join(lib.Sidecars(), " or "))
+			os.Exit(1)
+		}
+		client.Sidecar = sidecar
+	}
+	if warmStandby != "" {
+		n, err := parseSize(warmStandby)
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "Invalid warm standby size: %s\n", warmStandby)
+			os.Exit(1)
+		}
+		client.StandbyThreshold = n
+	}
+	if bufferSize == "auto" {
+		client.AutoTuneBuffer = true
+	} else if bufferSize != "" {
+		n, err := parseSize(bufferSize)
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "Invalid buffer size: %s\n", bufferSize)
+			os.Exit(1)
+		}
+		client.BufferSize = int(n)
+	}
+	return client
+}
+
+// allowedSchemes returns the URL schemes which a Client may request for the
+// given URLs: HTTP and HTTPS, and the schemes of the URLs themselves, such as
+// data: or ftp:, which are not reachable from other URLs otherwise.
+func allowedSchemes(urls []string) []string {
+	schemes := slices.Clone(lib.DefaultAllowedSchemes)
+	for _, s := range urls {
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme == "" {
+			continue
+		}
+		if scheme := strings.ToLower(u.Scheme); !slices.Contains(schemes, scheme) {
+			schemes = append(schemes, scheme)
+		}
+	}
+	return schemes
+}
+
+// applyProfile applies the profile selected by --profile from the config file
+// given by --config, or the default config file, to the given Client. The
+// headers and credentials of a profile without hosts are sent to the hosts of
+// the given URLs.
+func applyProfile(client *lib.Client, urls []string) error {
+	path := configPath
+	if path == "" {
+		var err error
+		if path, err = lib.DefaultConfigPath(); err != nil {
+			return err
+		}
+	}
+	cfg, err := lib.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	p, err := cfg.Profile(profileName)
+	if err != nil {
+		return err
+	}
+	if len(p.Hosts) == 0 {
+		for _, s := range urls {
+			if u, err := url.Parse(s); err == nil && u.Host != "" {
+				p.Hosts = append(p.Hosts, u.Host)
+			}
+		}
+	}
+	return client.ApplyProfile(p)
+}
+
+// textProgress is a lib.ProgressSink which prints a progress bar of a single
+// download to stdout.
+type textProgress struct{}
+
+func (textProgress) Start(resp *lib.Response, s lib.Snapshot) {
+	printProgress(s)
+}
+
+func (textProgress) Update(resp *lib.Response, s lib.Snapshot) {
+	printProgress(s)
+}
+
+func (textProgress) Finish(resp *lib.Response, s lib.Snapshot, err error) {
+	printProgress(s)
+	fmt.Println() // Newline after progress bar
+}
+
+// printProgress prints the progress bar of the given snapshot, replacing the
+// current line.
+func printProgress(s lib.Snapshot) {
+	if s.Size > 0 {
+		percent := s.Progress * 100
+		barLen := 40
+		filledLen := min(int(float64(barLen)*s.Progress), barLen)
+		bar := "[" + strings.Repeat("=", filledLen) + strings.Repeat(" ", barLen-filledLen) + "]"
+		rate := ""
+		if s.BytesPerSecond > 0 {
+			rate = ", " + formatSpeed(s.BytesPerSecond, "%.1f")
+			if left := s.Remaining().Round(time.Second); left > 0 {
+				rate += fmt.Sprintf(", %v left", left)
+			}
+		}
+		fmt.Printf("\rDownloading: %s %6.2f%% (%d/%d bytes%s)   ", bar, percent, s.BytesComplete, s.Size, rate)
+	} else {
+		fmt.Printf("\rDownloading: %d bytes complete (total unknown)", s.BytesComplete)
+	}
+}
+
+// overrideProxy gives the proxy settings of --no-proxy and --proxy precedence
+// over those of the profile and the environment.
+func overrideProxy(client *lib.Client) error {
+	var rules []lib.ProxyRule
+	if len(noProxy) > 0 {
+		rules = append(rules, lib.BypassProxy(noProxy...))
+	}
+	switch proxyFlag {
+	case "":
+	case "direct":
+		rules = append(rules, lib.ExplicitProxy(nil, "set by --proxy"))
+	default:
+		u, err := url.Parse(proxyFlag)
+		if err != nil {
+			return err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%s: expected a URL such as http://proxy:3128", proxyFlag)
+		}
+		rules = append(rules, lib.ExplicitProxy(u, "set by --proxy"))
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return client.OverrideProxy(rules...)
+}
+
+// printEvents writes the events recorded while downloading the given
+// Response to stderr, to explain how it was downloaded or why it failed.
+func printEvents(resp *lib.Response) {
+	_, _ = fmt.Fprintf(os.Stderr, "Events for %s:\n", lib.RedactURL(resp.Request.URL()))
+	for _, e := range resp.Events() {
+		_, _ = fmt.Fprintf(os.Stderr, "  %s\n", e)
+	}
+}
+
+// parseSize parses a size in bytes with an optional binary unit suffix, such
+// as 512, 64K, 1M or 1G.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch suffix := strings.ToUpper(s[len(s)-1:]); suffix {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mult, nil
+}
+
+// parseRange parses an inclusive byte range such as 0-1023, or 1024- for the
+// rest of the file, and returns its offset and length. The length is -1 if the
+// range is open-ended.
+func parseRange(s string) (int64, int64, error) {
+	first, last, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected FIRST-LAST")
+	}
+	off, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || off < 0 {
+		return 0, 0, fmt.Errorf("invalid first byte %q", first)
+	}
+	if last == "" {
+		return off, -1, nil
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < off {
+		return 0, 0, fmt.Errorf("invalid last byte %q", last)
+	}
+	return off, end - off + 1, nil
+}
+
+// isDirOutput reports whether the given output names a directory, in which
+// the download is saved under the name of the remote file.
+func isDirOutput(name string) bool {
+	if strings.HasSuffix(name, "/") || strings.HasSuffix(name, string(os.PathSeparator)) {
+		return true
+	}
+	fi, err := os.Stat(name)
+	return err == nil && fi.IsDir()
+}
+
+// skipMode selects the URLs which downloadURLs skips, as the history database
+// shows that they were already downloaded.
+type skipMode int
+
+const (
+	// skipNone downloads every URL.
+	skipNone skipMode = iota
+
+	// skipExisting skips URLs whose last download still exists locally with
+	// the recorded size.
+	skipExisting
+
+	// skipSeen skips URLs which were downloaded before, even if the file was
+	// since removed, such as the episodes of a podcast once listened to.
+	skipSeen
+)
+
+// skipDownloads returns the skipMode selected by --skip-downloaded.
+func skipDownloads() skipMode {
+	if skipDownloaded {
+		return skipExisting
+	}
+	return skipNone
+}
+
+// downloadURLs downloads the given URLs one at a time and returns the exit
+// code describing the first failure. Each URL is saved to the path at the same
+// index of dsts, or to the current directory if dsts is shorter. URLs which
+// the history database shows were already downloaded are skipped as selected
+// by skip. An interrupt cancels the current download and any remaining URLs.
+func downloadURLs(client *lib.Client, urls []string, dsts []string, skip skipMode) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// partial files are only continued if their resume state shows that they
+	// are of the same remote file
+	client.ResumeState = true
+	var history *historyDB
+	if !noHistory || skip != skipNone {
+		var err error
+		if history, err = openHistory(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot open download history: %v\n", err)
+		}
+	}
+	defer func() {
+		_ = history.Close()
+	}()
+	code := exitOK
+	var summary lib.BatchSummary
+	var reqs []*lib.Request
+	for i, url := range urls {
+		if skip != skipNone && history != nil {
+			if e, err := history.findDownloaded(url, skip == skipExisting); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: cannot read download history: %v\n", err)
+			} else if e != nil {
+				if verbose {
+					fmt.Printf("Already downloaded: %s (%s)\n", e.Path, e.Time.Format(time.RFC3339))
+				}
+				summary.Requested++
+				summary.Skipped++
+				continue
+			}
+		}
+		dst := "."
+		if i < len(dsts) {
+			dst = dsts[i]
+		}
+		req, err := lib.NewRequest(dst, url)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid URL: %s (%v)\n", url, err)
+			summary.Requested++
+			summary.Failed++
+			if code == exitOK {
+				code = exitError
+			}
+			if failFast {
+				break
+			}
+			continue
+		}
+		req = req.WithContext(ctx)
+		req.Quarantine = !noQuarantine
+		req.NoCreateDirectories = noCreateDirs
+		req.MirrorListURL = mirrorList
+		req.UnmodifiedSince = unmodifiedTime
+		req.ResumeIfMatch = resumeIfMatch
+		req.SetSignatureURL(keyring, "", true)
+		if byteRange != "" {
+			// ranged downloads are never resumed, so skip the part file
+			req.SetRange(rangeStart, rangeLength)
+		} else {
+			req.BeforeStore = partialStore
+			req.BeforeCopy = printResume
+		}
+		if history != nil && !noHistory || eventLog != nil {
+			req.Destinations = append(req.Destinations, lib.Destination{Writer: newTransferDigest()})
+		}
+		if verbose {
+			req.Progress = textProgress{}
+		}
+		eventLog.start(req)
+		reqs = append(reqs, req)
+	}
+
+	// a single worker downloads the files one at a time, spaced by the
+	// politeness delays
+	opts := lib.BatchOptions{
+		Workers:   1,
+		Ordered:   true,
+		Delay:     batchDelay,
+		HostDelay: hostDelay,
+	}
+	for resp := range client.DoBatchWithOptions(ctx, opts, reqs...) {
+		<-resp.Done
+		if err := finishPart(resp, client.Sidecar); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot move %s into place: %v\n", resp.Filename, err)
+		}
+		sum := requestDigest(resp.Request).sum(resp)
+		eventLog.done(resp, sum)
+		if verbose {
+			if err := resp.Err(); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "Failed: %s (%v)\n", resp.Filename, err)
+			} else {
+				info := ""
+				if fi, err := os.Stat(resp.Filename); err == nil && fi.Mode().IsRegular() {
+					size := fi.Size()
+					info += fmt.Sprintf("size: %d bytes, ", size)
+				}
+				info += fmt.Sprintf("avg %s, peak %s",
+					formatSpeed(resp.AverageBytesPerSecond(), "%.1f"),
+					formatSpeed(resp.PeakBytesPerSecond(), "%.1f"))
+				_, _ = fmt.Fprintf(os.Stdout, "Downloaded: %s (%s)\n", resp.Filename, info)
+				if u := resp.EffectiveURL(); u.String() != resp.Request.URL().String() {
+					_, _ = fmt.Fprintf(os.Stdout, "Effective URL: %s\n", lib.RedactURL(u))
+				}
+				if t := resp.Timing().String(); t != "" {
+					_, _ = fmt.Fprintf(os.Stdout, "Timing: %s\n", t)
+				}
+			}
+			if hint := resp.ThrottleHint(); hint != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "Note: %s\n", hint)
+			}
+		}
+		if verbose || resp.Err() != nil {
+			printEvents(resp)
+		}
+		if history != nil && !noHistory {
+			if err := history.record(resp, sum); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: cannot record download history: %v\n", err)
+			}
+		}
+		summary.Add(resp)
+		if err := resp.Err(); err != nil {
+			if code == exitOK {
+				code = exitCode(err)
+			}
+			if failFast || ctx.Err() != nil {
+				// cancel the remaining downloads
+				stop()
+				break
+			}
+		}
+	}
+	if len(urls) > 1 {
+		fmt.Printf("Summary: %s\n", summary)
+	}
+	return code
+}
+
+// downloadArchive streams the given URLs into a single archive file whose
+// format is chosen by its extension, and returns the exit code.
+func downloadArchive(client *lib.Client, path string, urls []string) int {
+	var format lib.ArchiveFormat
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tar":
+		format = lib.ArchiveTar
+	case ".zip":
+		format = lib.ArchiveZip
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported archive format: %s (use .tar or .zip)\n", path)
+		return exitError
+	}
+
+	reqs := make([]*lib.Request, 0, len(urls))
+	for _, url := range urls {
+		req, err := lib.NewRequest(".", url)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid URL: %s (%v)\n", url, err)
+			return exitError
+		}
+		eventLog.start(req)
+		reqs = append(reqs, req)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create archive: %v\n", err)
+		return exitDisk
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	responses, err := client.DoArchive(ctx, f, format, reqs...)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	for _, resp := range responses {
+		eventLog.done(resp, "")
+	}
+	if verbose {
+		for _, resp := range responses {
+			if resp.Err() == nil {
+				fmt.Printf("Archived: %s (size: %d bytes)\n", resp.Filename, resp.Size())
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed: %s (%v)\n", path, err)
+		return exitCode(err)
+	}
+	if len(urls) > 1 {
+		fmt.Printf("Summary: %s\n", lib.Summarize(responses...))
+	}
+	return exitOK
+}
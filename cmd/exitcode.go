@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+
+	"github.com/sebrandon1/grab/lib"
+)
+
+// Exit codes of the download commands. If several downloads fail, the exit
+// code describes the first failure.
+const (
+	exitOK        = 0 // all downloads succeeded
+	exitError     = 1 // invalid arguments or any other error
+	exitNetwork   = 2 // the remote server could not be reached or the connection failed
+	exitHTTP      = 3 // the server responded with an error status, too many redirects or an HTML page
+	exitChecksum  = 4 // the download did not match its checksum, signature or expected size
+	exitDisk      = 5 // the file could not be written to local storage
+	exitCancelled = 6 // the download was interrupted
+)
+
+// exitCode returns the exit code which describes the given error.
+func exitCode(err error) int {
+	var statusErr lib.StatusCodeError
+	var connErr *lib.ConnError
+	var netErr net.Error
+	var pathErr *fs.PathError
+	var writeErr *lib.WriteError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, context.Canceled):
+		return exitCancelled
+	case errors.As(err, &statusErr), errors.Is(err, lib.ErrTooManyRedirects), errors.Is(err, lib.ErrInterstitial):
+		return exitHTTP
+	case errors.Is(err, lib.ErrBadChecksum), errors.Is(err, lib.ErrBadLength), errors.Is(err, lib.ErrResumeMismatch),
+		errors.Is(err, lib.ErrBadSignature):
+		return exitChecksum
+	case errors.As(err, &writeErr):
+		return exitDisk
+	case errors.As(err, &connErr), errors.As(err, &netErr), errors.Is(err, lib.ErrIncompleteBody),
+		errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, context.DeadlineExceeded):
+		return exitNetwork
+	case errors.As(err, &pathErr):
+		return exitDisk
+	}
+	return exitError
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var feedFilters []string
+
+var feedCmd = &cobra.Command{
+	Use:   "feed [url]",
+	Short: "Download files enclosed in an RSS or Atom feed",
+	Long: `Download the files enclosed in the entries of an RSS or Atom feed, such as
+podcast episodes or release artifacts, to the current directory.
+
+Files which the history database shows were already downloaded are skipped,
+even if they were since removed locally. Running the same command
+periodically therefore only downloads new entries.`,
+	Example: `  # Download all enclosures of a feed
+  grab feed https://example.com/podcast.xml
+
+  # Only download ISO images
+  grab feed https://example.com/releases.atom --filter '*.iso'`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		client := newDownloadClient(args[0])
+		items, err := client.Feed(context.Background(), args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read feed: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		var urls []string
+		for _, item := range items {
+			if matchFilters(feedFilters, item.URL) {
+				urls = append(urls, item.URL)
+			}
+		}
+		if len(urls) == 0 {
+			fmt.Println("No matching files in feed")
+			return
+		}
+		os.Exit(downloadURLs(client, urls, nil, skipSeen))
+	},
+}
+
+func init() {
+	feedCmd.Flags().StringSliceVar(&feedFilters, "filter", nil, "Only download files whose name matches the given glob pattern (may be repeated)")
+	feedCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output with real-time progress bar and download details")
+	feedCmd.Flags().DurationVar(&batchDelay, "delay", 0, "Minimum time between the start of any two downloads (0 for no delay)")
+	feedCmd.Flags().DurationVar(&hostDelay, "host-delay", 0, "Minimum time between the start of two downloads from the same host, like a robots.txt Crawl-delay (0 for no delay)")
+	feedCmd.Flags().BoolVar(&noHistory, "no-history", false, "Do not record downloads in the history database")
+	rootCmd.AddCommand(feedCmd)
+}
+
+// matchFilters reports whether the given URL matches any of the given glob
+// patterns, or true if there are none. Patterns containing a slash are
+// matched against the URL path and others against its last element.
+func matchFilters(patterns []string, rawURL string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		name := path.Base(u.Path)
+		if strings.Contains(pattern, "/") {
+			name = u.Path
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
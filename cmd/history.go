@@ -0,0 +1,403 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sebrandon1/grab/lib"
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	historyJSON   bool
+	historySearch string
+	historyLimit  int
+	historyPath   string
+	noHistory     bool
+)
+
+// historyBucket is the bbolt bucket in which history entries are stored,
+// keyed by a big-endian sequence number.
+var historyBucket = []byte("downloads")
+
+// historyURLBucket indexes the history by URL. It maps the SHA-256 digest of
+// each URL to the key of its most recent successful download in
+// historyBucket, as the entries themselves only record the URL without its
+// password and query.
+var historyURLBucket = []byte("url_sha256")
+
+// legacyURLBucket is the URL index of earlier versions, which was keyed by
+// the full URL.
+var legacyURLBucket = []byte("urls")
+
+// A historyEntry records a single completed or failed download. Its URL is
+// redacted as by lib.RedactURL.
+type historyEntry struct {
+	ID       uint64        `json:"id"`
+	Time     time.Time     `json:"time"`
+	URL      string        `json:"url"`
+	Path     string        `json:"path"`
+	Size     int64         `json:"size"`
+	SHA256   string        `json:"sha256,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Timing   *lib.Timing   `json:"timing,omitempty"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show previously downloaded files",
+	Long: `Show the history of files downloaded with grab.
+
+Every download made with the download command is recorded in a local database,
+including its URL without password and query, destination path, size, SHA256
+digest, duration, timing breakdown (DNS, connect, TLS, time to first byte and
+transfer) and exit status. Use --no-history on the download command to disable
+recording.`,
+	Example: `  # Show all downloads
+  grab history
+
+  # Search by URL or path
+  grab history --search go1.21
+
+  # Output as JSON
+  grab history --json`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := openHistory()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read history: %v\n", err)
+			os.Exit(1)
+		}
+		entries, err := db.read(historySearch, historyLimit)
+		_ = db.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read history: %v\n", err)
+			os.Exit(1)
+		}
+		if historyJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(entries); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write history: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "ID\tTIME\tSTATUS\tSIZE\tDURATION\tPATH\tURL")
+		for _, e := range entries {
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				e.ID, e.Time.Format(time.RFC3339), e.Status, e.Size,
+				e.Duration.Round(time.Millisecond), e.Path, e.URL)
+		}
+		_ = w.Flush()
+	},
+}
+
+func init() {
+	historyCmd.Flags().BoolVar(&historyJSON, "json", false, "Output history as JSON")
+	historyCmd.Flags().StringVar(&historySearch, "search", "", "Only show downloads whose URL or path contains the given text")
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 0, "Maximum number of most recent downloads to show (0 for all)")
+	rootCmd.PersistentFlags().StringVar(&historyPath, "history-db", "", "Path to the download history database (default: user config directory)")
+	rootCmd.AddCommand(historyCmd)
+}
+
+// historyDBPath returns the path of the history database.
+func historyDBPath() (string, error) {
+	if historyPath != "" {
+		return historyPath, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "grab", "history.db"), nil
+}
+
+// historyDB is the history database. It is opened once by each command and
+// used for all of its downloads.
+type historyDB struct {
+	db *bolt.DB
+}
+
+// openHistory opens the history database, creating it if necessary.
+func openHistory() (*historyDB, error) {
+	path, err := historyDBPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(indexHistory); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &historyDB{db: db}, nil
+}
+
+// indexHistory adds the URL index to a database written by an earlier
+// version, which lacks it or indexed the full URLs. The full URLs recorded by
+// the entries of earlier versions are indexed by their digest and redacted.
+func indexHistory(tx *bolt.Tx) error {
+	if tx.Bucket(historyURLBucket) != nil {
+		return nil
+	}
+	idx, err := tx.CreateBucket(historyURLBucket)
+	if err != nil {
+		return err
+	}
+	if tx.Bucket(legacyURLBucket) != nil {
+		if err := tx.DeleteBucket(legacyURLBucket); err != nil {
+			return err
+		}
+	}
+	b := tx.Bucket(historyBucket)
+	if b == nil {
+		return nil
+	}
+	// entries are visited oldest first, so the index ends up with the most
+	// recent download of each URL
+	redacted := make(map[string][]byte)
+	err = b.ForEach(func(k, v []byte) error {
+		var e historyEntry
+		if err := json.Unmarshal(v, &e); err != nil {
+			return err
+		}
+		if e.Status == "ok" {
+			if err := idx.Put(historyURLKey(e.URL), k); err != nil {
+				return err
+			}
+		}
+		if u := redactHistoryURL(e.URL); u != e.URL {
+			e.URL = u
+			v, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			redacted[string(k)] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// entries can't be replaced while the bucket is iterated
+	for k, v := range redacted {
+		if err := b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// historyURLKey returns the key of the given URL in historyURLBucket, the hex
+// encoded SHA-256 digest of the full URL.
+func historyURLKey(rawURL string) []byte {
+	sum := sha256.Sum256([]byte(rawURL))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+// redactHistoryURL redacts a URL recorded by an earlier version as
+// lib.RedactURL does. URLs which do not parse are reduced to the text before
+// any query.
+func redactHistoryURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		s, _, _ = strings.Cut(s, "?")
+		return s
+	}
+	return lib.RedactURL(u)
+}
+
+// Close closes the database. It is a no-op for a nil database.
+func (h *historyDB) Close() error {
+	if h == nil {
+		return nil
+	}
+	return h.db.Close()
+}
+
+// record stores the outcome of the given download in the history database,
+// with the given SHA256 digest of the downloaded file, if known. The URL is
+// recorded redacted, and indexed by the digest of the full URL.
+func (h *historyDB) record(resp *lib.Response, sum string) error {
+	key := historyURLKey(resp.Request.URL().String())
+	e := historyEntry{
+		Time:     resp.Start,
+		URL:      lib.RedactURL(resp.Request.URL()),
+		Path:     resp.Filename,
+		Size:     resp.BytesComplete(),
+		SHA256:   sum,
+		Duration: resp.Duration(),
+		Status:   "ok",
+	}
+	if abs, err := filepath.Abs(resp.Filename); err == nil {
+		e.Path = abs
+	}
+	if t := resp.Timing(); t != (lib.Timing{}) {
+		e.Timing = &t
+	}
+	if err := resp.Err(); err != nil {
+		e.Status = "failed"
+		e.Error = err.Error()
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(historyBucket)
+		if err != nil {
+			return err
+		}
+		e.ID, err = b.NextSequence()
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		k := historyKey(e.ID)
+		if err := b.Put(k, v); err != nil {
+			return err
+		}
+		if e.Status != "ok" {
+			return nil
+		}
+		idx, err := tx.CreateBucketIfNotExists(historyURLBucket)
+		if err != nil {
+			return err
+		}
+		return idx.Put(key, k)
+	})
+}
+
+// read returns up to limit of the most recent history entries matching the
+// given search text, oldest first. A limit less than one returns all matching
+// entries.
+func (h *historyDB) read(search string, limit int) ([]historyEntry, error) {
+	entries := []historyEntry{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var e historyEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if search != "" && !strings.Contains(e.URL, search) && !strings.Contains(e.Path, search) {
+				continue
+			}
+			entries = append(entries, e)
+			if limit > 0 && len(entries) == limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// reverse to oldest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// findDownloaded returns the most recent successful history entry for the
+// given URL. If exists is true, it is only returned if its file still exists
+// locally with the recorded size.
+func (h *historyDB) findDownloaded(rawURL string, exists bool) (*historyEntry, error) {
+	var e *historyEntry
+	err := h.db.View(func(tx *bolt.Tx) error {
+		idx, b := tx.Bucket(historyURLBucket), tx.Bucket(historyBucket)
+		if idx == nil || b == nil {
+			return nil
+		}
+		k := idx.Get(historyURLKey(rawURL))
+		if k == nil {
+			return nil
+		}
+		v := b.Get(k)
+		if v == nil {
+			return nil
+		}
+		e = &historyEntry{}
+		return json.Unmarshal(v, e)
+	})
+	if err != nil || e == nil || !exists {
+		return e, err
+	}
+	if fi, err := os.Stat(e.Path); err != nil || fi.Size() != e.Size {
+		return nil, nil
+	}
+	return e, nil
+}
+
+func historyKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// transferDigest computes the SHA256 digest of a download as it is written. It
+// is added to the Destinations of the Request, so that the downloaded file
+// need not be read again to record its digest.
+type transferDigest struct {
+	h hash.Hash
+	n int64
+}
+
+func newTransferDigest() *transferDigest {
+	return &transferDigest{h: sha256.New()}
+}
+
+func (d *transferDigest) Write(p []byte) (int, error) {
+	n, err := d.h.Write(p)
+	d.n += int64(n)
+	return n, err
+}
+
+// requestDigest returns the transferDigest of the given Request, or nil if it
+// has none.
+func requestDigest(req *lib.Request) *transferDigest {
+	for _, d := range req.Destinations {
+		if digest, ok := d.Writer.(*transferDigest); ok {
+			return digest
+		}
+	}
+	return nil
+}
+
+// sum returns the hex encoded SHA256 digest of the file downloaded by the
+// given Response, or an empty string if the download failed or the digest
+// does not cover the entire file, such as when the transfer restarted from an
+// earlier byte or was written to a pipe.
+func (d *transferDigest) sum(resp *lib.Response) string {
+	if d == nil || resp.Err() != nil {
+		return ""
+	}
+	if fi, err := os.Stat(resp.Filename); err != nil || !fi.Mode().IsRegular() || fi.Size() != d.n {
+		return ""
+	}
+	return hex.EncodeToString(d.h.Sum(nil))
+}
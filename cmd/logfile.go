@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sebrandon1/grab/lib"
+)
+
+// logRecord is a line of the log file written by --log-file.
+type logRecord struct {
+	Time     time.Time `json:"time"`
+	URL      string    `json:"url"`
+	Event    string    `json:"event"`
+	Message  string    `json:"message,omitempty"`
+	Worker   int       `json:"worker,omitempty"`
+	Filename string    `json:"filename,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Duration string    `json:"duration,omitempty"`
+	SHA256   string    `json:"sha256,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// downloadLog appends the event stream of every download of an invocation to
+// a file, as text or, if the file name ends in .json, as JSON lines, so that
+// long unattended runs leave a record of what happened to each file. URLs are
+// logged without passwords and query strings, which may hold access tokens.
+type downloadLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	json bool
+}
+
+// openDownloadLog opens the named log file for appending, creating it if
+// necessary. The log is only readable by its owner, as error messages may
+// still reveal details of private servers.
+func openDownloadLog(name string) (*downloadLog, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &downloadLog{f: f, json: strings.HasSuffix(strings.ToLower(name), ".json")}, nil
+}
+
+// Close closes the log file. It is a no-op for a nil log.
+func (l *downloadLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// start records the start of the download of the given Request and logs its
+// events as they are recorded. It is a no-op for a nil log.
+func (l *downloadLog) start(req *lib.Request) {
+	if l == nil {
+		return
+	}
+	url := lib.RedactURL(req.URL())
+	l.write(logRecord{Time: time.Now(), URL: url, Event: "start", Filename: req.Filename})
+	req.OnEvent = func(resp *lib.Response, e lib.Event) {
+		l.write(logRecord{Time: e.Time, URL: url, Event: "event", Message: e.Message, Worker: e.Worker})
+	}
+}
+
+// done records the outcome of the given completed Response, with the given
+// SHA256 digest of the downloaded file, if known. It is a no-op for a nil log.
+func (l *downloadLog) done(resp *lib.Response, sum string) {
+	if l == nil {
+		return
+	}
+	r := logRecord{
+		Time:     time.Now(),
+		URL:      lib.RedactURL(resp.Request.URL()),
+		Event:    "complete",
+		Worker:   resp.Worker(),
+		Filename: resp.Filename,
+		Size:     resp.BytesComplete(),
+		Duration: resp.Duration().Round(time.Millisecond).String(),
+		SHA256:   sum,
+	}
+	if err := resp.Err(); err != nil {
+		r.Event = "failed"
+		r.Error = lib.RedactError(err)
+	}
+	l.write(r)
+}
+
+// write appends a record to the log file. Write errors are reported once and
+// otherwise ignored, so that logging never fails a download.
+func (l *downloadLog) write(r logRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return
+	}
+	var err error
+	if l.json {
+		err = json.NewEncoder(l.f).Encode(r)
+	} else {
+		err = writeLogLine(l.f, r)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot write log file: %v\n", err)
+		_ = l.f.Close()
+		l.f = nil
+	}
+}
+
+// writeLogLine writes a record as a line of text.
+func writeLogLine(w io.Writer, r logRecord) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", r.Time.UTC().Format(time.RFC3339Nano), r.URL)
+	if r.Worker > 0 {
+		fmt.Fprintf(&b, " [worker %d]", r.Worker)
+	}
+	switch r.Event {
+	case "event":
+		fmt.Fprintf(&b, " %s", r.Message)
+	case "start":
+		fmt.Fprintf(&b, " start")
+		if r.Filename != "" {
+			fmt.Fprintf(&b, " -> %s", r.Filename)
+		}
+	default:
+		fmt.Fprintf(&b, " %s: %s, %d bytes in %s", r.Event, r.Filename, r.Size, r.Duration)
+		if r.SHA256 != "" {
+			fmt.Fprintf(&b, ", sha256 %s", r.SHA256)
+		}
+		if r.Error != "" {
+			fmt.Fprintf(&b, ", error: %s", r.Error)
+		}
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
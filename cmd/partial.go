@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/sebrandon1/grab/lib"
+)
+
+// partSuffix is appended to the name of a file while it is downloaded, so
+// that an incomplete file is never mistaken for a complete one.
+const partSuffix = ".grab-part"
+
+func init() {
+	// partial files are removed by grab clean along with the files of the
+	// library
+	lib.PartialSuffixes = append(lib.PartialSuffixes, partSuffix)
+}
+
+// partialStore downloads to a partial file next to the destination. It is a
+// BeforeStore hook. Whether a partial file left by a previous run is
+// continued or downloaded again is decided by its resume state, which the
+// client records (see lib.Client.ResumeState).
+func partialStore(resp *lib.Response, path string) (string, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&(os.ModeNamedPipe|os.ModeDevice) != 0 {
+		// pipes and devices are written directly
+		return path, nil
+	}
+	part := path + partSuffix
+	if _, err := os.Stat(part); err != nil {
+		if _, err := os.Stat(path); err == nil {
+			// a previous run completed, so let grab validate the file
+			return path, nil
+		}
+	}
+	return part, nil
+}
+
+// printResume reports that a partial download is continued, once the client
+// decided to resume it. It is a BeforeCopy hook.
+func printResume(resp *lib.Response) error {
+	if !resp.DidResume || !strings.HasSuffix(resp.Filename, partSuffix) {
+		return nil
+	}
+	path := strings.TrimSuffix(resp.Filename, partSuffix)
+	if size := resp.Size(); size > 0 {
+		fmt.Printf("Resuming %s at %.1f%%\n", path, float64(resp.BytesComplete())/float64(size)*100)
+	} else {
+		fmt.Printf("Resuming %s at %d bytes\n", path, resp.BytesComplete())
+	}
+	return nil
+}
+
+// finishPart moves a successfully downloaded partial file to its destination,
+// along with any metadata stored by the given sidecar. Failed downloads are
+// kept, with their resume state, to be continued.
+func finishPart(resp *lib.Response, sidecar lib.Sidecar) error {
+	if resp.Err() != nil || !strings.HasSuffix(resp.Filename, partSuffix) {
+		return nil
+	}
+	path := strings.TrimSuffix(resp.Filename, partSuffix)
+	if err := os.Rename(resp.Filename, path); err != nil {
+		return err
+	}
+	part := resp.Filename
+	resp.Filename = path
+	if sidecar == nil {
+		return nil
+	}
+	meta, err := sidecar.Read(part)
+	if err == nil {
+		if err := sidecar.Write(path, meta); err != nil {
+			return err
+		}
+		err = sidecar.Remove(part)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, lib.ErrSidecarUnsupported) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sebrandon1/grab/lib"
+	"github.com/sebrandon1/grab/lib/proxyhandler"
+	"github.com/spf13/cobra"
+)
+
+var (
+	proxyListen   string
+	proxyCacheDir string
+)
+
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Run a read-through caching download proxy",
+	Long: `Run an HTTP server which serves downloads from a local cache.
+
+Files which are not yet cached are downloaded from the remote server on first
+request and stored in the cache directory by the digest of their content.
+Cached files are revalidated against the ETag or Last-Modified time of the
+remote file before they are served. Concurrent requests for the same URL
+share a single download.
+
+Clients may use the server as an HTTP proxy, or request a URL explicitly using
+the url query parameter. The proxy fetches any URL it is asked for, so by
+default it only listens on the loopback interface.`,
+	Example: `  # Start a caching proxy on port 8080 of this host
+  grab proxy
+
+  # Serve the local network
+  grab proxy --listen :8080
+
+  # Download through the proxy
+  curl -O http://localhost:8080/?url=https://go.dev/dl/go1.21.5.src.tar.gz
+
+  # Use a custom cache directory
+  grab proxy --cache-dir /var/cache/grab`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := proxyCacheDir
+		if dir == "" {
+			cacheDir, err := os.UserCacheDir()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to determine cache directory: %v\n", err)
+				os.Exit(1)
+			}
+			dir = filepath.Join(cacheDir, "grab", "proxy")
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create cache directory: %v\n", err)
+			os.Exit(1)
+		}
+
+		srv := &http.Server{
+			Addr:              proxyListen,
+			Handler:           proxyhandler.New(dir, lib.NewClient()),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		fmt.Fprintf(os.Stderr, "Serving cache %s on %s\n", dir, proxyListen)
+		if err := srv.ListenAndServe(); err != nil {
+			fmt.Fprintf(os.Stderr, "Proxy failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	proxyCmd.Flags().StringVar(&proxyListen, "listen", "127.0.0.1:8080", "Address to listen on")
+	proxyCmd.Flags().StringVar(&proxyCacheDir, "cache-dir", "", "Directory in which to cache downloads (default: user cache directory)")
+	rootCmd.AddCommand(proxyCmd)
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sebrandon1/grab/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	repairManifest string
+	repairOutput   string
+)
+
+var repairCmd = &cobra.Command{
+	Use:   "repair [url]",
+	Short: "Re-download only the corrupted blocks of a file",
+	Long: `Repair a corrupted or truncated local copy of a file.
+
+Each block of the local file is verified against a block manifest, and only
+the blocks which do not match are downloaded again, using one ranged request
+for each run of adjacent corrupted blocks. Block manifests are created with
+grab hash --block-size.`,
+	Example: `  # Create a manifest of 1 MiB blocks of the original file
+  grab hash --block-size 1M --type sha256 image.iso > image.iso.blocks
+
+  # Repair the local copy of the file in the current directory
+  grab repair --manifest image.iso.blocks https://example.com/image.iso
+
+  # Fetch the manifest from the server and repair a file elsewhere
+  grab repair --manifest https://example.com/image.iso.blocks -o /data/image.iso https://example.com/image.iso`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := lib.NewRequest(repairOutput, args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid URL: %v\n", err)
+			os.Exit(exitError)
+		}
+		if strings.HasPrefix(repairManifest, "http://") || strings.HasPrefix(repairManifest, "https://") {
+			req.BlockManifestURL = repairManifest
+		} else {
+			b, err := os.ReadFile(repairManifest)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot read manifest: %v\n", err)
+				os.Exit(exitError)
+			}
+			if req.BlockManifest, err = lib.ParseBlockManifest(b); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(exitError)
+			}
+		}
+		result, err := newDownloadClient(args[0]).Repair(req)
+		if result != nil && len(result.Repaired) > 0 {
+			fmt.Printf("Repaired %d of %d blocks of %s with %d requests (%d bytes)\n",
+				len(result.Repaired), result.Blocks, result.Filename, result.Requests, result.BytesTransferred)
+		} else if result != nil && err == nil {
+			fmt.Printf("%s is intact (%d blocks)\n", result.Filename, result.Blocks)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Repair failed: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+	},
+}
+
+func init() {
+	repairCmd.Flags().StringVar(&repairManifest, "manifest", "", "Path or URL of the block manifest of the file")
+	repairCmd.Flags().StringVarP(&repairOutput, "output", "o", "", "Path of the local file (default: named after the URL in the current directory)")
+	_ = repairCmd.MarkFlagRequired("manifest")
+	rootCmd.AddCommand(repairCmd)
+}
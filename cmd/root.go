@@ -5,11 +5,13 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 
+	"github.com/sebrandon1/grab/lib"
 	"github.com/spf13/cobra"
 )
 
@@ -55,6 +57,9 @@ Output format matches common checksum tools: hash followed by filename.`,
   # Compute SHA1 hash
   grab hash go1.21.5.darwin-amd64.tar.gz -t sha1
 
+  # Write a block manifest for grab repair
+  grab hash image.iso --block-size 1M > image.iso.blocks
+
   # Verify downloaded file integrity
   grab download https://github.com/sebrandon1/grab/archive/refs/heads/main.zip
   grab hash main.zip --type sha256`,
@@ -62,6 +67,7 @@ Output format matches common checksum tools: hash followed by filename.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		file := args[0]
 		hashType, _ := cmd.Flags().GetString("type")
+		blockSize, _ := cmd.Flags().GetString("block-size")
 		f, err := os.Open(file)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to open file: %v\n", err)
@@ -70,6 +76,10 @@ Output format matches common checksum tools: hash followed by filename.`,
 		defer func() {
 			_ = f.Close()
 		}()
+		if blockSize != "" {
+			printBlockManifest(f, hashType, blockSize)
+			return
+		}
 		var sum []byte
 		switch strings.ToLower(hashType) {
 		case "sha256":
@@ -101,8 +111,26 @@ Output format matches common checksum tools: hash followed by filename.`,
 	},
 }
 
+// printBlockManifest writes a JSON block manifest of the given file to stdout,
+// for use with grab repair.
+func printBlockManifest(f *os.File, hashType, blockSize string) {
+	n, err := parseSize(blockSize)
+	if err != nil || n < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid block size: %s\n", blockSize)
+		os.Exit(1)
+	}
+	m, err := lib.NewBlockManifest(f, hashType, n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to hash file: %v\n", err)
+		os.Exit(1)
+	}
+	b, _ := json.MarshalIndent(m, "", "  ")
+	fmt.Println(string(b))
+}
+
 func init() {
 	hashCmd.Flags().StringP("type", "t", "sha256", "Hash algorithm to use (sha256, sha1, md5)")
+	hashCmd.Flags().String("block-size", "", "Print a JSON manifest of the hashes of blocks of the given size, such as 1M, for grab repair")
 	rootCmd.AddCommand(hashCmd)
 }
 
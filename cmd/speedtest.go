@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sebrandon1/grab/lib"
+	"github.com/spf13/cobra"
+)
+
+var speedtestDuration time.Duration
+
+var speedtestCmd = &cobra.Command{
+	Use:   "speedtest [url]",
+	Short: "Measure download throughput from a URL",
+	Long: `Measure the download throughput from a URL without saving the file.
+
+The URL is downloaded for at most the given duration and the content is
+discarded. The report includes the latency until the response headers were
+received and the average, sustained and peak transfer rates, which helps to
+tell a slow mirror from a slow local network.`,
+	Example: `  # Measure throughput for up to 10 seconds
+  grab speedtest https://go.dev/dl/go1.21.5.src.tar.gz
+
+  # Measure for 30 seconds
+  grab speedtest --duration 30s https://go.dev/dl/go1.21.5.src.tar.gz`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		client := lib.NewClient(lib.WithResponseHeaderTimeout(headerTimeout))
+		if userAgent != "" {
+			client.UserAgent = userAgent
+		}
+		result, err := client.SpeedTest(context.Background(), args[0], speedtestDuration)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Speed test failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Latency:   %v\n", result.Latency.Round(time.Millisecond))
+		fmt.Printf("Received:  %d bytes in %v\n", result.Bytes, result.Duration.Round(time.Millisecond))
+		fmt.Printf("Average:   %s\n", formatRate(result.Average))
+		fmt.Printf("Sustained: %s\n", formatRate(result.Sustained))
+		fmt.Printf("Peak:      %s\n", formatRate(result.Peak))
+	},
+}
+
+// formatRate formats a transfer rate in bytes per second using decimal units,
+// as network speeds are usually given, followed by the rate in Mbit/s.
+func formatRate(bps float64) string {
+	return fmt.Sprintf("%s (%.1f Mbit/s)", formatSpeed(bps, "%.2f"), bps*8/1e6)
+}
+
+// formatSpeed formats a transfer rate in bytes per second using decimal units
+// and the given verb for the value, such as "42.0 MB/s" for "%.1f".
+func formatSpeed(bps float64, verb string) string {
+	const unit = 1000
+	if bps < unit {
+		return fmt.Sprintf("%.0f B/s", bps)
+	}
+	units := []string{"kB/s", "MB/s", "GB/s", "TB/s"}
+	v, i := bps/unit, 0
+	for v >= unit && i < len(units)-1 {
+		v /= unit
+		i++
+	}
+	return fmt.Sprintf(verb+" %s", v, units[i])
+}
+
+func init() {
+	speedtestCmd.Flags().DurationVar(&speedtestDuration, "duration", 10*time.Second, "Maximum duration of the test")
+	speedtestCmd.Flags().DurationVar(&headerTimeout, "header-timeout", 0, "Fail if the server does not send response headers within this duration (0 for no limit)")
+	speedtestCmd.Flags().StringVarP(&userAgent, "user-agent", "A", "", "User-Agent to send; may contain {version}, {os}, {arch} and {url} placeholders")
+	rootCmd.AddCommand(speedtestCmd)
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sebrandon1/grab/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyOutput   string
+	verifyManifest string
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [url]",
+	Short: "Check whether a local file is a current and intact copy of a URL",
+	Long: `Check whether a local file is a current and intact copy of a remote file,
+without downloading or modifying it.
+
+The size, modification time and any checksum advertised by the server are
+compared with the local file, as well as the blocks of a block manifest if one
+is given. The exit code is 0 if the file is current and intact, and 4 if it is
+missing, stale or corrupted, which makes the command suitable for drift
+detection in scripts.`,
+	Example: `  # Check the copy in the current directory
+  grab verify https://go.dev/dl/go1.21.5.src.tar.gz
+
+  # Check a copy elsewhere against a block manifest
+  grab verify -o /data/image.iso --manifest image.iso.blocks https://example.com/image.iso`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := lib.NewRequest(verifyOutput, args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid URL: %v\n", err)
+			os.Exit(exitError)
+		}
+		if verifyManifest != "" {
+			b, err := os.ReadFile(verifyManifest)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot read manifest: %v\n", err)
+				os.Exit(exitError)
+			}
+			if req.BlockManifest, err = lib.ParseBlockManifest(b); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(exitError)
+			}
+		}
+		result, err := newDownloadClient(args[0]).Verify(req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Verify failed: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		if result.OK() {
+			fmt.Printf("OK: %s\n", result.Filename)
+			return
+		}
+		fmt.Printf("FAILED: %s\n", result.Filename)
+		for _, p := range result.Problems {
+			fmt.Printf("  %s\n", p)
+		}
+		os.Exit(exitChecksum)
+	},
+}
+
+func init() {
+	verifyCmd.Flags().StringVarP(&verifyOutput, "output", "o", "", "Path of the local file (default: named after the URL in the current directory)")
+	verifyCmd.Flags().StringVar(&verifyManifest, "manifest", "", "Also compare the file with the given block manifest, created with grab hash --block-size")
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func newArchiveMockClient() *mockHTTPClient {
+	mockClient := newMockHTTPClient()
+	for _, f := range []struct{ url, content string }{
+		{"http://example.com/a.txt", "first file"},
+		{"http://example.com/dir/b.txt", "second file"},
+	} {
+		resp := createMockHTTPResponse("200 OK", http.StatusOK, f.content,
+			map[string]string{"Last-Modified": "Wed, 21 Oct 2015 07:28:00 GMT"})
+		resp.Request, _ = http.NewRequest("GET", f.url, nil)
+		mockClient.addResponse("GET", f.url, resp)
+	}
+	return mockClient
+}
+
+func newArchiveRequests(t *testing.T) []*Request {
+	t.Helper()
+	a, err := NewRequest("", "http://example.com/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewRequest("files/", "http://example.com/dir/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*Request{a, b}
+}
+
+func TestClient_DoArchive_Tar(t *testing.T) {
+	client := &Client{HTTPClient: newArchiveMockClient(), UserAgent: "test-agent"}
+
+	var buf bytes.Buffer
+	responses, err := client.DoArchive(context.Background(), &buf, ArchiveTar, newArchiveRequests(t)...)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("Expected 2 responses, got %d", len(responses))
+	}
+
+	expect := map[string]string{"a.txt": "first file", "files/b.txt": "second file"}
+	tr := tar.NewReader(&buf)
+	n := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(tr)
+		if string(b) != expect[hdr.Name] {
+			t.Errorf("Expected entry %q to contain %q, got %q", hdr.Name, expect[hdr.Name], string(b))
+		}
+		if hdr.ModTime.Year() != 2015 {
+			t.Errorf("Expected remote modification time, got %v", hdr.ModTime)
+		}
+		n++
+	}
+	if n != len(expect) {
+		t.Errorf("Expected %d entries, got %d", len(expect), n)
+	}
+}
+
+func TestClient_DoArchive_Zip(t *testing.T) {
+	client := &Client{HTTPClient: newArchiveMockClient(), UserAgent: "test-agent"}
+
+	var buf bytes.Buffer
+	if _, err := client.DoArchive(context.Background(), &buf, ArchiveZip, newArchiveRequests(t)...); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{"a.txt": "first file", "files/b.txt": "second file"}
+	if len(zr.File) != len(expect) {
+		t.Fatalf("Expected %d entries, got %d", len(expect), len(zr.File))
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		_ = rc.Close()
+		if string(b) != expect[f.Name] {
+			t.Errorf("Expected entry %q to contain %q, got %q", f.Name, expect[f.Name], string(b))
+		}
+	}
+}
+
+func TestClient_DoArchive_Checksum(t *testing.T) {
+	client := &Client{HTTPClient: newArchiveMockClient(), UserAgent: "test-agent"}
+
+	reqs := newArchiveRequests(t)
+	good := sha256.Sum256([]byte("first file"))
+	reqs[0].SetChecksum(sha256.New(), good[:], false)
+	reqs[1].SetChecksum(sha256.New(), good[:], false)
+
+	responses, err := client.DoArchive(context.Background(), io.Discard, ArchiveZip, reqs...)
+	if !errors.Is(err, ErrBadChecksum) {
+		t.Fatalf("Expected %v, got %v", ErrBadChecksum, err)
+	}
+	if responses[0].Err() != nil {
+		t.Errorf("Unexpected error for first file: %v", responses[0].Err())
+	}
+}
+
+func TestClient_DoArchive_TarUnknownSize(t *testing.T) {
+	mockClient := newArchiveMockClient()
+	resp := createSuccessResponse("first file")
+	resp.ContentLength = -1
+	resp.Request, _ = http.NewRequest("GET", "http://example.com/a.txt", nil)
+	mockClient.addResponse("GET", "http://example.com/a.txt", resp)
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+	_, err := client.DoArchive(context.Background(), io.Discard, ArchiveTar, newArchiveRequests(t)...)
+	if !errors.Is(err, ErrArchiveSizeUnknown) {
+		t.Fatalf("Expected %v, got %v", ErrArchiveSizeUnknown, err)
+	}
+}
@@ -0,0 +1,195 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BatchOptions configures a batch of downloads executed by
+// Client.DoBatchWithOptions.
+type BatchOptions struct {
+	// Workers is the number of concurrent workers. If less than one, a worker
+	// is created for every request.
+	Workers int
+
+	// Priority weights the share of Client.BatchRateLimit of the batch while
+	// other batches of the Client are transferring at the same time. A batch
+	// with priority 2 receives twice the bandwidth of a batch with priority
+	// 1. Default: 1.
+	Priority int
+
+	// Ordered specifies that responses are sent in the order of the given
+	// requests, rather than in the order they are received from the remote
+	// servers. Responses received out of order are buffered until all
+	// earlier responses have been sent. Requests which are never started,
+	// because the batch was canceled, are skipped.
+	Ordered bool
+
+	// Atomic specifies that the files of the batch must be consistent as a
+	// set, such as the parts of a multi-file artifact. Each file is
+	// downloaded and verified next to its destination as a hidden staging
+	// file. Only once every transfer has succeeded, or was skipped by
+	// Request.SkipExisting, are the staged files moved into place with their
+	// sidecar metadata, replacing any existing files. Otherwise all staged
+	// files are discarded and the Responses of files which were downloaded
+	// successfully return ErrBatchAborted. Responses are only sent, and their
+	// Done channels closed, once the batch has been committed or discarded,
+	// so transfer progress cannot be monitored. Requests with NoStore set are
+	// not staged.
+	Atomic bool
+
+	// SoftQuota is a budget of bytes which the batch may transfer, such as to
+	// stay within capped cloud egress. Once it is reached, OnSoftQuota is
+	// called once with the number of bytes transferred and the batch
+	// continues. Zero means no soft quota.
+	SoftQuota int64
+
+	// OnSoftQuota is called from its own goroutine once the batch has
+	// transferred SoftQuota bytes.
+	OnSoftQuota func(bytes int64)
+
+	// HardQuota is a budget of bytes which the batch must not exceed, such as
+	// the free space of a disk. Once it is reached, no further transfers are
+	// started and transfers in progress are canceled, returning
+	// ErrQuotaExceeded. Bytes of resumed files which were already transferred
+	// do not count. The transferred bytes are counted periodically, so the
+	// quota may be exceeded by the amount transferred in a fraction of a
+	// second. Zero means no hard quota.
+	HardQuota int64
+
+	// Delay is the minimum time between the start of any two downloads of the
+	// batch. Zero means no delay.
+	Delay time.Duration
+
+	// HostDelay is the minimum time between the start of two downloads from
+	// the same host, such as the Crawl-delay of its robots.txt, so that
+	// recursive and manifest downloads do not trip rate limits. A download
+	// which waits for its host does not occupy a worker, so downloads from
+	// other hosts may start in the meantime. Zero means no delay.
+	HostDelay time.Duration
+
+	// HostDelays overrides HostDelay for the hosts given by name, such as
+	// "example.com", without a port. Subdomains are not matched.
+	HostDelays map[string]time.Duration
+}
+
+// DoBatchWithOptions is like DoBatch, with the behavior of the batch
+// configured by the given options.
+func (c *Client) DoBatchWithOptions(ctx context.Context, opts BatchOptions, requests ...*Request) <-chan *Response {
+	if c.BatchRateLimit > 0 {
+		requests = c.shareBandwidth(requests, opts.Priority)
+	}
+	respch := c.doBatchWithOptions(ctx, opts, requests...)
+	if c.ReportStore != nil {
+		return c.reportBatch(ctx, len(requests), respch)
+	}
+	return respch
+}
+
+// doBatchWithOptions implements DoBatchWithOptions, without uploading a
+// report of the batch.
+func (c *Client) doBatchWithOptions(ctx context.Context, opts BatchOptions, requests ...*Request) <-chan *Response {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = len(requests)
+	}
+	if opts.Delay > 0 || opts.HostDelay > 0 || len(opts.HostDelays) > 0 {
+		return c.doBatchPolite(ctx, opts, requests...)
+	}
+	if opts.SoftQuota > 0 || opts.HardQuota > 0 {
+		return c.doBatchQuota(ctx, opts, requests...)
+	}
+	if opts.Atomic {
+		return c.doBatchAtomic(ctx, opts, requests...)
+	}
+	if !opts.Ordered {
+		return c.doBatch(ctx, workers, requests...)
+	}
+	return c.doBatchOrdered(ctx, workers, requests...)
+}
+
+// doBatchOrdered executes the given requests like doBatch, but sends their
+// responses in request order.
+func (c *Client) doBatchOrdered(ctx context.Context, workers int, requests ...*Request) <-chan *Response {
+	requests = c.watchDevices(requests)
+
+	// each request has a slot which receives its response
+	slots := make([]chan *Response, len(requests))
+	for i := range requests {
+		slots[i] = make(chan *Response, 1)
+	}
+	jobs := make(chan int)
+	go func() {
+		schedule(ctx, requests, func(i int) bool {
+			select {
+			case jobs <- i:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		close(jobs)
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runWorker(ctx, i+1, func(ctx context.Context) {
+				for i := range jobs {
+					select {
+					case <-ctx.Done():
+						return
+					default:
+					}
+					resp := c.Do(requests[i].WithContext(ctx))
+					slots[i] <- resp
+					<-resp.finished
+				}
+			})
+		}()
+	}
+	workersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(workersDone)
+	}()
+
+	// send responses in order, skipping requests that were never started
+	respch := make(chan *Response, len(requests))
+	go func() {
+		for _, slot := range slots {
+			select {
+			case resp := <-slot:
+				respch <- resp
+			case <-workersDone:
+				select {
+				case resp := <-slot:
+					respch <- resp
+				default:
+				}
+			}
+		}
+		<-workersDone
+		close(respch)
+	}()
+	return respch
+}
+
+// shareBandwidth returns copies of the given requests which share the
+// transfer rate of a batch with the given priority, as a share of
+// Client.BatchRateLimit.
+func (c *Client) shareBandwidth(requests []*Request, priority int) []*Request {
+	c.batchLimiterOnce.Do(func() {
+		c.batchLimiter = NewFairLimiter(c.BatchRateLimit)
+	})
+	share := c.batchLimiter.Share(priority)
+	shared := make([]*Request, len(requests))
+	for i, req := range requests {
+		shared[i] = req.WithContext(req.Context())
+		shared[i].batchShare = share
+	}
+	return shared
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestClient_DoBatchWithOptions_Ordered(t *testing.T) {
+	mockClient := newMockHTTPClient()
+	var reqs []*Request
+	for i := 0; i < 8; i++ {
+		url := fmt.Sprintf("http://example.com/file%d", i)
+		resp := createSuccessResponse("")
+		// earlier requests are slower, so they complete last
+		resp.Body = io.NopCloser(&mockReader{data: []byte("test content"), readDelay: time.Duration(8-i) * 5 * time.Millisecond})
+		resp.ContentLength = 12
+		mockClient.addResponse("GET", url, resp)
+
+		req, _ := NewRequest("", url)
+		req.NoStore = true
+		req.Label = fmt.Sprint(i)
+		reqs = append(reqs, req)
+	}
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+	var labels []string
+	respch := client.DoBatchWithOptions(context.Background(), BatchOptions{Ordered: true}, reqs...)
+	for resp := range respch {
+		labels = append(labels, resp.Request.Label)
+		if err := resp.Err(); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if len(labels) != len(reqs) {
+		t.Fatalf("Expected %d responses, got %d", len(reqs), len(labels))
+	}
+	for i, label := range labels {
+		if label != fmt.Sprint(i) {
+			t.Fatalf("Expected responses in request order, got %v", labels)
+		}
+	}
+}
+
+func TestClient_DoBatchWithOptions_OrderedCanceled(t *testing.T) {
+	mockClient := newMockHTTPClient()
+	var reqs []*Request
+	for i := 0; i < 4; i++ {
+		url := fmt.Sprintf("http://example.com/file%d", i)
+		resp := createSuccessResponse("")
+		resp.Body = io.NopCloser(&mockReader{data: []byte("test content"), readDelay: 100 * time.Millisecond})
+		resp.ContentLength = 12
+		mockClient.addResponse("GET", url, resp)
+		req, _ := NewRequest("", url)
+		req.NoStore = true
+		reqs = append(reqs, req)
+	}
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	n := 0
+	for resp := range client.DoBatchWithOptions(ctx, BatchOptions{Workers: 1, Ordered: true}, reqs...) {
+		resp.Wait()
+		n++
+	}
+	if n == 0 || n == len(reqs) {
+		t.Errorf("Expected canceled batch to skip requests which were not started, got %d responses", n)
+	}
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// fetchBlockManifest sets the block manifest against which the file is
+// verified as it is written, fetching it from Request.BlockManifestURL if
+// needed, before continuing with next.
+func (c *Client) fetchBlockManifest(next stateFunc) stateFunc {
+	return func(resp *Response) stateFunc {
+		if resp.Request.BlockManifest == nil {
+			resp.logf("fetching block manifest from %s", redactURLString(resp.Request.BlockManifestURL))
+		}
+		resp.blocks, resp.err = c.blockManifest(resp.Request)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		return next
+	}
+}
+
+// A blockVerifier hashes the blocks of a file as they are written and fails
+// the write which completes a block that does not match the manifest.
+type blockVerifier struct {
+	m   *BlockManifest
+	h   hash.Hash
+	off int64 // offset in the file of the next byte written
+
+	// skip indicates that the start of the current block was not hashed, so
+	// that it cannot be verified.
+	skip bool
+}
+
+// newBlockVerifier returns a verifier of the blocks of the file of the
+// Response following the bytes which were resumed. A block which was only
+// partially written before is hashed from the existing file, if stored, or
+// else left unverified.
+func newBlockVerifier(resp *Response) (*blockVerifier, error) {
+	m := resp.blocks
+	if size := resp.Size(); size >= 0 && size != m.Size {
+		return nil, fmt.Errorf("%w: block manifest is for %d bytes, file has %d", ErrBadLength, m.Size, size)
+	}
+	v := &blockVerifier{m: m, h: blockHashes[m.Algorithm](), off: resp.bytesResumed}
+	start := v.off - v.off%m.BlockSize
+	if start == v.off {
+		return v, nil
+	}
+	if resp.Request.NoStore {
+		v.skip = true
+		return v, nil
+	}
+	f, err := os.Open(resp.Filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if _, err := io.Copy(v.h, io.NewSectionReader(f, start, v.off-start)); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *blockVerifier) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		i := int(v.off / v.m.BlockSize)
+		if i >= len(v.m.Blocks) {
+			return written, fmt.Errorf("%w: file is longer than %d bytes of block manifest", ErrBadLength, v.m.Size)
+		}
+		off, n := v.m.block(i)
+		k := int(min(int64(len(p)), off+n-v.off))
+		v.h.Write(p[:k])
+		v.off += int64(k)
+		written += k
+		p = p[k:]
+		if v.off < off+n {
+			break
+		}
+		want, _ := hex.DecodeString(v.m.Blocks[i])
+		if !v.skip && !bytes.Equal(v.h.Sum(nil), want) {
+			return written, &BlockMismatchError{Block: i, Offset: off}
+		}
+		v.h.Reset()
+		v.skip = false
+	}
+	return written, nil
+}
+
+// truncateBadBlock truncates the stored file of a Response whose transfer
+// failed with the given BlockMismatchError to the start of the corrupted
+// block, so that only the verified blocks are resumed.
+func truncateBadBlock(resp *Response, err *BlockMismatchError) {
+	resp.logf("block %d does not match manifest, truncating file to byte %d", err.Block, err.Offset)
+	t, ok := resp.writer.(truncater)
+	if !ok || resp.Request.NoStore || resp.special {
+		return
+	}
+	if err := t.Truncate(err.Offset); err != nil {
+		resp.logf("cannot truncate file: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClient_Do_BlockManifest(t *testing.T) {
+	content := make([]byte, 1<<20)
+	rand.New(rand.NewSource(2)).Read(content)
+	manifest, err := NewBlockManifest(bytes.NewReader(content), "sha256", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestJSON, _ := json.Marshal(manifest)
+	corrupt := bytes.Clone(content)
+	corrupt[5600] ^= 0xff // block 5
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file.bin.blocks":
+			_, _ = w.Write(manifestJSON)
+		case "/corrupt/file.bin":
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(corrupt))
+		default:
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+		}
+	}))
+	defer server.Close()
+
+	// the partial block of a resumed file is hashed from the file
+	filename := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(filename, content[:5500], 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient()
+	req, _ := NewRequest(filename, server.URL+"/corrupt/file.bin")
+	req.BlockManifestURL = server.URL + "/file.bin.blocks"
+	resp := client.Do(req)
+	var mismatch *BlockMismatchError
+	if err := resp.Err(); !errors.As(err, &mismatch) || !errors.Is(err, ErrBadChecksum) {
+		t.Fatalf("Expected BlockMismatchError, got %v", err)
+	}
+	if mismatch.Block != 5 || mismatch.Offset != 5000 {
+		t.Errorf("Expected mismatch of block 5 at byte 5000, got %+v", mismatch)
+	}
+	if n := resp.BytesComplete(); n >= int64(len(content))/2 {
+		t.Errorf("Expected transfer to abort early, got %d bytes", n)
+	}
+	if fi, err := os.Stat(filename); err != nil || fi.Size() != 5000 {
+		t.Fatalf("Expected file truncated to 5000 bytes, got %v", fi.Size())
+	}
+
+	// resume from the last good block
+	req, _ = NewRequest(filename, server.URL+"/file.bin")
+	req.BlockManifest = manifest
+	resp = client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.DidResume {
+		t.Error("Expected download to resume")
+	}
+	if got, _ := os.ReadFile(filename); !bytes.Equal(got, content) {
+		t.Error("Downloaded file does not match content")
+	}
+}
+
+func TestClient_Do_BlockManifestSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	manifest, _ := NewBlockManifest(bytes.NewReader([]byte("012345678")), "sha1", 4)
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file.bin"), server.URL)
+	req.BlockManifest = manifest
+	if err := NewClient().Do(req).Err(); !errors.Is(err, ErrBadLength) {
+		t.Errorf("Expected ErrBadLength, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"context"
+	"sync"
+)
+
+// byteBudget limits the total size of the transfer buffers of a Client, as
+// configured by Client.MaxBufferedBytes. Waiters are served in order, so that
+// large buffers are not starved by small ones.
+type byteBudget struct {
+	mu      sync.Mutex
+	used    int64
+	waiters []*budgetWaiter
+}
+
+// budgetWaiter is a transfer waiting for n bytes of the budget.
+type budgetWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// acquire blocks until n bytes of the budget of size max are available.
+func (b *byteBudget) acquire(ctx context.Context, n, max int64) error {
+	b.mu.Lock()
+	if len(b.waiters) == 0 && b.used+n <= max {
+		b.used += n
+		b.mu.Unlock()
+		return nil
+	}
+	w := &budgetWaiter{n: n, ready: make(chan struct{})}
+	b.waiters = append(b.waiters, w)
+	b.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		select {
+		case <-w.ready:
+			// acquired while canceled
+			b.used -= n
+			b.notify(max)
+		default:
+			for i, other := range b.waiters {
+				if other == w {
+					b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+					break
+				}
+			}
+			b.notify(max)
+		}
+		return ctx.Err()
+	}
+}
+
+// tryAcquire acquires n bytes of the budget of size max without blocking, and
+// reports whether it succeeded.
+func (b *byteBudget) tryAcquire(n, max int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.waiters) > 0 || b.used+n > max {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// release returns n bytes to the budget of size max.
+func (b *byteBudget) release(n, max int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	b.notify(max)
+}
+
+// notify wakes the waiters, in order, which fit into the budget.
+func (b *byteBudget) notify(max int64) {
+	for len(b.waiters) > 0 {
+		w := b.waiters[0]
+		if b.used+w.n > max {
+			return
+		}
+		b.used += w.n
+		b.waiters = b.waiters[1:]
+		close(w.ready)
+	}
+}
+
+// defaultBufferSize is the size of transfer buffers if neither
+// Request.BufferSize nor Client.BufferSize is set.
+const defaultBufferSize = 32 * 1024
+
+// A bufferReservation is the share of Client.MaxBufferedBytes held by a
+// transfer, from before its GET request is sent until it is closed, so that
+// a response is not left idle while the transfer waits for memory.
+type bufferReservation struct {
+	budget *byteBudget
+	max    int64
+
+	// size is the size of the transfer buffer to allocate.
+	size int64
+
+	mu       sync.Mutex
+	acquired int64
+}
+
+// reserveBuffer blocks until the transfer buffer of the given Response fits
+// into Client.MaxBufferedBytes, and reserves it. Buffers larger than the
+// budget are shrunk to fit. It returns nil if the budget is not limited. The
+// reservation must be released once the transfer is complete.
+func (c *Client) reserveBuffer(resp *Response) (*bufferReservation, error) {
+	max := c.MaxBufferedBytes
+	if max < 1 {
+		return nil, nil
+	}
+	if resp.bufferSize < 1 {
+		resp.bufferSize = defaultBufferSize
+	}
+	n := min(int64(resp.bufferSize), max)
+	if !c.buffers.tryAcquire(n, max) {
+		resp.logf("waiting for %d bytes of buffer memory", n)
+		if err := c.buffers.acquire(resp.ctx, n, max); err != nil {
+			return nil, err
+		}
+	}
+	return &bufferReservation{budget: &c.buffers, max: max, size: n, acquired: n}, nil
+}
+
+// allocate allocates the reserved transfer buffer of the given transfer, and
+// lets it grow as long as the budget allows.
+func (r *bufferReservation) allocate(t *transfer) {
+	r.mu.Lock()
+	if r.acquired > r.size {
+		// a previous transfer of the Response grew its buffer
+		r.budget.release(r.acquired-r.size, r.max)
+		r.acquired = r.size
+	}
+	r.mu.Unlock()
+	t.b = make([]byte, r.size)
+	t.grow = func(extra int) bool {
+		if !r.budget.tryAcquire(int64(extra), r.max) {
+			return false
+		}
+		r.mu.Lock()
+		r.acquired += int64(extra)
+		r.mu.Unlock()
+		return true
+	}
+}
+
+// release returns the reserved bytes to the budget. It is a no-op for a nil
+// reservation.
+func (r *bufferReservation) release() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.budget.release(r.acquired, r.max)
+	r.acquired = 0
+}
@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestByteBudget(t *testing.T) {
+	var b byteBudget
+	ctx := context.Background()
+	if err := b.acquire(ctx, 60, 100); err != nil {
+		t.Fatal(err)
+	}
+	if b.tryAcquire(60, 100) {
+		t.Fatal("Expected budget to be exhausted")
+	}
+
+	acquired := make(chan error)
+	go func() { acquired <- b.acquire(ctx, 60, 100) }()
+	select {
+	case <-acquired:
+		t.Fatal("Expected acquire to block")
+	case <-time.After(20 * time.Millisecond):
+	}
+	b.release(60, 100)
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := b.acquire(cctx, 60, 100); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	b.release(60, 100)
+	if b.used != 0 || len(b.waiters) != 0 {
+		t.Errorf("Expected empty budget, got %d bytes used and %d waiters", b.used, len(b.waiters))
+	}
+}
+
+func TestClient_MaxBufferedBytes(t *testing.T) {
+	mockClient := newMockHTTPClient()
+	var reqs []*Request
+	for i := 0; i < 2; i++ {
+		url := fmt.Sprintf("http://example.com/file%d", i)
+		resp := createSuccessResponse("")
+		resp.Body = io.NopCloser(&mockReader{data: []byte(strings.Repeat("x", 500)), readDelay: 10 * time.Millisecond})
+		resp.ContentLength = 500
+		mockClient.addResponse("GET", url, resp)
+		req, _ := NewRequest("", url)
+		req.NoStore = true
+		req.BufferSize = 100
+		reqs = append(reqs, req)
+	}
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent", MaxBufferedBytes: 150}
+
+	waited := 0
+	for resp := range client.DoBatch(context.Background(), 0, reqs...) {
+		if err := resp.Err(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if b, _ := resp.Bytes(); len(b) != 500 {
+			t.Errorf("Expected 500 bytes, got %d", len(b))
+		}
+		for _, e := range resp.Events() {
+			if strings.HasPrefix(e.Message, "waiting for 100 bytes of buffer memory") {
+				waited++
+			}
+		}
+	}
+	if waited != 1 {
+		t.Errorf("Expected one transfer to wait for buffer memory, got %d", waited)
+	}
+	if client.buffers.used != 0 {
+		t.Errorf("Expected all buffer memory to be released, got %d bytes", client.buffers.used)
+	}
+}
+
+func TestClient_MaxBufferedBytes_BeforeRequest(t *testing.T) {
+	httpClient := &concurrencyHTTPClient{}
+	client := &Client{HTTPClient: httpClient, UserAgent: "test-agent", MaxBufferedBytes: 150}
+	var reqs []*Request
+	for i := 0; i < 4; i++ {
+		req, _ := NewRequest("", fmt.Sprintf("http://example.com/file%d", i))
+		req.NoStore = true
+		req.BufferSize = 100
+		reqs = append(reqs, req)
+	}
+	for resp := range client.DoBatch(context.Background(), 0, reqs...) {
+		if err := resp.Err(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// a transfer waiting for buffer memory has not sent its GET request
+	if httpClient.maxOpen != 1 {
+		t.Errorf("Expected one GET request at a time, got %d", httpClient.maxOpen)
+	}
+	if client.buffers.used != 0 {
+		t.Errorf("Expected all buffer memory to be released, got %d bytes", client.buffers.used)
+	}
+}
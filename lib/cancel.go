@@ -0,0 +1,162 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CancelReason describes why a download was canceled.
+type CancelReason int
+
+const (
+	// NotCanceled indicates that the download was not canceled.
+	NotCanceled CancelReason = iota
+
+	// CanceledByCaller indicates that the download was canceled by
+	// Response.Cancel or by canceling the context of the Request.
+	CanceledByCaller
+
+	// CanceledByDeadline indicates that the deadline of the context of the
+	// Request expired.
+	CanceledByDeadline
+
+	// CanceledByStall indicates that no data was received for
+	// Client.StallTimeout.
+	CanceledByStall
+
+	// CanceledByShutdown indicates that the download was canceled by
+	// Client.Shutdown.
+	CanceledByShutdown
+)
+
+func (r CancelReason) String() string {
+	switch r {
+	case NotCanceled:
+		return "not canceled"
+	case CanceledByCaller:
+		return "canceled"
+	case CanceledByDeadline:
+		return "deadline exceeded"
+	case CanceledByStall:
+		return "stalled"
+	case CanceledByShutdown:
+		return "client shut down"
+	}
+	return "unknown"
+}
+
+// CancelError is the error of a download which was canceled for a reason of
+// its own, such as a stall or Client.Shutdown, recording why. A download
+// whose context was canceled by the caller, or whose deadline expired, fails
+// with the error of the context instead. A CancelError unwraps to
+// context.Canceled, so that existing checks of this error continue to match.
+type CancelError struct {
+	Reason CancelReason
+	Err    error
+}
+
+func (err *CancelError) Error() string {
+	return "download " + err.Reason.String()
+}
+
+func (err *CancelError) Unwrap() error {
+	return err.Err
+}
+
+// CancelReason returns why the download was canceled, or NotCanceled if it
+// completed or failed otherwise. It blocks until the download is complete.
+func (c *Response) CancelReason() CancelReason {
+	err := c.Err()
+	var cancelErr *CancelError
+	switch {
+	case errors.As(err, &cancelErr):
+		return cancelErr.Reason
+	case err == context.Canceled:
+		return CanceledByCaller
+	case err == context.DeadlineExceeded:
+		return CanceledByDeadline
+	}
+	return NotCanceled
+}
+
+// watchStall cancels the transfer of the Response with CanceledByStall if no
+// data is received for Client.StallTimeout. Time spent waiting for a rate
+// limiter does not count. The returned function stops watching.
+func (c *Client) watchStall(resp *Response) (stop func()) {
+	timeout := c.StallTimeout
+	if timeout <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		t := time.NewTicker(max(timeout/4, 10*time.Millisecond))
+		defer t.Stop()
+		last, since := resp.transfer.N(), time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				if n := resp.transfer.N(); n != last || resp.transfer.waiting() {
+					last, since = n, now
+				} else if now.Sub(since) >= timeout {
+					resp.logf("no data received for %v, aborting", timeout)
+					resp.abort(&CancelError{Reason: CanceledByStall, Err: context.Canceled})
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// activeSet tracks the downloads of a Client which are in progress, so that
+// they can be canceled by Client.Shutdown.
+type activeSet struct {
+	mu       sync.Mutex
+	shutdown bool
+	active   map[*Response]struct{}
+}
+
+// add tracks the given Response until it is removed, or cancels it at once if
+// the Client was shut down.
+func (s *activeSet) add(resp *Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		resp.abort(&CancelError{Reason: CanceledByShutdown, Err: context.Canceled})
+		return
+	}
+	if s.active == nil {
+		s.active = make(map[*Response]struct{})
+	}
+	s.active[resp] = struct{}{}
+}
+
+// remove stops tracking the given Response.
+func (s *activeSet) remove(resp *Response) {
+	s.mu.Lock()
+	delete(s.active, resp)
+	s.mu.Unlock()
+}
+
+// Shutdown cancels all downloads of the Client which are in progress, and any
+// started later, with CanceledByShutdown, such as when the program is
+// exiting. It does not wait for the downloads to close.
+func (c *Client) Shutdown() {
+	s := &c.active
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = true
+	for resp := range s.active {
+		resp.abort(&CancelError{Reason: CanceledByShutdown, Err: context.Canceled})
+	}
+	s.active = nil
+}
@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// hangingServer returns a server which sends the first bytes of a body and
+// then stops sending until the test ends.
+func hangingServer(t *testing.T) *httptest.Server {
+	stop := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		_, _ = w.Write(make([]byte, 100))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(stop)
+		server.Close()
+	})
+	return server
+}
+
+func TestResponse_CancelReason(t *testing.T) {
+	server := hangingServer(t)
+	dir := t.TempDir()
+
+	t.Run("Caller", func(t *testing.T) {
+		req, _ := NewRequest(filepath.Join(dir, "caller"), server.URL)
+		resp := NewClient().Do(req)
+		time.Sleep(50 * time.Millisecond)
+		resp.Cancel()
+		if err := resp.Err(); err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		if got := resp.CancelReason(); got != CanceledByCaller {
+			t.Errorf("Expected %v, got %v", CanceledByCaller, got)
+		}
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		req, _ := NewRequest(filepath.Join(dir, "deadline"), server.URL)
+		resp := NewClient().Do(req.WithContext(ctx))
+		if err := resp.Err(); err != context.DeadlineExceeded {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+		if got := resp.CancelReason(); got != CanceledByDeadline {
+			t.Errorf("Expected %v, got %v", CanceledByDeadline, got)
+		}
+	})
+
+	t.Run("Stall", func(t *testing.T) {
+		client := NewClient()
+		client.StallTimeout = 100 * time.Millisecond
+		req, _ := NewRequest(filepath.Join(dir, "stall"), server.URL)
+		resp := client.Do(req)
+		if err := resp.Err(); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		if got := resp.CancelReason(); got != CanceledByStall {
+			t.Errorf("Expected %v, got %v", CanceledByStall, got)
+		}
+		if !hasEvent(resp, "no data received for 100ms, aborting") {
+			t.Error("Expected stall event")
+		}
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		client := NewClient()
+		req, _ := NewRequest(filepath.Join(dir, "shutdown"), server.URL)
+		resp := client.Do(req)
+		time.Sleep(50 * time.Millisecond)
+		client.Shutdown()
+		if got := resp.CancelReason(); got != CanceledByShutdown {
+			t.Errorf("Expected %v, got %v", CanceledByShutdown, got)
+		}
+
+		// downloads started after shutdown are canceled at once
+		req, _ = NewRequest(filepath.Join(dir, "later"), server.URL)
+		resp = client.Do(req)
+		if got := resp.CancelReason(); got != CanceledByShutdown {
+			t.Errorf("Expected %v for later download, got %v", CanceledByShutdown, got)
+		}
+	})
+
+	t.Run("Completed", func(t *testing.T) {
+		resp := &Response{Done: make(chan struct{})}
+		close(resp.Done)
+		if got := resp.CancelReason(); got != NotCanceled {
+			t.Errorf("Expected %v, got %v", NotCanceled, got)
+		}
+	})
+}
+
+func TestClient_Do_StallRateLimited(t *testing.T) {
+	content := make([]byte, 14000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// waiting for the rate limiter takes longer than the stall timeout
+	client := NewClient()
+	client.StallTimeout = 100 * time.Millisecond
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file"), server.URL)
+	req.RateLimiter = NewRateLimiter(10000)
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("Expected rate limited download not to stall, got %v", err)
+	}
+}
+
+func TestClient_Do_CanceledAfterFailure(t *testing.T) {
+	server := hangingServer(t)
+	errHook := errors.New("hook failed")
+
+	// the request fails for another reason as it is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file"), server.URL)
+	req.BeforeCopy = func(*Response) error {
+		cancel()
+		return errHook
+	}
+	resp := NewClient().Do(req.WithContext(ctx))
+	if err := resp.Err(); !errors.Is(err, errHook) {
+		t.Errorf("Expected error of hook, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"encoding"
+	"time"
+)
+
+// A Checkpoint records the progress of a download, so that it can be
+// persisted by the caller, such as to a database, and the download resumed
+// later or on another machine which has the partially downloaded file. It is
+// marshaled to JSON by encoding/json.
+type Checkpoint struct {
+	// URL is the URL from which the file is downloaded, after any redirects.
+	URL string `json:"url"`
+
+	// Filename is the path of the destination file.
+	Filename string `json:"filename,omitempty"`
+
+	// Offset is the number of bytes of the file which were written and synced
+	// to the destination.
+	Offset int64 `json:"offset"`
+
+	// Size is the size of the remote file in bytes, or -1 if unknown.
+	Size int64 `json:"size"`
+
+	// ETag and LastModified are the validators of the remote file, if sent by
+	// the server.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+
+	// HashState is the marshaled state of the checksum set by
+	// Request.SetChecksum after hashing the first Offset bytes, if the hash
+	// implements encoding.BinaryMarshaler.
+	HashState []byte `json:"hash_state,omitempty"`
+
+	// Time is when the checkpoint was taken.
+	Time time.Time `json:"time"`
+}
+
+// checkpointer calls Request.OnCheckpoint as the transfer of a Response
+// progresses.
+type checkpointer struct {
+	resp     *Response
+	bytes    int64
+	interval time.Duration
+
+	written    int64
+	lastOffset int64
+	lastTime   time.Time
+}
+
+// newCheckpointer returns a checkpointer for the given Response, or nil if no
+// checkpoints were requested.
+func newCheckpointer(resp *Response) *checkpointer {
+	req := resp.Request
+	if req.OnCheckpoint == nil || (req.CheckpointBytes < 1 && req.CheckpointInterval <= 0) {
+		return nil
+	}
+	if req.hasRange || len(req.Filters) > 0 {
+		// offsets of ranged and filtered downloads cannot be resumed
+		return nil
+	}
+	return &checkpointer{
+		resp:       resp,
+		bytes:      req.CheckpointBytes,
+		interval:   req.CheckpointInterval,
+		lastOffset: resp.bytesResumed,
+		lastTime:   time.Now(),
+	}
+}
+
+// wrote is called by the transfer after each write, with the number of bytes
+// written so far, and takes a checkpoint once CheckpointBytes were written or
+// CheckpointInterval elapsed since the last.
+func (cp *checkpointer) wrote(written int64) {
+	cp.written = written
+	offset := cp.resp.bytesResumed + written
+	if offset == cp.lastOffset {
+		return
+	}
+	now := time.Now()
+	if (cp.bytes < 1 || offset-cp.lastOffset < cp.bytes) &&
+		(cp.interval <= 0 || now.Sub(cp.lastTime) < cp.interval) {
+		return
+	}
+	cp.take(offset, now)
+}
+
+// take syncs the destination and calls Request.OnCheckpoint with a
+// checkpoint at the given offset. No checkpoint is taken if the destination
+// cannot be synced.
+func (cp *checkpointer) take(offset int64, now time.Time) {
+	resp := cp.resp
+	if s, ok := resp.writer.(interface{ Sync() error }); ok {
+		if err := s.Sync(); err != nil {
+			resp.logf("cannot sync for checkpoint: %v", err)
+			return
+		}
+	}
+	cp.lastOffset, cp.lastTime = offset, now
+	c := Checkpoint{
+		URL:      resp.Request.URL().String(),
+		Filename: resp.Filename,
+		Offset:   offset,
+		Size:     resp.Size(),
+		Time:     now,
+	}
+	if hresp := resp.HTTPResponse; hresp != nil {
+		c.URL = hresp.Request.URL.String()
+		c.ETag = hresp.Header.Get("ETag")
+		c.LastModified = hresp.Header.Get("Last-Modified")
+	}
+	if m, ok := resp.Request.hash.(encoding.BinaryMarshaler); ok && (resp.hashed || resp.streamed) {
+		c.HashState, _ = m.MarshalBinary()
+	}
+	resp.Request.OnCheckpoint(resp, c)
+}
+
+// finish takes a final checkpoint once the transfer is interrupted, unless
+// one was already taken at the same offset.
+func (cp *checkpointer) finish() {
+	if cp == nil {
+		return
+	}
+	if offset := cp.resp.bytesResumed + cp.written; offset > cp.lastOffset {
+		cp.take(offset, time.Now())
+	}
+}
+
+// restoreCheckpoint restores the hash state of Request.ResumeCheckpoint if it
+// was taken at the offset from which the download resumes, and reports
+// whether it is valid.
+func restoreCheckpoint(resp *Response) bool {
+	c := resp.Request.ResumeCheckpoint
+	if c == nil || c.Offset != resp.bytesResumed || len(c.HashState) == 0 {
+		return false
+	}
+	u, ok := resp.Request.hash.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return false
+	}
+	if err := u.UnmarshalBinary(c.HashState); err != nil {
+		resp.Request.hash.Reset()
+		return false
+	}
+	resp.logf("restored checksum state from checkpoint at byte %d", c.Offset)
+	return true
+}
+
+// checkpointChanged reports whether the remote file of the given Response
+// differs from the file of Request.ResumeCheckpoint, as shown by its
+// validators.
+func checkpointChanged(resp *Response) bool {
+	c := resp.Request.ResumeCheckpoint
+	if c == nil || resp.HTTPResponse == nil {
+		return false
+	}
+	h := resp.HTTPResponse.Header
+	if c.ETag != "" && h.Get("ETag") != "" {
+		return c.ETag != h.Get("ETag")
+	}
+	return c.LastModified != "" && h.Get("Last-Modified") != "" && c.LastModified != h.Get("Last-Modified")
+}
@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClient_Do_Checkpoint(t *testing.T) {
+	content := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(content)
+	sum := sha256.Sum256(content)
+	server, _, _ := newFlakyServer(t, content, 50000)
+
+	// the first download is interrupted after 50000 bytes
+	var checkpoints []Checkpoint
+	client := NewClient()
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file.bin"), server.URL+"/file.bin")
+	req.BufferSize = 4096
+	req.SetChecksum(sha256.New(), sum[:], false)
+	req.CheckpointBytes = 20000
+	req.OnCheckpoint = func(resp *Response, c Checkpoint) {
+		checkpoints = append(checkpoints, c)
+	}
+	if err := client.Do(req).Err(); err == nil {
+		t.Fatal("Expected interrupted download to fail")
+	}
+	if len(checkpoints) != 3 {
+		t.Fatalf("Expected 3 checkpoints, got %d", len(checkpoints))
+	}
+	for i, c := range checkpoints[:2] {
+		if c.Offset < int64(i+1)*20000 || c.Offset > int64(i+1)*20000+4096 {
+			t.Errorf("Checkpoint %d: unexpected offset %d", i, c.Offset)
+		}
+	}
+	last := checkpoints[2]
+	if last.Offset != 50000 || last.Size != 100000 || last.ETag != `"1"` || len(last.HashState) == 0 {
+		t.Errorf("Unexpected final checkpoint: %+v", last)
+	}
+
+	// another machine resumes from the persisted checkpoint and partial file
+	b, _ := json.Marshal(last)
+	var restored Checkpoint
+	if err := json.Unmarshal(b, &restored); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(filename, content[:50000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	req, _ = NewRequest(filename, restored.URL)
+	req.SetChecksum(sha256.New(), sum[:], false)
+	req.ResumeCheckpoint = &restored
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.DidResume || !hasEvent(resp, "restored checksum state from checkpoint at byte 50000") {
+		t.Errorf("Expected download to resume from checkpoint, events: %v", resp.Events())
+	}
+	if got, _ := os.ReadFile(filename); !bytes.Equal(got, content) {
+		t.Error("Resumed file does not match remote file")
+	}
+}
+
+func TestClient_Do_CheckpointChanged(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 10000)
+	server, _, _ := newFlakyServer(t, content, 0)
+
+	filename := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(filename, bytes.Repeat([]byte("b"), 5000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := NewRequest(filename, server.URL+"/file.bin")
+	req.ResumeCheckpoint = &Checkpoint{Offset: 5000, ETag: `"0"`}
+	resp := NewClient().Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if resp.DidResume {
+		t.Error("Expected changed remote file to be downloaded again")
+	}
+	if got, _ := os.ReadFile(filename); !bytes.Equal(got, content) {
+		t.Error("Downloaded file does not match remote file")
+	}
+}
+
+// hasEvent reports whether the given Response recorded an event with the
+// given message.
+func hasEvent(resp *Response, message string) bool {
+	for _, e := range resp.Events() {
+		if e.Message == message {
+			return true
+		}
+	}
+	return false
+}
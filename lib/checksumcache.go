@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// A ChecksumCache remembers the checksums of local files, so that repeated
+// runs over the same files, such as a mirror which is updated periodically,
+// do not need to hash every existing file again to validate it. Entries are
+// keyed by algorithm, path, size and modification time, so they are
+// invalidated as soon as a file changes. Checksums computed with hashes whose
+// algorithm cannot be named, such as CRC-32 with a custom table or HMAC, are
+// not cached.
+//
+// A ChecksumCache is safe for concurrent use. Use LoadChecksumCache and
+// ChecksumCache.Save to keep the cache between runs.
+type ChecksumCache struct {
+	mu      sync.Mutex
+	entries map[string]checksumEntry
+}
+
+// checksumEntry is the cached checksum of a file.
+type checksumEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Sum     []byte    `json:"sum"`
+}
+
+// NewChecksumCache returns an empty ChecksumCache.
+func NewChecksumCache() *ChecksumCache {
+	return &ChecksumCache{entries: make(map[string]checksumEntry)}
+}
+
+// LoadChecksumCache reads a ChecksumCache previously written by
+// ChecksumCache.Save. If the file does not exist, an empty cache is returned.
+func LoadChecksumCache(name string) (*ChecksumCache, error) {
+	c := NewChecksumCache()
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &c.entries); err != nil {
+		return nil, fmt.Errorf("invalid checksum cache %s: %v", name, err)
+	}
+	return c, nil
+}
+
+// Save writes the cache to the named file, replacing it atomically. The file
+// is only readable by its owner.
+func (c *ChecksumCache) Save(name string) error {
+	c.mu.Lock()
+	b, err := json.Marshal(c.entries)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFilePrivate(name, b)
+}
+
+// Len returns the number of cached checksums.
+func (c *ChecksumCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// get returns the cached checksum of the given file, computed with the named
+// algorithm, if the file is unchanged.
+func (c *ChecksumCache) get(name, algorithm string, fi os.FileInfo) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[algorithm+":"+name]
+	if !ok || e.Size != fi.Size() || !e.ModTime.Equal(fi.ModTime()) {
+		return nil, false
+	}
+	return e.Sum, true
+}
+
+// put caches the checksum of the given file, computed with the named
+// algorithm.
+func (c *ChecksumCache) put(name, algorithm string, fi os.FileInfo, sum []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]checksumEntry)
+	}
+	c.entries[algorithm+":"+name] = checksumEntry{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Sum:     sum,
+	}
+}
+
+// checksumHashes maps the names of the algorithms which checksumAlgorithm
+// identifies to their hashes. Each has a distinct type or size, unlike
+// variants of CRC-32 with different tables, or HMACs with different keys,
+// which cannot be told apart and so are never cached.
+var checksumHashes = map[string]func() hash.Hash{
+	"md5":        md5.New,
+	"sha1":       sha1.New,
+	"sha224":     sha256.New224,
+	"sha256":     sha256.New,
+	"sha384":     sha512.New384,
+	"sha512":     sha512.New,
+	"sha512/224": sha512.New512_224,
+	"sha512/256": sha512.New512_256,
+}
+
+// checksumAlgorithm returns the name of the algorithm of h, by which its
+// checksums are cached, or an empty string if it cannot be named.
+func checksumAlgorithm(h hash.Hash) string {
+	if h == nil {
+		return ""
+	}
+	for name, newHash := range checksumHashes {
+		if g := newHash(); reflect.TypeOf(g) == reflect.TypeOf(h) && g.Size() == h.Size() {
+			return name
+		}
+	}
+	return ""
+}
+
+// cachedChecksum returns the checksum of the downloaded file of the given
+// Response, using and updating the ChecksumCache of the client if set.
+func (c *Client) cachedChecksum(resp *Response) ([]byte, error) {
+	cache := c.ChecksumCache
+	algorithm := resp.Request.hashAlgorithm
+	if cache == nil || algorithm == "" || resp.Request.NoStore || resp.streamed || resp.special {
+		return resp.checksumUnsafe()
+	}
+	name, err := filepath.Abs(resp.Filename)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if sum, ok := cache.get(name, algorithm, fi); ok {
+		return sum, nil
+	}
+	sum, err := resp.checksumUnsafe()
+	if err != nil {
+		return nil, err
+	}
+	// only cache the checksum if the file did not change while it was hashed
+	if after, err := os.Stat(name); err == nil && after.Size() == fi.Size() && after.ModTime().Equal(fi.ModTime()) {
+		cache.put(name, algorithm, fi, sum)
+	}
+	return sum, nil
+}
@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClient_ChecksumCache(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(filename, []byte("test content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("test content"))
+	cache := NewChecksumCache()
+	client := &Client{HTTPClient: newMockHTTPClient(), UserAgent: "test-agent", ChecksumCache: cache}
+	validate := func() error {
+		req, _ := NewRequest(filename, "http://example.com/file.txt")
+		req.SetChecksum(sha256.New(), sum[:], false)
+		return client.Do(req).Err()
+	}
+
+	if err := validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("Expected checksum to be cached, got %d entries", cache.Len())
+	}
+
+	// a cached checksum is used without hashing the file again
+	fi, _ := os.Stat(filename)
+	cache.put(filename, "sha256", fi, []byte("bogus"))
+	if err := validate(); err != ErrBadChecksum {
+		t.Errorf("Expected cached checksum to be used, got %v", err)
+	}
+
+	// changing the file invalidates the cached checksum
+	mtime := fi.ModTime().Add(-time.Hour)
+	if err := os.Chtimes(filename, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := validate(); err != nil {
+		t.Errorf("Expected modified file to be hashed again, got %v", err)
+	}
+}
+
+func TestChecksumCache_SaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(filename, []byte("test content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(filename)
+	cacheFile := filepath.Join(dir, "checksums.json")
+
+	cache, err := LoadChecksumCache(cacheFile)
+	if err != nil || cache.Len() != 0 {
+		t.Fatalf("Expected empty cache for missing file, got %d entries (%v)", cache.Len(), err)
+	}
+	cache.put(filename, "sha256", fi, []byte("sum"))
+	if err := cache.Save(cacheFile); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	loaded, err := LoadChecksumCache(cacheFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sum, ok := loaded.get(filename, "sha256", fi); !ok || string(sum) != "sum" {
+		t.Errorf("Expected cached checksum after reload, got %q (%v)", sum, ok)
+	}
+	if _, ok := loaded.get(filename, "sha224", fi); ok {
+		t.Error("Expected checksums of other hashes not to match")
+	}
+	if fi, err := os.Stat(cacheFile); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected cache only readable by its owner, got %v", fi.Mode())
+	}
+}
+
+func TestClient_ChecksumCache_Unnamed(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "file.txt")
+	content := []byte("test content")
+	if err := os.WriteFile(filename, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write(content)
+	castagnoli := crc32.MakeTable(crc32.Castagnoli)
+	tests := []struct {
+		name string
+		hash func() hash.Hash
+		sum  []byte
+	}{
+		{"crc32", func() hash.Hash { return crc32.NewIEEE() }, binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(content))},
+		{"crc32c", func() hash.Hash { return crc32.New(castagnoli) }, binary.BigEndian.AppendUint32(nil, crc32.Checksum(content, castagnoli))},
+		{"hmac", func() hash.Hash { return hmac.New(sha256.New, []byte("key")) }, mac.Sum(nil)},
+	}
+	cache := NewChecksumCache()
+	client := &Client{HTTPClient: newMockHTTPClient(), UserAgent: "test-agent", ChecksumCache: cache}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := NewRequest(filename, "http://example.com/file.txt")
+			req.SetChecksum(tt.hash(), tt.sum, false)
+			if err := client.Do(req).Err(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if cache.Len() != 0 {
+				t.Errorf("Expected checksum of unnamed hash not to be cached, got %d entries", cache.Len())
+			}
+		})
+	}
+}
+
+func TestChecksumAlgorithm(t *testing.T) {
+	tests := []struct {
+		hash hash.Hash
+		want string
+	}{
+		{sha256.New(), "sha256"},
+		{sha256.New224(), "sha224"},
+		{sha512.New512_256(), "sha512/256"},
+		{md5.New(), "md5"},
+		{crc32.NewIEEE(), ""},
+		{hmac.New(sha256.New, []byte("key")), ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := checksumAlgorithm(tt.hash); got != tt.want {
+			t.Errorf("checksumAlgorithm(%T) = %q, want %q", tt.hash, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A checksumLine is an entry of a checksum file: a checksum and the name of the
+// file it belongs to, which is empty for a file holding a bare checksum.
+type checksumLine struct {
+	name string
+	sum  []byte
+}
+
+// parseChecksums parses a checksum file in the format written by sha256sum,
+// md5sum and similar tools: a hex encoded checksum, a space, a space or * for
+// binary mode, and the file name, which may contain spaces. Lines of names
+// which contain a backslash or newline start with a backslash, and escape
+// those as \\ and \n. A line may also hold only a checksum. Blank lines and
+// comments starting with # are ignored.
+func parseChecksums(r io.Reader) ([]checksumLine, error) {
+	var entries []checksumLine
+	s := bufio.NewScanner(io.LimitReader(r, maxChecksumFileSize))
+	for s.Scan() {
+		line := strings.TrimSuffix(s.Text(), "\r")
+		if t := strings.TrimSpace(line); t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		escaped := strings.HasPrefix(line, "\\")
+		if escaped {
+			line = line[1:]
+		}
+		digest, name, _ := strings.Cut(strings.TrimRight(line, " \t"), " ")
+		sum, err := hex.DecodeString(digest)
+		if err != nil || len(sum) == 0 {
+			return nil, fmt.Errorf("invalid checksum line: %q", s.Text())
+		}
+		if name != "" && (name[0] == ' ' || name[0] == '*') {
+			name = name[1:]
+		}
+		if escaped {
+			if name, err = unescapeChecksumName(name); err != nil {
+				return nil, fmt.Errorf("invalid checksum line: %q", s.Text())
+			}
+		}
+		entries = append(entries, checksumLine{name: name, sum: sum})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// unescapeChecksumName decodes a file name escaped by sha256sum.
+func unescapeChecksumName(name string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '\\' {
+			b.WriteByte(name[i])
+			continue
+		}
+		if i++; i == len(name) {
+			return "", errors.New("trailing backslash")
+		}
+		switch name[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			return "", fmt.Errorf("invalid escape \\%c", name[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// ParseChecksumFile parses a checksum file in the format written by sha256sum,
+// md5sum and similar tools, such as SHA256SUMS, and returns the hex decoded
+// checksum of each file it lists, keyed by the file name as written, after
+// undoing the escaping of sha256sum. Blank lines and comments starting with #
+// are ignored.
+func ParseChecksumFile(r io.Reader) (map[string][]byte, error) {
+	entries, err := parseChecksums(r)
+	if err != nil {
+		return nil, err
+	}
+	sums := make(map[string][]byte)
+	for _, e := range entries {
+		if e.name == "" {
+			return nil, fmt.Errorf("invalid checksum line: missing file name for %x", e.sum)
+		}
+		sums[e.name] = e.sum
+	}
+	return sums, nil
+}
+
+// SetChecksumFromFile is like SetChecksum, but the expected checksum is read
+// from a local checksum file, such as SHA256SUMS or MD5SUMS, in the format
+// written by sha256sum. The entry matching the file name of the request URL
+// is used, or else the one matching the base name of Filename. A file which
+// holds a single checksum without a file name is used as is. If the file has no matching checksum
+// of the size of h, ErrNoChecksum is returned and the Request is unchanged.
+func (r *Request) SetChecksumFromFile(h hash.Hash, filename string, deleteOnError bool) error {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	sum, err := checksumFor(b, path.Base(r.URL().Path), h.Size())
+	if err != nil && r.Filename != "" && !strings.HasSuffix(r.Filename, string(filepath.Separator)) {
+		sum, err = checksumFor(b, filepath.Base(r.Filename), h.Size())
+	}
+	if err != nil {
+		return err
+	}
+	r.SetChecksum(h, sum, deleteOnError)
+	return nil
+}
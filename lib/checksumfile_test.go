@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseChecksumFile(t *testing.T) {
+	a := strings.Repeat("a", 64)
+	b := strings.Repeat("b", 32)
+	file := "# release 1.0\n\n" + a + "  dist/file.iso\n" + b + " *file.txt\r\n" +
+		a + "  my file.iso\n" + "\\" + b + "  back\\\\slash\\nnewline.txt\n"
+	sums, err := ParseChecksumFile(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"dist/file.iso": a, "file.txt": b, "my file.iso": a, "back\\slash\nnewline.txt": b}
+	if len(sums) != len(want) {
+		t.Errorf("Unexpected checksums: %x", sums)
+	}
+	for name, sum := range want {
+		if hex.EncodeToString(sums[name]) != sum {
+			t.Errorf("Expected checksum %s for %q, got %x", sum, name, sums[name])
+		}
+	}
+
+	for _, bad := range []string{"zz  file.iso\n", a + "\n", "\\" + a + "  bad\\x\n"} {
+		if _, err := ParseChecksumFile(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestRequest_SetChecksumFromFile(t *testing.T) {
+	content := []byte("hello, world")
+	sha := sha256.Sum256(content)
+	md := md5.Sum(content)
+	dir := t.TempDir()
+	shaSums := filepath.Join(dir, "SHA256SUMS")
+	md5Sums := filepath.Join(dir, "MD5SUMS")
+	_ = os.WriteFile(shaSums, []byte(strings.Repeat("0", 64)+"  other.iso\n"+hex.EncodeToString(sha[:])+"  file.iso\n"), 0644)
+	_ = os.WriteFile(md5Sums, []byte(hex.EncodeToString(md[:])+" *saved.iso\n"+strings.Repeat("0", 32)+"  other.iso\n"), 0644)
+
+	req, _ := NewRequest(filepath.Join(dir, "saved.iso"), "http://example.com/download/file.iso")
+	if err := req.SetChecksumFromFile(sha256.New(), shaSums, true); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(req.checksum, sha[:]) || !req.deleteOnError {
+		t.Errorf("Expected checksum of request URL, got %x", req.checksum)
+	}
+
+	// falls back to the name of the local file
+	if err := req.SetChecksumFromFile(md5.New(), md5Sums, false); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(req.checksum, md[:]) {
+		t.Errorf("Expected checksum of local file name, got %x", req.checksum)
+	}
+
+	req, _ = NewRequest(filepath.Join(dir, "missing.iso"), "http://example.com/missing.iso")
+	if err := req.SetChecksumFromFile(sha256.New(), shaSums, true); !errors.Is(err, ErrNoChecksum) {
+		t.Errorf("Expected ErrNoChecksum, got %v", err)
+	}
+	if req.hash != nil {
+		t.Error("Expected request to be unchanged")
+	}
+}
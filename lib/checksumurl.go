@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+)
+
+// ErrNoChecksum indicates that the checksum file given by
+// Request.SetChecksumURL has no checksum for the requested file.
+var ErrNoChecksum = errors.New("no checksum found for file")
+
+// maxChecksumFileSize is the largest checksum file which is read.
+const maxChecksumFileSize = 1 << 20
+
+// fetchChecksum sets the expected checksum of the Request from the checksum
+// file given by SetChecksumURL, before continuing with next.
+func (c *Client) fetchChecksum(next stateFunc) stateFunc {
+	return func(resp *Response) stateFunc {
+		resp.logf("fetching checksum from %s", redactURLString(resp.Request.checksumURL))
+		resp.Request.checksum, resp.err = c.checksumFromURL(resp.Request)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		return next
+	}
+}
+
+// checksumFromURL fetches the checksum file of the given Request, given by
+// SetChecksumURL, and returns the checksum of the requested file.
+func (c *Client) checksumFromURL(req *Request) ([]byte, error) {
+	hreq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, req.checksumURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the checksum file may be served by another host than the file, so
+	// the credentials of the file are not sent with it
+	if req.ChecksumHeader != nil {
+		hreq.Header = req.ChecksumHeader.Clone()
+	}
+	hresp, err := c.doHTTPRequest(hreq)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch checksum: %w", err)
+	}
+	defer func() {
+		_ = hresp.Body.Close()
+	}()
+	if hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+		return nil, fmt.Errorf("cannot fetch checksum: %w", StatusCodeError(hresp.StatusCode))
+	}
+	b, err := io.ReadAll(io.LimitReader(hresp.Body, maxChecksumFileSize))
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch checksum: %w", err)
+	}
+	return checksumFor(b, path.Base(req.URL().Path), req.hash.Size())
+}
+
+// checksumFor returns the checksum of the named file from a checksum file,
+// which contains either a single hex encoded checksum or lines in the format
+// written by sha256sum and similar tools, as parsed by parseChecksums. If the
+// file names any file, the entry for the named file is required.
+func checksumFor(b []byte, name string, size int) ([]byte, error) {
+	entries, err := parseChecksums(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	var bare []byte
+	n, named := 0, false
+	for _, e := range entries {
+		if len(e.sum) != size {
+			continue
+		}
+		n++
+		if e.name == "" {
+			bare = e.sum
+			continue
+		}
+		named = true
+		if path.Base(e.name) == name {
+			return e.sum, nil
+		}
+	}
+	if n == 1 && !named {
+		return bare, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoChecksum, name)
+}
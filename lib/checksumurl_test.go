@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChecksumFor(t *testing.T) {
+	a := strings.Repeat("a", 64)
+	b := strings.Repeat("b", 64)
+	tests := []struct {
+		name  string
+		file  string
+		want  string
+		error bool
+	}{
+		{"bare digest", a + "\n", a, false},
+		{"matching entry", a + "  other.iso\n" + b + " *file.iso\n", b, false},
+		{"comment", "# comment\n" + a + "\n", a, false},
+		{"other entry", a + "  other.iso\n", "", true},
+		{"name with spaces", a + "  file.iso.bak\n" + b + "  dir name/file.iso\n", b, false},
+		{"no matching entry", a + "  one.iso\n" + b + "  two.iso\n", "", true},
+		{"wrong size", strings.Repeat("a", 40) + "  file.iso\n", "", true},
+	}
+	for _, test := range tests {
+		sum, err := checksumFor([]byte(test.file), "file.iso", sha256.Size)
+		if test.error {
+			if !errors.Is(err, ErrNoChecksum) {
+				t.Errorf("%s: expected ErrNoChecksum, got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil || hex.EncodeToString(sum) != test.want {
+			t.Errorf("%s: expected %s, got %x (%v)", test.name, test.want, sum, err)
+		}
+	}
+}
+
+func TestClient_Do_ChecksumURL(t *testing.T) {
+	content := "hello, world"
+	sum := sha256.Sum256([]byte(content))
+	var checksumAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file.txt":
+			if r.Header.Get("Authorization") != "Bearer file-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(content))
+		case "/file.txt.sha256":
+			checksumAuth = r.Header.Get("Authorization")
+			if r.Header.Get("Authorization") != "Bearer checksum-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(hex.EncodeToString(sum[:]) + "  file.txt\n"))
+		}
+	}))
+	defer server.Close()
+
+	newRequest := func() *Request {
+		req, _ := NewRequest(filepath.Join(t.TempDir(), "file.txt"), server.URL+"/file.txt")
+		req.HTTPRequest.Header.Set("Authorization", "Bearer file-token")
+		req.SetChecksumURL(sha256.New(), server.URL+"/file.txt.sha256", true)
+		return req
+	}
+
+	// the checksum file is requested without the credentials of the file
+	req := newRequest()
+	if err := NewClient().Do(req).Err(); !errors.Is(err, StatusCodeError(http.StatusUnauthorized)) {
+		t.Errorf("Expected 401 for checksum file, got %v", err)
+	}
+	if checksumAuth != "" {
+		t.Errorf("Expected no credentials for checksum file, got %q", checksumAuth)
+	}
+
+	req = newRequest()
+	req.ChecksumHeader = http.Header{"Authorization": {"Bearer checksum-token"}}
+	if err := NewClient().Do(req).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,11 +3,15 @@ package lib
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -35,27 +39,278 @@ type Client struct {
 	// UserAgent specifies the User-Agent string which will be set in the
 	// headers of all requests made by this client.
 	//
+	// The string may contain the placeholders {version}, {os}, {arch} and
+	// {url}, which are expanded to the grab version, runtime.GOOS,
+	// runtime.GOARCH and the grab project URL when each request is sent. For
+	// example: "grab/{version} ({os}/{arch}) +{url}".
+	//
 	// The user agent string may be overridden in the headers of each request.
 	UserAgent string
 
+	// Header specifies headers which are sent with all requests made by this
+	// client, such as authentication for a private repository. Headers set on
+	// each request take precedence.
+	Header http.Header
+
+	// HostHeaders maps host patterns to headers which are only sent to
+	// matching hosts, such as credentials for a private repository, which
+	// must not reach mirrors, gateways or redirect targets elsewhere. A
+	// pattern is a host name, which matches any port, a host and port such as
+	// example.com:8443, or a wildcard such as *.example.com for all
+	// subdomains. Headers are removed from redirects to other hosts. Headers
+	// set on each request take precedence. See ApplyProfile.
+	HostHeaders map[string]http.Header
+
 	// BufferSize specifies the size in bytes of the buffer that is used for
 	// transferring all requested files. Larger buffers may result in faster
 	// throughput but will use more memory and result in less frequent updates
 	// to the transfer progress statistics. The BufferSize of each request can
 	// be overridden on each Request object. Default: 32KB.
 	BufferSize int
+
+	// SingleRequestThreshold specifies a file size in bytes at or below which
+	// requests are sent in single-request mode, as if Request.SingleRequest
+	// were set. Only requests with a known Request.Size are affected. If zero,
+	// single-request mode is only used if enabled on each Request.
+	SingleRequestThreshold int64
+
+	// RateLimit limits the transfer rate of each request without its own
+	// Request.RateLimiter, in bytes per second. Zero means no limit.
+	RateLimit int64
+
+	// BatchRateLimit limits the total transfer rate of all batches executed
+	// by DoBatch and DoBatchWithOptions, in bytes per second. While several
+	// batches are transferring, the rate is divided between them in
+	// proportion to their BatchOptions.Priority, so that a batch started
+	// later is not starved by earlier ones. It applies in addition to
+	// RateLimit and Request.RateLimiter, and must be set before the first
+	// batch. Zero means no limit.
+	BatchRateLimit int64
+
+	// RateLimiter, if set, limits the total transfer rate of all requests of
+	// the client, however many transfer concurrently, whether started by Do
+	// or by the workers of DoBatch. It applies in addition to RateLimit,
+	// BatchRateLimit and Request.RateLimiter. Use NewRateLimiter to cap the
+	// total bandwidth in bytes per second.
+	RateLimiter RateLimiter
+
+	// RateHalfLife is the half-life of the exponentially weighted moving
+	// average by which Response.BytesPerSecond measures the transfer rate.
+	// Bytes transferred RateHalfLife ago weigh half as much as bytes
+	// transferred now, so a shorter half-life follows changes of the rate
+	// faster, while a longer one is steadier. Default: 2s.
+	RateHalfLife time.Duration
+
+	// AutoTuneBuffer specifies that the transfer buffer of each request grows
+	// from its initial size, up to MaxBufferSize, while the measured
+	// throughput shows that the buffer size limits the transfer, such as on
+	// 10GbE links. Requests with a RateLimiter are not tuned.
+	AutoTuneBuffer bool
+
+	// MaxBufferSize limits the size in bytes to which transfer buffers grow if
+	// AutoTuneBuffer is enabled. Default: 4MB.
+	MaxBufferSize int
+
+	// HeadFallback specifies how remote file metadata is discovered if the
+	// server rejects HEAD requests with status 403, 405 or 501. Default:
+	// HeadFallbackNone.
+	HeadFallback HeadFallbackPolicy
+
+	// NoHEAD specifies that HEAD requests are never sent, for servers such as
+	// S3-compatible services which charge or rate-limit them differently. The
+	// size of the remote file is read from GET responses only. Where a HEAD
+	// request would be needed to resume an existing file or to determine its
+	// name, a GET request for the first byte of the file is sent instead, as
+	// with HeadFallbackRangeProbe.
+	NoHEAD bool
+
+	// Country is the ISO 3166 code of the country of the client, such as "DE",
+	// used to prefer nearby mirrors of a Request.MirrorListURL.
+	Country string
+
+	// RankMirrorsByLatency specifies that, if a request has several candidate
+	// URLs from Request.Mirrors or Request.MirrorListURL, all of them are
+	// probed concurrently with a GET request for the first byte of the file
+	// before the download starts, and tried in order of the time to the first
+	// byte of their response. The ranking is available from
+	// Response.MirrorRanking.
+	RankMirrorsByLatency bool
+
+	// LinkMirrors specifies that mirrors advertised by the remote server in
+	// Link headers with rel=duplicate (RFC 6249), as emitted by some CDNs and
+	// repository tools, are added to Request.Mirrors for failover.
+	LinkMirrors bool
+
+	// FollowInterstitials specifies that if the remote server responds with a
+	// small HTML page which redirects to the requested file with a meta
+	// refresh tag, such as the download pages of some mirror networks, the
+	// redirect is followed once. Otherwise, or if the page does not redirect,
+	// an InterstitialError is returned. See Request.AcceptHTML.
+	FollowInterstitials bool
+
+	// ContentTypeExtensions maps media types to file extensions, such as
+	// "application/pdf" to ".pdf". If set, the extension of the Content-Type
+	// of the response is appended to file names without an extension which
+	// are determined from the URL or the Content-Disposition header, so that
+	// downloads are not left without one. Names given by Request.Filename are
+	// not changed. Use DefaultContentTypeExtensions for common types.
+	ContentTypeExtensions map[string]string
+
+	// ResumeState specifies that the URL, validators and size of each remote
+	// file, the number of bytes written and the state of its checksum, if
+	// any (see Request.SetChecksum), are recorded in a ".grab" file
+	// next to its partial download, which is removed once it completes. A
+	// later download to the same file, whether by another process or from
+	// another URL, overwrites the partial file instead of resuming it if the
+	// recorded remote file differs from the one requested, or if the size of
+	// the partial file differs from the bytes written. The file is only
+	// readable by its owner. It identifies the remote file by a SHA-256
+	// digest of its full URL, and records the URL itself only for display,
+	// without its password and query.
+	ResumeState bool
+
+	// AllowedSchemes lists the URL schemes which may be requested, such as
+	// DefaultAllowedSchemes, including by mirrors, checksum and mirror list
+	// URLs and redirects. Other URLs fail with ErrSchemeNotAllowed before any
+	// request is sent. If nil, DefaultAllowedSchemes apply, so that only HTTP
+	// and HTTPS are reachable unless other protocols supported by grab, such
+	// as data:, ftp:, ipfs:, magnet: and torrent: URLs, are listed explicitly.
+	AllowedSchemes []string
+
+	// TorrentFiles allows torrent: URLs of local .torrent files, such as
+	// torrent:/tmp/image.torrent, in builds with the torrent build tag.
+	// Otherwise they fail with ErrSchemeNotAllowed, so that URLs from remote
+	// sources cannot read local files. Redirects to them are never followed.
+	TorrentFiles bool
+
+	// StallTimeout specifies that a transfer which receives no data for this
+	// long is aborted with a CancelError of CanceledByStall, rather than
+	// waiting for the server or the network indefinitely. Time spent waiting
+	// for a rate limiter does not count. If zero, transfers never stall.
+	StallTimeout time.Duration
+
+	// StandbyThreshold specifies a file size in bytes at or above which a
+	// download with mirrors keeps a warm connection to the best mirror other
+	// than the one transferring, which accepts ranged requests. If the
+	// transfer fails with a connection error, it is resumed from that mirror
+	// at once, paying only for the ranged request rather than a new
+	// connection and TLS handshake. The last Request.ResumeOverlap bytes of
+	// the partial file, at least 4KB, are requested again and compared, and
+	// the request is conditional on the ETag of the original response, so
+	// that a mirror with a different file sends it whole. Mirrors are ranked
+	// as usual. If zero, no standby is kept.
+	StandbyThreshold int64
+
+	// StandbyInterval is the interval at which the connection to a standby
+	// mirror is kept warm, which must be shorter than the idle timeout of the
+	// mirror and of the transport of the HTTPClient. Default: 30s.
+	StandbyInterval time.Duration
+
+	// RetryPolicy, if set, retries downloads which fail with a transient
+	// error, such as a dropped connection or a 503 response, resuming the
+	// transfer where possible, so that callers need not retry Do themselves.
+	RetryPolicy *RetryPolicy
+
+	// MaxBufferedBytes limits the total size in bytes of the transfer buffers
+	// of all concurrent transfers of the Client, including buffers grown by
+	// AutoTuneBuffer, to prevent memory spikes when many transfers with large
+	// buffers run at once. Transfers wait for buffer memory before the GET
+	// request is sent, and hold it until they complete. Buffers larger than
+	// the limit are shrunk to fit. Auto-tuned buffers only grow while the
+	// limit allows. If zero, buffers are not limited.
+	MaxBufferedBytes int64
+
+	// MaxWritesPerDevice limits the number of files which are written
+	// concurrently to each storage device, to avoid seek-thrashing a single
+	// disk when a batch writes many large files at once. Transfers wait for
+	// capacity on their destination device before the GET request is sent,
+	// and hold it until they complete. If zero, writes are not limited.
+	MaxWritesPerDevice int
+
+	// DeviceFunc maps the destination path of a transfer to a key identifying
+	// its storage device, for use with MaxWritesPerDevice. Paths with the same
+	// key share a limit. If nil, the device number reported by the file system
+	// is used, which does not distinguish the physical disks of a RAID or
+	// network file system.
+	DeviceFunc func(filename string) string
+
+	// HostOverrides maps host names to the IP address or alternate host name
+	// to connect to instead, like an entry in /etc/hosts. The original host
+	// name is still used for the Host header and TLS verification. Only
+	// applies to clients created with NewClient.
+	HostOverrides map[string]string
+
+	// DNSCacheTTL enables a DNS cache shared by all requests of the Client,
+	// such as a batch of downloads from the same host, and specifies how long
+	// resolved addresses are reused. The system resolver does not report the
+	// TTL of DNS records, so this lifetime applies to all hosts. The hosts of
+	// a batch are resolved concurrently as soon as it is submitted, except
+	// those which are reached through a proxy. If zero,
+	// each connection resolves its host. Only applies to clients created with
+	// NewClient.
+	DNSCacheTTL time.Duration
+
+	// ChecksumCache, if set, remembers the checksums of downloaded and
+	// existing files which are validated using Request.SetChecksum, so that
+	// complete files which are unchanged since they were last hashed are not
+	// hashed again. This avoids re-reading every file when a mirror is
+	// updated repeatedly. Only checksums of the MD5, SHA-1 and SHA-2 hashes
+	// of the standard library, and those advertised by the remote server,
+	// are cached.
+	ChecksumCache *ChecksumCache
+
+	// Sidecar, if set, stores the provenance of each downloaded file, such as
+	// its URL and ETag, merged with Request.Metadata, alongside the file once
+	// the download has completed successfully. If the metadata cannot be
+	// written, the download fails.
+	Sidecar Sidecar
+
+	// Preflight, if set, is sent once before the first request of the Client
+	// to establish a session, such as for download pages which require a
+	// login or consent. Its cookies and selected response headers are used
+	// for later requests to the same server. If it fails, the download fails and the
+	// Preflight is sent again before the next download.
+	Preflight *Preflight
+
+	// IPFSGateways lists the base URLs of the path-style gateways, such as
+	// "https://ipfs.io", through which requests for ipfs://CID URLs are
+	// fetched, in order of preference. If a gateway fails, the next one is
+	// tried. If empty, DefaultIPFSGateways are used.
+	IPFSGateways []string
+
+	// ReportStore, if set, receives a BatchReport of each batch executed by
+	// DoBatch or DoBatchWithOptions, with the events of its transfers, once
+	// all of them have completed, including batches which were canceled. The
+	// upload starts after the Response channel of the batch is closed; use
+	// ReportStore.OnUpload to wait for it.
+	ReportStore *ReportStore
+
+	dns dnsCache
+
+	buffers byteBudget
+
+	preflightState preflightState
+
+	batchLimiterOnce sync.Once
+	batchLimiter     *FairLimiter
+
+	devicesMu sync.Mutex
+	devices   map[string]chan struct{} // write semaphore per device
+
+	active activeSet
 }
 
 // NewClient returns a new file download Client, using default configuration.
-func NewClient() *Client {
-	return &Client{
-		UserAgent: "grab",
-		HTTPClient: &http.Client{
-			Transport: &http.Transport{
-				Proxy: http.ProxyFromEnvironment,
-			},
-		},
+// The given TransportOptions are applied to the http.Transport of the Client's
+// HTTPClient.
+func NewClient(opts ...TransportOption) *Client {
+	c := &Client{UserAgent: "grab"}
+	t := newTransport(opts...)
+	if t.DialContext == nil {
+		t.DialContext = c.dialContext
 	}
+	c.HTTPClient = &http.Client{Transport: t}
+	return c
 }
 
 // DefaultClient is the default client and is used by all Get convenience
@@ -75,27 +330,62 @@ var DefaultClient = NewClient()
 // will block the caller until the transfer is completed, successfully or
 // otherwise.
 func (c *Client) Do(req *Request) *Response {
-	// cancel will be called on all code-paths via closeResponse
-	ctx, cancel := context.WithCancel(req.Context())
+	// cancel will be called on all code-paths via closeResponse, and abort
+	// cancels with a cause, such as a stall or a full device
+	ctx, abort := context.WithCancelCause(req.Context())
+	if req.NoStore && req.memory == nil {
+		// shared by the copies of the request, so a retry can resume
+		req.memory = new(memoryPartial)
+	}
+	if req.MaxRedirects != 0 {
+		ctx = context.WithValue(ctx, maxRedirectsKey{}, req.MaxRedirects)
+	}
 	req = req.WithContext(ctx)
 	resp := &Response{
 		Request:    req,
 		Start:      time.Now(),
 		Done:       make(chan struct{}),
+		finished:   make(chan struct{}),
 		Filename:   req.Filename,
 		ctx:        ctx,
-		cancel:     cancel,
+		cancel:     func() { abort(nil) },
+		abort:      abort,
 		bufferSize: req.BufferSize,
+		phases:     make(chan Phase, phaseBufferSize),
+		attempts:   1,
+		worker:     WorkerID(ctx),
 	}
+	resp.phases <- PhaseResolving
+	c.active.add(resp)
+	initMirrors(resp)
+	setUnmodifiedSince(req)
 	if resp.bufferSize == 0 {
 		// default to Client.BufferSize
 		resp.bufferSize = c.BufferSize
 	}
+	if req.SingleRequest || (req.Size > 0 && req.Size <= c.SingleRequestThreshold) {
+		resp.singleRequest = true
+		resp.logf("using single-request mode")
+	}
 
 	// Run state-machine while caller is blocked to initialize the file transfer.
 	// Must never transition to the copyFile state - this happens next in another
 	// goroutine.
-	c.run(resp, c.statFileInfo)
+	start := c.measureMirrors
+	if req.MirrorListURL != "" {
+		start = c.fetchMirrorList
+	}
+	if req.checksumURL != "" {
+		start = c.fetchChecksum(start)
+	}
+	if req.BlockManifest != nil || req.BlockManifestURL != "" {
+		start = c.fetchBlockManifest(start)
+	}
+	if req.URL().Scheme == "ipfs" {
+		start = c.resolveIPFS(start)
+	}
+	start = c.checkScheme(start)
+	c.run(resp, start)
 
 	// Run copyFile in a new goroutine. copyFile will no-op if the transfer is
 	// already complete or failed.
@@ -129,7 +419,7 @@ func (c *Client) DoChannel(ctx context.Context, reqch <-chan *Request, respch ch
 			req = req.WithContext(ctx)
 			resp := c.Do(req)
 			respch <- resp
-			<-resp.Done
+			<-resp.finished
 		}
 	}
 }
@@ -142,30 +432,51 @@ func (c *Client) DoChannel(ctx context.Context, reqch <-chan *Request, respch ch
 // for every request. I.e. all requests will be executed concurrently.
 //
 // If an error occurs during any of the file transfers it will be accessible via
-// call to the associated Response.Err.
+// call to the associated Response.Err. If a transfer fails because its
+// destination device is full, the other transfers of the batch to the same
+// device are aborted at once with ErrDiskFull, rather than each failing in
+// turn.
 //
 // The returned Response channel is closed only after all of the given Requests
 // have completed, successfully or otherwise.
 func (c *Client) DoBatch(ctx context.Context, workers int, requests ...*Request) <-chan *Response {
-	if workers < 1 {
-		workers = len(requests)
+	return c.DoBatchWithOptions(ctx, BatchOptions{Workers: workers}, requests...)
+}
+
+// doBatch implements DoBatch, sending each Response as soon as it is received.
+func (c *Client) doBatch(ctx context.Context, workers int, requests ...*Request) <-chan *Response {
+	requests = c.watchDevices(requests)
+	c.prefetchDNS(ctx, requests)
+	// requests held back by politeness delays are only queued once they may
+	// start, so that the workers take them as they become free
+	var reqch chan *Request
+	if slices.ContainsFunc(requests, func(req *Request) bool { return req.politeness != nil }) {
+		reqch = make(chan *Request)
+	} else {
+		reqch = make(chan *Request, len(requests))
 	}
-	reqch := make(chan *Request, len(requests))
 	respch := make(chan *Response, len(requests))
 	wg := sync.WaitGroup{}
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
 		go func() {
-			c.DoChannel(ctx, reqch, respch)
+			runWorker(ctx, i+1, func(ctx context.Context) {
+				c.DoChannel(ctx, reqch, respch)
+			})
 			wg.Done()
 		}()
 	}
 
 	// queue requests
 	go func() {
-		for _, req := range requests {
-			reqch <- req
-		}
+		schedule(ctx, requests, func(i int) bool {
+			select {
+			case reqch <- requests[i]:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
 		close(reqch)
 		wg.Wait()
 		close(respch)
@@ -185,10 +496,14 @@ func (c *Client) run(resp *Response, f stateFunc) {
 	for {
 		select {
 		case <-resp.ctx.Done():
-			if resp.IsComplete() {
+			if resp.isFinished() {
 				return
 			}
-			resp.err = resp.ctx.Err()
+			// keep the error of a request which failed, unless it was
+			// kept only until a retry
+			if resp.err == nil || (c.RetryPolicy != nil && c.RetryPolicy.retryable(resp.err)) {
+				resp.err = resp.ctx.Err()
+			}
 			f = c.closeResponse
 
 		default:
@@ -211,25 +526,68 @@ func (c *Client) run(resp *Response, f stateFunc) {
 //
 // If an error occurs, the next stateFunc is closeResponse.
 func (c *Client) statFileInfo(resp *Response) stateFunc {
+	resp.setPhase(PhaseResolving)
+	if resp.Request.NoStore && resumeMemory(resp) {
+		return c.getRequest
+	}
 	if resp.Request.NoStore || resp.Filename == "" {
 		return c.headRequest
 	}
 	fi, err := os.Stat(resp.Filename)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return c.headRequest
-		}
+	if err != nil && !os.IsNotExist(err) {
 		resp.err = err
 		return c.closeResponse
 	}
-	if fi.IsDir() {
+	if fi != nil && fi.IsDir() {
 		resp.Filename = ""
 		return c.headRequest
 	}
+	if !resp.storeApproved {
+		filename := resp.Filename
+		resp.err = beforeStore(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		if resp.Filename != filename {
+			return c.statFileInfo
+		}
+	}
+	if fi == nil {
+		return c.headRequest
+	}
+	if isSpecialFile(fi) {
+		// pipes and devices cannot be resumed, so are written from the start
+		resp.logf("writing to special file %s", resp.Filename)
+		resp.special = true
+		return c.getRequest
+	}
 	resp.fi = fi
 	return c.validateLocal
 }
 
+// beforeStore calls the BeforeStore hook of the Request, if any, once the
+// destination path of the Response is known and updates it with the returned
+// path.
+func beforeStore(resp *Response) error {
+	resp.storeApproved = true
+	f := resp.Request.BeforeStore
+	if f == nil {
+		return nil
+	}
+	path, err := filepath.Abs(resp.Filename)
+	if err != nil {
+		return err
+	}
+	newPath, err := f(resp, path)
+	if err != nil {
+		return err
+	}
+	if newPath != path {
+		resp.Filename = newPath
+	}
+	return nil
+}
+
 // validateLocal compares a local copy of the downloaded file to the remote
 // file.
 //
@@ -243,10 +601,22 @@ func (c *Client) statFileInfo(resp *Response) stateFunc {
 // known to support ranged requests, the next stateFunc is getRequest.
 func (c *Client) validateLocal(resp *Response) stateFunc {
 	if resp.Request.SkipExisting {
+		resp.logf("destination %s exists, skipping", resp.Filename)
 		resp.err = ErrFileExists
 		return c.closeResponse
 	}
 
+	if resp.Request.hasRange || resp.singleRequest || len(resp.Request.Filters) > 0 {
+		// ranged, single and filtered requests overwrite any existing file
+		resp.logf("overwriting existing file (%d bytes)", resp.fi.Size())
+		return c.getRequest
+	}
+
+	if c.resumeStateChanged(resp) {
+		resp.logf("overwriting existing file (%d bytes): remote file changed since partial download", resp.fi.Size())
+		return c.getRequest
+	}
+
 	// determine target file size
 	expectedSize := resp.Request.Size
 	if expectedSize == 0 && resp.HTTPResponse != nil {
@@ -262,39 +632,60 @@ func (c *Client) validateLocal(resp *Response) stateFunc {
 
 	if expectedSize == resp.fi.Size() {
 		// local file matches remote file size - wrap it up
+		resp.logf("existing file is complete (%d bytes)", expectedSize)
 		resp.DidResume = true
 		resp.bytesResumed = resp.fi.Size()
+		useRemoteChecksum(resp)
 		return c.checksumFile
 	}
 
 	if resp.Request.NoResume {
 		// local file should be overwritten
+		resp.logf("overwriting existing file (%d bytes): resume disabled", resp.fi.Size())
 		return c.getRequest
 	}
 
 	if expectedSize >= 0 && expectedSize < resp.fi.Size() {
 		// remote size is known, is smaller than local size and we want to resume
+		resp.logf("existing file (%d bytes) is larger than remote file (%d bytes)", resp.fi.Size(), expectedSize)
 		resp.err = ErrBadLength
 		return c.closeResponse
 	}
 
+	if checkpointChanged(resp) {
+		resp.logf("overwriting existing file (%d bytes): remote file changed since checkpoint", resp.fi.Size())
+		return c.getRequest
+	}
+
 	if resp.CanResume {
+		// re-request the tail of the local file, if it is to be verified
+		resp.resumeOverlap = resp.Request.ResumeOverlap
+		if resp.resumeOverlap > resp.fi.Size() {
+			resp.resumeOverlap = resp.fi.Size()
+		}
+
 		// set resume range on GET request
 		resp.Request.HTTPRequest.Header.Set(
 			"Range",
-			fmt.Sprintf("bytes=%d-", resp.fi.Size()))
+			fmt.Sprintf("bytes=%d-", resp.fi.Size()-resp.resumeOverlap))
+		c.setResumeIfMatch(resp)
 		resp.DidResume = true
 		resp.bytesResumed = resp.fi.Size()
+		resp.logf("resuming existing file at byte %d", resp.fi.Size()-resp.resumeOverlap)
 		return c.getRequest
 	}
+	if resp.optionsKnown {
+		resp.logf("overwriting existing file (%d bytes): server does not support ranges", resp.fi.Size())
+	}
 	return c.headRequest
 }
 
 func (c *Client) checksumFile(resp *Response) stateFunc {
 	if resp.Request.hash == nil {
-		return c.closeResponse
+		return c.checkSignature
 	}
-	if resp.Filename == "" {
+	resp.setPhase(PhaseVerifying)
+	if resp.Filename == "" && !resp.Request.NoStore {
 		panic("grab: developer error: filename not set")
 	}
 	if resp.Size() < 0 {
@@ -304,7 +695,7 @@ func (c *Client) checksumFile(resp *Response) stateFunc {
 
 	// compute checksum
 	var sum []byte
-	sum, resp.err = resp.checksumUnsafe()
+	sum, resp.err = c.cachedChecksum(resp)
 	if resp.err != nil {
 		return c.closeResponse
 	}
@@ -312,7 +703,7 @@ func (c *Client) checksumFile(resp *Response) stateFunc {
 	// compare checksum
 	if !bytes.Equal(sum, req.checksum) {
 		resp.err = ErrBadChecksum
-		if !resp.Request.NoStore && req.deleteOnError {
+		if !resp.Request.NoStore && !resp.special && req.deleteOnError {
 			if err := os.Remove(resp.Filename); err != nil {
 				// err should be os.PathError and include file path
 				resp.err = fmt.Errorf(
@@ -320,16 +711,61 @@ func (c *Client) checksumFile(resp *Response) stateFunc {
 					err)
 			}
 		}
+		return c.closeResponse
+	}
+	return c.checkSignature
+}
+
+// useRemoteChecksum configures the Request to validate the downloaded file
+// using any checksum advertised by the remote server, unless the caller has
+// already set a checksum or disabled remote checksums.
+func useRemoteChecksum(resp *Response) {
+	req := resp.Request
+	// remote checksums describe the entire file, not the requested range or
+	// filtered content
+	if req.hash != nil || req.IgnoreRemoteChecksum || req.hasRange || len(req.Filters) > 0 || resp.streamed {
+		return
+	}
+	// the body of a resumed transfer is only the rest of the file
+	if algorithm, h, sum := remoteChecksum(resp.HTTPResponse, req.VerifyServerDigest, resp.DidResume); h != nil {
+		req.setChecksum(algorithm, h, sum, false)
 	}
-	return c.closeResponse
 }
 
 // doHTTPRequest sends a HTTP Request and returns the response
 func (c *Client) doHTTPRequest(req *http.Request) (*http.Response, error) {
+	if err := c.allowScheme(req.URL); err != nil {
+		return nil, err
+	}
+	if c.Preflight != nil {
+		if err := c.preflight(req); err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		c.applyPreflight(req)
+	}
 	if c.UserAgent != "" && req.Header.Get("User-Agent") == "" {
-		req.Header.Set("User-Agent", c.UserAgent)
+		req.Header.Set("User-Agent", expandUserAgent(c.UserAgent))
+	}
+	if len(c.Header) > 0 {
+		req = req.Clone(req.Context())
+		for name, values := range c.Header {
+			if req.Header.Get(name) == "" {
+				req.Header[name] = values
+			}
+		}
+	}
+	req = c.applyHostHeaders(req)
+	req = c.withTorrentFetcher(req)
+	req = withTimingTrace(req)
+	trace := &connTrace{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace.clientTrace()))
+	hresp, err := c.scopeHostHeaders(c.restrictRedirects(limitRedirects(c.HTTPClient, req))).Do(req)
+	if err != nil {
+		return nil, trace.wrap(err, canonicalHost(req.URL), c.proxyFor(req))
 	}
-	return c.HTTPClient.Do(req)
+	stripFileMode(hresp)
+	return hresp, nil
 }
 
 func (c *Client) headRequest(resp *Response) stateFunc {
@@ -338,7 +774,7 @@ func (c *Client) headRequest(resp *Response) stateFunc {
 	}
 	resp.optionsKnown = true
 
-	if resp.Request.NoResume {
+	if resp.Request.NoResume || resp.Request.hasRange || resp.singleRequest || len(resp.Request.Filters) > 0 {
 		return c.getRequest
 	}
 
@@ -347,39 +783,190 @@ func (c *Client) headRequest(resp *Response) stateFunc {
 		return c.getRequest
 	}
 
+	resp.setPhase(PhaseHead)
+	if c.NoHEAD {
+		return c.probeRequest
+	}
 	hreq := new(http.Request)
 	*hreq = *resp.Request.HTTPRequest
 	hreq.Method = "HEAD"
 
+	c.recordProxy(resp, hreq)
 	resp.HTTPResponse, resp.err = c.doHTTPRequest(hreq)
 	if resp.err != nil {
+		if failover(resp) || c.retry(resp) {
+			resp.optionsKnown = false
+			return c.headRequest
+		}
 		return c.closeResponse
 	}
+	recordRedirects(resp, resp.HTTPResponse)
+	logResponse(resp, "HEAD", hreq)
+	c.addLinkMirrors(resp)
 	if resp.HTTPResponse.Body != nil {
 		if err := resp.HTTPResponse.Body.Close(); err != nil {
 			resp.err = fmt.Errorf("cannot close HEAD response body: %w", err)
 			return c.closeResponse
 		}
 	}
+	if resp.err = checkUnmodified(resp); resp.err != nil {
+		return c.closeResponse
+	}
 
 	if resp.HTTPResponse.StatusCode != http.StatusOK {
+		if c.HeadFallback == HeadFallbackRangeProbe && headRejected(resp.HTTPResponse.StatusCode) {
+			resp.logf("HEAD rejected, probing with ranged GET")
+			return c.probeRequest
+		}
 		return c.getRequest
 	}
 
-	// In case of redirects during HEAD, record the final URL and use it
-	// instead of the original URL when sending future requests.
-	// This way we avoid sending potentially unsupported requests to
-	// the original URL, e.g. "Range", since it was the final URL
-	// that advertised its support.
-	resp.Request.HTTPRequest.URL = resp.HTTPResponse.Request.URL
-	resp.Request.HTTPRequest.Host = resp.HTTPResponse.Request.Host
+	useFinalURL(resp)
+	return c.readResponse
+}
+
+// probeRequest discovers the size and resume support of a remote file with a
+// GET request for its first byte, for servers that reject HEAD requests.
+func (c *Client) probeRequest(resp *Response) stateFunc {
+	hreq := resp.Request.HTTPRequest.Clone(resp.Request.Context())
+	hreq.Header.Set("Range", "bytes=0-0")
+
+	c.recordProxy(resp, hreq)
+	resp.HTTPResponse, resp.err = c.doHTTPRequest(hreq)
+	if resp.err != nil {
+		if failover(resp) || c.retry(resp) {
+			return c.probeRequest
+		}
+		return c.closeResponse
+	}
+	recordRedirects(resp, resp.HTTPResponse)
+	logResponse(resp, "probe", hreq)
+	c.addLinkMirrors(resp)
+
+	// close the body without reading it, aborting the transfer if the server
+	// ignored the range
+	hresp := resp.HTTPResponse
+	if hresp.Body != nil {
+		if err := hresp.Body.Close(); err != nil {
+			resp.err = fmt.Errorf("cannot close probe response body: %w", err)
+			return c.closeResponse
+		}
+	}
+	if resp.err = checkUnmodified(resp); resp.err != nil {
+		return c.closeResponse
+	}
+
+	switch hresp.StatusCode {
+	case http.StatusPartialContent:
+		size, err := contentRangeSize(hresp.Header.Get("Content-Range"))
+		if err != nil {
+			return c.getRequest
+		}
+		hresp.ContentLength = size
+		hresp.Header.Set("Accept-Ranges", "bytes")
+	case http.StatusOK:
+		hresp.Header.Del("Accept-Ranges")
+	default:
+		return c.getRequest
+	}
 
+	resp.probed = true
+	useFinalURL(resp)
 	return c.readResponse
 }
 
+// refreshURL discards the forbidden response of a request and replaces its
+// URL with one returned by Request.RefreshURL.
+func refreshURL(resp *Response) error {
+	if err := resp.closeResponseBody(); err != nil {
+		return fmt.Errorf("cannot close response body: %w", err)
+	}
+	s, err := resp.Request.RefreshURL(resp.Request.Context())
+	if err != nil {
+		return fmt.Errorf("cannot refresh URL: %w", err)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("cannot refresh URL: %w", err)
+	}
+	resp.Request.HTTPRequest.URL = u
+	resp.Request.HTTPRequest.Host = u.Host
+	return nil
+}
+
+// logResponse records the response to the given HEAD, probe or GET request as
+// an event.
+func logResponse(resp *Response, kind string, req *http.Request) {
+	hresp := resp.HTTPResponse
+	if hresp.Request != nil {
+		// final URL after redirects
+		req = hresp.Request
+	}
+	msg := fmt.Sprintf("%s %s: %s", kind, RedactURL(req.URL), hresp.Status)
+	if hresp.ContentLength >= 0 {
+		msg += fmt.Sprintf(", size %d", hresp.ContentLength)
+	}
+	if v := hresp.Header.Get("Accept-Ranges"); v != "" {
+		msg += ", Accept-Ranges: " + v
+	}
+	if v := hresp.Header.Get("Content-Range"); v != "" {
+		msg += ", Content-Range: " + v
+	}
+	resp.logf("%s", msg)
+}
+
+// useFinalURL records the final URL of any redirects during a HEAD or probe
+// request and uses it instead of the original URL when sending future
+// requests. This way we avoid sending potentially unsupported requests to the
+// original URL, e.g. "Range", since it was the final URL that advertised its
+// support.
+func useFinalURL(resp *Response) {
+	resp.Request.HTTPRequest.URL = resp.HTTPResponse.Request.URL
+	resp.Request.HTTPRequest.Host = resp.HTTPResponse.Request.Host
+}
+
 func (c *Client) getRequest(resp *Response) stateFunc {
-	resp.HTTPResponse, resp.err = c.doHTTPRequest(resp.Request.HTTPRequest)
+	resp.setPhase(PhaseDownloading)
+
+	// wait for capacity on the destination device, which is held until the
+	// Response is closed
+	if resp.releaseDevice == nil {
+		release, err := c.acquireDevice(resp)
+		if err != nil {
+			resp.err = err
+			return c.closeResponse
+		}
+		resp.releaseDevice = release
+	}
+
+	// reserve buffer memory, which is also held until the Response is closed
+	if resp.buffer == nil {
+		resp.buffer, resp.err = c.reserveBuffer(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+	hreq := resp.Request.HTTPRequest
+	if resp.Request.hasRange {
+		hreq = hreq.Clone(hreq.Context())
+		hreq.Header.Set("Range", resp.Request.rangeHeader())
+	}
+	c.recordProxy(resp, hreq)
+	resp.HTTPResponse, resp.err = c.doHTTPRequest(hreq)
 	if resp.err != nil {
+		if failover(resp) || c.retry(resp) {
+			return c.getRequest
+		}
+		return c.closeResponse
+	}
+	recordRedirects(resp, resp.HTTPResponse)
+	resp.timing = requestTiming(resp.HTTPResponse)
+	logResponse(resp, "GET", hreq)
+	c.addLinkMirrors(resp)
+	if restartPreconditionFailed(resp) {
+		return c.getRequest
+	}
+	if resp.err = checkUnmodified(resp); resp.err != nil {
 		return c.closeResponse
 	}
 
@@ -389,7 +976,7 @@ func (c *Client) getRequest(resp *Response) stateFunc {
 		if contentRange != "" {
 			var start int64
 			if _, err := fmt.Sscanf(contentRange, "bytes %d-", &start); err == nil {
-				if start != resp.bytesResumed {
+				if start != resp.bytesResumed-resp.resumeOverlap {
 					resp.err = ErrBadLength
 					return c.closeResponse
 				}
@@ -397,14 +984,37 @@ func (c *Client) getRequest(resp *Response) stateFunc {
 		}
 	}
 
+	// refresh expired URLs
+	if resp.HTTPResponse.StatusCode == http.StatusForbidden && resp.Request.RefreshURL != nil && !resp.refreshed {
+		resp.refreshed = true
+		resp.logf("refreshing forbidden URL")
+		resp.err = refreshURL(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		return c.getRequest
+	}
+	resp.refreshed = false
+
 	// check status code
 	if !resp.Request.IgnoreBadStatusCodes {
 		if resp.HTTPResponse.StatusCode < 200 || resp.HTTPResponse.StatusCode > 299 {
+			if failover(resp) || c.retry(resp) {
+				return c.getRequest
+			}
 			resp.err = StatusCodeError(resp.HTTPResponse.StatusCode)
 			return c.closeResponse
 		}
 	}
 
+	restartMemory(resp)
+	restartStandby(resp)
+
+	// detect HTML pages sent in place of the requested file
+	if next := c.checkInterstitial(resp); next != nil {
+		return next
+	}
+
 	return c.readResponse
 }
 
@@ -415,9 +1025,15 @@ func (c *Client) readResponse(resp *Response) stateFunc {
 
 	// check expected size
 	resp.sizeUnsafe = resp.HTTPResponse.ContentLength
+	if resp.Request.hasRange {
+		resp.err = applyRange(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
 	if resp.sizeUnsafe >= 0 {
 		// remote size is known
-		resp.sizeUnsafe += resp.bytesResumed
+		resp.sizeUnsafe += resp.bytesResumed - resp.resumeOverlap
 		if resp.Request.Size > 0 && resp.Request.Size != resp.sizeUnsafe {
 			resp.err = ErrBadLength
 			return c.closeResponse
@@ -425,17 +1041,22 @@ func (c *Client) readResponse(resp *Response) stateFunc {
 	}
 
 	// check filename
-	if resp.Filename == "" && !resp.Request.NoStore {
-		filename, err := guessFilename(resp.HTTPResponse)
+	if (resp.Filename == "" && !resp.Request.NoStore) || (resp.Request.writer != nil && isDirName(resp.Filename)) {
+		filename, err := guessFilenameFromRedirects(resp.HTTPResponse, resp.redirects)
 		if err != nil {
 			resp.err = err
 			return c.closeResponse
 		}
+		if ext := c.contentTypeExtension(filename, resp.HTTPResponse); ext != "" {
+			resp.logf("appending extension %s for Content-Type %s", ext, resp.HTTPResponse.Header.Get("Content-Type"))
+			filename += ext
+		}
 		// Request.Filename will be empty or a directory
 		resp.Filename = filepath.Join(resp.Request.Filename, filename)
 	}
 
-	if !resp.Request.NoStore && resp.requestMethod() == "HEAD" {
+	if !resp.Request.NoStore && (resp.requestMethod() == "HEAD" || resp.probed) {
+		resp.probed = false
 		if resp.HTTPResponse.Header.Get("Accept-Ranges") == "bytes" {
 			resp.CanResume = true
 		}
@@ -449,6 +1070,13 @@ func (c *Client) readResponse(resp *Response) stateFunc {
 //
 // Requires that Response.Filename and resp.DidResume are already be set.
 func (c *Client) openWriter(resp *Response) stateFunc {
+	if !resp.Request.NoStore && !resp.storeApproved {
+		resp.err = beforeStore(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+
 	if !resp.Request.NoStore && !resp.Request.NoCreateDirectories {
 		resp.err = mkdirp(resp.Filename)
 		if resp.err != nil {
@@ -456,9 +1084,43 @@ func (c *Client) openWriter(resp *Response) stateFunc {
 		}
 	}
 
-	if resp.Request.NoStore {
+	if resp.resumeOverlap > 0 {
+		resp.err = verifyResumeOverlap(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+
+	if resp.Request.NoStore && resp.Request.writer != nil {
+		// content is not stored, so checksums must be computed as it is
+		// written
+		useRemoteChecksum(resp)
+		var w io.Writer
+		w, resp.err = resp.Request.writer(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		if h := resp.Request.hash; h != nil {
+			h.Reset()
+			w = io.MultiWriter(w, h)
+		}
+		// hide any Close method, as the writer is closed by its owner
+		resp.writer = struct{ io.Writer }{w}
+		resp.streamed = true
+	} else if resp.Request.NoStore {
+		restoreMemory(resp)
 		resp.writer = &resp.storeBuffer
 	} else {
+		// the destination may already exist if its name was only determined
+		// by the GET request
+		if resp.fi == nil && !resp.DidResume {
+			if fi, err := os.Stat(resp.Filename); err == nil && fi.Mode().IsRegular() {
+				resp.fi = fi
+			} else if err == nil && isSpecialFile(fi) {
+				resp.special = true
+			}
+		}
+
 		// compute write flags
 		flag := os.O_CREATE | os.O_WRONLY
 		if resp.fi != nil {
@@ -479,28 +1141,84 @@ func (c *Client) openWriter(resp *Response) stateFunc {
 		}
 		resp.writer = f
 
-		// seek to start or end
-		whence := io.SeekStart
-		if resp.bytesResumed > 0 {
-			whence = io.SeekEnd
-		}
-		_, resp.err = f.Seek(0, whence)
-		if resp.err != nil {
-			return c.closeResponse
+		// seek to start or end, unless writing to a pipe or device
+		if !resp.special {
+			whence := io.SeekStart
+			if resp.bytesResumed > 0 {
+				whence = io.SeekEnd
+			}
+			_, resp.err = f.Seek(0, whence)
+			if resp.err != nil {
+				return c.closeResponse
+			}
 		}
 	}
 
 	// init transfer
 	if resp.bufferSize < 1 {
-		resp.bufferSize = 32 * 1024
+		resp.bufferSize = defaultBufferSize
+	}
+	var b []byte
+	if resp.buffer == nil {
+		b = make([]byte, resp.bufferSize)
+	} // else allocated from the reservation in copyFile
+	w := openDestinations(resp, resp.writer, resp.bytesResumed)
+	if resp.Request.hash != nil && !resp.Request.NoStore {
+		// hash the file as it is written
+		w, resp.err = startHash(resp, w)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+	if resp.blocks != nil && !resp.Request.hasRange {
+		// verify the blocks of the file as it is written
+		var v *blockVerifier
+		v, resp.err = newBlockVerifier(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		w = io.MultiWriter(w, v)
+	}
+	lim := resp.Request.RateLimiter
+	if lim == nil && c.RateLimit > 0 {
+		lim = NewRateLimiter(c.RateLimit)
+	}
+	if share := resp.Request.batchShare; share != nil {
+		if lim != nil {
+			lim = chainLimiter{lim, share}
+		} else {
+			lim = share
+		}
+	}
+	if c.RateLimiter != nil {
+		// shared by all transfers of the client
+		if lim != nil {
+			lim = chainLimiter{lim, c.RateLimiter}
+		} else {
+			lim = c.RateLimiter
+		}
 	}
-	b := make([]byte, resp.bufferSize)
 	resp.transfer = newTransfer(
 		resp.Request.Context(),
-		resp.Request.RateLimiter,
-		resp.writer,
+		lim,
+		w,
 		resp.HTTPResponse.Body,
 		b)
+	if c.RateHalfLife > 0 {
+		resp.transfer.gauge = newEWMAGauge(c.RateHalfLife, time.Now())
+	}
+	if len(resp.Request.Filters) > 0 {
+		resp.transfer.filter(resp.Request.Filters)
+	}
+	if resp.checkpoint = newCheckpointer(resp); resp.checkpoint != nil {
+		resp.transfer.wrote = resp.checkpoint.wrote
+	}
+	if c.AutoTuneBuffer && lim == nil {
+		resp.transfer.maxBuf = c.MaxBufferSize
+		if resp.transfer.maxBuf == 0 {
+			resp.transfer.maxBuf = defaultMaxBufferSize
+		}
+	}
 
 	// next step is copyFile, but this will be called later in another goroutine
 	return nil
@@ -508,7 +1226,7 @@ func (c *Client) openWriter(resp *Response) stateFunc {
 
 // copy transfers content for a HTTP connection established via Client.do()
 func (c *Client) copyFile(resp *Response) stateFunc {
-	if resp.IsComplete() {
+	if resp.isFinished() {
 		return nil
 	}
 
@@ -525,6 +1243,19 @@ func (c *Client) copyFile(resp *Response) stateFunc {
 		panic("grab: developer error: Response.transfer is nil")
 	}
 
+	// fail fast if another download of the batch filled the device
+	if resp.err = resp.Request.devices.add(resp); resp.err != nil {
+		return c.closeResponse
+	}
+	defer resp.Request.devices.remove(resp)
+
+	// allocate the buffer memory reserved before the GET request
+	if resp.buffer != nil {
+		resp.buffer.allocate(resp.transfer)
+	}
+
+	startProgress(resp)
+
 	// We waited to truncate the file in openWriter() to make sure
 	// the BeforeCopy didn't cancel the copy. If this was an existing
 	// file that is not going to be resumed, truncate the contents.
@@ -535,20 +1266,50 @@ func (c *Client) copyFile(resp *Response) stateFunc {
 		}
 	}
 
+	c.saveResumeState(resp, resp.bytesResumed, nil)
+	standby := c.startStandby(resp)
+	stopWatch := c.watchStall(resp)
 	bytesCopied, resp.err = resp.transfer.copy()
+	stopWatch()
+	standby.close()
+	if received := resp.bytesResumed + bytesCopied; resp.Size() >= 0 && received < resp.Size() &&
+		(resp.err == nil || errors.Is(resp.err, io.ErrUnexpectedEOF)) {
+		resp.err = &IncompleteBodyError{Expected: resp.Size(), Received: received}
+	}
+	var mismatch *BlockMismatchError
+	if errors.As(resp.err, &mismatch) {
+		// only the blocks before the corrupted one may be resumed
+		truncateBadBlock(resp, mismatch)
+	} else if resp.err != nil {
+		resp.checkpoint.finish()
+	}
 	if resp.err != nil {
-		return c.closeResponse
+		if mismatch != nil {
+			// the hash covers the truncated block
+			c.saveResumeState(resp, mismatch.Offset, nil)
+		} else {
+			written := resp.bytesResumed + bytesCopied
+			c.saveResumeState(resp, written, saveHash(resp, written))
+		}
+		if u := standby.ready(); u != nil && isTransient(resp.err) && resp.Request.Context().Err() == nil {
+			return func(resp *Response) stateFunc {
+				return c.failoverStandby(resp, u)
+			}
+		}
+		return c.retryTransfer
 	}
 	closeWriter(resp)
 
-	// set file timestamp
-	if !resp.Request.NoStore && !resp.Request.IgnoreRemoteTime {
-		resp.err = setLastModified(resp.HTTPResponse, resp.Filename)
+	// preserve remote file timestamp and permissions
+	if !resp.Request.NoStore && !resp.special {
+		resp.err = applyMetadata(resp.Filename, remoteMetadata(resp))
 		if resp.err != nil {
 			return c.closeResponse
 		}
 	}
 
+	applyQuarantine(resp)
+
 	// update transfer size if previously unknown
 	if resp.Size() < 0 {
 		discoveredSize := resp.bytesResumed + bytesCopied
@@ -565,17 +1326,32 @@ func (c *Client) copyFile(resp *Response) stateFunc {
 		if resp.err != nil {
 			return c.closeResponse
 		}
+		// the hook may have changed the file, so it is hashed again
+		if !resp.streamed {
+			resp.hashed = false
+		}
 	}
 
+	// trailers are only available once the body has been read
+	useRemoteChecksum(resp)
 	return c.checksumFile
 }
 
+// callOnDone calls the given Request.OnDone callback, recovering from any
+// panic.
+func callOnDone(f func(*Response), resp *Response) {
+	defer func() {
+		_ = recover()
+	}()
+	f(resp)
+}
+
 func closeWriter(resp *Response) {
 	if closer, ok := resp.writer.(io.Closer); ok {
 		if err := closer.Close(); err != nil {
 			resp.err = fmt.Errorf("cannot close writer for %q: %w", resp.Filename, err)
 			// if we cannot close the writer, we cannot continue
-			if resp.err != nil && !resp.Request.NoStore && resp.Request.deleteOnError {
+			if resp.err != nil && !resp.Request.NoStore && !resp.special && resp.Request.deleteOnError {
 				// if we cannot close the writer, we cannot continue
 				if err := os.Remove(resp.Filename); err != nil {
 					resp.err = fmt.Errorf(
@@ -591,12 +1367,40 @@ func closeWriter(resp *Response) {
 
 // close finalizes the Response
 func (c *Client) closeResponse(resp *Response) stateFunc {
-	if resp.IsComplete() {
+	if resp.isFinished() {
 		panic("grab: developer error: response already closed")
 	}
+	resp.setPhase(PhaseFinalizing)
+	if resp.ctx != nil && resp.ctx.Err() != nil &&
+		(errors.Is(resp.err, context.Canceled) || errors.Is(resp.err, context.DeadlineExceeded)) {
+		// report why the request was canceled, such as ErrQuotaExceeded or a
+		// stall, unless it failed for another reason; the cancellation of the
+		// caller is reported by the error of the context
+		resp.err = context.Cause(resp.ctx)
+	}
+	c.active.remove(resp)
 
 	resp.fi = nil
 	closeWriter(resp)
+	closeDestinations(resp)
+	saveMemory(resp)
+	classifyWriteError(resp)
+	if resp.Request != nil {
+		resp.Request.devices.done(resp)
+	}
+	if resp.releaseDevice != nil {
+		resp.releaseDevice()
+		resp.releaseDevice = nil
+	}
+	resp.buffer.release()
+	resp.buffer = nil
+	if resp.err == nil {
+		c.removeResumeState(resp)
+		resp.err = c.writeSidecar(resp)
+	}
+	if hint := resp.ThrottleHint(); hint != nil {
+		resp.logf("throttled: %v", hint)
+	}
 	if err := resp.closeResponseBody(); err != nil {
 		// optionally log or ignore
 		resp.err = fmt.Errorf("cannot close response body for %q: %w", resp.Filename, err)
@@ -604,10 +1408,32 @@ func (c *Client) closeResponse(resp *Response) stateFunc {
 	}
 
 	resp.End = time.Now()
+	close(resp.finished)
+	if resp.Request == nil || !resp.Request.staged {
+		releaseResponse(resp)
+	}
+	return nil
+}
+
+// releaseResponse reports the outcome of a finalized Response and closes
+// Done. The Response of the staged file of an atomic batch is released once
+// the batch has been committed or discarded, which may change its outcome.
+func releaseResponse(resp *Response) {
+	finishProgress(resp)
+	if resp.err != nil {
+		resp.logf("failed: %s", RedactError(resp.err))
+	} else {
+		resp.logf("complete")
+	}
+	resp.setPhase(PhaseComplete)
+	if resp.phases != nil {
+		close(resp.phases)
+	}
 	close(resp.Done)
 	if resp.cancel != nil {
 		resp.cancel()
 	}
-
-	return nil
+	if resp.Request != nil && resp.Request.OnDone != nil {
+		go callOnDone(resp.Request.OnDone, resp)
+	}
 }
@@ -1,13 +1,19 @@
 package lib
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -477,6 +483,11 @@ func TestClient_doHTTPRequest(t *testing.T) {
 			},
 			expectUA: "existing-agent",
 		},
+		{
+			name:      "user agent template",
+			userAgent: "test-agent ({os}/{arch})",
+			expectUA:  "test-agent (" + runtime.GOOS + "/" + runtime.GOARCH + ")",
+		},
 		{
 			name:      "empty user agent",
 			userAgent: "",
@@ -523,9 +534,10 @@ func TestClient_run(t *testing.T) {
 	defer cancel()
 
 	resp := &Response{
-		ctx:    ctx,
-		cancel: cancel,
-		Done:   make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+		Done:     make(chan struct{}),
+		finished: make(chan struct{}),
 	}
 
 	callCount := 0
@@ -551,9 +563,10 @@ func TestClient_run_ContextCanceled(t *testing.T) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 	resp := &Response{
-		ctx:    ctx,
-		cancel: cancel,
-		Done:   make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+		Done:     make(chan struct{}),
+		finished: make(chan struct{}),
 	}
 
 	// Cancel context immediately
@@ -591,6 +604,7 @@ func createTestResponse(t *testing.T, client *Client) *Response {
 		Request:    req,
 		Start:      time.Now(),
 		Done:       make(chan struct{}),
+		finished:   make(chan struct{}),
 		Filename:   req.Filename,
 		ctx:        ctx,
 		cancel:     cancel,
@@ -760,6 +774,7 @@ func TestClient_getRequest_ContentRange(t *testing.T) {
 				Request:      req,
 				Start:        time.Now(),
 				Done:         make(chan struct{}),
+				finished:     make(chan struct{}),
 				Filename:     req.Filename,
 				ctx:          ctx,
 				cancel:       cancel,
@@ -838,3 +853,340 @@ func BenchmarkClient_doHTTPRequest(b *testing.B) {
 		_, _ = client.doHTTPRequest(req)
 	}
 }
+
+func TestClient_Do_SingleRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		single    bool
+		size      int64
+		threshold int64
+		expectGET bool
+	}{
+		{name: "request option", single: true, expectGET: true},
+		{name: "below threshold", size: 12, threshold: 1024, expectGET: true},
+		{name: "above threshold", size: 2048, threshold: 1024},
+		{name: "unknown size", threshold: 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDirectoryWithCleanup(t, "grab-single-test")
+
+			// existing file is longer than the download and must be replaced
+			if err := os.WriteFile("file.txt", []byte("stale content from before"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			testURL := "http://example.com/file.txt"
+			mockClient := newMockHTTPClient()
+			head := createMockHTTPResponse("200 OK", http.StatusOK, "",
+				map[string]string{"Accept-Ranges": "bytes"})
+			head.Request, _ = http.NewRequest("HEAD", testURL, nil)
+			mockClient.addResponse("HEAD", testURL, head)
+			client := &Client{
+				HTTPClient:             mockClient,
+				UserAgent:              "test-agent",
+				SingleRequestThreshold: tt.threshold,
+			}
+
+			req, _ := NewRequest(".", testURL)
+			req.SingleRequest = tt.single
+			req.Size = tt.size
+			resp := client.Do(req)
+			err := resp.Err()
+
+			requests := mockClient.getRequests()
+			if !tt.expectGET {
+				if len(requests) == 0 || requests[0].Method != "HEAD" {
+					t.Fatal("Expected a HEAD request before downloading")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(requests) != 1 || requests[0].Method != "GET" {
+				t.Fatalf("Expected a single GET request, got %d requests", len(requests))
+			}
+			if requests[0].Header.Get("Range") != "" {
+				t.Error("Single requests should not attempt to resume")
+			}
+			b, err := os.ReadFile("file.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "test content" {
+				t.Errorf("Expected existing file to be replaced, got %q", string(b))
+			}
+		})
+	}
+}
+
+func TestClient_Do_RefreshURL(t *testing.T) {
+	expiredURL := "http://example.com/file.txt?signature=expired"
+	freshURL := "http://example.com/file.txt?signature=fresh"
+	refreshErr := errors.New("cannot sign URL")
+
+	tests := []struct {
+		name        string
+		refresh     string
+		refreshErr  error
+		expectError error
+		expectCalls int
+	}{
+		{name: "refreshed", refresh: freshURL, expectCalls: 1},
+		{name: "still forbidden", refresh: expiredURL, expectError: StatusCodeError(http.StatusForbidden), expectCalls: 1},
+		{name: "refresh error", refreshErr: refreshErr, expectError: refreshErr, expectCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := newMockHTTPClient()
+			mockClient.addResponse("GET", expiredURL, createErrorResponse(http.StatusForbidden, "expired"))
+			mockClient.addResponse("GET", freshURL, createSuccessResponse("test content"))
+			client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+			calls := 0
+			req, _ := NewRequest("", expiredURL)
+			req.NoStore = true
+			req.RefreshURL = func(ctx context.Context) (string, error) {
+				calls++
+				return tt.refresh, tt.refreshErr
+			}
+			resp := client.Do(req)
+			err := resp.Err()
+			if !errors.Is(err, tt.expectError) {
+				t.Fatalf("Expected error %v, got %v", tt.expectError, err)
+			}
+			if calls != tt.expectCalls {
+				t.Errorf("Expected %d calls to RefreshURL, got %d", tt.expectCalls, calls)
+			}
+			if err != nil {
+				return
+			}
+			if b, _ := resp.Bytes(); string(b) != "test content" {
+				t.Errorf("Expected content %q, got %q", "test content", string(b))
+			}
+			if got := req.URL().String(); got != expiredURL {
+				t.Errorf("Expected caller's request URL to be unchanged, got %q", got)
+			}
+		})
+	}
+}
+
+func TestClient_Do_IncompleteBody(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "grab-incomplete-test")
+
+	testURL := "http://example.com/file.txt"
+	content := "0123456789"
+
+	// first attempt is truncated after 4 bytes
+	mockClient := newMockHTTPClient()
+	short := createSuccessResponse(content[:4])
+	short.ContentLength = int64(len(content))
+	mockClient.addResponse("GET", testURL, short)
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+	req, _ := NewRequest("file.txt", testURL)
+	resp := client.Do(req)
+	err := resp.Err()
+	if !errors.Is(err, ErrIncompleteBody) {
+		t.Fatalf("Expected %v, got %v", ErrIncompleteBody, err)
+	}
+	var bodyErr *IncompleteBodyError
+	if !errors.As(err, &bodyErr) || bodyErr.Expected != 10 || bodyErr.Received != 4 {
+		t.Fatalf("Expected 4 of 10 bytes received, got %+v", bodyErr)
+	}
+	if b, _ := os.ReadFile("file.txt"); string(b) != content[:4] {
+		t.Fatalf("Expected partial file to be kept, got %q", string(b))
+	}
+
+	// second attempt resumes the partial file
+	mockClient = newResumeMockClient(testURL, "bytes 4-9/10", content[4:])
+	client.HTTPClient = mockClient
+	resp = client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !resp.DidResume {
+		t.Error("Expected the download to resume")
+	}
+	if b, _ := os.ReadFile("file.txt"); string(b) != content {
+		t.Errorf("Expected file content %q, got %q", content, string(b))
+	}
+}
+
+func TestClient_Do_Filters(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "grab-filters-test")
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, _ = zw.Write([]byte(strings.Repeat("grab", 100)))
+	_ = zw.Close()
+
+	testURL := "http://example.com/file.txt.gz"
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", testURL, createSuccessResponse(compressed.String()))
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+	// an existing file is overwritten rather than resumed
+	if err := os.WriteFile("file.txt", []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := NewRequest("file.txt", testURL)
+	req.Filters = []Filter{func(r io.Reader) io.Reader {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return iotest.ErrReader(err)
+		}
+		return zr
+	}}
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if b, _ := os.ReadFile("file.txt"); string(b) != strings.Repeat("grab", 100) {
+		t.Errorf("Expected decompressed content, got %q", b)
+	}
+	if resp.DidResume {
+		t.Error("Expected filtered download not to resume")
+	}
+	if resp.BytesComplete() != int64(compressed.Len()) {
+		t.Errorf("Expected %d bytes received, got %d", compressed.Len(), resp.BytesComplete())
+	}
+}
+
+func TestClient_Do_BeforeStore(t *testing.T) {
+	tests := []struct {
+		name        string
+		dst         string
+		hook        StoreHook
+		expectFile  string
+		expectError error
+		expectGET   bool
+	}{
+		{
+			name: "accept",
+			dst:  "file.txt",
+			hook: func(resp *Response, path string) (string, error) {
+				return path, nil
+			},
+			expectFile: "file.txt",
+			expectGET:  true,
+		},
+		{
+			name: "rename guessed filename",
+			dst:  ".",
+			hook: func(resp *Response, path string) (string, error) {
+				if resp.HTTPResponse == nil {
+					return "", errors.New("expected response metadata")
+				}
+				return filepath.Join(filepath.Dir(path), "renamed.txt"), nil
+			},
+			expectFile: "renamed.txt",
+			expectGET:  true,
+		},
+		{
+			name: "redirect to directory",
+			dst:  "file.txt",
+			hook: func(resp *Response, path string) (string, error) {
+				return filepath.Join(filepath.Dir(path), "other", filepath.Base(path)), nil
+			},
+			expectFile: filepath.Join("other", "file.txt"),
+			expectGET:  true,
+		},
+		{
+			name: "veto",
+			dst:  "file.txt",
+			hook: func(resp *Response, path string) (string, error) {
+				return "", ErrSkipped
+			},
+			expectError: ErrSkipped,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDirectoryWithCleanup(t, "grab-beforestore-test")
+
+			testURL := "http://example.com/file.txt"
+			mockClient := newMockHTTPClient()
+			get := createSuccessResponse("test content")
+			get.Request, _ = http.NewRequest("GET", testURL, nil)
+			mockClient.addResponse("GET", testURL, get)
+			client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+			req, _ := NewRequest(tt.dst, testURL)
+			req.BeforeStore = tt.hook
+			resp := client.Do(req)
+			if err := resp.Err(); !errors.Is(err, tt.expectError) {
+				t.Fatalf("Expected error %v, got %v", tt.expectError, err)
+			}
+			if got := len(mockClient.getRequests()) > 0; got != tt.expectGET {
+				t.Errorf("Expected request sent to be %v, got %v", tt.expectGET, got)
+			}
+			if tt.expectFile == "" {
+				if _, err := os.Stat(tt.dst); !os.IsNotExist(err) {
+					t.Errorf("Expected no file to be created, got %v", err)
+				}
+				return
+			}
+			b, err := os.ReadFile(tt.expectFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "test content" {
+				t.Errorf("Expected file content %q, got %q", "test content", string(b))
+			}
+		})
+	}
+}
+
+func TestClient_Do_OnDone(t *testing.T) {
+	tests := []struct {
+		name        string
+		response    *http.Response
+		panics      bool
+		expectError bool
+	}{
+		{name: "success", response: createSuccessResponse("test content")},
+		{name: "failure", response: createErrorResponse(http.StatusNotFound, ""), expectError: true},
+		{name: "panic", response: createSuccessResponse("test content"), panics: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testURL := "http://example.com/file.txt"
+			mockClient := newMockHTTPClient()
+			mockClient.addResponse("GET", testURL, tt.response)
+			client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+			called := make(chan error, 2)
+			req, _ := NewRequest("", testURL)
+			req.NoStore = true
+			req.OnDone = func(resp *Response) {
+				called <- resp.Err()
+				if tt.panics {
+					panic("callback failed")
+				}
+			}
+			resp := client.Do(req)
+			resp.Wait()
+
+			select {
+			case err := <-called:
+				if (err != nil) != tt.expectError {
+					t.Errorf("Unexpected error in OnDone: %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("OnDone was not called")
+			}
+			select {
+			case <-called:
+				t.Error("OnDone was called more than once")
+			case <-time.After(20 * time.Millisecond):
+			}
+		})
+	}
+}
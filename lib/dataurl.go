@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidDataURL indicates that a data: URL is malformed or its payload
+// cannot be decoded.
+var ErrInvalidDataURL = errors.New("invalid data URL")
+
+// registerData registers the handler of data: URLs with the given Transport,
+// so that inline payloads, as defined by RFC 2397, are written to their
+// destination like any other download. Base64 and percent-encoded payloads
+// are decoded and served like an HTTP response with the media type of the URL
+// and support for ranges. data: URLs have no file name, so the destination of
+// their Request must be a file name rather than a directory.
+func registerData(t *http.Transport) {
+	t.RegisterProtocol("data", dataTransport{})
+}
+
+// dataTransport is an http.RoundTripper which serves the payloads of data:
+// URLs.
+type dataTransport struct{}
+
+func (dataTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	mediaType, data, err := decodeDataURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp.Status = "405 Method Not Allowed"
+		resp.StatusCode = http.StatusMethodNotAllowed
+		return resp, nil
+	}
+	size := int64(len(data))
+	resp.Header.Set("Content-Type", mediaType)
+	resp.Header.Set("Accept-Ranges", "bytes")
+	start, end, ok := parseRangeHeader(req.Header.Get("Range"), size)
+	if !ok {
+		resp.Status = "416 Requested Range Not Satisfiable"
+		resp.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return resp, nil
+	}
+	if start > 0 || end < size {
+		resp.Status = "206 Partial Content"
+		resp.StatusCode = http.StatusPartialContent
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+	}
+	resp.ContentLength = end - start
+	resp.Header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	if req.Method == http.MethodGet && resp.ContentLength > 0 {
+		resp.Body = io.NopCloser(bytes.NewReader(data[start:end]))
+	}
+	return resp, nil
+}
+
+// decodeDataURL returns the media type and decoded payload of the given data:
+// URL. The media type defaults to text/plain;charset=US-ASCII.
+func decodeDataURL(u *url.URL) (string, []byte, error) {
+	// the payload may have been split into the query of the URL
+	s := u.Opaque
+	if s == "" {
+		s = strings.TrimPrefix(u.Path, "/")
+	}
+	if u.RawQuery != "" || u.ForceQuery {
+		s += "?" + u.RawQuery
+	}
+	header, payload, ok := strings.Cut(s, ",")
+	if !ok {
+		return "", nil, fmt.Errorf("%w: missing comma", ErrInvalidDataURL)
+	}
+	header, err := url.PathUnescape(header)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidDataURL, err)
+	}
+	header, isBase64 := strings.CutSuffix(header, ";base64")
+	mediaType := "text/plain;charset=US-ASCII"
+	if header != "" {
+		if strings.HasPrefix(header, ";") {
+			header = "text/plain" + header
+		}
+		if _, _, err := mime.ParseMediaType(header); err != nil {
+			return "", nil, fmt.Errorf("%w: %v", ErrInvalidDataURL, err)
+		}
+		mediaType = header
+	}
+	data, err := url.PathUnescape(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidDataURL, err)
+	}
+	if !isBase64 {
+		return mediaType, []byte(data), nil
+	}
+	// be lenient about whitespace and missing padding
+	data = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" \t\r\n", r) {
+			return -1
+		}
+		return r
+	}, data)
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		b, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(data, "="))
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidDataURL, err)
+	}
+	return mediaType, b, nil
+}
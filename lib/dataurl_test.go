@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClient_Do_DataURL(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		url       string
+		want      string
+		mediaType string
+	}{
+		{"data:,Hello%2C%20World%21", "Hello, World!", "text/plain;charset=US-ASCII"},
+		{"data:text/plain;base64,SGVsbG8sIFdvcmxkIQ==", "Hello, World!", "text/plain"},
+		{"data:application/octet-stream;base64,SGVsbG8sIFdvcmxkIQ", "Hello, World!", "application/octet-stream"},
+		{"data:;charset=utf-8,what?%20why", "what? why", "text/plain;charset=utf-8"},
+		{"data:text/plain,", "", "text/plain"},
+	}
+	client := NewClient()
+	client.AllowedSchemes = []string{"data"}
+	for i, test := range tests {
+		filename := filepath.Join(dir, "file"+string(rune('a'+i)))
+		req, err := NewRequest(filename, test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := client.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Errorf("%s: %v", test.url, err)
+			continue
+		}
+		if got, _ := os.ReadFile(filename); string(got) != test.want {
+			t.Errorf("%s: expected %q, got %q", test.url, test.want, got)
+		}
+		if ct := resp.HTTPResponse.Header.Get("Content-Type"); ct != test.mediaType {
+			t.Errorf("%s: expected Content-Type %q, got %q", test.url, test.mediaType, ct)
+		}
+	}
+
+	t.Run("Resume", func(t *testing.T) {
+		filename := filepath.Join(dir, "resumed")
+		if err := os.WriteFile(filename, []byte("Hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		req, _ := NewRequest(filename, "data:,Hello%2C%20World%21")
+		resp := client.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.DidResume {
+			t.Error("Expected download to resume")
+		}
+		if got, _ := os.ReadFile(filename); string(got) != "Hello, World!" {
+			t.Errorf("Unexpected content: %q", got)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, u := range []string{"data:text/plain", "data:;base64,!!!"} {
+			req, _ := NewRequest(filepath.Join(dir, "invalid"), u)
+			if err := client.Do(req).Err(); !errors.Is(err, ErrInvalidDataURL) {
+				t.Errorf("%s: expected ErrInvalidDataURL, got %v", u, err)
+			}
+		}
+	})
+
+	t.Run("NoFilename", func(t *testing.T) {
+		req, _ := NewRequest(dir, "data:,x")
+		if err := client.Do(req).Err(); !errors.Is(err, ErrNoFilename) {
+			t.Errorf("Expected ErrNoFilename, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A Destination is a further destination of a download, which receives the
+// downloaded content in the same pass as the file of the Request, such as a
+// network share next to the local disk. Exactly one of Path or Writer is set.
+// See Request.Destinations.
+type Destination struct {
+	// Path is the name of a file which is created or overwritten with the
+	// content. If Path is an existing directory, the file is written into
+	// it under the name of the downloaded file.
+	Path string
+
+	// Writer receives the content. It is not closed, and as it cannot be
+	// rewound, it fails if the download restarts from an earlier byte than
+	// was already written to it.
+	Writer io.Writer
+}
+
+// String returns the path of the Destination, or a description of its writer.
+func (d Destination) String() string {
+	if d.Path != "" {
+		return d.Path
+	}
+	return fmt.Sprintf("writer %T", d.Writer)
+}
+
+// DestinationError records the failure to write a download to one of its
+// Request.Destinations. It does not fail the download.
+type DestinationError struct {
+	Destination Destination
+	Err         error
+}
+
+func (err *DestinationError) Error() string {
+	return fmt.Sprintf("cannot write to %v: %v", err.Destination, err.Err)
+}
+
+func (err *DestinationError) Unwrap() error {
+	return err.Err
+}
+
+// errCannotRewind indicates that a download restarted from an earlier byte
+// than was already written to a Destination writer.
+var errCannotRewind = errors.New("cannot rewind writer")
+
+// destWriter writes a download to a Destination, tracking the number of bytes
+// written. Once a write fails, later writes are discarded, so that the
+// transfer continues to the other destinations.
+type destWriter struct {
+	d   Destination
+	f   *os.File // the file of a Destination path, once opened
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (d *destWriter) Write(p []byte) (int, error) {
+	if d.err == nil {
+		n, err := d.w.Write(p)
+		d.n += int64(n)
+		if err != nil {
+			d.err = err
+		}
+	}
+	return len(p), nil
+}
+
+// open opens the file of a Destination path for the download of the given
+// Response.
+func (d *destWriter) open(resp *Response) error {
+	if d.d.Path == "" {
+		d.w = d.d.Writer
+		return nil
+	}
+	name := d.d.Path
+	if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+		name = filepath.Join(name, filepath.Base(resp.Filename))
+	} else if !resp.Request.NoCreateDirectories {
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	d.f, d.w = f, f
+	return nil
+}
+
+// sync brings the Destination to the given offset of the download, copying
+// the bytes it lacks from the file or memory of the Response, or rewinding
+// its file if the download restarted from an earlier byte.
+func (d *destWriter) sync(resp *Response, offset int64) {
+	if d.err != nil {
+		return
+	}
+	if d.w == nil {
+		if d.err = d.open(resp); d.err != nil {
+			return
+		}
+	}
+	if d.n > offset {
+		if d.f == nil {
+			d.err = errCannotRewind
+			return
+		}
+		if d.err = d.f.Truncate(offset); d.err != nil {
+			return
+		}
+		if _, d.err = d.f.Seek(offset, io.SeekStart); d.err != nil {
+			return
+		}
+		d.n = offset
+	}
+	if d.n == offset {
+		return
+	}
+	var src io.ReaderAt
+	if resp.Request.NoStore {
+		src = bytes.NewReader(resp.storeBuffer.Bytes())
+	} else {
+		f, err := os.Open(resp.Filename)
+		if err != nil {
+			d.err = err
+			return
+		}
+		defer f.Close()
+		src = f
+	}
+	n, err := io.Copy(d.w, io.NewSectionReader(src, d.n, offset-d.n))
+	d.n += n
+	if err == nil && d.n < offset {
+		err = io.ErrUnexpectedEOF
+	}
+	d.err = err
+}
+
+// close closes the file of a Destination path.
+func (d *destWriter) close() {
+	if d.f == nil {
+		return
+	}
+	if err := d.f.Close(); err != nil && d.err == nil {
+		d.err = err
+	}
+	d.f = nil
+}
+
+// openDestinations prepares the Request.Destinations of the Response for a
+// transfer which starts at the given offset, and returns a writer which
+// writes to w and all destinations. Destinations are not written by transfers
+// streamed to a writer of the caller.
+func openDestinations(resp *Response, w io.Writer, offset int64) io.Writer {
+	if len(resp.Request.Destinations) == 0 || resp.streamed {
+		return w
+	}
+	if resp.destinations == nil {
+		resp.destinations = make([]*destWriter, len(resp.Request.Destinations))
+		for i, d := range resp.Request.Destinations {
+			resp.destinations[i] = &destWriter{d: d}
+		}
+	}
+	writers := []io.Writer{w}
+	for _, d := range resp.destinations {
+		d.sync(resp, offset)
+		writers = append(writers, d)
+	}
+	return io.MultiWriter(writers...)
+}
+
+// closeDestinations completes the Request.Destinations of a successful
+// download with any bytes they lack, such as those of a file which was
+// already complete, closes them and logs any failures.
+func closeDestinations(resp *Response) {
+	if resp.Request == nil || len(resp.Request.Destinations) == 0 || resp.streamed {
+		return
+	}
+	if resp.destinations == nil && resp.err == nil {
+		openDestinations(resp, io.Discard, 0)
+	}
+	for _, d := range resp.destinations {
+		if resp.err == nil && !resp.special {
+			d.sync(resp, resp.BytesComplete())
+		}
+		d.close()
+		if d.err != nil {
+			resp.logf("cannot write to %v: %v", d.d, d.err)
+		}
+	}
+}
+
+// DestinationErrors returns the failure to write the download to each of the
+// Request.Destinations, in the same order, or nil if all of them succeeded.
+// The entry of a destination which succeeded is nil. It blocks until the
+// download is complete.
+func (c *Response) DestinationErrors() []error {
+	<-c.Done
+	var errs []error
+	for i, d := range c.destinations {
+		if d.err == nil {
+			continue
+		}
+		if errs == nil {
+			errs = make([]error, len(c.destinations))
+		}
+		errs[i] = &DestinationError{Destination: d.d, Err: d.err}
+	}
+	return errs
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type failWriter struct{ n int }
+
+var errWrite = errors.New("write failed")
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.n += len(p); w.n > 1000 {
+		return 0, errWrite
+	}
+	return len(p), nil
+}
+
+func TestClient_Do_Destinations(t *testing.T) {
+	content := make([]byte, 100000)
+	rand.New(rand.NewSource(9)).Read(content)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		existing []byte
+	}{
+		{"new", nil},
+		{"resumed", content[:40000]},
+		{"complete", content},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "file.bin")
+			if test.existing != nil {
+				if err := os.WriteFile(filename, test.existing, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var buf bytes.Buffer
+			share := filepath.Join(dir, "share", "copy.bin")
+			req, _ := NewRequest(filename, server.URL+"/file.bin")
+			req.Destinations = []Destination{{Path: share}, {Writer: &buf}, {Writer: &failWriter{}}}
+			resp := NewClient().Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(share); !bytes.Equal(got, content) {
+				t.Errorf("Expected copy of %d bytes, got %d", len(content), len(got))
+			}
+			if !bytes.Equal(buf.Bytes(), content) {
+				t.Errorf("Expected %d bytes written, got %d", len(content), buf.Len())
+			}
+			errs := resp.DestinationErrors()
+			var destErr *DestinationError
+			if len(errs) != 3 || errs[0] != nil || errs[1] != nil || !errors.As(errs[2], &destErr) || !errors.Is(errs[2], errWrite) {
+				t.Errorf("Expected only the third destination to fail, got %v", errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package lib
+
+// acquireDevice blocks until the destination device of the given Response
+// has capacity for another concurrent write, as configured by
+// Client.MaxWritesPerDevice. It is called before the GET request, so that a
+// response is not left idle while the transfer waits, and the destination
+// directory stands for a file name which is not yet known. The returned func
+// must be called to release the device once the write is complete.
+func (c *Client) acquireDevice(resp *Response) (release func(), err error) {
+	if c.MaxWritesPerDevice < 1 || resp.Request.NoStore {
+		return func() {}, nil
+	}
+	deviceFunc := c.DeviceFunc
+	if deviceFunc == nil {
+		deviceFunc = deviceID
+	}
+	filename := resp.Filename
+	if filename == "" {
+		filename = resp.Request.Filename
+	}
+	device := deviceFunc(filename)
+
+	c.devicesMu.Lock()
+	if c.devices == nil {
+		c.devices = make(map[string]chan struct{})
+	}
+	sem, ok := c.devices[device]
+	if !ok {
+		sem = make(chan struct{}, c.MaxWritesPerDevice)
+		c.devices[device] = sem
+	}
+	c.devicesMu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	default:
+		resp.logf("waiting for capacity on device %s", device)
+	}
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-resp.ctx.Done():
+		return nil, resp.ctx.Err()
+	}
+}
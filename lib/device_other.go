@@ -0,0 +1,15 @@
+//go:build !unix
+
+package lib
+
+import "path/filepath"
+
+// deviceID returns an identifier of the storage device which holds the given
+// file. On this platform, the volume name of the file path is used.
+func deviceID(filename string) string {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return ""
+	}
+	return filepath.VolumeName(path)
+}
@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyHTTPClient serves slow response bodies and records the maximum
+// number of bodies being read concurrently, and of GET responses which are
+// open.
+type concurrencyHTTPClient struct {
+	mu      sync.Mutex
+	active  int
+	max     int
+	open    int
+	maxOpen int
+}
+
+func (c *concurrencyHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	resp := createSuccessResponse("")
+	body := &concurrencyBody{client: c, r: strings.NewReader("test content")}
+	if req.Method == http.MethodGet {
+		c.mu.Lock()
+		c.open++
+		c.maxOpen = max(c.maxOpen, c.open)
+		c.mu.Unlock()
+		body.get = true
+	}
+	resp.Body = body
+	resp.ContentLength = 12
+	resp.Request = req
+	return resp, nil
+}
+
+type concurrencyBody struct {
+	client  *concurrencyHTTPClient
+	r       io.Reader
+	get     bool
+	started bool
+	done    bool
+}
+
+func (c *concurrencyBody) Read(p []byte) (int, error) {
+	if !c.started {
+		c.started = true
+		c.client.mu.Lock()
+		c.client.active++
+		if c.client.active > c.client.max {
+			c.client.max = c.client.active
+		}
+		c.client.mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+	}
+	n, err := c.r.Read(p)
+	if err == io.EOF && !c.done {
+		c.done = true
+		c.client.mu.Lock()
+		c.client.active--
+		c.client.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *concurrencyBody) Close() error {
+	if c.get {
+		c.get = false
+		c.client.mu.Lock()
+		c.client.open--
+		c.client.mu.Unlock()
+	}
+	return nil
+}
+
+func TestClient_MaxWritesPerDevice(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxWrites int
+		devices   int
+		expectMax int
+	}{
+		{name: "single device", maxWrites: 1, devices: 1, expectMax: 1},
+		{name: "two devices", maxWrites: 1, devices: 2, expectMax: 2},
+		{name: "two writes per device", maxWrites: 2, devices: 1, expectMax: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			httpClient := &concurrencyHTTPClient{}
+			client := &Client{
+				HTTPClient:         httpClient,
+				UserAgent:          "test-agent",
+				MaxWritesPerDevice: tt.maxWrites,
+				DeviceFunc: func(filename string) string {
+					return filepath.Base(filepath.Dir(filename))
+				},
+			}
+
+			var reqs []*Request
+			for i := 0; i < 8; i++ {
+				sub := filepath.Join(dir, fmt.Sprintf("disk%d", i%tt.devices))
+				req, _ := NewRequest(filepath.Join(sub, fmt.Sprintf("file%d", i)), fmt.Sprintf("http://example.com/file%d", i))
+				reqs = append(reqs, req)
+			}
+			for resp := range client.DoBatch(context.Background(), 0, reqs...) {
+				if err := resp.Err(); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			if httpClient.max != tt.expectMax {
+				t.Errorf("Expected at most %d concurrent writes, got %d", tt.expectMax, httpClient.max)
+			}
+			if httpClient.maxOpen != tt.expectMax {
+				t.Errorf("Expected at most %d GET requests waiting to be written, got %d", tt.expectMax, httpClient.maxOpen)
+			}
+		})
+	}
+}
+
+func TestDeviceID(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	existing := deviceID(filepath.Join(dir, "a"))
+	missing := deviceID(filepath.Join(dir, "missing", "b"))
+	if existing != missing {
+		t.Errorf("Expected files in the same directory to share a device, got %q and %q", existing, missing)
+	}
+}
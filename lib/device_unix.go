@@ -0,0 +1,32 @@
+//go:build unix
+
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// deviceID returns an identifier of the storage device which holds the given
+// file, or of its nearest existing parent directory.
+func deviceID(filename string) string {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return ""
+	}
+	for {
+		if fi, err := os.Stat(path); err == nil {
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+				return strconv.FormatUint(uint64(st.Dev), 10)
+			}
+			return ""
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return ""
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// A ConnError describes a failure to connect to a remote server, with
+// diagnostic details of how the connection was attempted.
+type ConnError struct {
+	// Host is the host and port which grab attempted to connect to. If a
+	// proxy was used, this is the address of the proxy.
+	Host string
+
+	// Addrs lists the IP addresses which Host resolved to, if it was resolved.
+	Addrs []string
+
+	// DNSErr is any error which occurred while resolving Host.
+	DNSErr error
+
+	// Dials lists each attempt to connect to a resolved address.
+	Dials []DialAttempt
+
+	// Proxy is the proxy which was used to connect to the remote server, or nil
+	// if the connection was direct.
+	Proxy *url.URL
+
+	// Err is the underlying error returned by the HTTPClient.
+	Err error
+}
+
+// A DialAttempt describes an attempt to connect to a single network address.
+type DialAttempt struct {
+	Network string
+	Addr    string
+	Err     error
+}
+
+func (e *ConnError) Error() string {
+	var details []string
+	if e.Proxy != nil {
+		details = append(details, "via proxy "+e.Proxy.Redacted())
+	}
+	if len(e.Addrs) > 0 {
+		details = append(details, "resolved "+strings.Join(e.Addrs, ", "))
+	}
+	for _, d := range e.Dials {
+		if d.Err != nil {
+			details = append(details, fmt.Sprintf("dial %s %s: %v", d.Network, d.Addr, d.Err))
+		} else {
+			details = append(details, fmt.Sprintf("dial %s %s: ok", d.Network, d.Addr))
+		}
+	}
+	if len(details) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v (%s)", e.Err, strings.Join(details, "; "))
+}
+
+func (e *ConnError) Unwrap() error {
+	return e.Err
+}
+
+// connTrace records the name resolution and dial attempts of a request.
+type connTrace struct {
+	mu     sync.Mutex
+	host   string
+	addrs  []string
+	dnsErr error
+	dials  []DialAttempt
+}
+
+func (t *connTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.host = info.Host
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsErr = info.Err
+			for _, addr := range info.Addrs {
+				t.addrs = append(t.addrs, addr.String())
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dials = append(t.dials, DialAttempt{Network: network, Addr: addr, Err: err})
+		},
+	}
+}
+
+// wrap returns a ConnError describing err, if err was caused by a failure to
+// resolve or connect to the remote server. Otherwise err is returned
+// unchanged.
+func (t *connTrace) wrap(err error, host string, proxy *url.URL) error {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	if !errors.As(err, &dnsErr) && !(errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect")) {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if proxy != nil {
+		host = proxy.Host
+	}
+	return &ConnError{
+		Host:   host,
+		Addrs:  t.addrs,
+		DNSErr: t.dnsErr,
+		Dials:  t.dials,
+		Proxy:  proxy,
+		Err:    err,
+	}
+}
+
+// canonicalHost returns the host and port of the given URL, using the default
+// port of its scheme if none is given.
+func canonicalHost(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// closedAddr returns the address of a local TCP port with no listener.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	return addr
+}
+
+func TestClient_ConnError(t *testing.T) {
+	addr := closedAddr(t)
+	client := NewClient()
+
+	req, _ := NewRequest("", "http://"+addr+"/file.txt")
+	req.NoStore = true
+	err := client.Do(req).Err()
+
+	var connErr *ConnError
+	if !errors.As(err, &connErr) {
+		t.Fatalf("Expected ConnError, got %T: %v", err, err)
+	}
+	if connErr.Host != addr {
+		t.Errorf("Expected host %q, got %q", addr, connErr.Host)
+	}
+	if len(connErr.Dials) == 0 {
+		t.Fatal("Expected dial attempts to be recorded")
+	}
+	if connErr.Dials[0].Addr != addr || connErr.Dials[0].Err == nil {
+		t.Errorf("Expected failed dial to %q, got %+v", addr, connErr.Dials[0])
+	}
+	if connErr.Proxy != nil {
+		t.Errorf("Expected direct connection, got proxy %v", connErr.Proxy)
+	}
+	if !strings.Contains(err.Error(), "dial tcp "+addr) {
+		t.Errorf("Expected error to describe dial attempt, got %q", err.Error())
+	}
+}
+
+func TestClient_ConnError_Proxy(t *testing.T) {
+	proxy, _ := url.Parse("http://" + closedAddr(t))
+	client := &Client{
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(proxy)},
+		},
+	}
+
+	req, _ := NewRequest("", "http://example.com/file.txt")
+	req.NoStore = true
+	err := client.Do(req).Err()
+
+	var connErr *ConnError
+	if !errors.As(err, &connErr) {
+		t.Fatalf("Expected ConnError, got %T: %v", err, err)
+	}
+	if connErr.Proxy == nil || connErr.Proxy.String() != proxy.String() {
+		t.Errorf("Expected proxy %v, got %v", proxy, connErr.Proxy)
+	}
+	if connErr.Host != proxy.Host {
+		t.Errorf("Expected host %q, got %q", proxy.Host, connErr.Host)
+	}
+	if !strings.Contains(err.Error(), "via proxy") {
+		t.Errorf("Expected error to mention proxy, got %q", err.Error())
+	}
+}
+
+func TestConnTrace_WrapOtherErrors(t *testing.T) {
+	trace := &connTrace{}
+	err := errors.New("network error")
+	if got := trace.wrap(err, "example.com:80", nil); got != err {
+		t.Errorf("Expected error to be returned unchanged, got %v", got)
+	}
+}
+
+func TestCanonicalHost(t *testing.T) {
+	tests := []struct {
+		url    string
+		expect string
+	}{
+		{"http://example.com/file", "example.com:80"},
+		{"https://example.com/file", "example.com:443"},
+		{"http://example.com:8080/file", "example.com:8080"},
+		{"http://[::1]/file", "[::1]:80"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := canonicalHost(u); got != tt.expect {
+			t.Errorf("canonicalHost(%q) = %q, expected %q", tt.url, got, tt.expect)
+		}
+	}
+}
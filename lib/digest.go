@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"hash/crc32"
+	"net/http"
+	"strings"
+)
+
+// remoteDigest describes a checksum header which may be advertised by a remote
+// server, in order of preference.
+type remoteDigest struct {
+	header    string
+	key       string // key within a multi-valued header such as x-goog-hash
+	algorithm string // name of the algorithm, see checksumAlgorithm
+	hash      func() hash.Hash
+
+	// standard is set for the checksum headers of HTTP standards, which are
+	// only used if requested by Request.VerifyServerDigest
+	standard bool
+
+	// body is set for checksums of the message body rather than of the
+	// entire file, which do not describe the file if only a part of it was
+	// sent
+	body bool
+}
+
+var remoteDigests = []remoteDigest{
+	{header: "X-Amz-Checksum-Sha256", algorithm: "sha256", hash: sha256.New},
+	{header: "X-Amz-Checksum-Sha1", algorithm: "sha1", hash: sha1.New},
+	{header: "X-Goog-Hash", key: "md5", algorithm: "md5", hash: md5.New},
+	{header: "X-Amz-Checksum-Crc32c", algorithm: "crc32c", hash: newCRC32C},
+	{header: "X-Goog-Hash", key: "crc32c", algorithm: "crc32c", hash: newCRC32C},
+	{header: "X-Amz-Checksum-Crc32", algorithm: "crc32", hash: newCRC32},
+	{header: "Repr-Digest", key: "sha-512", algorithm: "sha512", hash: sha512.New, standard: true},
+	{header: "Repr-Digest", key: "sha-256", algorithm: "sha256", hash: sha256.New, standard: true},
+	{header: "Digest", key: "SHA-512", algorithm: "sha512", hash: sha512.New, standard: true},
+	{header: "Digest", key: "SHA-256", algorithm: "sha256", hash: sha256.New, standard: true},
+	{header: "Digest", key: "SHA", algorithm: "sha1", hash: sha1.New, standard: true},
+	{header: "Digest", key: "MD5", algorithm: "md5", hash: md5.New, standard: true},
+	{header: "Content-MD5", algorithm: "md5", hash: md5.New, standard: true, body: true},
+}
+
+func newCRC32() hash.Hash {
+	return crc32.NewIEEE()
+}
+
+func newCRC32C() hash.Hash {
+	return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+}
+
+// remoteChecksum returns the name of the algorithm, a hash and the expected
+// checksum of the entire remote
+// file, as advertised by the remote server in provider-specific response
+// headers or trailers, such as those sent by Amazon S3 and Google Cloud
+// Storage. If standard is set, the Repr-Digest (RFC 9530), Digest (RFC 3230)
+// and Content-MD5 headers are also used. No checksum is used if the body was
+// decompressed by the transport, as it no longer matches the checksums of the
+// stored object. If partial is set, or the status
+// of the response is 206 Partial Content, the body is only a part of the file
+// and Content-MD5, which describes the body, is not used. If no usable
+// checksum was advertised, a nil hash is returned.
+//
+// Trailers are only available once the response body has been read in full.
+func remoteChecksum(resp *http.Response, standard, partial bool) (string, hash.Hash, []byte) {
+	if resp == nil || resp.Uncompressed {
+		return "", nil, nil
+	}
+	partial = partial || resp.StatusCode == http.StatusPartialContent
+	for _, d := range remoteDigests {
+		if d.standard && !standard {
+			continue
+		}
+		if d.body && partial {
+			continue
+		}
+		for _, h := range []http.Header{resp.Header, resp.Trailer} {
+			if sum := lookupDigest(h, d); sum != nil {
+				return d.algorithm, d.hash(), sum
+			}
+		}
+	}
+	return "", nil, nil
+}
+
+// lookupDigest returns the decoded checksum for d in the given header, or nil
+// if it is missing or malformed.
+func lookupDigest(h http.Header, d remoteDigest) []byte {
+	for _, v := range h.Values(d.header) {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if d.key != "" {
+				k, val, ok := strings.Cut(part, "=")
+				if !ok || !strings.EqualFold(k, d.key) {
+					continue
+				}
+				part = val
+			}
+			// byte sequences of structured fields, as in Repr-Digest, are
+			// enclosed in colons
+			part = strings.Trim(part, ":")
+			// checksums of multipart objects are suffixed with the part count and
+			// cannot be compared with the checksum of the whole file
+			if part == "" || strings.Contains(part, "-") {
+				continue
+			}
+			sum, err := base64.StdEncoding.DecodeString(part)
+			if err != nil || len(sum) != d.hash().Size() {
+				continue
+			}
+			return sum
+		}
+	}
+	return nil
+}
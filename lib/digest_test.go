@@ -0,0 +1,203 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash/crc32"
+	"net/http"
+	"testing"
+)
+
+func TestRemoteChecksum(t *testing.T) {
+	content := []byte("test content")
+	md5sum := md5.Sum(content)
+	sha256sum := sha256.Sum256(content)
+	crc := crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli))
+	crcsum := []byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)}
+	b64 := base64.StdEncoding.EncodeToString
+
+	tests := []struct {
+		name         string
+		status       int
+		header       http.Header
+		trailer      http.Header
+		standard     bool
+		partial      bool
+		uncompressed bool
+		expect       []byte
+	}{
+		{
+			name:   "no checksum",
+			header: http.Header{},
+		},
+		{
+			name:   "goog hash md5 preferred over crc32c",
+			header: http.Header{"X-Goog-Hash": {"crc32c=" + b64(crcsum) + ",md5=" + b64(md5sum[:])}},
+			expect: md5sum[:],
+		},
+		{
+			name:   "goog hash crc32c",
+			header: http.Header{"X-Goog-Hash": {"crc32c=" + b64(crcsum)}},
+			expect: crcsum,
+		},
+		{
+			name:   "amz checksum sha256",
+			header: http.Header{"X-Amz-Checksum-Sha256": {b64(sha256sum[:])}},
+			expect: sha256sum[:],
+		},
+		{
+			name:    "amz checksum in trailer",
+			header:  http.Header{},
+			trailer: http.Header{"X-Amz-Checksum-Sha256": {b64(sha256sum[:])}},
+			expect:  sha256sum[:],
+		},
+		{
+			name:   "multipart checksum ignored",
+			header: http.Header{"X-Amz-Checksum-Crc32c": {b64(crcsum) + "-3"}},
+		},
+		{
+			name:   "malformed checksum ignored",
+			header: http.Header{"X-Amz-Checksum-Sha256": {"not base64!"}},
+		},
+		{
+			name:   "standard digest ignored unless requested",
+			header: http.Header{"Digest": {"SHA-256=" + b64(sha256sum[:])}},
+		},
+		{
+			name:     "digest sha-256 preferred over md5",
+			header:   http.Header{"Digest": {"md5=" + b64(md5sum[:]) + ",SHA-256=" + b64(sha256sum[:])}},
+			standard: true,
+			expect:   sha256sum[:],
+		},
+		{
+			name:     "repr-digest",
+			header:   http.Header{"Repr-Digest": {"sha-256=:" + b64(sha256sum[:]) + ":"}},
+			standard: true,
+			expect:   sha256sum[:],
+		},
+		{
+			name:     "content-md5",
+			header:   http.Header{"Content-Md5": {b64(md5sum[:])}},
+			standard: true,
+			expect:   md5sum[:],
+		},
+		{
+			name:         "checksum of compressed object ignored",
+			header:       http.Header{"X-Goog-Hash": {"md5=" + b64(md5sum[:])}},
+			uncompressed: true,
+		},
+		{
+			name:         "standard digest of compressed content ignored",
+			header:       http.Header{"Repr-Digest": {"sha-256=:" + b64(sha256sum[:]) + ":"}},
+			standard:     true,
+			uncompressed: true,
+		},
+		{
+			name:     "content-md5 of partial content ignored",
+			status:   http.StatusPartialContent,
+			header:   http.Header{"Content-Md5": {b64(md5sum[:])}},
+			standard: true,
+		},
+		{
+			name:     "content-md5 of resumed transfer ignored",
+			header:   http.Header{"Content-Md5": {b64(md5sum[:])}},
+			standard: true,
+			partial:  true,
+		},
+		{
+			name:     "repr-digest of partial content",
+			status:   http.StatusPartialContent,
+			header:   http.Header{"Repr-Digest": {"sha-256=:" + b64(sha256sum[:]) + ":"}, "Content-Md5": {b64(md5sum[:])}},
+			standard: true,
+			expect:   sha256sum[:],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h, sum := remoteChecksum(&http.Response{StatusCode: tt.status, Header: tt.header, Trailer: tt.trailer, Uncompressed: tt.uncompressed}, tt.standard, tt.partial)
+			if tt.expect == nil {
+				if h != nil {
+					t.Errorf("Expected no checksum, got %x", sum)
+				}
+				return
+			}
+			if h == nil {
+				t.Fatal("Expected checksum, got none")
+			}
+			if !bytes.Equal(sum, tt.expect) {
+				t.Errorf("Expected checksum %x, got %x", tt.expect, sum)
+			}
+			h.Write(content)
+			if !bytes.Equal(h.Sum(nil), tt.expect) {
+				t.Errorf("Hash of content %x does not match expected %x", h.Sum(nil), tt.expect)
+			}
+		})
+	}
+}
+
+func TestClient_RemoteChecksum(t *testing.T) {
+	content := "test content"
+	good := md5.Sum([]byte(content))
+	bad := md5.Sum([]byte("other content"))
+
+	tests := []struct {
+		name        string
+		sum         []byte
+		ignore      bool
+		expectError error
+	}{
+		{name: "matching checksum", sum: good[:]},
+		{name: "mismatched checksum", sum: bad[:], expectError: ErrBadChecksum},
+		{name: "ignored checksum", sum: bad[:], ignore: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testURL := "http://example.com/file.txt"
+			mockClient := newMockHTTPClient()
+			mockClient.addResponse("GET", testURL, createMockHTTPResponse("200 OK", 200, content, map[string]string{
+				"X-Goog-Hash": "md5=" + base64.StdEncoding.EncodeToString(tt.sum),
+			}))
+			client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+			req, _ := NewRequest("", testURL)
+			req.NoStore = true
+			req.IgnoreRemoteChecksum = tt.ignore
+
+			err := client.Do(req).Err()
+			if !errors.Is(err, tt.expectError) {
+				t.Errorf("Expected error %v, got %v", tt.expectError, err)
+			}
+		})
+	}
+}
+
+func TestClient_VerifyServerDigest(t *testing.T) {
+	content := "test content"
+	bad := sha256.Sum256([]byte("other content"))
+
+	for _, verify := range []bool{false, true} {
+		testURL := "http://example.com/file.txt"
+		mockClient := newMockHTTPClient()
+		mockClient.addResponse("GET", testURL, createMockHTTPResponse("200 OK", 200, content, map[string]string{
+			"Digest": "SHA-256=" + base64.StdEncoding.EncodeToString(bad[:]),
+		}))
+		client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+		req, _ := NewRequest("", testURL)
+		req.NoStore = true
+		req.VerifyServerDigest = verify
+
+		err := client.Do(req).Err()
+		if verify && !errors.Is(err, ErrBadChecksum) {
+			t.Errorf("Expected ErrBadChecksum, got %v", err)
+		}
+		if !verify && err != nil {
+			t.Errorf("Expected Digest header to be ignored, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// dnsCache caches the addresses of resolved hosts for a fixed lifetime.
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+	pending map[string]*dnsLookup
+
+	// lookup resolves a host. If nil, net.DefaultResolver is used.
+	lookup func(ctx context.Context, host string) ([]string, error)
+
+	// now returns the current time. If nil, time.Now is used.
+	now func() time.Time
+
+	// dial connects to an address. If nil, a net.Dialer is used.
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+const (
+	// dialTimeout limits the time to connect to a host, including all of its
+	// addresses, as in http.DefaultTransport.
+	dialTimeout = 30 * time.Second
+
+	// dialKeepAlive is the keep-alive period of connections, as in
+	// http.DefaultTransport.
+	dialKeepAlive = 30 * time.Second
+
+	// minDialAddrTimeout is the least time for which each address of a host
+	// with several addresses is tried, unless the time to connect runs out.
+	minDialAddrTimeout = 2 * time.Second
+)
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsLookup is a lookup in progress, whose result is shared by all callers
+// which need the same host meanwhile, such as a worker of a batch and the DNS
+// prefetch of the batch.
+type dnsLookup struct {
+	done  chan struct{}
+	addrs []string
+	err   error
+}
+
+// LookupHost returns the cached addresses of the given host, resolving it
+// again if they are older than ttl. Callers which need a host while it is
+// being resolved share the result of the lookup. Failed lookups are not
+// cached.
+func (c *dnsCache) LookupHost(ctx context.Context, host string, ttl time.Duration) ([]string, error) {
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	if ok && now().Before(e.expires) {
+		c.mu.Unlock()
+		return e.addrs, nil
+	}
+	if l, ok := c.pending[host]; ok {
+		c.mu.Unlock()
+		select {
+		case <-l.done:
+			if l.err != nil && ctx.Err() == nil && (errors.Is(l.err, context.Canceled) || errors.Is(l.err, context.DeadlineExceeded)) {
+				// the lookup was canceled by its caller, not by this one
+				return c.LookupHost(ctx, host, ttl)
+			}
+			return l.addrs, l.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	l := &dnsLookup{done: make(chan struct{})}
+	if c.pending == nil {
+		c.pending = make(map[string]*dnsLookup)
+	}
+	c.pending[host] = l
+	c.mu.Unlock()
+
+	lookup := net.DefaultResolver.LookupHost
+	if c.lookup != nil {
+		lookup = c.lookup
+	}
+	l.addrs, l.err = lookup(ctx, host)
+
+	c.mu.Lock()
+	delete(c.pending, host)
+	if l.err == nil {
+		if c.entries == nil {
+			c.entries = make(map[string]dnsEntry)
+		}
+		c.entries[host] = dnsEntry{addrs: l.addrs, expires: now().Add(ttl)}
+	}
+	c.mu.Unlock()
+	close(l.done)
+	return l.addrs, l.err
+}
+
+// dialContext dials the given address, applying Client.HostOverrides and
+// resolving host names via the DNS cache of the Client if
+// Client.DNSCacheTTL is set. It is the DialContext of the http.Transport
+// created by NewClient. The addresses of a host are tried in turn, each for
+// its share of the time left to connect, so that an unreachable address does
+// not use it up.
+func (c *Client) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if override, ok := c.HostOverrides[host]; ok {
+		host = override
+	}
+
+	dial := (&net.Dialer{KeepAlive: dialKeepAlive}).DialContext
+	if c.dns.dial != nil {
+		dial = c.dns.dial
+	}
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	if c.DNSCacheTTL <= 0 || net.ParseIP(host) != nil {
+		return dial(ctx, network, net.JoinHostPort(host, port))
+	}
+	addrs, err := c.dns.LookupHost(ctx, host, c.DNSCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for i, a := range addrs {
+		actx, acancel := ctx, context.CancelFunc(func() {})
+		if left := len(addrs) - i; left > 1 {
+			deadline, _ := ctx.Deadline()
+			remaining := time.Until(deadline)
+			timeout := max(remaining/time.Duration(left), min(minDialAddrTimeout, remaining))
+			actx, acancel = context.WithTimeout(ctx, timeout)
+		}
+		conn, err := dial(actx, network, net.JoinHostPort(a, port))
+		acancel()
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// dnsPrefetchLookups is the number of hosts which are resolved concurrently
+// when a batch is submitted.
+const dnsPrefetchLookups = 16
+
+// prefetchDNS resolves the unique hosts of the given requests and their
+// mirrors concurrently in the background, if Client.DNSCacheTTL is set, so
+// that their addresses are cached before the workers of a batch connect to
+// them. Hosts which are reached through a proxy are not resolved. Lookups which
+// fail are not cached and are retried when connecting.
+func (c *Client) prefetchDNS(ctx context.Context, requests []*Request) {
+	if c.DNSCacheTTL <= 0 {
+		return
+	}
+	var hosts []string
+	seen := make(map[string]bool)
+	add := func(rawURL string) {
+		hreq, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil || (hreq.URL.Scheme != "http" && hreq.URL.Scheme != "https") {
+			return
+		}
+		if c.proxyFor(hreq) != nil {
+			// the proxy resolves the host
+			return
+		}
+		host := hreq.URL.Hostname()
+		if override, ok := c.HostOverrides[host]; ok {
+			host = override
+		}
+		if host == "" || net.ParseIP(host) != nil || seen[host] {
+			return
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	for _, req := range requests {
+		add(req.URL().String())
+		for _, m := range req.Mirrors {
+			add(m)
+		}
+	}
+	if len(hosts) == 0 {
+		return
+	}
+
+	hostch := make(chan string, len(hosts))
+	for _, host := range hosts {
+		hostch <- host
+	}
+	close(hostch)
+	for i := 0; i < min(dnsPrefetchLookups, len(hosts)); i++ {
+		go func() {
+			for host := range hostch {
+				if ctx.Err() != nil {
+					return
+				}
+				_, _ = c.dns.LookupHost(ctx, host, c.DNSCacheTTL)
+			}
+		}()
+	}
+}
@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDNSCache_LookupHost(t *testing.T) {
+	lookups := 0
+	now := time.Now()
+	cache := &dnsCache{
+		lookup: func(ctx context.Context, host string) ([]string, error) {
+			lookups++
+			return []string{"192.0.2.1"}, nil
+		},
+		now: func() time.Time { return now },
+	}
+
+	for i := 0; i < 3; i++ {
+		addrs, err := cache.LookupHost(context.Background(), "example.com", time.Minute)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+			t.Fatalf("Unexpected addresses: %v", addrs)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("Expected 1 lookup while cached, got %d", lookups)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := cache.LookupHost(context.Background(), "example.com", time.Minute); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if lookups != 2 {
+		t.Errorf("Expected expired entry to be resolved again, got %d lookups", lookups)
+	}
+}
+
+func TestClient_dialContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on loopback: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	t.Run("host override", func(t *testing.T) {
+		c := &Client{HostOverrides: map[string]string{"files.invalid": "127.0.0.1"}}
+		conn, err := c.dialContext(context.Background(), "tcp", net.JoinHostPort("files.invalid", port))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_ = conn.Close()
+	})
+
+	t.Run("dns cache", func(t *testing.T) {
+		lookups := 0
+		c := &Client{DNSCacheTTL: time.Minute}
+		c.dns.lookup = func(ctx context.Context, host string) ([]string, error) {
+			lookups++
+			return []string{"127.0.0.1"}, nil
+		}
+		for i := 0; i < 3; i++ {
+			conn, err := c.dialContext(context.Background(), "tcp", net.JoinHostPort("cached.invalid", port))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			_ = conn.Close()
+		}
+		if lookups != 1 {
+			t.Errorf("Expected 1 lookup, got %d", lookups)
+		}
+	})
+
+	t.Run("unreachable address", func(t *testing.T) {
+		c := &Client{DNSCacheTTL: time.Minute}
+		c.dns.lookup = func(ctx context.Context, host string) ([]string, error) {
+			return []string{"192.0.2.1", "127.0.0.1"}, nil
+		}
+		var d net.Dialer
+		c.dns.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if strings.HasPrefix(addr, "192.0.2.1:") {
+				// a black hole, which never answers
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return d.DialContext(ctx, network, addr)
+		}
+
+		// the first address may only use its share of the time to connect
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := c.dialContext(ctx, "tcp", net.JoinHostPort("multi.invalid", port))
+		if err != nil {
+			t.Fatalf("Expected the second address to be tried, got %v", err)
+		}
+		_ = conn.Close()
+	})
+}
+
+func TestNewClient_DialContext(t *testing.T) {
+	c := NewClient()
+	if clientTransport(t, c).DialContext == nil {
+		t.Error("Expected NewClient to install a DialContext for HostOverrides and DNSCacheTTL")
+	}
+}
+
+func TestClient_prefetchDNS(t *testing.T) {
+	resolved := make(chan string, 10)
+	release := make(chan struct{})
+	proxy, _ := url.Parse("http://proxy.invalid:3128")
+	c := &Client{
+		DNSCacheTTL:   time.Minute,
+		HostOverrides: map[string]string{"override.invalid": "127.0.0.1"},
+		HTTPClient: &http.Client{Transport: &http.Transport{
+			Proxy: func(req *http.Request) (*url.URL, error) {
+				if req.URL.Hostname() == "proxied.invalid" {
+					return proxy, nil
+				}
+				return nil, nil
+			},
+		}},
+	}
+	c.dns.lookup = func(ctx context.Context, host string) ([]string, error) {
+		resolved <- host
+		<-release
+		return []string{"192.0.2.1"}, nil
+	}
+
+	var requests []*Request
+	for _, u := range []string{
+		"http://a.invalid/1",
+		"https://a.invalid/2",
+		"http://b.invalid/file",
+		"http://override.invalid/file",
+		"http://192.0.2.7/file",
+		"http://proxied.invalid/file",
+	} {
+		req, _ := NewRequest("", u)
+		requests = append(requests, req)
+	}
+	requests[0].Mirrors = []string{"http://c.invalid/1"}
+	c.prefetchDNS(context.Background(), requests)
+
+	// all hosts are resolved concurrently
+	hosts := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		select {
+		case host := <-resolved:
+			hosts[host] = true
+		case <-time.After(time.Second):
+			t.Fatalf("Expected concurrent lookups, got %v", hosts)
+		}
+	}
+	if !hosts["a.invalid"] || !hosts["b.invalid"] || !hosts["c.invalid"] {
+		t.Errorf("Unexpected hosts resolved: %v", hosts)
+	}
+
+	// a connection to a host being prefetched waits for its lookup
+	done := make(chan error)
+	go func() {
+		_, err := c.dns.LookupHost(context.Background(), "a.invalid", time.Minute)
+		done <- err
+	}()
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case host := <-resolved:
+		t.Errorf("Unexpected lookup of %s", host)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
 var (
@@ -25,8 +28,128 @@ var (
 
 	// ErrFileExists indicates that the destination path already exists.
 	ErrFileExists = errors.New("file exists")
+
+	// ErrSkipped may be returned by a Request.BeforeStore hook to skip a
+	// download.
+	ErrSkipped = errors.New("download skipped")
+
+	// ErrResumeMismatch indicates that the content sent by the remote server
+	// for a resumed download does not match the partially downloaded file.
+	ErrResumeMismatch = errors.New("resumed content does not match existing file")
+
+	// ErrIncompleteBody indicates that the connection to the remote server
+	// ended before the expected content length was received. It is matched by
+	// errors of type IncompleteBodyError.
+	ErrIncompleteBody = errors.New("incomplete response body")
+
+	// ErrTooManyRedirects indicates that the remote server redirected more
+	// times than allowed by Request.MaxRedirects. It is matched by errors of
+	// type TooManyRedirectsError.
+	ErrTooManyRedirects = errors.New("too many redirects")
+
+	// ErrModified indicates that the remote file was modified after
+	// Request.UnmodifiedSince. It is matched by errors of type ModifiedError.
+	ErrModified = errors.New("remote file modified")
+
+	// ErrSchemeNotAllowed indicates that a URL has a scheme which is not
+	// listed by Client.AllowedSchemes.
+	ErrSchemeNotAllowed = errors.New("URL scheme not allowed")
 )
 
+// IncompleteBodyError indicates that fewer bytes were received than the
+// remote server announced, such as when a connection is dropped mid-transfer.
+// The partially downloaded file is kept, so the download may be resumed by
+// sending the same request again.
+type IncompleteBodyError struct {
+	// Expected is the expected size of the file in bytes.
+	Expected int64
+
+	// Received is the number of bytes of the file which were received,
+	// including any bytes resumed from an existing file.
+	Received int64
+}
+
+func (err *IncompleteBodyError) Error() string {
+	return fmt.Sprintf("%v: received %d of %d bytes", ErrIncompleteBody, err.Received, err.Expected)
+}
+
+// Is returns true if target is ErrIncompleteBody.
+func (err *IncompleteBodyError) Is(target error) bool {
+	return target == ErrIncompleteBody
+}
+
+// TooManyRedirectsError indicates that a request was redirected more times
+// than allowed by Request.MaxRedirects, such as by a redirect loop between
+// misconfigured mirrors.
+type TooManyRedirectsError struct {
+	// Max is the maximum number of redirects which were allowed.
+	Max int
+
+	// Chain lists the requested URLs in order, starting with the original URL
+	// and ending with the redirect which was not followed.
+	Chain []*url.URL
+}
+
+func (err *TooManyRedirectsError) Error() string {
+	urls := make([]string, len(err.Chain))
+	for i, u := range err.Chain {
+		urls[i] = u.String()
+	}
+	return fmt.Sprintf("%v: stopped after %d redirects: %s", ErrTooManyRedirects, err.Max, strings.Join(urls, " -> "))
+}
+
+// Is returns true if target is ErrTooManyRedirects.
+func (err *TooManyRedirectsError) Is(target error) bool {
+	return target == ErrTooManyRedirects
+}
+
+// BlockMismatchError indicates that a block of a downloaded file does not
+// match the block manifest of the Request, such as when a mirror serves a
+// corrupted copy. The transfer is aborted as soon as the block is complete,
+// and the file is truncated to the start of the block, so that the download
+// may be resumed from the last good block, such as from another mirror.
+type BlockMismatchError struct {
+	// Block is the index of the block in the manifest.
+	Block int
+
+	// Offset is the offset of the block in the file.
+	Offset int64
+}
+
+func (err *BlockMismatchError) Error() string {
+	return fmt.Sprintf("%v: block %d at byte %d", ErrBadChecksum, err.Block, err.Offset)
+}
+
+// Is returns true if target is ErrBadChecksum.
+func (err *BlockMismatchError) Is(target error) bool {
+	return target == ErrBadChecksum
+}
+
+// ModifiedError indicates that the remote file was modified after
+// Request.UnmodifiedSince, as shown by its Last-Modified header or by a 412
+// Precondition Failed response to the If-Unmodified-Since header.
+type ModifiedError struct {
+	// Since is the time of Request.UnmodifiedSince.
+	Since time.Time
+
+	// LastModified is the modification time of the remote file, or zero if
+	// the server only responded with 412 Precondition Failed.
+	LastModified time.Time
+}
+
+func (err *ModifiedError) Error() string {
+	if err.LastModified.IsZero() {
+		return fmt.Sprintf("%v since %v", ErrModified, err.Since.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%v at %v, after %v", ErrModified,
+		err.LastModified.UTC().Format(time.RFC3339), err.Since.UTC().Format(time.RFC3339))
+}
+
+// Is returns true if target is ErrModified.
+func (err *ModifiedError) Is(target error) bool {
+	return target == ErrModified
+}
+
 // StatusCodeError indicates that the server response had a status code that
 // was not in the 200-299 range (after following any redirects).
 type StatusCodeError int
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxEvents is the number of most recent events kept for each Response.
+const maxEvents = 32
+
+// An Event records a significant decision or occurrence during a transfer,
+// such as the result of a HEAD request, whether an existing file is resumed,
+// a redirect or a wait for a rate limit.
+type Event struct {
+	// Time is the time at which the event occurred.
+	Time time.Time
+
+	// Message describes the event.
+	Message string
+
+	// Worker is the ID of the batch worker which sent the request, or zero.
+	// See WorkerID.
+	Worker int
+}
+
+// String returns the time and message of the event, and the batch worker
+// which sent the request, if any.
+func (e Event) String() string {
+	if e.Worker > 0 {
+		return e.Time.Format("15:04:05.000") + " [worker " + strconv.Itoa(e.Worker) + "] " + e.Message
+	}
+	return e.Time.Format("15:04:05.000") + " " + e.Message
+}
+
+// eventLog is a ring buffer of the most recent events of a transfer.
+type eventLog struct {
+	mu     sync.Mutex
+	events [maxEvents]Event
+	n      int
+}
+
+func (l *eventLog) add(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events[l.n%maxEvents] = e
+	l.n++
+}
+
+func (l *eventLog) list() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.n <= maxEvents {
+		return append([]Event(nil), l.events[:l.n]...)
+	}
+	i := l.n % maxEvents
+	return append(append([]Event(nil), l.events[i:]...), l.events[:i]...)
+}
+
+// Events returns up to the last 32 significant events of the transfer, oldest
+// first, to explain the decisions made while downloading a file. The events
+// are intended for debugging and bug reports; their messages are not stable.
+func (c *Response) Events() []Event {
+	return c.events.list()
+}
+
+// logf records an event with the given formatted message.
+func (c *Response) logf(format string, args ...interface{}) {
+	e := Event{Time: time.Now(), Message: fmt.Sprintf(format, args...), Worker: c.worker}
+	c.events.add(e)
+	if c.Request != nil && c.Request.OnEvent != nil {
+		c.Request.OnEvent(c, e)
+	}
+}
@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// DefaultContentTypeExtensions maps common media types to the extensions
+// appended to file names without one, for use as Client.ContentTypeExtensions.
+// Types which do not identify a file format, such as
+// application/octet-stream, are deliberately absent.
+var DefaultContentTypeExtensions = map[string]string{
+	"application/gzip":                      ".gz",
+	"application/javascript":                ".js",
+	"application/json":                      ".json",
+	"application/pdf":                       ".pdf",
+	"application/vnd.debian.binary-package": ".deb",
+	"application/wasm":                      ".wasm",
+	"application/x-bzip2":                   ".bz2",
+	"application/x-gzip":                    ".gz",
+	"application/x-iso9660-image":           ".iso",
+	"application/x-rpm":                     ".rpm",
+	"application/x-tar":                     ".tar",
+	"application/x-xz":                      ".xz",
+	"application/xml":                       ".xml",
+	"application/zip":                       ".zip",
+	"application/zstd":                      ".zst",
+	"audio/mpeg":                            ".mp3",
+	"image/gif":                             ".gif",
+	"image/jpeg":                            ".jpg",
+	"image/png":                             ".png",
+	"image/svg+xml":                         ".svg",
+	"image/webp":                            ".webp",
+	"text/css":                              ".css",
+	"text/csv":                              ".csv",
+	"text/html":                             ".html",
+	"text/javascript":                       ".js",
+	"text/plain":                            ".txt",
+	"text/xml":                              ".xml",
+	"video/mp4":                             ".mp4",
+}
+
+// contentTypeExtension returns the extension which Client.ContentTypeExtensions
+// maps the Content-Type of the given response to, if the given file name has
+// no extension, or else an empty string.
+func (c *Client) contentTypeExtension(filename string, hresp *http.Response) string {
+	if c.ContentTypeExtensions == nil || path.Ext(filename) != "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(hresp.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	ext := c.ContentTypeExtensions[mediaType]
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestClient_ContentTypeExtensions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path, contentType, want string
+	}{
+		{"/report", "application/pdf", "report.pdf"},
+		{"/report", "text/plain; charset=utf-8", "report.txt"},
+		{"/report", "application/x-custom", "report.custom"},
+		{"/report", "application/octet-stream", "report"},
+		{"/report", "", "report"},
+		{"/archive.tar.gz", "application/x-tar", "archive.tar.gz"},
+	}
+	client := NewClient()
+	client.ContentTypeExtensions = map[string]string{"application/x-custom": "custom"}
+	for k, v := range DefaultContentTypeExtensions {
+		client.ContentTypeExtensions[k] = v
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		req, _ := NewRequest(dir, server.URL+test.path+"?type="+url.QueryEscape(test.contentType))
+		resp := client.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if got := filepath.Base(resp.Filename); got != test.want {
+			t.Errorf("%s with %q: expected %s, got %s", test.path, test.contentType, test.want, got)
+		}
+	}
+
+	// names given by the request are kept
+	filename := filepath.Join(t.TempDir(), "report")
+	req, _ := NewRequest(filename, server.URL+"/report?type=application/pdf")
+	if resp := client.Do(req); resp.Err() != nil || resp.Filename != filename {
+		t.Errorf("Expected %s, got %s (%v)", filename, resp.Filename, resp.Err())
+	}
+}
@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRSSFeed = `<?xml version="1.0"?>
+<rss version="2.0">
+  <channel>
+    <title>Releases</title>
+    <item>
+      <title>Release 1.1</title>
+      <pubDate>Mon, 02 Jan 2006 15:04:05 -0700</pubDate>
+      <enclosure url="/dl/release-1.1.iso" type="application/x-iso9660-image" length="1024"/>
+    </item>
+    <item>
+      <title>Announcement</title>
+    </item>
+    <item>
+      <title>Release 1.0</title>
+      <enclosure url="http://mirror.example.com/release-1.0.iso" length="512"/>
+    </item>
+  </channel>
+</rss>`
+
+const testAtomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Podcast</title>
+  <entry>
+    <title>Episode 2</title>
+    <updated>2006-01-02T15:04:05Z</updated>
+    <link rel="alternate" href="http://example.com/episode-2"/>
+    <link rel="enclosure" type="audio/mpeg" length="2048" href="episode-2.mp3"/>
+  </entry>
+</feed>`
+
+func TestParseFeed(t *testing.T) {
+	base, _ := url.Parse("http://example.com/feeds/releases.xml")
+	tests := []struct {
+		name   string
+		feed   string
+		expect []FeedItem
+	}{
+		{
+			name: "rss",
+			feed: testRSSFeed,
+			expect: []FeedItem{
+				{
+					Title:     "Release 1.1",
+					URL:       "http://example.com/dl/release-1.1.iso",
+					Type:      "application/x-iso9660-image",
+					Length:    1024,
+					Published: time.Date(2006, 1, 2, 22, 4, 5, 0, time.UTC),
+				},
+				{
+					Title:  "Release 1.0",
+					URL:    "http://mirror.example.com/release-1.0.iso",
+					Length: 512,
+				},
+			},
+		},
+		{
+			name: "atom",
+			feed: testAtomFeed,
+			expect: []FeedItem{
+				{
+					Title:     "Episode 2",
+					URL:       "http://example.com/feeds/episode-2.mp3",
+					Type:      "audio/mpeg",
+					Length:    2048,
+					Published: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := ParseFeed(strings.NewReader(tt.feed), base)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(items) != len(tt.expect) {
+				t.Fatalf("Expected %d items, got %+v", len(tt.expect), items)
+			}
+			for i, item := range items {
+				expect := tt.expect[i]
+				if item.Title != expect.Title || item.URL != expect.URL || item.Type != expect.Type || item.Length != expect.Length {
+					t.Errorf("Expected item %+v, got %+v", expect, item)
+				}
+				if !item.Published.Equal(expect.Published) {
+					t.Errorf("Expected published time %v, got %v", expect.Published, item.Published)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFeed_Invalid(t *testing.T) {
+	if _, err := ParseFeed(strings.NewReader("<rss><channel>"), nil); err == nil {
+		t.Error("Expected error for truncated feed")
+	}
+}
+
+func TestClient_Feed(t *testing.T) {
+	feedURL := "http://example.com/feeds/releases.xml"
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", feedURL, createSuccessResponse(testRSSFeed))
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+	items, err := client.Feed(context.Background(), feedURL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(items) != 2 || items[0].URL != "http://example.com/dl/release-1.1.iso" {
+		t.Errorf("Expected enclosures resolved against the feed URL, got %+v", items)
+	}
+
+	mockClient.addResponse("GET", feedURL, createErrorResponse(404, "Not Found"))
+	if _, err := client.Feed(context.Background(), feedURL); !IsStatusCodeError(err) {
+		t.Errorf("Expected StatusCodeError, got %v", err)
+	}
+}
@@ -0,0 +1,410 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registerFTP registers the FTP backend with the given Transport, so that
+// ftp:// and ftps:// URLs are downloaded like any other URL. The file is
+// served like an HTTP response, with its size and modification time and with
+// support for ranges, so that progress, resuming via REST and checksums work
+// as with HTTP downloads.
+//
+// ftps:// URLs use implicit TLS, by default on port 990, for the control and
+// data connections. Credentials are taken from the URL, or else the anonymous
+// login is used. Connections are made in passive mode using the dialer and
+// TLS configuration of the Transport. The Proxy of the Transport is not used,
+// as HTTP proxies cannot carry FTP sessions, so ftp:// URLs always connect
+// directly.
+func registerFTP(t *http.Transport) {
+	rt := &ftpTransport{transport: t}
+	t.RegisterProtocol("ftp", rt)
+	t.RegisterProtocol("ftps", rt)
+}
+
+// ftpTransport is an http.RoundTripper which downloads files from FTP servers.
+type ftpTransport struct {
+	transport *http.Transport
+}
+
+// ftpError is an unexpected reply of an FTP server.
+type ftpError struct {
+	Code int
+	Msg  string
+}
+
+func (err *ftpError) Error() string {
+	return fmt.Sprintf("ftp: %d %s", err.Code, err.Msg)
+}
+
+// ftpConn is the control connection of an FTP session.
+type ftpConn struct {
+	*textproto.Conn
+	conn net.Conn
+	host string
+	tls  *tls.Config // set for ftps
+}
+
+// cmd sends a command and returns the reply, which must have the given code
+// or class of codes, such as 2 for all positive completion replies.
+func (c *ftpConn) cmd(expect int, format string, args ...any) (int, string, error) {
+	if _, err := c.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.reply(expect)
+}
+
+// reply reads a reply which must have the given code or class of codes.
+func (c *ftpConn) reply(expect int) (int, string, error) {
+	code, msg, err := c.ReadResponse(expect)
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return code, msg, &ftpError{Code: protoErr.Code, Msg: protoErr.Msg}
+	}
+	return code, msg, err
+}
+
+// errFTPControlChar is returned for ftp:// URLs whose path or credentials
+// contain characters which would end an FTP command and begin another.
+var errFTPControlChar = errors.New("ftp: URL path, user name or password contains CR, LF or NUL")
+
+// checkFTPURL rejects URLs whose decoded path or credentials would inject
+// commands into the FTP session.
+func checkFTPURL(u *url.URL) error {
+	fields := []string{u.Path}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		fields = append(fields, u.User.Username(), pass)
+	}
+	for _, s := range fields {
+		if strings.ContainsAny(s, "\r\n\x00") {
+			return errFTPControlChar
+		}
+	}
+	return nil
+}
+
+func (t *ftpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	if err := checkFTPURL(req.URL); err != nil {
+		return nil, err
+	}
+	ctx := req.Context()
+	c, err := t.login(ctx, req.URL)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.conn.Close()
+	})
+	resp, err := t.roundTrip(ctx, c, req)
+	if err != nil || resp.Body == http.NoBody {
+		stop()
+		_ = c.Close()
+		if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+			err = ctxErr
+		}
+		return resp, err
+	}
+	resp.Body.(*ftpBody).stop = stop
+	return resp, nil
+}
+
+// roundTrip serves the request on the logged in control connection.
+func (t *ftpTransport) roundTrip(ctx context.Context, c *ftpConn, req *http.Request) (*http.Response, error) {
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp.Status = "405 Method Not Allowed"
+		resp.StatusCode = http.StatusMethodNotAllowed
+		return resp, nil
+	}
+
+	// paths are relative to the login directory, see RFC 1738
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	if _, _, err := c.cmd(2, "TYPE I"); err != nil {
+		return nil, err
+	}
+	size := int64(-1)
+	_, msg, err := c.cmd(213, "SIZE %s", path)
+	var ftpErr *ftpError
+	switch {
+	case err == nil:
+		if size, err = strconv.ParseInt(strings.TrimSpace(msg), 10, 64); err != nil {
+			size = -1
+		}
+	case errors.As(err, &ftpErr) && ftpErr.Code == 550:
+		resp.Status = "404 Not Found"
+		resp.StatusCode = http.StatusNotFound
+		return resp, nil
+	case !errors.As(err, &ftpErr):
+		return nil, err
+	}
+	// otherwise the server does not support SIZE and the size is unknown
+	if _, msg, err := c.cmd(213, "MDTM %s", path); err == nil {
+		if s := strings.TrimSpace(msg); len(s) >= 14 {
+			if mtime, err := time.Parse("20060102150405", s[:14]); err == nil {
+				resp.Header.Set("Last-Modified", mtime.UTC().Format(http.TimeFormat))
+			}
+		}
+	} else if !errors.As(err, &ftpErr) {
+		return nil, err
+	}
+
+	start, end := int64(0), size
+	if size >= 0 {
+		resp.Header.Set("Accept-Ranges", "bytes")
+		var ok bool
+		if start, end, ok = parseRangeHeader(req.Header.Get("Range"), size); !ok {
+			resp.Status = "416 Requested Range Not Satisfiable"
+			resp.StatusCode = http.StatusRequestedRangeNotSatisfiable
+			resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			return resp, nil
+		}
+		if start > 0 || end < size {
+			resp.Status = "206 Partial Content"
+			resp.StatusCode = http.StatusPartialContent
+			resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+		}
+		resp.ContentLength = end - start
+		resp.Header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	} else {
+		resp.ContentLength = -1
+	}
+	if req.Method == http.MethodHead || resp.ContentLength == 0 {
+		return resp, nil
+	}
+
+	data, err := t.openData(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	// a canceled transfer must not block on a stalled data connection
+	stopData := context.AfterFunc(ctx, func() {
+		_ = data.Close()
+	})
+	if start > 0 {
+		if _, _, err := c.cmd(350, "REST %d", start); err != nil {
+			stopData()
+			_ = data.Close()
+			return nil, err
+		}
+	}
+	if _, _, err := c.cmd(1, "RETR %s", path); err != nil {
+		stopData()
+		_ = data.Close()
+		if errors.As(err, &ftpErr) && ftpErr.Code == 550 {
+			resp.Status = "404 Not Found"
+			resp.StatusCode = http.StatusNotFound
+			return resp, nil
+		}
+		return nil, err
+	}
+	if c.tls != nil {
+		data = tls.Client(data, c.tls)
+	}
+	body := &ftpBody{ctx: ctx, ctrl: c, data: data, r: data, stopData: stopData}
+	if resp.ContentLength >= 0 {
+		body.r = io.LimitReader(data, resp.ContentLength)
+		body.n = resp.ContentLength
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// login connects to the FTP server of the given URL and logs in.
+func (t *ftpTransport) login(ctx context.Context, u *url.URL) (*ftpConn, error) {
+	port := u.Port()
+	if port == "" {
+		port = "21"
+		if u.Scheme == "ftps" {
+			port = "990"
+		}
+	}
+	conn, err := t.dial(ctx, net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	c := &ftpConn{conn: conn, host: u.Hostname()}
+	if u.Scheme == "ftps" {
+		c.tls = &tls.Config{}
+		if t.transport.TLSClientConfig != nil {
+			c.tls = t.transport.TLSClientConfig.Clone()
+		}
+		if c.tls.ServerName == "" {
+			c.tls.ServerName = u.Hostname()
+		}
+		if c.tls.ClientSessionCache == nil {
+			// servers often require the TLS session of the control connection
+			// to be resumed by the data connection
+			c.tls.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+		}
+		c.conn = tls.Client(conn, c.tls)
+	}
+	c.Conn = textproto.NewConn(c.conn)
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	user, pass := "anonymous", "anonymous@"
+	if u.User != nil {
+		user = u.User.Username()
+		pass, _ = u.User.Password()
+	}
+	err = func() error {
+		if _, _, err := c.reply(220); err != nil {
+			return err
+		}
+		code, _, err := c.cmd(0, "USER %s", user)
+		if err != nil {
+			return err
+		}
+		switch code {
+		case 230:
+		case 331:
+			if _, _, err := c.cmd(230, "PASS %s", pass); err != nil {
+				return err
+			}
+		default:
+			return &ftpError{Code: code, Msg: "login failed"}
+		}
+		if c.tls != nil {
+			if _, _, err := c.cmd(200, "PBSZ 0"); err != nil {
+				return err
+			}
+			if _, _, err := c.cmd(200, "PROT P"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		_ = c.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("cannot log in to %s: %w", u.Host, err)
+	}
+	return c, nil
+}
+
+// openData opens a passive data connection, preferring extended passive mode.
+func (t *ftpTransport) openData(ctx context.Context, c *ftpConn) (net.Conn, error) {
+	var port string
+	if _, msg, err := c.cmd(229, "EPSV"); err == nil {
+		// Entering Extended Passive Mode (|||port|)
+		if i, j := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)"); i >= 0 && j > i+4 {
+			port = msg[i+4 : j]
+		}
+	}
+	if port == "" {
+		_, msg, err := c.cmd(227, "PASV")
+		if err != nil {
+			return nil, err
+		}
+		// Entering Passive Mode (h1,h2,h3,h4,p1,p2); the address is ignored
+		// in favor of the host of the control connection, as it is often
+		// wrong behind NAT
+		i, j := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+		if i < 0 || j < i {
+			return nil, fmt.Errorf("ftp: invalid passive mode reply: %s", msg)
+		}
+		fields := strings.Split(msg[i+1:j], ",")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("ftp: invalid passive mode reply: %s", msg)
+		}
+		p1, err1 := strconv.Atoi(strings.TrimSpace(fields[4]))
+		p2, err2 := strconv.Atoi(strings.TrimSpace(fields[5]))
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("ftp: invalid passive mode reply: %s", msg)
+		}
+		port = strconv.Itoa(p1<<8 | p2)
+	}
+	return t.dial(ctx, net.JoinHostPort(c.host, port))
+}
+
+// dial connects to the given address with the dialer of the Transport.
+func (t *ftpTransport) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if dial := t.transport.DialContext; dial != nil {
+		return dial(ctx, "tcp", addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// ftpBody is the body of a response served from an FTP data connection.
+type ftpBody struct {
+	ctx  context.Context
+	ctrl *ftpConn
+	data net.Conn
+	r    io.Reader
+	n    int64 // expected size, or 0 if unknown
+	read int64
+	eof  bool
+	stop func() bool
+
+	// stopData stops closing the data connection on cancelation
+	stopData func() bool
+}
+
+func (b *ftpBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.ctx != nil && b.ctx.Err() != nil {
+		// the data connection was closed because the transfer was canceled
+		return n, b.ctx.Err()
+	}
+	if err == io.EOF {
+		if b.n > 0 && b.read < b.n {
+			// a limited reader hides a data connection which closed early
+			return n, io.ErrUnexpectedEOF
+		}
+		b.eof = true
+	}
+	return n, err
+}
+
+// Close closes the data connection and ends the session, confirming that
+// the transfer completed if the body was read in full.
+func (b *ftpBody) Close() error {
+	// the data connection was already closed if the transfer was canceled
+	canceled := b.stopData != nil && !b.stopData()
+	err := b.data.Close()
+	if canceled {
+		err = nil
+	}
+	if b.eof && b.n <= 0 {
+		// the server confirms the end of the transfer of a file of unknown
+		// size; otherwise the transfer may have been cut short
+		if _, _, replyErr := b.ctrl.reply(2); replyErr != nil {
+			err = replyErr
+		}
+	}
+	_ = b.ctrl.Close()
+	if b.stop != nil {
+		b.stop()
+	}
+	return err
+}
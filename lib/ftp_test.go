@@ -0,0 +1,298 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ftpTestServer is a minimal FTP server which serves files from memory.
+type ftpTestServer struct {
+	ln     net.Listener
+	files  map[string][]byte
+	noSize bool // reply to SIZE as unsupported
+	noEPSV bool // reply to EPSV as unsupported
+	stall  bool // send half of each file, then stall the data connection
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newFTPTestServer(t *testing.T, files map[string][]byte) *ftpTestServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on loopback: %v", err)
+	}
+	s := &ftpTestServer{ln: ln, files: files}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { _ = ln.Close() })
+	return s
+}
+
+// URL returns the URL of the given path on the server.
+func (s *ftpTestServer) URL(userinfo, path string) string {
+	if userinfo != "" {
+		userinfo += "@"
+	}
+	return "ftp://" + userinfo + s.ln.Addr().String() + path
+}
+
+// sent reports whether the server received the given command.
+func (s *ftpTestServer) sent(command string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ftpTestServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reply := func(format string, args ...any) {
+		_, _ = fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	reply("220 ready")
+	r := bufio.NewReader(conn)
+	var pasv net.Listener
+	var user string
+	var rest int64
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+		cmd, arg, _ := strings.Cut(line, " ")
+		f, found := s.files[arg]
+		switch cmd {
+		case "USER":
+			user = arg
+			reply("331 password required")
+		case "PASS":
+			if (user == "anonymous" && arg == "anonymous@") || (user == "user" && arg == "secret") {
+				reply("230 logged in")
+			} else {
+				reply("530 login incorrect")
+			}
+		case "TYPE":
+			reply("200 binary")
+		case "SIZE", "MDTM":
+			switch {
+			case !found:
+				reply("550 not found")
+			case cmd == "MDTM":
+				reply("213 20240501120000")
+			case s.noSize:
+				reply("502 not implemented")
+			default:
+				reply("213 %d", len(f))
+			}
+		case "EPSV", "PASV":
+			if cmd == "EPSV" && s.noEPSV {
+				reply("502 not implemented")
+				continue
+			}
+			if pasv, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				reply("425 cannot open data connection")
+				continue
+			}
+			port := pasv.Addr().(*net.TCPAddr).Port
+			if cmd == "EPSV" {
+				reply("229 Entering Extended Passive Mode (|||%d|)", port)
+			} else {
+				// a wrong address, as sent by servers behind NAT
+				reply("227 Entering Passive Mode (10,0,0,1,%d,%d)", port>>8, port&0xff)
+			}
+		case "REST":
+			rest, _ = strconv.ParseInt(arg, 10, 64)
+			reply("350 restarting")
+		case "RETR":
+			if !found {
+				reply("550 not found")
+				continue
+			}
+			reply("150 opening data connection")
+			data, err := pasv.Accept()
+			_ = pasv.Close()
+			if err != nil {
+				return
+			}
+			if s.stall {
+				_, _ = data.Write(f[rest : len(f)/2])
+				// wait for the client to give up
+				_, _ = io.Copy(io.Discard, data)
+				_ = data.Close()
+				return
+			}
+			_, _ = data.Write(f[rest:])
+			_ = data.Close()
+			rest = 0
+			reply("226 transfer complete")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// newFTPClient returns a Client which allows ftp: URLs.
+func newFTPClient() *Client {
+	client := NewClient()
+	client.AllowedSchemes = []string{"ftp"}
+	return client
+}
+
+func TestClient_Do_FTP(t *testing.T) {
+	content := []byte("hello, ftp world")
+	server := newFTPTestServer(t, map[string][]byte{"pub/file.txt": content})
+	client := newFTPClient()
+	dir := t.TempDir()
+
+	t.Run("Anonymous", func(t *testing.T) {
+		req, _ := NewRequest(dir, server.URL("", "/pub/file.txt"))
+		resp := client.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Filename != filepath.Join(dir, "file.txt") {
+			t.Errorf("Unexpected filename: %s", resp.Filename)
+		}
+		if got, _ := os.ReadFile(resp.Filename); !bytes.Equal(got, content) {
+			t.Errorf("Downloaded file does not match content: %q", got)
+		}
+		if resp.Size() != int64(len(content)) {
+			t.Errorf("Expected size %d, got %d", len(content), resp.Size())
+		}
+		if lm := resp.HTTPResponse.Header.Get("Last-Modified"); lm != "Wed, 01 May 2024 12:00:00 GMT" {
+			t.Errorf("Unexpected Last-Modified: %s", lm)
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		filename := filepath.Join(dir, "resumed.txt")
+		if err := os.WriteFile(filename, content[:5], 0644); err != nil {
+			t.Fatal(err)
+		}
+		req, _ := NewRequest(filename, server.URL("user:secret", "/pub/file.txt"))
+		resp := client.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.DidResume || !server.sent("REST 5") {
+			t.Error("Expected download to resume via REST")
+		}
+		if got, _ := os.ReadFile(filename); !bytes.Equal(got, content) {
+			t.Errorf("Resumed file does not match content: %q", got)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		req, _ := NewRequest(filepath.Join(dir, "missing"), server.URL("", "/pub/missing.txt"))
+		if err := client.Do(req).Err(); !errors.Is(err, StatusCodeError(http.StatusNotFound)) {
+			t.Errorf("Expected 404 error, got %v", err)
+		}
+	})
+
+	t.Run("BadLogin", func(t *testing.T) {
+		req, _ := NewRequest(filepath.Join(dir, "denied"), server.URL("user:wrong", "/pub/file.txt"))
+		err := client.Do(req).Err()
+		var ftpErr *ftpError
+		if !errors.As(err, &ftpErr) || ftpErr.Code != 530 {
+			t.Errorf("Expected 530 error, got %v", err)
+		}
+	})
+}
+
+func TestClient_Do_FTPLegacyServer(t *testing.T) {
+	// a server without SIZE and EPSV
+	content := []byte("hello, legacy ftp world")
+	server := newFTPTestServer(t, map[string][]byte{"file.txt": content})
+	server.noSize = true
+	server.noEPSV = true
+
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file.txt"), server.URL("", "/file.txt"))
+	resp := newFTPClient().Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(resp.Filename); !bytes.Equal(got, content) {
+		t.Errorf("Downloaded file does not match content: %q", got)
+	}
+	if !server.sent("PASV") {
+		t.Error("Expected passive mode to be used")
+	}
+}
+
+func TestClient_Do_FTPControlChars(t *testing.T) {
+	server := newFTPTestServer(t, map[string][]byte{"a": []byte("a")})
+	dir := t.TempDir()
+	for _, u := range []string{
+		server.URL("", "/a%0D%0ADELE%20x"),
+		server.URL("u%0D%0ANOOP:p", "/a"),
+		server.URL("user:p%0AQUIT", "/a"),
+		server.URL("", "/a%00"),
+	} {
+		req, err := NewRequest(filepath.Join(dir, "a"), u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := newFTPClient().Do(req).Err(); !errors.Is(err, errFTPControlChar) {
+			t.Errorf("%s: expected URL to be rejected, got %v", u, err)
+		}
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.commands) != 0 {
+		t.Errorf("Expected no commands to be sent, got %q", server.commands)
+	}
+}
+
+func TestClient_Do_FTPCancelStalled(t *testing.T) {
+	server := newFTPTestServer(t, map[string][]byte{"file.bin": make([]byte, 1<<20)})
+	server.stall = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file.bin"), server.URL("", "/file.bin"))
+	resp := newFTPClient().Do(req.WithContext(ctx))
+	for resp.BytesComplete() == 0 && !resp.IsComplete() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-resp.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Canceled transfer blocked on stalled data connection")
+	}
+	if err := resp.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
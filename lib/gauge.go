@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultRateHalfLife is the default half-life of the transfer rate gauge.
+const defaultRateHalfLife = 2 * time.Second
+
+// ewmaGauge measures a transfer rate as an exponentially weighted moving
+// average, in which bytes transferred one half-life ago weigh half as much as
+// bytes transferred now. The average is corrected for its start at zero, so
+// that it is meaningful before the first half-life has passed.
+type ewmaGauge struct {
+	mu       sync.Mutex
+	halfLife float64 // seconds
+	start    time.Time
+	last     time.Time
+	rate     float64 // bytes per second, at last
+}
+
+// newEWMAGauge returns a gauge with the given half-life, or the default if
+// zero, which measures the rate from the given start time.
+func newEWMAGauge(halfLife time.Duration, start time.Time) *ewmaGauge {
+	if halfLife <= 0 {
+		halfLife = defaultRateHalfLife
+	}
+	return &ewmaGauge{halfLife: halfLife.Seconds(), start: start, last: start}
+}
+
+// decay returns the weight of a rate measured the given duration ago.
+func (g *ewmaGauge) decay(d time.Duration) float64 {
+	return math.Exp2(-d.Seconds() / g.halfLife)
+}
+
+// Sample records n bytes transferred since the last sample, at time t.
+func (g *ewmaGauge) Sample(t time.Time, n int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	dt := t.Sub(g.last)
+	if dt <= 0 {
+		// add to the latest sample, weighted as if spread over a millisecond
+		dt = time.Millisecond
+	}
+	alpha := g.decay(dt)
+	g.rate = alpha*g.rate + (1-alpha)*float64(n)/dt.Seconds()
+	if t.After(g.last) {
+		g.last = t
+	}
+}
+
+// BPS returns the average rate in bytes per second, decayed for the time
+// since the last sample.
+func (g *ewmaGauge) BPS() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	weight := 1 - g.decay(now.Sub(g.start))
+	if weight <= 0 {
+		return 0
+	}
+	return g.rate * g.decay(now.Sub(g.last)) / weight
+}
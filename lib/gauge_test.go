@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEWMAGauge(t *testing.T) {
+	now := time.Now()
+	start := now.Add(-3 * time.Second)
+	g := newEWMAGauge(time.Second, start)
+
+	// 100000 bytes per second for three seconds
+	for ts := start.Add(10 * time.Millisecond); !ts.After(now); ts = ts.Add(10 * time.Millisecond) {
+		g.Sample(ts, 1000)
+	}
+	if bps := g.BPS(); bps < 95000 || bps > 105000 {
+		t.Errorf("Expected about 100000 B/s, got %.0f", bps)
+	}
+
+	// followed by one second idle, which weighs as much as the rest
+	g = newEWMAGauge(time.Second, start)
+	for ts := start.Add(10 * time.Millisecond); !ts.After(now.Add(-time.Second)); ts = ts.Add(10 * time.Millisecond) {
+		g.Sample(ts, 1000)
+	}
+	if bps := g.BPS(); bps < 38000 || bps > 48000 {
+		t.Errorf("Expected about 43000 B/s after idling, got %.0f", bps)
+	}
+}
+
+func TestResponse_PeakBytesPerSecond(t *testing.T) {
+	content := strings.Repeat("x", 600000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	// the first second of the limit is a burst, the rest is limited
+	client := NewClient()
+	client.RateLimit = 200000
+	client.BufferSize = 8192
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file.bin"), server.URL)
+	resp := client.Do(req)
+	time.Sleep(1500 * time.Millisecond)
+	if bps := resp.BytesPerSecond(); bps < 100000 || bps > 600000 {
+		t.Errorf("Expected a current rate of 100000 to 600000 B/s, got %.0f", bps)
+	}
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	avg, peak := resp.AverageBytesPerSecond(), resp.PeakBytesPerSecond()
+	if avg < 200000 || peak < 350000 || peak < avg {
+		t.Errorf("Unexpected average %.0f and peak %.0f B/s", avg, peak)
+	}
+}
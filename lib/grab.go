@@ -26,6 +26,19 @@ func Get(dst, urlStr string) (*Response, error) {
 	return resp, resp.Err()
 }
 
+// GetContext is like Get but with a context. The download is canceled if ctx
+// is canceled or its deadline expires, in which case the context's error is
+// returned.
+func GetContext(ctx context.Context, dst, urlStr string) (*Response, error) {
+	req, err := NewRequest(dst, urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := DefaultClient.Do(req.WithContext(ctx))
+	return resp, resp.Err()
+}
+
 // GetBatch sends multiple HTTP requests and downloads the content of the
 // requested URLs to the given destination directory using the given number of
 // concurrent worker goroutines.
@@ -41,6 +54,10 @@ func Get(dst, urlStr string) (*Response, error) {
 // If an error occurs during any download, it will be available via call to the
 // associated Response.Err.
 //
+// If ctx is canceled or its deadline expires, downloads in progress are
+// canceled and their Response.Err returns the context's error. URLs which were
+// not yet started are skipped and no Response is sent for them.
+//
 // For control over HTTP client headers, redirect policy, and other settings,
 // create a Client instead.
 func GetBatch(ctx context.Context, workers int, dst string, urlStrs ...string) (<-chan *Response, error) {
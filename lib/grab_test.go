@@ -3,6 +3,7 @@ package lib
 import (
 	"context"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -120,6 +121,38 @@ func TestGet_ClientError(t *testing.T) {
 	})
 }
 
+func TestGetContext(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "grab_get_context")
+
+	testURL := "http://example.com/file.txt"
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", testURL, createSuccessResponse("test file content"))
+
+	withMockClient(t, mockClient, func() {
+		resp, err := GetContext(context.Background(), "test_download.txt", testURL)
+		if err != nil {
+			t.Fatalf("GetContext() returned error: %v", err)
+		}
+		if b, _ := os.ReadFile("test_download.txt"); string(b) != "test file content" {
+			t.Errorf("Expected downloaded content, got %q", string(b))
+		}
+		if !resp.IsComplete() {
+			t.Error("Response should be complete after GetContext() returns")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = GetContext(ctx, "canceled.txt", testURL)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected %v, got %v", context.Canceled, err)
+		}
+	})
+
+	if _, err := GetContext(context.Background(), "test_download.txt", "://invalid-url"); err == nil {
+		t.Error("Expected an error for an invalid URL")
+	}
+}
+
 func TestGet_RealHTTP(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping real HTTP test in short mode")
@@ -225,6 +258,46 @@ func TestGetBatch_Success(t *testing.T) {
 	})
 }
 
+func TestGetBatch_Deadline(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "grab_batch_deadline")
+
+	mockClient := newMockHTTPClient()
+	testURLs := []string{
+		"http://example.com/slow1.txt",
+		"http://example.com/slow2.txt",
+		"http://example.com/slow3.txt",
+	}
+	for _, url := range testURLs {
+		resp := createSuccessResponse("")
+		resp.Body = io.NopCloser(&mockReader{data: []byte("slow content"), readDelay: 200 * time.Millisecond})
+		resp.ContentLength = 12
+		mockClient.addResponse("GET", url, resp)
+	}
+
+	withMockClient(t, mockClient, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		ch, err := GetBatch(ctx, 1, ".", testURLs...)
+		if err != nil {
+			t.Fatalf("GetBatch() returned error: %v", err)
+		}
+
+		var responses []*Response
+		for resp := range ch {
+			responses = append(responses, resp)
+		}
+		if len(responses) == 0 {
+			t.Fatal("Expected a response for the download in progress")
+		}
+		for _, resp := range responses {
+			if err := resp.Err(); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+			}
+		}
+	})
+}
+
 func TestGetBatch_EmptyURLs(t *testing.T) {
 	setupTestDirectoryWithCleanup(t, "grab_batch_empty")
 
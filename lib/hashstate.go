@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// hashState is the saved state of the checksum of a partially downloaded
+// file, recorded in its resume state, so that an interrupted download can be
+// resumed without hashing the existing part of the file again.
+type hashState struct {
+	// Offset is the number of bytes of the file which were hashed.
+	Offset int64 `json:"offset"`
+
+	// State is the marshaled state of the hash.
+	State []byte `json:"state"`
+
+	// ModTime is the modification time of the file when the state was saved.
+	// The state is only restored for the same file, unmodified since.
+	ModTime time.Time `json:"mtime"`
+}
+
+// startHash prepares the checksum of the Request to be computed as the file
+// is written, so that no separate pass over the file is needed once the
+// transfer completes, and returns a Writer which writes to both w and the
+// hash. The existing part of a resumed file is hashed first, unless the hash
+// state saved when the transfer was interrupted is still valid.
+func startHash(resp *Response, w io.Writer) (io.Writer, error) {
+	h := resp.Request.hash
+	h.Reset()
+	if resp.bytesResumed > 0 && !restoreCheckpoint(resp) && !restoreHash(resp) {
+		f, err := os.Open(resp.Filename)
+		if err != nil {
+			return nil, err
+		}
+		n, err := io.Copy(h, io.LimitReader(f, resp.bytesResumed))
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if n != resp.bytesResumed {
+			return nil, fmt.Errorf("cannot hash %q: file is shorter than %d bytes", resp.Filename, resp.bytesResumed)
+		}
+	}
+	resp.hashed = true
+	return io.MultiWriter(w, h), nil
+}
+
+// restoreHash restores the hash state recorded in the resume state of the
+// resumed file, and reports whether it is valid for the existing part of the
+// file: the file must have the size and modification time it had when the
+// state was saved. Otherwise the file was truncated, extended or modified
+// since, and is hashed again.
+func restoreHash(resp *Response) bool {
+	u, ok := resp.Request.hash.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return false
+	}
+	rs := loadResumeState(resp.Filename)
+	if rs == nil || rs.Hash == nil {
+		return false
+	}
+	s := rs.Hash
+	if s.Offset != resp.bytesResumed || s.ModTime.IsZero() {
+		return false
+	}
+	fi, err := os.Stat(resp.Filename)
+	if err != nil || fi.Size() != s.Offset || !fi.ModTime().Equal(s.ModTime) {
+		resp.logf("discarding checksum state: file changed since byte %d was written", s.Offset)
+		return false
+	}
+	if err := u.UnmarshalBinary(s.State); err != nil {
+		resp.Request.hash.Reset()
+		return false
+	}
+	resp.logf("restored checksum state at byte %d", s.Offset)
+	return true
+}
+
+// saveHash returns the state of the hash of an interrupted transfer, which
+// hashed all n bytes of the file written, to be recorded in its resume state
+// and restored when the transfer is resumed. The file is synced first, so
+// that the state does not outlive bytes lost in a crash. Nil is returned if
+// the file was not hashed as it was written, or its hash cannot be marshaled.
+func saveHash(resp *Response, n int64) *hashState {
+	m, ok := resp.Request.hash.(encoding.BinaryMarshaler)
+	if !resp.hashed || !ok {
+		return nil
+	}
+	f, ok := resp.writer.(*os.File)
+	if !ok || f.Sync() != nil {
+		return nil
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.Size() != n {
+		return nil
+	}
+	state, err := m.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	return &hashState{Offset: n, State: state, ModTime: fi.ModTime()}
+}
@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestClient_Do_HashStateResume(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "grab-hashstate-test")
+
+	testURL := "http://example.com/file.txt"
+	content := "0123456789"
+	sum := sha256.Sum256([]byte(content))
+
+	// first attempt is truncated after 4 bytes
+	mockClient := newMockHTTPClient()
+	short := createSuccessResponse(content[:4])
+	short.ContentLength = int64(len(content))
+	mockClient.addResponse("GET", testURL, short)
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent", ResumeState: true}
+
+	req, _ := NewRequest("file.txt", testURL)
+	req.SetChecksum(sha256.New(), sum[:], false)
+	if err := client.Do(req).Err(); err == nil {
+		t.Fatal("Expected truncated transfer to fail")
+	}
+	s := loadResumeState("file.txt")
+	if s == nil || s.Hash == nil || s.Hash.Offset != 4 {
+		t.Fatalf("Expected hash state at offset 4 in resume state, got %+v", s)
+	}
+	if fi, err := os.Stat("file.txt" + resumeStateSuffix); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected hash state only readable by its owner, got %v", fi.Mode())
+	}
+
+	// second attempt resumes the partial file and its hash
+	client.HTTPClient = newResumeMockClient(testURL, "bytes 4-9/10", content[4:])
+	req.SetChecksum(sha256.New(), sum[:], false)
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := false
+	for _, e := range resp.Events() {
+		if e.Message == "restored checksum state at byte 4" {
+			restored = true
+		}
+	}
+	if !restored {
+		t.Errorf("Expected hash state to be restored, events: %v", resp.Events())
+	}
+	if _, err := os.Stat("file.txt" + resumeStateSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected hash state to be removed, got %v", err)
+	}
+}
+
+func TestClient_Do_HashStateInvalid(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "grab-hashstate-invalid-test")
+
+	testURL := "http://example.com/file.txt"
+	content := "0123456789"
+	sum := sha256.Sum256([]byte(content))
+	if err := os.WriteFile("file.txt", []byte(content[:4]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// state saved at another offset is ignored and the file hashed instead
+	u, _ := url.Parse(testURL)
+	state := `{"url":"` + testURL + `","url_sha256":"` + urlHash(u) + `","size":10,"written":4,"hash":{"offset":2,"state":""}}`
+	if err := os.WriteFile("file.txt"+resumeStateSuffix, []byte(state), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{HTTPClient: newResumeMockClient(testURL, "bytes 4-9/10", content[4:]), UserAgent: "test-agent", ResumeState: true}
+	req, _ := NewRequest("file.txt", testURL)
+	req.SetChecksum(sha256.New(), sum[:], false)
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestClient_Do_HashStateModified(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "grab-hashstate-modified-test")
+
+	testURL := "http://example.com/file.txt"
+	content := "0123456789"
+	sum := sha256.Sum256([]byte(content))
+
+	mockClient := newMockHTTPClient()
+	short := createSuccessResponse(content[:4])
+	short.ContentLength = int64(len(content))
+	mockClient.addResponse("GET", testURL, short)
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent", ResumeState: true}
+	req, _ := NewRequest("file.txt", testURL)
+	req.SetChecksum(sha256.New(), sum[:], false)
+	if err := client.Do(req).Err(); err == nil {
+		t.Fatal("Expected truncated transfer to fail")
+	}
+
+	// the partial file is corrupted after the hash state was saved
+	if err := os.WriteFile("file.txt", []byte("XXXX"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes("file.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	client.HTTPClient = newResumeMockClient(testURL, "bytes 4-9/10", content[4:])
+	req.SetChecksum(sha256.New(), sum[:], false)
+	resp := client.Do(req)
+	if err := resp.Err(); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("Expected ErrBadChecksum for corrupted partial file, got %v", err)
+	}
+	if !hasEvent(resp, "discarding checksum state: file changed since byte 4 was written") {
+		t.Errorf("Expected hash state to be discarded, events: %v", resp.Events())
+	}
+}
+
+func TestClient_Do_ChecksumAfterCopy(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "grab-checksum-aftercopy-test")
+
+	testURL := "http://example.com/file.txt"
+	content := "0123456789"
+	sum := sha256.Sum256([]byte(content))
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", testURL, createSuccessResponse(content))
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+	// changes made by AfterCopy are validated
+	req, _ := NewRequest("file.txt", testURL)
+	req.SetChecksum(sha256.New(), sum[:], false)
+	req.AfterCopy = func(resp *Response) error {
+		return os.WriteFile(resp.Filename, []byte("tampered"), 0644)
+	}
+	if err := client.Do(req).Err(); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("Expected ErrBadChecksum for file changed by AfterCopy, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// hostMatches reports whether the host of the given URL matches pattern: a
+// host name, which matches any port, a host and port, or a wildcard such as
+// *.example.com, which matches the subdomains of example.com. Host names are
+// compared without regard to case.
+func hostMatches(pattern string, u *url.URL) bool {
+	name, port := pattern, ""
+	if p, err := url.Parse("//" + pattern); err == nil {
+		name, port = p.Hostname(), p.Port()
+	}
+	if port != "" {
+		// the port of the URL may be implied by its scheme
+		if _, uport, _ := net.SplitHostPort(canonicalHost(u)); uport != port {
+			return false
+		}
+	}
+	name, host := strings.ToLower(name), strings.ToLower(u.Hostname())
+	if suffix, ok := strings.CutPrefix(name, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == name
+}
+
+// hostHeaders returns the headers of Client.HostHeaders and of the Preflight
+// session which are sent to the host of the given URL.
+func (c *Client) hostHeaders(u *url.URL) http.Header {
+	h := c.preflightHeaders(u).Clone()
+	for pattern, values := range c.HostHeaders {
+		if !hostMatches(pattern, u) {
+			continue
+		}
+		if h == nil {
+			h = make(http.Header)
+		}
+		for name, v := range values {
+			h[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	return h
+}
+
+// applyHostHeaders returns a copy of the given request with the headers of
+// Client.HostHeaders and of the Preflight session for its host, or req itself
+// if there are none. Headers set on the request take precedence.
+func (c *Client) applyHostHeaders(req *http.Request) *http.Request {
+	h := c.hostHeaders(req.URL)
+	if len(h) == 0 {
+		return req
+	}
+	req = req.Clone(req.Context())
+	for name, values := range h {
+		if req.Header.Get(name) == "" {
+			req.Header[name] = values
+		}
+	}
+	return req
+}
+
+// scopeHostHeaders returns a copy of the given HTTPClient which removes the
+// headers of Client.HostHeaders and of the Preflight session from redirects
+// to hosts they are not meant for, and adds those of the new host, or hc
+// itself if there are none.
+func (c *Client) scopeHostHeaders(hc HTTPClient) HTTPClient {
+	client, ok := hc.(*http.Client)
+	if !ok || (len(c.HostHeaders) == 0 && c.Preflight == nil) {
+		return hc
+	}
+	check := client.CheckRedirect
+	scoped := *client
+	scoped.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		prev := c.hostHeaders(via[len(via)-1].URL)
+		h := c.hostHeaders(next.URL)
+		for name, values := range prev {
+			if slices.Equal(next.Header[name], values) {
+				next.Header.Del(name)
+			}
+		}
+		for name, values := range h {
+			if next.Header.Get(name) == "" {
+				next.Header[name] = values
+			}
+		}
+		if check != nil {
+			return check(next, via)
+		}
+		// the default policy of http.Client
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &scoped
+}
@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHostMatches(t *testing.T) {
+	tests := []struct {
+		pattern, url string
+		want         bool
+	}{
+		{"example.com", "https://example.com/file", true},
+		{"Example.com", "https://EXAMPLE.COM:8443/file", true},
+		{"example.com", "https://mirror.example.com/file", false},
+		{"example.com:443", "https://example.com/file", true},
+		{"example.com:80", "https://example.com/file", false},
+		{"*.example.com", "https://mirror.example.com/file", true},
+		{"*.example.com", "https://example.com/file", false},
+		{"*.example.com", "https://badexample.com/file", false},
+		{"[::1]", "http://[::1]:8080/file", true},
+		{"[::1]:8080", "http://[::1]:8080/file", true},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		if got := hostMatches(test.pattern, u); got != test.want {
+			t.Errorf("hostMatches(%q, %s) = %v, want %v", test.pattern, test.url, got, test.want)
+		}
+	}
+}
+
+func TestClient_Do_HostHeadersRedirect(t *testing.T) {
+	var leaked string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("X-Api-Key"); v != "" {
+			leaked = v
+		}
+		w.Write([]byte("data"))
+	}))
+	defer other.Close()
+	var sent string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r.Header.Get("X-Api-Key")
+		http.Redirect(w, r, other.URL+"/file", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	client := NewClient()
+	client.HostHeaders = map[string]http.Header{
+		strings.TrimPrefix(origin.URL, "http://"): {"X-Api-Key": {"secret"}},
+	}
+	req, _ := NewRequest(t.TempDir(), origin.URL+"/file")
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sent != "secret" {
+		t.Errorf("Expected host header on origin, got %q", sent)
+	}
+	if leaked != "" {
+		t.Errorf("Expected host header to be removed on redirect, got %q", leaked)
+	}
+}
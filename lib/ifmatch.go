@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+)
+
+// setResumeIfMatch sends the ETag of the partial file of the Response in an
+// If-Match header with its resume range, if Request.ResumeIfMatch is set. Only
+// an ETag recorded when the partial file was written is used, from
+// Request.ResumeCheckpoint, the resume state or the Sidecar of the client, as
+// that of the HEAD request describes the remote file as it is now. Weak ETags
+// cannot be used with If-Match and are ignored.
+func (c *Client) setResumeIfMatch(resp *Response) {
+	if !resp.Request.ResumeIfMatch {
+		return
+	}
+	etag := c.partialETag(resp)
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		resp.logf("resuming without If-Match: no ETag recorded for partial file")
+		return
+	}
+	resp.Request.HTTPRequest.Header.Set("If-Match", etag)
+}
+
+// partialETag returns the ETag of the remote file recorded when the partial
+// file of the Response was written, if any.
+func (c *Client) partialETag(resp *Response) string {
+	if cp := resp.Request.ResumeCheckpoint; cp != nil && cp.ETag != "" {
+		return cp.ETag
+	}
+	if c.keepsResumeState(resp) {
+		if s := loadResumeState(resp.Filename); s != nil && s.ETag != "" {
+			return s.ETag
+		}
+	}
+	if c.Sidecar != nil {
+		if meta, err := c.Sidecar.Read(resp.Filename); err == nil {
+			return meta[MetadataETag]
+		}
+	}
+	return ""
+}
+
+// restartPreconditionFailed reports whether the server rejected the If-Match
+// header of a resumed download because the remote file has changed, in which
+// case the Response is reset to download the whole file again, overwriting
+// the existing file.
+func restartPreconditionFailed(resp *Response) bool {
+	h := resp.Request.HTTPRequest.Header
+	if !resp.DidResume || h.Get("If-Match") == "" ||
+		resp.HTTPResponse.StatusCode != http.StatusPreconditionFailed {
+		return false
+	}
+	_ = resp.HTTPResponse.Body.Close()
+	resp.logf("overwriting existing file (%d bytes): remote file changed since partial download", resp.bytesResumed)
+	h.Del("Range")
+	h.Del("If-Match")
+	resp.DidResume = false
+	resp.bytesResumed = 0
+	resp.resumeOverlap = 0
+	return true
+}
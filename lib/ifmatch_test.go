@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_Do_ResumeIfMatch(t *testing.T) {
+	content := make([]byte, 10000)
+	rand.New(rand.NewSource(11)).Read(content)
+	changed := bytes.Clone(content)
+	changed[9000] ^= 0xff
+	mixed := append(bytes.Clone(content[:5000]), changed[5000:]...)
+
+	// the file is replaced between the HEAD and GET requests of /changed
+	var mu sync.Mutex
+	var ifMatch []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, etag := content, `"v1"`
+		if r.URL.Path == "/changed" && r.Method == http.MethodGet {
+			body, etag = changed, `"v2"`
+		}
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			ifMatch = append(ifMatch, r.Header.Get("If-Match"))
+			mu.Unlock()
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		etag    string
+		want    []byte
+		resumed bool
+		ifMatch []string
+	}{
+		{"unchanged", "/file", `"v1"`, content, true, []string{`"v1"`}},
+		{"changed", "/changed", `"v1"`, changed, false, []string{`"v1"`, ""}},
+
+		// the ETag of the HEAD request does not describe the partial file
+		{"unrecorded", "/changed", "", mixed, true, []string{""}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ifMatch = nil
+			filename := filepath.Join(t.TempDir(), "file.bin")
+			if err := os.WriteFile(filename, content[:5000], 0644); err != nil {
+				t.Fatal(err)
+			}
+			u, _ := url.Parse(server.URL + test.path)
+			state := fmt.Sprintf(`{"url":%q,"url_sha256":%q,"etag":%q,"size":10000,"written":5000}`, u, urlHash(u), test.etag)
+			if err := os.WriteFile(filename+resumeStateSuffix, []byte(state), 0600); err != nil {
+				t.Fatal(err)
+			}
+			client := NewClient()
+			client.ResumeState = true
+			req, _ := NewRequest(filename, server.URL+test.path)
+			req.ResumeIfMatch = true
+			resp := client.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if resp.DidResume != test.resumed {
+				t.Errorf("Expected DidResume to be %v", test.resumed)
+			}
+			if !reflect.DeepEqual(ifMatch, test.ifMatch) {
+				t.Errorf("Expected If-Match headers %q, got %q", test.ifMatch, ifMatch)
+			}
+			if got, _ := os.ReadFile(filename); !bytes.Equal(got, test.want) {
+				t.Error("Downloaded file does not match remote file")
+			}
+			if !test.resumed && !hasEvent(resp, "overwriting existing file (5000 bytes): remote file changed since partial download") {
+				t.Error("Expected restart to be logged")
+			}
+		})
+	}
+}
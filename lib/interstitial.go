@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrInterstitial indicates that the remote server responded with an HTML
+// page, such as a download page which redirects after a delay, in place of the
+// requested file. It is matched by errors of type InterstitialError.
+var ErrInterstitial = errors.New("server returned an HTML page instead of the requested file")
+
+// InterstitialError indicates that the remote server responded with an HTML
+// page in place of the requested file, which was not saved.
+type InterstitialError struct {
+	// URL is the URL which responded with the page.
+	URL *url.URL
+
+	// Target is the URL of the file to which the page redirects with a meta
+	// refresh tag, or empty if it does not redirect. It is followed once if
+	// Client.FollowInterstitials is set.
+	Target string
+}
+
+func (err *InterstitialError) Error() string {
+	if err.Target != "" {
+		return fmt.Sprintf("%v: %s redirects to %s", ErrInterstitial, err.URL, err.Target)
+	}
+	return fmt.Sprintf("%v: %s", ErrInterstitial, err.URL)
+}
+
+// Is returns true if target is ErrInterstitial.
+func (err *InterstitialError) Is(target error) bool {
+	return target == ErrInterstitial
+}
+
+// maxInterstitialSize is the largest HTML response which is treated as an
+// interstitial page. Larger responses are saved as usual.
+const maxInterstitialSize = 256 << 10
+
+// htmlExtensions lists the file extensions of URLs which are expected to
+// respond with HTML.
+var htmlExtensions = map[string]bool{
+	".htm": true, ".html": true, ".xhtml": true, ".shtml": true,
+	".php": true, ".asp": true, ".aspx": true, ".jsp": true, ".cgi": true,
+}
+
+var (
+	metaTagPattern     = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	httpEquivPattern   = regexp.MustCompile(`(?is)http-equiv\s*=\s*["']?\s*refresh\b`)
+	contentAttrPattern = regexp.MustCompile(`(?is)\bcontent\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+// isInterstitial returns true if the response of the given Response is a
+// small HTML page, while the file extension of the URL or destination shows
+// that another type of file was requested.
+func isInterstitial(resp *Response) bool {
+	req := resp.Request
+	hresp := resp.HTTPResponse
+	if req.AcceptHTML || resp.DidResume || hresp.StatusCode != 200 {
+		return false
+	}
+	if hresp.ContentLength > maxInterstitialSize {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(hresp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return false
+	}
+	ext := path.Ext(req.URL().Path)
+	if ext == "" && resp.Filename != "" && !isDirName(resp.Filename) {
+		ext = filepath.Ext(resp.Filename)
+	}
+	return ext != "" && !htmlExtensions[strings.ToLower(ext)]
+}
+
+// checkInterstitial fails the given Response with an InterstitialError if the
+// remote server sent an HTML page in place of the requested file, or follows
+// the redirect of the page once if Client.FollowInterstitials is set.
+func (c *Client) checkInterstitial(resp *Response) stateFunc {
+	if !isInterstitial(resp) {
+		return nil
+	}
+	hresp := resp.HTTPResponse
+	b, err := io.ReadAll(io.LimitReader(hresp.Body, maxInterstitialSize))
+	if err != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+	if resp.err = resp.closeResponseBody(); resp.err != nil {
+		return c.closeResponse
+	}
+
+	ierr := &InterstitialError{URL: hresp.Request.URL}
+	if target, ok := metaRefreshTarget(b, hresp.Request.URL); ok {
+		ierr.Target = target.String()
+		if c.FollowInterstitials && !resp.interstitialFollowed {
+			resp.interstitialFollowed = true
+			resp.logf("following interstitial page to %s", RedactURL(target))
+			resp.Request.HTTPRequest.URL = target
+			resp.Request.HTTPRequest.Host = target.Host
+			return c.getRequest
+		}
+	}
+	resp.err = ierr
+	return c.closeResponse
+}
+
+// metaRefreshTarget returns the URL to which the given HTML page redirects with
+// a meta refresh tag, resolved against base.
+func metaRefreshTarget(page []byte, base *url.URL) (*url.URL, bool) {
+	for _, tag := range metaTagPattern.FindAll(page, -1) {
+		if !httpEquivPattern.Match(tag) {
+			continue
+		}
+		m := contentAttrPattern.FindSubmatch(tag)
+		if m == nil {
+			continue
+		}
+		content := html.UnescapeString(string(m[1]) + string(m[2]) + string(m[3]))
+		// the content is a delay in seconds, optionally followed by the URL:
+		// "5; url=https://example.com/file.iso"
+		_, target, ok := strings.Cut(content, ";")
+		if !ok {
+			continue
+		}
+		target = strings.TrimSpace(target)
+		if len(target) < 4 || !strings.EqualFold(target[:3], "url") {
+			continue
+		}
+		target = strings.TrimSpace(target[3:])
+		target, ok = strings.CutPrefix(target, "=")
+		if !ok {
+			continue
+		}
+		target = strings.Trim(strings.TrimSpace(target), `"'`)
+		u, err := base.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		return u, true
+	}
+	return nil, false
+}
@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetaRefreshTarget(t *testing.T) {
+	base, _ := url.Parse("https://example.com/project/files/image.iso/download")
+	tests := []struct {
+		page   string
+		target string
+	}{
+		{`<meta http-equiv="refresh" content="5; url=https://mirror.example.com/image.iso">`, "https://mirror.example.com/image.iso"},
+		{`<META CONTENT='0;URL=/dl/image.iso?a=1&amp;b=2' HTTP-EQUIV='Refresh'>`, "https://example.com/dl/image.iso?a=1&b=2"},
+		{`<meta http-equiv=refresh content="3;url='mirror/image.iso'">`, "https://example.com/project/files/image.iso/mirror/image.iso"},
+		{`<meta http-equiv="refresh" content="30">`, ""},
+		{`<meta name="description" content="0; url=https://example.com/">`, ""},
+		{`<meta http-equiv="refresh" content="0; url=javascript:alert(1)">`, ""},
+		{`<p>Your download will start shortly</p>`, ""},
+	}
+	for _, test := range tests {
+		u, ok := metaRefreshTarget([]byte(test.page), base)
+		got := ""
+		if ok {
+			got = u.String()
+		}
+		if got != test.target {
+			t.Errorf("%s: expected %q, got %q", test.page, test.target, got)
+		}
+	}
+}
+
+func TestClient_Do_Interstitial(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.iso":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(`<html><head><meta http-equiv="refresh" content="5; url=/mirror/image.iso"></head></html>`))
+		case "/mirror/image.iso":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write([]byte("iso content"))
+		case "/blocked.iso", "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(`<html><body>Access denied</body></html>`))
+		}
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	req, _ := NewRequest(dir, server.URL+"/image.iso")
+	err := NewClient().Do(req).Err()
+	var ierr *InterstitialError
+	if !errors.As(err, &ierr) || !errors.Is(err, ErrInterstitial) {
+		t.Fatalf("Expected InterstitialError, got %v", err)
+	}
+	if ierr.Target != server.URL+"/mirror/image.iso" {
+		t.Errorf("Expected target %s/mirror/image.iso, got %s", server.URL, ierr.Target)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "image.iso")); !os.IsNotExist(err) {
+		t.Error("Expected interstitial page not to be saved")
+	}
+
+	client := NewClient()
+	client.FollowInterstitials = true
+	req, _ = NewRequest(dir, server.URL+"/image.iso")
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(resp.Filename); string(b) != "iso content" || filepath.Base(resp.Filename) != "image.iso" {
+		t.Errorf("Expected followed download in image.iso, got %q in %s", b, resp.Filename)
+	}
+
+	req, _ = NewRequest(dir, server.URL+"/blocked.iso")
+	if err := client.Do(req).Err(); !errors.As(err, &ierr) || ierr.Target != "" {
+		t.Errorf("Expected InterstitialError without target, got %v", err)
+	}
+
+	req, _ = NewRequest(dir, server.URL+"/blocked.iso")
+	req.AcceptHTML = true
+	if err := client.Do(req).Err(); err != nil {
+		t.Errorf("Expected HTML to be accepted, got %v", err)
+	}
+	req, _ = NewRequest(dir, server.URL+"/page.html")
+	if err := client.Do(req).Err(); err != nil {
+		t.Errorf("Expected HTML page to be saved, got %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+var (
+	// ErrDiskFull indicates that the destination device of a download has no
+	// space left. It is matched by errors of type WriteError.
+	ErrDiskFull = errors.New("destination device is full")
+
+	// ErrDeviceIO indicates that the destination device of a download failed
+	// with an I/O error, such as a failing disk or a lost network mount. It
+	// is matched by errors of type WriteError.
+	ErrDeviceIO = errors.New("destination device I/O error")
+
+	// ErrReadOnlyFS indicates that the destination of a download is on a
+	// read-only file system. It is matched by errors of type WriteError.
+	ErrReadOnlyFS = errors.New("destination file system is read-only")
+)
+
+// writeErrorKinds maps the system errors which are classified by WriteError to
+// their kind.
+var writeErrorKinds = []struct {
+	errno syscall.Errno
+	kind  error
+}{
+	{syscall.ENOSPC, ErrDiskFull},
+	{syscall.EIO, ErrDeviceIO},
+	{syscall.EROFS, ErrReadOnlyFS},
+}
+
+// WriteError indicates that the destination of a download could not be
+// written because of the state of its device, so that retrying the download is
+// pointless until the device is fixed.
+type WriteError struct {
+	// Filename is the path of the destination file.
+	Filename string
+
+	// Kind is ErrDiskFull, ErrDeviceIO or ErrReadOnlyFS.
+	Kind error
+
+	// Err is the underlying error, such as a *fs.PathError wrapping
+	// syscall.ENOSPC.
+	Err error
+}
+
+func (err *WriteError) Error() string {
+	return fmt.Sprintf("%v: %v", err.Kind, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *WriteError) Unwrap() error {
+	return err.Err
+}
+
+// Is returns true if target is the Kind of the error.
+func (err *WriteError) Is(target error) bool {
+	return target == err.Kind
+}
+
+// classifyWriteError wraps the error of the given Response in a WriteError if
+// it was caused by the state of the destination device.
+func classifyWriteError(resp *Response) {
+	var werr *WriteError
+	if resp.err == nil || errors.As(resp.err, &werr) {
+		return
+	}
+	for _, k := range writeErrorKinds {
+		if errors.Is(resp.err, k.errno) {
+			resp.err = &WriteError{Filename: resp.Filename, Kind: k.kind, Err: resp.err}
+			return
+		}
+	}
+}
+
+// deviceWatch aborts the downloads of a batch which write to the same device
+// as soon as one of them fails because the device is full, rather than
+// letting each of them fail in turn.
+type deviceWatch struct {
+	deviceFunc func(string) string
+
+	mu     sync.Mutex
+	full   map[string]string // filename which filled each device
+	active map[*Response]string
+}
+
+// watchDevices returns copies of the given requests which share a deviceWatch.
+func (c *Client) watchDevices(requests []*Request) []*Request {
+	w := &deviceWatch{
+		deviceFunc: c.DeviceFunc,
+		full:       make(map[string]string),
+		active:     make(map[*Response]string),
+	}
+	if w.deviceFunc == nil {
+		w.deviceFunc = deviceID
+	}
+	watched := make([]*Request, len(requests))
+	for i, req := range requests {
+		watched[i] = req.WithContext(req.Context())
+		watched[i].devices = w
+	}
+	return watched
+}
+
+// add registers the transfer of the given Response with the device of its
+// destination. A WriteError is returned if another download of the batch
+// already filled the device.
+func (w *deviceWatch) add(resp *Response) error {
+	if w == nil || resp.Request.NoStore {
+		return nil
+	}
+	device := w.deviceFunc(resp.Filename)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if filename, ok := w.full[device]; ok {
+		return siblingDiskFull(resp, filename)
+	}
+	w.active[resp] = device
+	return nil
+}
+
+// remove unregisters the transfer of the given Response.
+func (w *deviceWatch) remove(resp *Response) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	delete(w.active, resp)
+	w.mu.Unlock()
+}
+
+// done marks the device of the given Response as full if the device ran out
+// of space, and aborts all other transfers to the device.
+func (w *deviceWatch) done(resp *Response) {
+	if w == nil || !errors.Is(resp.err, syscall.ENOSPC) {
+		return
+	}
+	w.mu.Lock()
+	device, ok := w.active[resp]
+	if !ok {
+		device = w.deviceFunc(resp.Filename)
+	}
+	delete(w.active, resp)
+	w.full[device] = resp.Filename
+	var siblings []*Response
+	for r, d := range w.active {
+		if d == device {
+			siblings = append(siblings, r)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, r := range siblings {
+		r.logf("aborting: %s filled the device", resp.Filename)
+		r.abort(siblingDiskFull(r, resp.Filename))
+	}
+}
+
+// siblingDiskFull returns the error of a download which was aborted because
+// the named file filled its device.
+func siblingDiskFull(resp *Response, filename string) error {
+	return &WriteError{
+		Filename: resp.Filename,
+		Kind:     ErrDiskFull,
+		Err:      fmt.Errorf("aborted, as %s filled the device", filename),
+	}
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyWriteError(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{&fs.PathError{Op: "write", Path: "a.bin", Err: syscall.ENOSPC}, ErrDiskFull},
+		{&fs.PathError{Op: "write", Path: "a.bin", Err: syscall.EIO}, ErrDeviceIO},
+		{&fs.PathError{Op: "open", Path: "a.bin", Err: syscall.EROFS}, ErrReadOnlyFS},
+		{&fs.PathError{Op: "open", Path: "a.bin", Err: syscall.EACCES}, nil},
+		{ErrBadChecksum, nil},
+	}
+	for _, test := range tests {
+		resp := &Response{Filename: "a.bin", err: test.err}
+		classifyWriteError(resp)
+		var werr *WriteError
+		if test.kind == nil {
+			if errors.As(resp.err, &werr) {
+				t.Errorf("%v: expected no WriteError, got %v", test.err, resp.err)
+			}
+			continue
+		}
+		if !errors.As(resp.err, &werr) || !errors.Is(resp.err, test.kind) {
+			t.Errorf("%v: expected %v, got %v", test.err, test.kind, resp.err)
+			continue
+		}
+		if werr.Filename != "a.bin" || !errors.Is(resp.err, test.err) {
+			t.Errorf("%v: unexpected WriteError %+v", test.err, werr)
+		}
+	}
+}
+
+func TestDeviceWatch(t *testing.T) {
+	devices := map[string]string{"/a/1": "a", "/a/2": "a", "/a/3": "a", "/b/1": "b"}
+	client := &Client{DeviceFunc: func(name string) string { return devices[name] }}
+	req, _ := NewRequest("", "http://example.com/file.bin")
+	w := client.watchDevices([]*Request{req})[0].devices
+
+	newResponse := func(filename string) *Response {
+		ctx, abort := context.WithCancelCause(context.Background())
+		return &Response{Request: &Request{}, Filename: filename, ctx: ctx, abort: abort}
+	}
+	full, sibling, other := newResponse("/a/1"), newResponse("/a/2"), newResponse("/b/1")
+	for _, resp := range []*Response{full, sibling, other} {
+		if err := w.add(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	full.err = &fs.PathError{Op: "write", Path: "/a/1", Err: syscall.ENOSPC}
+	w.done(full)
+	if cause := context.Cause(sibling.ctx); !errors.Is(cause, ErrDiskFull) || !strings.Contains(cause.Error(), "/a/1") {
+		t.Errorf("Expected sibling to be aborted with ErrDiskFull, got %v", cause)
+	}
+	if other.ctx.Err() != nil {
+		t.Error("Expected download to another device to continue")
+	}
+
+	// later downloads to the full device fail immediately
+	if err := w.add(newResponse("/a/3")); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("Expected ErrDiskFull, got %v", err)
+	}
+	if err := w.add(newResponse("/b/1")); err != nil {
+		t.Errorf("Expected no error for another device, got %v", err)
+	}
+}
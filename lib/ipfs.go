@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+)
+
+// DefaultIPFSGateways are the public gateways through which ipfs:// URLs are
+// fetched if Client.IPFSGateways is empty.
+var DefaultIPFSGateways = []string{"https://ipfs.io", "https://dweb.link"}
+
+// ErrInvalidCID indicates that the host of an ipfs:// URL is not a valid
+// content identifier.
+var ErrInvalidCID = errors.New("invalid CID")
+
+// Multicodec codes of the content identifiers which are understood.
+const (
+	cidCodecRaw    = 0x55
+	cidCodecDagPB  = 0x70
+	multihashSHA2  = 0x12
+	multihashSHA2b = 0x13
+)
+
+// multihashes maps the multihash codes of content identifiers which can be
+// verified to their hashes.
+var multihashes = map[uint64]func() hash.Hash{
+	multihashSHA2:  sha256.New,
+	multihashSHA2b: sha512.New,
+}
+
+// A cid is a parsed IPFS content identifier.
+type cid struct {
+	version int
+	codec   uint64
+	hash    uint64
+	digest  []byte
+}
+
+// resolveIPFS rewrites an ipfs://CID/path request to the first of the
+// Client's IPFS gateways, with the other gateways as mirrors for failover,
+// before continuing with next. If the CID addresses a raw block which is
+// requested in whole, the downloaded content is verified against the digest
+// of the CID, unless the Request has a checksum already.
+func (c *Client) resolveIPFS(next stateFunc) stateFunc {
+	return func(resp *Response) stateFunc {
+		req := resp.Request
+		u := req.URL()
+		id, err := parseCID(u.Host)
+		if err != nil {
+			resp.err = fmt.Errorf("%w: %s", err, u.Host)
+			return c.closeResponse
+		}
+		gateways := c.IPFSGateways
+		if len(gateways) == 0 {
+			gateways = DefaultIPFSGateways
+		}
+		resp.mirrors = nil
+		for _, g := range gateways {
+			addMirror(resp, ipfsGatewayURL(g, u))
+		}
+		for _, m := range req.Mirrors {
+			addMirror(resp, m)
+		}
+		gw, err := url.Parse(resp.mirrors[0])
+		if err != nil {
+			resp.err = err
+			return c.closeResponse
+		}
+		req.HTTPRequest.URL = gw
+		req.HTTPRequest.Host = gw.Host
+		resp.mirrorNext = 1
+		resp.logf("fetching %s through %d IPFS gateways", u, len(gateways))
+
+		newHash, ok := multihashes[id.hash]
+		switch {
+		case req.hash != nil:
+		case id.codec != cidCodecRaw || !ok || strings.Trim(u.Path, "/") != "":
+			resp.logf("cannot verify content of %s; trusting the gateway", u.Host)
+		default:
+			h := newHash()
+			req.setChecksum(checksumAlgorithm(h), h, id.digest, true)
+			resp.logf("verifying content against CID %s", u.Host)
+		}
+		return next
+	}
+}
+
+// ipfsGatewayURL returns the URL of the path-style gateway at the given base
+// URL for the ipfs:// URL u.
+func ipfsGatewayURL(gateway string, u *url.URL) string {
+	gu := &url.URL{
+		Path:     "/ipfs/" + u.Host + u.Path,
+		RawQuery: u.RawQuery,
+	}
+	return strings.TrimRight(gateway, "/") + gu.String()
+}
+
+// parseCID parses a CIDv0, which is a base58btc encoded SHA2-256 multihash of
+// a dag-pb node, or a CIDv1 in the base16, base32 or base58btc multibase
+// encoding.
+func parseCID(s string) (cid, error) {
+	if len(s) == 46 && strings.HasPrefix(s, "Qm") {
+		b, err := decodeBase58(s)
+		if err != nil {
+			return cid{}, ErrInvalidCID
+		}
+		id := cid{codec: cidCodecDagPB}
+		if id.hash, id.digest, err = parseMultihash(b); err != nil {
+			return cid{}, err
+		}
+		return id, nil
+	}
+	if s == "" {
+		return cid{}, ErrInvalidCID
+	}
+	var b []byte
+	var err error
+	switch data := s[1:]; s[0] {
+	case 'b', 'B':
+		b, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(data))
+	case 'f', 'F':
+		b, err = hex.DecodeString(data)
+	case 'z':
+		b, err = decodeBase58(data)
+	default:
+		return cid{}, ErrInvalidCID
+	}
+	if err != nil {
+		return cid{}, ErrInvalidCID
+	}
+	version, n := binary.Uvarint(b)
+	if n <= 0 || version != 1 {
+		return cid{}, ErrInvalidCID
+	}
+	b = b[n:]
+	codec, n := binary.Uvarint(b)
+	if n <= 0 {
+		return cid{}, ErrInvalidCID
+	}
+	id := cid{version: 1, codec: codec}
+	if id.hash, id.digest, err = parseMultihash(b[n:]); err != nil {
+		return cid{}, err
+	}
+	return id, nil
+}
+
+// parseMultihash returns the hash function code and digest of a multihash.
+func parseMultihash(b []byte) (uint64, []byte, error) {
+	code, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, ErrInvalidCID
+	}
+	b = b[n:]
+	size, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) != size {
+		return 0, nil, ErrInvalidCID
+	}
+	return code, b[n:], nil
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// decodeBase58 decodes a string in the base58 encoding used by Bitcoin.
+func decodeBase58(s string) ([]byte, error) {
+	var b []byte // big-endian, without leading zeros
+	for i := 0; i < len(s); i++ {
+		carry := strings.IndexByte(base58Alphabet, s[i])
+		if carry < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", s[i])
+		}
+		for j := len(b) - 1; j >= 0; j-- {
+			carry += int(b[j]) * 58
+			b[j] = byte(carry)
+			carry >>= 8
+		}
+		for ; carry > 0; carry >>= 8 {
+			b = append([]byte{byte(carry)}, b...)
+		}
+	}
+	zeros := len(s) - len(strings.TrimLeft(s, "1"))
+	return append(make([]byte, zeros), b...), nil
+}
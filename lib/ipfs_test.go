@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawCID returns the CIDv1 of a raw block with the given content.
+func rawCID(content []byte) string {
+	sum := sha256.Sum256(content)
+	b := append([]byte{0x01, cidCodecRaw, multihashSHA2, sha256.Size}, sum[:]...)
+	return "b" + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+}
+
+func TestParseCID(t *testing.T) {
+	// the same dag-pb node as CIDv0 and CIDv1
+	v0, err := parseCID("QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := parseCID("bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v0.codec != cidCodecDagPB || v1.codec != cidCodecDagPB || v0.hash != multihashSHA2 {
+		t.Errorf("Unexpected CIDs: %+v, %+v", v0, v1)
+	}
+	if len(v0.digest) != sha256.Size || !bytes.Equal(v0.digest, v1.digest) {
+		t.Errorf("Expected equal digests, got %x and %x", v0.digest, v1.digest)
+	}
+
+	for _, s := range []string{"", "Qm0000", "bafy!", "xabc", "f0155"} {
+		if _, err := parseCID(s); !errors.Is(err, ErrInvalidCID) {
+			t.Errorf("Expected ErrInvalidCID for %q, got %v", s, err)
+		}
+	}
+}
+
+func TestClient_Do_IPFS(t *testing.T) {
+	content := []byte("hello, ipfs")
+	id := rawCID(content)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	var served []byte
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ipfs/"+id {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(served))
+	}))
+	defer up.Close()
+
+	client := NewClient()
+	client.AllowedSchemes = []string{"ipfs", "http"}
+	client.IPFSGateways = []string{down.URL, up.URL + "/"}
+
+	// fails over to the second gateway and verifies the content
+	served = content
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file.txt"), "ipfs://"+id)
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !hasEvent(resp, "verifying content against CID "+id) {
+		t.Errorf("Expected content to be verified, events: %v", resp.Events())
+	}
+
+	// a gateway which serves other content is detected
+	served = []byte("hello, world")
+	req, _ = NewRequest(filepath.Join(t.TempDir(), "file.txt"), "ipfs://"+id)
+	if err := client.Do(req).Err(); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("Expected ErrBadChecksum, got %v", err)
+	}
+
+	req, _ = NewRequest(t.TempDir(), "ipfs://notacid")
+	if err := client.Do(req).Err(); !errors.Is(err, ErrInvalidCID) {
+		t.Errorf("Expected ErrInvalidCID, got %v", err)
+	}
+}
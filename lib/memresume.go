@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// defaultMemoryResumeOverlap is the number of buffered bytes which are
+// requested again to verify a resumed NoStore download if
+// Request.ResumeOverlap is zero. Unlike a partial file on disk, buffered bytes
+// carry no size or timestamp which would reveal a changed remote file.
+const defaultMemoryResumeOverlap = 4 << 10
+
+// memoryPartial holds the bytes received by an interrupted NoStore download,
+// so that the next download of the same Request resumes from them.
+type memoryPartial struct {
+	mu           sync.Mutex
+	data         []byte
+	etag         string
+	lastModified string
+}
+
+// canResumeMemory reports whether the given NoStore Response may resume from
+// or save buffered bytes. Ranged and filtered downloads do not map buffered
+// bytes onto offsets of the remote file.
+func canResumeMemory(resp *Response) bool {
+	req := resp.Request
+	return req != nil && req.NoStore && req.writer == nil && req.memory != nil &&
+		!req.hasRange && len(req.Filters) == 0
+}
+
+// resumeMemory prepares a ranged GET request for the bytes of the remote file
+// which follow those buffered by a previous, interrupted download of the same
+// Request. It returns false if there are no buffered bytes.
+func resumeMemory(resp *Response) bool {
+	if !canResumeMemory(resp) || resp.Request.NoResume || resp.singleRequest {
+		return false
+	}
+	p := resp.Request.memory
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := int64(len(p.data))
+	if n == 0 {
+		return false
+	}
+
+	resp.resumeOverlap = resp.Request.ResumeOverlap
+	if resp.resumeOverlap == 0 {
+		resp.resumeOverlap = defaultMemoryResumeOverlap
+	}
+	resp.resumeOverlap = min(resp.resumeOverlap, n)
+
+	// the request is shared with the caller, so the headers are cloned
+	hreq := resp.Request.HTTPRequest.Clone(resp.Request.Context())
+	hreq.Header.Set("Range", fmt.Sprintf("bytes=%d-", n-resp.resumeOverlap))
+	if p.etag != "" && !strings.HasPrefix(p.etag, "W/") {
+		hreq.Header.Set("If-Range", p.etag)
+	} else if p.lastModified != "" {
+		hreq.Header.Set("If-Range", p.lastModified)
+	}
+	resp.Request.HTTPRequest = hreq
+	resp.buffered = p.data
+	resp.DidResume = true
+	resp.bytesResumed = n
+	resp.logf("resuming buffered download at byte %d", n-resp.resumeOverlap)
+	return true
+}
+
+// restartMemory discards the buffered bytes of a resumed NoStore download if
+// the server sent the whole file in place of the requested range, as it does
+// when the file changed since the bytes were buffered.
+func restartMemory(resp *Response) {
+	if !resp.DidResume || !canResumeMemory(resp) ||
+		resp.HTTPResponse.StatusCode == http.StatusPartialContent {
+		return
+	}
+	resp.logf("server did not resume buffered download, restarting")
+	resp.DidResume = false
+	resp.bytesResumed = 0
+	resp.resumeOverlap = 0
+	resp.buffered = nil
+	p := resp.Request.memory
+	p.mu.Lock()
+	p.data = nil
+	p.mu.Unlock()
+}
+
+// restoreMemory copies the buffered bytes of a resumed NoStore download into
+// the buffer of the given Response. The overlap must already be verified.
+func restoreMemory(resp *Response) {
+	if resp.buffered == nil {
+		return
+	}
+	resp.storeBuffer.Grow(len(resp.buffered))
+	resp.storeBuffer.Write(resp.buffered)
+	resp.buffered = nil
+}
+
+// saveMemory keeps the bytes received by a failed NoStore download for the
+// next download of the same Request, or discards them once the download
+// succeeds or the bytes are known to be bad.
+func saveMemory(resp *Response) {
+	if !canResumeMemory(resp) {
+		return
+	}
+	p := resp.Request.memory
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case resp.err == nil || errors.Is(resp.err, ErrBadChecksum) || errors.Is(resp.err, ErrResumeMismatch):
+		p.data = nil
+	case resp.storeBuffer.Len() > 0:
+		// the buffer is not written to once the download has failed
+		p.data = resp.storeBuffer.Bytes()
+		p.etag = resp.HTTPResponse.Header.Get("ETag")
+		p.lastModified = resp.HTTPResponse.Header.Get("Last-Modified")
+	}
+}
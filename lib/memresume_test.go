@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newFlakyServer returns a server for the given content, which is replaced by
+// calling the returned function, and the Range headers of the requests it
+// received. The response to the first request is cut off after cut bytes.
+func newFlakyServer(t *testing.T, content []byte, cut int) (*httptest.Server, func([]byte), func() []string) {
+	var mu sync.Mutex
+	var ranges []string
+	etag := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		b, tag := content, etag
+		ranges = append(ranges, r.Header.Get("Range"))
+		first := len(ranges) == 1
+		mu.Unlock()
+		w.Header().Set("ETag", `"`+strconv.Itoa(tag)+`"`)
+		if first {
+			w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+			_, _ = w.Write(b[:cut])
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(b))
+	}))
+	t.Cleanup(server.Close)
+	replace := func(b []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		content = b
+		etag++
+	}
+	requested := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ranges...)
+	}
+	return server, replace, requested
+}
+
+func TestClient_Do_NoStoreResume(t *testing.T) {
+	content := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	server, _, ranges := newFlakyServer(t, content, 40000)
+
+	client := NewClient()
+	req, _ := NewRequest("", server.URL+"/file.bin")
+	req.NoStore = true
+	resp := client.Do(req)
+	if err := resp.Err(); err == nil {
+		t.Fatal("Expected interrupted download to fail")
+	}
+
+	resp = client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.DidResume {
+		t.Error("Expected download to resume")
+	}
+	if want := "bytes=" + strconv.Itoa(40000-defaultMemoryResumeOverlap) + "-"; ranges()[1] != want {
+		t.Errorf("Expected range %q, got %q", want, ranges()[1])
+	}
+	if b, _ := resp.Bytes(); !bytes.Equal(b, content) {
+		t.Error("Resumed download does not match remote file")
+	}
+
+	// a completed download is not resumed again
+	resp = client.Do(req)
+	if err := resp.Err(); err != nil || resp.DidResume {
+		t.Errorf("Expected fresh download, got resumed=%v, error %v", resp.DidResume, err)
+	}
+}
+
+func TestClient_Do_NoStoreResumeChanged(t *testing.T) {
+	content := []byte(strings.Repeat("a", 10000))
+	server, replace, _ := newFlakyServer(t, content, 6000)
+
+	client := NewClient()
+	req, _ := NewRequest("", server.URL+"/file.bin")
+	req.NoStore = true
+	req.ResumeOverlap = 100
+	if err := client.Do(req).Err(); err == nil {
+		t.Fatal("Expected interrupted download to fail")
+	}
+
+	// the changed ETag fails the If-Range condition, so the whole file is sent
+	changed := []byte(strings.Repeat("b", 8000))
+	replace(changed)
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if resp.DidResume {
+		t.Error("Expected download of changed file to restart")
+	}
+	if b, _ := resp.Bytes(); !bytes.Equal(b, changed) {
+		t.Errorf("Expected changed file, got %d bytes", len(b))
+	}
+}
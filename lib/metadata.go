@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileModeHeader is the response header in which a backend may advertise the
+// permission bits of the remote file, as an octal string such as "0755".
+//
+// Backends for protocols other than HTTP, such as FTP, report remote file
+// metadata by setting the headers of the http.Response they return:
+// Last-Modified for the modification time and FileModeHeader for permissions.
+// The header is removed from the responses of HTTP servers, which must not
+// decide the permissions of local files.
+const FileModeHeader = "X-Grab-File-Mode"
+
+// fileMetadata describes the attributes of a remote file which may be
+// preserved on the downloaded copy. Zero values are unknown and not applied.
+type fileMetadata struct {
+	ModTime time.Time
+	Mode    os.FileMode
+}
+
+// metadataFromHeader extracts fileMetadata from the given response headers.
+// Missing or malformed values are ignored.
+func metadataFromHeader(h http.Header) fileMetadata {
+	var md fileMetadata
+	// https://tools.ietf.org/html/rfc7232#section-2.2
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Last-Modified
+	if v := h.Get("Last-Modified"); v != "" {
+		if t, err := time.Parse(http.TimeFormat, v); err == nil {
+			md.ModTime = t
+		}
+	}
+	if v := h.Get(FileModeHeader); v != "" {
+		if mode, err := strconv.ParseUint(v, 8, 32); err == nil {
+			md.Mode = os.FileMode(mode) & os.ModePerm
+		}
+	}
+	return md
+}
+
+// applyMetadata applies any known attributes in md to the named local file.
+func applyMetadata(filename string, md fileMetadata) error {
+	if md.Mode != 0 {
+		if err := os.Chmod(filename, md.Mode); err != nil {
+			return err
+		}
+	}
+	if !md.ModTime.IsZero() {
+		return os.Chtimes(filename, md.ModTime, md.ModTime)
+	}
+	return nil
+}
+
+// remoteMetadata returns the metadata of the remote file which should be
+// preserved for the given Response, according to its Request options. The
+// mode is only taken from responses synthesized by backends.
+func remoteMetadata(resp *Response) fileMetadata {
+	if resp.HTTPResponse == nil {
+		return fileMetadata{}
+	}
+	md := metadataFromHeader(resp.HTTPResponse.Header)
+	if resp.Request.IgnoreRemoteTime {
+		md.ModTime = time.Time{}
+	}
+	if !resp.Request.PreserveMode || !fromBackend(resp.HTTPResponse) {
+		md.Mode = 0
+	}
+	return md
+}
+
+// fromBackend reports whether the given response was synthesized by a
+// backend for a protocol other than HTTP, rather than sent by an HTTP server.
+func fromBackend(hresp *http.Response) bool {
+	if hresp.Request == nil || hresp.Request.URL == nil {
+		return false
+	}
+	scheme := strings.ToLower(hresp.Request.URL.Scheme)
+	return scheme != "http" && scheme != "https"
+}
+
+// stripFileMode removes FileModeHeader from the responses of HTTP servers, so
+// that only backends may set it.
+func stripFileMode(hresp *http.Response) {
+	if !fromBackend(hresp) {
+		hresp.Header.Del(FileModeHeader)
+	}
+}
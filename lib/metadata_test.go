@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMetadataFromHeader(t *testing.T) {
+	lastMod := time.Date(2023, 10, 15, 12, 30, 45, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		expectTime time.Time
+		expectMode os.FileMode
+	}{
+		{
+			name: "no headers",
+		},
+		{
+			name:       "last modified",
+			headers:    map[string]string{"Last-Modified": lastMod.Format(http.TimeFormat)},
+			expectTime: lastMod,
+		},
+		{
+			name:       "file mode",
+			headers:    map[string]string{FileModeHeader: "0755"},
+			expectMode: 0755,
+		},
+		{
+			name:       "file mode strips special bits",
+			headers:    map[string]string{FileModeHeader: "4755"},
+			expectMode: 0755,
+		},
+		{
+			name:    "malformed values",
+			headers: map[string]string{"Last-Modified": "yesterday", FileModeHeader: "rwx"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := make(http.Header)
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			md := metadataFromHeader(h)
+			if !md.ModTime.Equal(tt.expectTime) {
+				t.Errorf("Expected mod time %v, got %v", tt.expectTime, md.ModTime)
+			}
+			if md.Mode != tt.expectMode {
+				t.Errorf("Expected mode %v, got %v", tt.expectMode, md.Mode)
+			}
+		})
+	}
+}
+
+func TestApplyMetadata(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not supported on windows")
+	}
+	setupTestDirectoryWithCleanup(t, "metadata_apply")
+
+	testFile := "test_metadata.txt"
+	if err := os.WriteFile(testFile, []byte("test"), 0600); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	lastMod := time.Date(2023, 10, 15, 12, 30, 45, 0, time.UTC)
+	if err := applyMetadata(testFile, fileMetadata{ModTime: lastMod, Mode: 0640}); err != nil {
+		t.Fatalf("applyMetadata() returned error: %v", err)
+	}
+
+	fi, err := os.Stat(testFile)
+	if err != nil {
+		t.Fatalf("Failed to stat test file: %v", err)
+	}
+	if !fi.ModTime().Equal(lastMod) {
+		t.Errorf("Expected mod time %v, got %v", lastMod, fi.ModTime())
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("Expected mode 0640, got %v", fi.Mode().Perm())
+	}
+}
+
+func TestRemoteMetadata_RequestOptions(t *testing.T) {
+	lastMod := time.Date(2023, 10, 15, 12, 30, 45, 0, time.UTC)
+	header := make(http.Header)
+	header.Set("Last-Modified", lastMod.Format(http.TimeFormat))
+	header.Set(FileModeHeader, "0755")
+
+	req, _ := NewRequest("", "ftp://example.com/file.txt")
+	resp := &Response{Request: req, HTTPResponse: &http.Response{Header: header, Request: req.HTTPRequest}}
+
+	md := remoteMetadata(resp)
+	if !md.ModTime.Equal(lastMod) || md.Mode != 0 {
+		t.Errorf("Expected only mod time by default, got %+v", md)
+	}
+
+	req.IgnoreRemoteTime = true
+	req.PreserveMode = true
+	md = remoteMetadata(resp)
+	if !md.ModTime.IsZero() || md.Mode != 0755 {
+		t.Errorf("Expected only mode, got %+v", md)
+	}
+}
+
+func TestRemoteMetadata_HTTPFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not supported on windows")
+	}
+	// an HTTP server cannot make the downloaded file executable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(FileModeHeader, "0755")
+		_, _ = w.Write([]byte("#!/bin/sh\n"))
+	}))
+	defer server.Close()
+
+	filename := filepath.Join(t.TempDir(), "script.sh")
+	req, _ := NewRequest(filename, server.URL+"/script.sh")
+	req.PreserveMode = true
+	resp := NewClient().Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if resp.HTTPResponse.Header.Get(FileModeHeader) != "" {
+		t.Error("Expected file mode header to be removed from HTTP response")
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm()&0111 != 0 {
+		t.Errorf("Expected file mode of HTTP server to be ignored, got %v", fi.Mode().Perm())
+	}
+}
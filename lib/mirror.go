@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLinkPriority is the priority of a mirror advertised in a Link header
+// without a pri parameter, as specified by RFC 6249.
+const defaultLinkPriority = 999999
+
+// ParseLinkMirrors returns the URLs of the mirrors advertised in the Link
+// headers of an HTTP response with rel=duplicate, as described by RFC 6249,
+// ordered by their pri parameter. Relative URLs are resolved against base.
+func ParseLinkMirrors(h http.Header, base *url.URL) []string {
+	type link struct {
+		url string
+		pri int
+	}
+	var links []link
+	for _, v := range h.Values("Link") {
+		for {
+			start := strings.IndexByte(v, '<')
+			if start < 0 {
+				break
+			}
+			end := strings.IndexByte(v[start:], '>')
+			if end < 0 {
+				break
+			}
+			target := v[start+1 : start+end]
+			v = v[start+end+1:]
+			params := v
+			if next := strings.IndexByte(v, '<'); next >= 0 {
+				params = v[:next]
+			}
+
+			duplicate, pri := false, defaultLinkPriority
+			for _, param := range strings.Split(params, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(strings.TrimRight(param, ", ")), "=")
+				value = strings.Trim(value, `"`)
+				switch strings.ToLower(key) {
+				case "rel":
+					for _, rel := range strings.Fields(value) {
+						if strings.EqualFold(rel, "duplicate") {
+							duplicate = true
+						}
+					}
+				case "pri":
+					if n, err := strconv.Atoi(value); err == nil {
+						pri = n
+					}
+				}
+			}
+			if !duplicate {
+				continue
+			}
+			if u, ok := resolveURL(base, target); ok {
+				links = append(links, link{u, pri})
+			}
+		}
+	}
+	sort.SliceStable(links, func(i, j int) bool { return links[i].pri < links[j].pri })
+	urls := make([]string, len(links))
+	for i, l := range links {
+		urls[i] = l.url
+	}
+	return urls
+}
+
+// initMirrors records the URL and mirrors of the request as the candidate
+// URLs of the Response, starting with the URL.
+func initMirrors(resp *Response) {
+	resp.mirrors = []string{resp.Request.URL().String()}
+	for _, m := range resp.Request.Mirrors {
+		addMirror(resp, m)
+	}
+	resp.mirrorNext = 1
+}
+
+// addMirror adds a candidate URL to the Response, unless already known.
+func addMirror(resp *Response, u string) bool {
+	for _, m := range resp.mirrors {
+		if m == u {
+			return false
+		}
+	}
+	resp.mirrors = append(resp.mirrors, u)
+	return true
+}
+
+// addLinkMirrors adds the mirrors advertised in the Link headers of the
+// current HTTP response, if enabled by Client.LinkMirrors.
+func (c *Client) addLinkMirrors(resp *Response) {
+	if !c.LinkMirrors {
+		return
+	}
+	hresp := resp.HTTPResponse
+	base := resp.Request.HTTPRequest.URL
+	if hresp.Request != nil {
+		base = hresp.Request.URL
+	}
+	for _, m := range ParseLinkMirrors(hresp.Header, base) {
+		if addMirror(resp, m) {
+			resp.logf("mirror advertised: %s", redactURLString(m))
+		}
+	}
+}
+
+// failover replaces the URL of the request with the next untried mirror after
+// a request failed with a connection error or an error status, and reports
+// whether one was available. The failed response, if any, is discarded.
+func failover(resp *Response) bool {
+	if resp.Request.Context().Err() != nil {
+		return false
+	}
+	for resp.mirrorNext < len(resp.mirrors) {
+		m := resp.mirrors[resp.mirrorNext]
+		resp.mirrorNext++
+		u, err := url.Parse(m)
+		if err != nil {
+			resp.logf("skipping invalid mirror %s", redactURLString(m))
+			continue
+		}
+		_ = resp.closeResponseBody()
+		if resp.err != nil {
+			resp.logf("%s; trying mirror %s", RedactError(resp.err), RedactURL(u))
+		} else {
+			resp.logf("%s; trying mirror %s", resp.HTTPResponse.Status, RedactURL(u))
+		}
+		resp.Request.HTTPRequest.URL = u
+		resp.Request.HTTPRequest.Host = u.Host
+		resp.HTTPResponse = nil
+		resp.err = nil
+		return true
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseLinkMirrors(t *testing.T) {
+	h := http.Header{}
+	h.Add("Link", `<http://mirror2.example.com/file.iso>; rel=duplicate; pri=2; geo=de, </pub/file.iso>; rel="duplicate"; pri=1`)
+	h.Add("Link", `<http://example.com/file.iso.meta4>; rel=describedby; type="application/metalink4+xml"`)
+	h.Add("Link", `<http://mirror3.example.com/file.iso>; rel=duplicate`)
+	base, _ := url.Parse("http://example.com/file.iso")
+
+	got := ParseLinkMirrors(h, base)
+	expect := []string{
+		"http://example.com/pub/file.iso",
+		"http://mirror2.example.com/file.iso",
+		"http://mirror3.example.com/file.iso",
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %v, got %v", expect, got)
+	}
+}
+
+func TestClient_Do_Mirrors(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "grab-mirrors-test")
+
+	primary := "http://example.com/file.txt"
+	broken := "http://broken.example.com/file.txt"
+	mirror := "http://mirror.example.com/file.txt"
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", primary, createErrorResponse(http.StatusServiceUnavailable, "Service Unavailable"))
+	mockClient.addResponse("GET", broken, createErrorResponse(http.StatusNotFound, "Not Found"))
+	mockClient.addResponse("GET", mirror, createSuccessResponse("mirrored"))
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+	req, _ := NewRequest("file.txt", primary)
+	req.Mirrors = []string{broken, mirror}
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Request.URL().String() != mirror {
+		t.Errorf("Expected download from %s, got %s", mirror, resp.Request.URL())
+	}
+	if b, _ := os.ReadFile("file.txt"); string(b) != "mirrored" {
+		t.Errorf("Expected mirrored content, got %q", b)
+	}
+
+	// all mirrors fail
+	req, _ = NewRequest("other.txt", primary)
+	req.Mirrors = []string{broken}
+	if err := client.Do(req).Err(); !IsStatusCodeError(err) {
+		t.Errorf("Expected StatusCodeError, got %v", err)
+	}
+}
+
+func TestClient_Do_LinkMirrors(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "grab-link-mirrors-test")
+
+	primary := "http://example.com/file.txt"
+	mirror := "http://mirror.example.com/file.txt"
+	failed := createErrorResponse(http.StatusServiceUnavailable, "Service Unavailable")
+	failed.Header.Set("Link", "<"+mirror+">; rel=duplicate")
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", primary, failed)
+	mockClient.addResponse("GET", mirror, createSuccessResponse("mirrored"))
+
+	for _, enabled := range []bool{false, true} {
+		client := &Client{HTTPClient: mockClient, UserAgent: "test-agent", LinkMirrors: enabled}
+		req, _ := NewRequest("", primary)
+		req.NoStore = true
+		err := client.Do(req).Err()
+		if enabled && err != nil {
+			t.Errorf("Expected failover to advertised mirror, got %v", err)
+		}
+		if !enabled && !IsStatusCodeError(err) {
+			t.Errorf("Expected advertised mirror to be ignored, got %v", err)
+		}
+	}
+}
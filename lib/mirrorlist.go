@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// A Mirror is an entry of a mirror list.
+type Mirror struct {
+	// URL is the base URL of the mirror, or the URL of the file itself.
+	URL string `json:"url"`
+
+	// Country is the ISO 3166 code of the country where the mirror is
+	// located, if known.
+	Country string `json:"country,omitempty"`
+
+	// Priority orders mirrors which are otherwise equal, lowest first. Zero
+	// means no preference.
+	Priority int `json:"priority,omitempty"`
+}
+
+// ParseMirrorList parses a mirror list, as published by Linux distributions,
+// in one of the following formats:
+//
+//   - Text with one mirror URL per line, optionally followed by the country
+//     code of the mirror, separated by white space. Blank lines and lines
+//     starting with '#' are ignored.
+//   - A JSON array of URLs or of Mirror objects, or a JSON object whose
+//     "mirrors" field is such an array.
+//
+// Relative URLs are resolved against base.
+func ParseMirrorList(b []byte, base *url.URL) ([]Mirror, error) {
+	var mirrors []Mirror
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		var err error
+		if mirrors, err = parseJSONMirrorList(trimmed); err != nil {
+			return nil, fmt.Errorf("invalid mirror list: %v", err)
+		}
+	} else {
+		for _, line := range strings.Split(string(b), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			m := Mirror{URL: fields[0]}
+			if len(fields) > 1 {
+				m.Country = fields[1]
+			}
+			mirrors = append(mirrors, m)
+		}
+	}
+
+	valid := mirrors[:0]
+	for _, m := range mirrors {
+		if u, ok := resolveURL(base, m.URL); ok {
+			m.URL = u
+			valid = append(valid, m)
+		}
+	}
+	return valid, nil
+}
+
+// parseJSONMirrorList parses a mirror list in JSON format.
+func parseJSONMirrorList(b []byte) ([]Mirror, error) {
+	if b[0] == '{' {
+		var list struct {
+			Mirrors json.RawMessage `json:"mirrors"`
+		}
+		if err := json.Unmarshal(b, &list); err != nil {
+			return nil, err
+		}
+		if len(list.Mirrors) == 0 {
+			return nil, nil
+		}
+		b = list.Mirrors
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	mirrors := make([]Mirror, 0, len(entries))
+	for _, e := range entries {
+		var m Mirror
+		if err := json.Unmarshal(e, &m.URL); err != nil {
+			if err := json.Unmarshal(e, &m); err != nil {
+				return nil, err
+			}
+		}
+		mirrors = append(mirrors, m)
+	}
+	return mirrors, nil
+}
+
+// rankMirrors orders the given mirrors, preferring those in the given country,
+// if any, and then by priority. The order of the list is otherwise kept.
+func rankMirrors(mirrors []Mirror, country string) {
+	rank := func(m Mirror) (int, int) {
+		local := 1
+		if country != "" && strings.EqualFold(m.Country, country) {
+			local = 0
+		}
+		pri := m.Priority
+		if pri == 0 {
+			pri = defaultLinkPriority
+		}
+		return local, pri
+	}
+	sort.SliceStable(mirrors, func(i, j int) bool {
+		li, pi := rank(mirrors[i])
+		lj, pj := rank(mirrors[j])
+		if li != lj {
+			return li < lj
+		}
+		return pi < pj
+	})
+}
+
+// mirrorFileURL returns the URL of the file with the given URL on the mirror
+// with the given base URL. The path of the file below the mirror base is
+// given by Request.MirrorPath, or else the path below any of the given mirror
+// bases which is a prefix of the file URL, or else the file name. Base URLs
+// name a directory, whether or not they end with '/', as mirror lists often
+// omit it.
+func mirrorFileURL(base string, file *url.URL, filePath string, bases []Mirror) string {
+	base = mirrorDir(base)
+	if filePath == "" {
+		s := file.String()
+		for _, m := range bases {
+			if dir := mirrorDir(m.URL); strings.HasPrefix(s, dir) {
+				filePath = strings.TrimPrefix(s, dir)
+				break
+			}
+		}
+	}
+	if filePath == "" {
+		filePath = path.Base(file.Path)
+	}
+	return base + strings.TrimPrefix(filePath, "/")
+}
+
+// mirrorDir returns the given mirror base URL with a trailing '/'.
+func mirrorDir(base string) string {
+	if strings.HasSuffix(base, "/") {
+		return base
+	}
+	return base + "/"
+}
+
+// fetchMirrorList fetches, parses and ranks the mirror list of the request,
+// if any, and makes its mirrors the first candidate URLs of the transfer,
+// followed by the URL and Request.Mirrors. If the mirror list cannot be
+// fetched, the transfer continues without it.
+func (c *Client) fetchMirrorList(resp *Response) stateFunc {
+	req := resp.Request
+	b, base, err := c.fetchDocument(req.Context(), req.MirrorListURL)
+	var mirrors []Mirror
+	if err == nil {
+		mirrors, err = ParseMirrorList(b, base)
+	}
+	if err != nil {
+		resp.logf("cannot fetch mirror list %s: %s", redactURLString(req.MirrorListURL), RedactError(err))
+		return c.measureMirrors
+	}
+	rankMirrors(mirrors, c.Country)
+	resp.logf("mirror list %s: %d mirrors", redactURLString(req.MirrorListURL), len(mirrors))
+
+	candidates := resp.mirrors
+	resp.mirrors = nil
+	for _, m := range mirrors {
+		addMirror(resp, mirrorFileURL(m.URL, req.URL(), req.MirrorPath, mirrors))
+	}
+	for _, m := range candidates {
+		addMirror(resp, m)
+	}
+	if len(mirrors) > 0 {
+		u, err := url.Parse(resp.mirrors[0])
+		if err != nil {
+			resp.err = err
+			return c.closeResponse
+		}
+		req.HTTPRequest.URL = u
+		req.HTTPRequest.Host = u.Host
+	}
+	return c.measureMirrors
+}
@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseMirrorList(t *testing.T) {
+	base, _ := url.Parse("http://example.com/mirrors.txt")
+	tests := []struct {
+		name   string
+		list   string
+		expect []Mirror
+	}{
+		{
+			"text",
+			"# Ubuntu mirrors\nhttp://de.example.com/ubuntu/ DE\n\nhttp://us.example.com/ubuntu/\n/local/ubuntu/\n",
+			[]Mirror{
+				{URL: "http://de.example.com/ubuntu/", Country: "DE"},
+				{URL: "http://us.example.com/ubuntu/"},
+				{URL: "http://example.com/local/ubuntu/"},
+			},
+		},
+		{
+			"json array",
+			`["http://a.example.com/", {"url": "http://b.example.com/", "country": "FR", "priority": 2}]`,
+			[]Mirror{
+				{URL: "http://a.example.com/"},
+				{URL: "http://b.example.com/", Country: "FR", Priority: 2},
+			},
+		},
+		{
+			"json object",
+			`{"mirrors": [{"url": "http://a.example.com/"}]}`,
+			[]Mirror{{URL: "http://a.example.com/"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mirrors, err := ParseMirrorList([]byte(tt.list), base)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(mirrors, tt.expect) {
+				t.Errorf("Expected %v, got %v", tt.expect, mirrors)
+			}
+		})
+	}
+	if _, err := ParseMirrorList([]byte(`{"mirrors": 1}`), base); err == nil {
+		t.Error("Expected error for invalid JSON mirror list")
+	}
+}
+
+func TestMirrorFileURL(t *testing.T) {
+	file, _ := url.Parse("http://archive.example.com/ubuntu/pool/main/a.deb")
+	bases := []Mirror{{URL: "http://de.example.com/ubuntu/"}, {URL: "http://archive.example.com/ubuntu"}}
+	tests := []struct {
+		base, path, expect string
+	}{
+		{"http://de.example.com/ubuntu/", "", "http://de.example.com/ubuntu/pool/main/a.deb"},
+		{"http://de.example.com/ubuntu/", "/dists/a.deb", "http://de.example.com/ubuntu/dists/a.deb"},
+		{"http://de.example.com/ubuntu", "", "http://de.example.com/ubuntu/pool/main/a.deb"},
+		{"http://de.example.com", "", "http://de.example.com/pool/main/a.deb"},
+	}
+	for _, tt := range tests {
+		if got := mirrorFileURL(tt.base, file, tt.path, bases); got != tt.expect {
+			t.Errorf("Expected %s, got %s", tt.expect, got)
+		}
+	}
+	if got := mirrorFileURL("http://de.example.com/ubuntu/", file, "", nil); got != "http://de.example.com/ubuntu/a.deb" {
+		t.Errorf("Expected file name below mirror, got %s", got)
+	}
+}
+
+func TestClient_Do_MirrorListURL(t *testing.T) {
+	listURL := "http://example.com/mirrors.txt"
+	fileURL := "http://example.com/pub/file.txt"
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", listURL, createSuccessResponse(
+		"http://us.example.com/pub/ US\nhttp://de.example.com/pub/ DE\nhttp://example.com/pub/\n"))
+	mockClient.addResponse("GET", "http://de.example.com/pub/file.txt", createErrorResponse(http.StatusNotFound, "Not Found"))
+	mockClient.addResponse("GET", "http://us.example.com/pub/file.txt", createSuccessResponse("from us"))
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent", Country: "de"}
+
+	req, _ := NewRequest("", fileURL)
+	req.NoStore = true
+	req.MirrorListURL = listURL
+	resp := client.Do(req)
+	b, err := resp.Bytes()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// the nearby mirror is tried first
+	if string(b) != "from us" {
+		t.Errorf("Expected download from second mirror, got %q", b)
+	}
+	var tried []string
+	for _, r := range mockClient.getRequests() {
+		tried = append(tried, r.URL.String())
+	}
+	expect := []string{listURL, "http://de.example.com/pub/file.txt", "http://us.example.com/pub/file.txt"}
+	if !reflect.DeepEqual(tried, expect) {
+		t.Errorf("Expected requests %v, got %v", expect, tried)
+	}
+
+	// the download proceeds if the list cannot be fetched
+	mockClient.addResponse("GET", listURL, createErrorResponse(http.StatusNotFound, "Not Found"))
+	req, _ = NewRequest("", fileURL)
+	req.NoStore = true
+	req.MirrorListURL = listURL
+	if err := client.Do(req).Err(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// mirrorProbeTimeout limits the time to wait for each mirror to respond
+	// to a latency probe.
+	mirrorProbeTimeout = 5 * time.Second
+
+	// maxMirrorProbes limits the number of mirrors probed concurrently.
+	maxMirrorProbes = 8
+)
+
+// MirrorLatency is the result of probing a candidate URL of a download to rank
+// it by latency.
+type MirrorLatency struct {
+	// URL is the candidate URL of the file.
+	URL string
+
+	// Latency is the time to the first byte of the response to the probe.
+	Latency time.Duration
+
+	// Err is the error of the probe, if it failed. Mirrors which failed to
+	// respond are ranked last.
+	Err error
+}
+
+func (c MirrorLatency) String() string {
+	if c.Err != nil {
+		return fmt.Sprintf("%s: %v", c.URL, c.Err)
+	}
+	return fmt.Sprintf("%s: %v", c.URL, c.Latency.Round(time.Millisecond))
+}
+
+// MirrorRanking returns the candidate URLs of the download in the order they
+// were ranked by latency if Client.RankMirrorsByLatency is set, or nil if
+// they were not ranked.
+func (c *Response) MirrorRanking() []MirrorLatency {
+	return append([]MirrorLatency(nil), c.mirrorRanking...)
+}
+
+// measureMirrors probes all candidate URLs of the transfer concurrently, if
+// there are several and Client.RankMirrorsByLatency is set, and orders them by
+// the time to the first byte of their response, so that the fastest one is
+// tried first.
+func (c *Client) measureMirrors(resp *Response) stateFunc {
+	if !c.RankMirrorsByLatency || len(resp.mirrors) < 2 {
+		return c.statFileInfo
+	}
+	ranking := make([]MirrorLatency, len(resp.mirrors))
+	sem := make(chan struct{}, maxMirrorProbes)
+	var wg sync.WaitGroup
+	for i, m := range resp.mirrors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			ranking[i] = c.measureMirror(resp.Request, m)
+		}()
+	}
+	wg.Wait()
+	if err := resp.Request.Context().Err(); err != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+
+	sort.SliceStable(ranking, func(i, j int) bool {
+		if (ranking[i].Err == nil) != (ranking[j].Err == nil) {
+			return ranking[i].Err == nil
+		}
+		return ranking[i].Err == nil && ranking[i].Latency < ranking[j].Latency
+	})
+	resp.mirrorRanking = ranking
+	for i, r := range ranking {
+		resp.logf("mirror %d: %v", i+1, r)
+		resp.mirrors[i] = r.URL
+	}
+	u, err := url.Parse(resp.mirrors[0])
+	if err != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+	resp.Request.HTTPRequest.URL = u
+	resp.Request.HTTPRequest.Host = u.Host
+	resp.mirrorNext = 1
+	return c.statFileInfo
+}
+
+// measureMirror sends a GET request for the first byte of the file at the
+// given candidate URL and measures the time to the first byte of the
+// response.
+func (c *Client) measureMirror(req *Request, rawURL string) MirrorLatency {
+	result := MirrorLatency{URL: rawURL}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), mirrorProbeTimeout)
+	defer cancel()
+	hreq := req.HTTPRequest.Clone(ctx)
+	hreq.URL = u
+	hreq.Host = u.Host
+	hreq.Header.Set("Range", "bytes=0-0")
+
+	start := time.Now()
+	hresp, err := c.doHTTPRequest(hreq)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Latency = time.Since(start)
+	_ = hresp.Body.Close()
+	if hresp.StatusCode != http.StatusOK && hresp.StatusCode != http.StatusPartialContent {
+		result.Err = StatusCodeError(hresp.StatusCode)
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// latencyHTTPClient responds to requests for each host after the given delay,
+// or fails if the host has no delay.
+type latencyHTTPClient struct {
+	delays map[string]time.Duration
+}
+
+func (c *latencyHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	delay, ok := c.delays[req.URL.Host]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	time.Sleep(delay)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Body:          io.NopCloser(strings.NewReader(req.URL.Host)),
+		ContentLength: int64(len(req.URL.Host)),
+		Header:        make(http.Header),
+		Request:       req,
+	}, nil
+}
+
+func TestClient_Do_RankMirrorsByLatency(t *testing.T) {
+	hc := &latencyHTTPClient{delays: map[string]time.Duration{
+		"slow.example.com": 100 * time.Millisecond,
+		"fast.example.com": 0,
+	}}
+	for _, rank := range []bool{false, true} {
+		client := &Client{HTTPClient: hc, UserAgent: "test-agent", RankMirrorsByLatency: rank}
+		req, _ := NewRequest("", "http://slow.example.com/file.txt")
+		req.NoStore = true
+		req.Mirrors = []string{"http://down.example.com/file.txt", "http://fast.example.com/file.txt"}
+		resp := client.Do(req)
+		b, err := resp.Bytes()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		ranking := resp.MirrorRanking()
+		if !rank {
+			if string(b) != "slow.example.com" || ranking != nil {
+				t.Errorf("Expected unranked download from primary URL, got %q and ranking %v", b, ranking)
+			}
+			continue
+		}
+		if string(b) != "fast.example.com" {
+			t.Errorf("Expected download from fastest mirror, got %q", b)
+		}
+		expect := []string{"fast", "slow", "down"}
+		if len(ranking) != len(expect) {
+			t.Fatalf("Expected %d ranked mirrors, got %v", len(expect), ranking)
+		}
+		for i, host := range expect {
+			if !strings.Contains(ranking[i].URL, host) {
+				t.Errorf("Expected %s mirror at rank %d, got %v", host, i+1, ranking)
+			}
+		}
+		if ranking[2].Err == nil || ranking[1].Latency < ranking[0].Latency {
+			t.Errorf("Unexpected ranking %v", ranking)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of a ReportStore.
+const (
+	defaultReportPartSize    = 8 << 20
+	defaultReportConcurrency = 4
+	defaultReportTimeout     = 5 * time.Minute
+	reportAbortTimeout       = 30 * time.Second
+	minReportPartSize        = 5 << 20 // the minimum part size of S3
+)
+
+// A ReportStore uploads a BatchReport of each batch of a Client to a bucket of
+// an S3 compatible object storage service, such as Amazon S3, MinIO, or
+// Google Cloud Storage with HMAC keys. Requests are signed with AWS Signature
+// Version 4 and use path-style URLs. Reports larger than PartSize are
+// uploaded in parts, several at a time.
+type ReportStore struct {
+	// Endpoint is the base URL of the service, such as
+	// "https://s3.eu-central-1.amazonaws.com" or
+	// "https://storage.googleapis.com".
+	Endpoint string
+
+	// Region is the region of the bucket, or "auto" for Google Cloud
+	// Storage. Default: "us-east-1".
+	Region string
+
+	// Bucket is the name of the bucket.
+	Bucket string
+
+	// Prefix is prepended to the names of the uploaded objects, such as
+	// "reports/".
+	Prefix string
+
+	// AccessKeyID, SecretAccessKey and the optional SessionToken are the
+	// credentials with which requests are signed.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// PartSize is the size in bytes of the parts in which large objects are
+	// uploaded, at least 5MB. Default: 8MB.
+	PartSize int64
+
+	// Concurrency is the number of parts which are uploaded at a time.
+	// Default: 4.
+	Concurrency int
+
+	// Timeout limits the time to upload a report, which continues after its
+	// batch has been canceled. Default: 5 minutes.
+	Timeout time.Duration
+
+	// OnUpload, if set, is called with the name of the uploaded report, and
+	// any error, once the report of a batch has been uploaded.
+	OnUpload func(key string, err error)
+}
+
+// put uploads an object, in parts if it is larger than the part size.
+func (s *ReportStore) put(ctx context.Context, client HTTPClient, key, contentType string, b []byte) error {
+	partSize := s.PartSize
+	if partSize == 0 {
+		partSize = defaultReportPartSize
+	}
+	partSize = max(partSize, minReportPartSize)
+	if int64(len(b)) <= partSize {
+		h := http.Header{"Content-Type": {contentType}}
+		_, err := s.do(ctx, client, http.MethodPut, key, nil, h, b)
+		return err
+	}
+
+	resp, err := s.do(ctx, client, http.MethodPost, key, url.Values{"uploads": {""}}, http.Header{"Content-Type": {contentType}}, nil)
+	if err != nil {
+		return err
+	}
+	var initiate struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(resp, &initiate); err != nil || initiate.UploadID == "" {
+		return fmt.Errorf("bad response to multipart upload of %s: %v", key, err)
+	}
+	uploadID := initiate.UploadID
+
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	parts := make([]part, (int64(len(b))+partSize-1)/partSize)
+	concurrency := s.Concurrency
+	if concurrency < 1 {
+		concurrency = defaultReportConcurrency
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, concurrency)
+	)
+	for i := range parts {
+		sem <- struct{}{}
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = ctx.Err()
+		}
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			// don't start the remaining parts of a failed upload
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			chunk := b[int64(i)*partSize : min(int64(i+1)*partSize, int64(len(b)))]
+			q := url.Values{"partNumber": {strconv.Itoa(i + 1)}, "uploadId": {uploadID}}
+			etag, err := s.doETag(ctx, client, http.MethodPut, key, q, chunk)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			parts[i] = part{PartNumber: i + 1, ETag: etag}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		s.abort(ctx, client, key, uploadID)
+		return firstErr
+	}
+
+	complete, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	resp, err = s.do(ctx, client, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, http.Header{"Content-Type": {"application/xml"}}, complete)
+	if err == nil {
+		// the upload may fail after the status 200 was sent, in which case
+		// the body is an error instead of the result
+		var result struct {
+			XMLName xml.Name
+			Code    string
+			Message string
+		}
+		if xerr := xml.Unmarshal(resp, &result); xerr != nil {
+			err = fmt.Errorf("bad response to multipart upload of %s: %v", key, xerr)
+		} else if result.XMLName.Local == "Error" {
+			err = fmt.Errorf("multipart upload of %s: %s: %s", key, result.Code, result.Message)
+		}
+	}
+	if err != nil {
+		s.abort(ctx, client, key, uploadID)
+	}
+	return err
+}
+
+// abort aborts a failed multipart upload, so that the storage of its parts is
+// freed. The upload is aborted even if ctx was canceled.
+func (s *ReportStore) abort(ctx context.Context, client HTTPClient, key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), reportAbortTimeout)
+	defer cancel()
+	_, _ = s.do(ctx, client, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+}
+
+// doETag sends a request like do and returns the ETag of the response.
+func (s *ReportStore) doETag(ctx context.Context, client HTTPClient, method, key string, q url.Values, body []byte) (string, error) {
+	var etag string
+	_, err := s.send(ctx, client, method, key, q, nil, body, func(resp *http.Response) {
+		etag = resp.Header.Get("ETag")
+	})
+	return etag, err
+}
+
+// do sends a signed request for the object with the given key and returns
+// the body of the response.
+func (s *ReportStore) do(ctx context.Context, client HTTPClient, method, key string, q url.Values, h http.Header, body []byte) ([]byte, error) {
+	return s.send(ctx, client, method, key, q, h, body, nil)
+}
+
+func (s *ReportStore) send(ctx context.Context, client HTTPClient, method, key string, q url.Values, h http.Header, body []byte, onResponse func(*http.Response)) ([]byte, error) {
+	u, err := url.Parse(strings.TrimRight(s.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	// the path is sent as it is signed
+	u.Path += "/" + s.Bucket + "/" + key
+	u.RawPath = awsEscapePath(u.Path)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	s.sign(req, body, time.Now())
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s: %w", method, key, StatusCodeError(resp.StatusCode))
+	}
+	if onResponse != nil {
+		onResponse(resp)
+	}
+	return b, nil
+}
+
+// sign adds the headers of AWS Signature Version 4 to the request, signing
+// its host and all of its headers.
+func (s *ReportStore) sign(req *http.Request, body []byte, now time.Time) {
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsEscapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, v := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query string of a request as signed by AWS
+// Signature Version 4, with sorted and strictly encoded parameters.
+func canonicalQuery(q url.Values) string {
+	var params []string
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// awsEscape percent-encodes all bytes of s other than the unreserved
+// characters of RFC 3986, as required by AWS Signature Version 4. Unlike
+// url.PathEscape, it also encodes characters such as !*'().
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// awsEscapePath encodes each segment of the given path as awsEscape does.
+func awsEscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = awsEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,242 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+)
+
+// An OpenPGPKeyring lists the OpenPGP public keys which are trusted to sign
+// downloaded files. Signatures are only accepted from keys and subkeys which
+// are valid for signing: revoked and expired keys, and subkeys without a
+// valid binding signature, are rejected. See ReadOpenPGPKeyring and
+// Request.SetSignatureURL.
+type OpenPGPKeyring openpgp.EntityList
+
+// VerifySignature implements SignatureVerifier for detached OpenPGP
+// signatures, as written by gpg --detach-sign in the binary or ASCII armored
+// format.
+func (k OpenPGPKeyring) VerifySignature(msg, sig []byte) error {
+	return verifyPGPSignature(k, sig, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(msg)), nil
+	})
+}
+
+// pgpArmorHeader starts each block of an ASCII armored key or signature.
+var pgpArmorHeader = []byte("-----BEGIN PGP")
+
+// ReadOpenPGPKeyring reads the public keys exported by gpg --export, in the
+// binary or ASCII armored format, such as a keyring file published by a
+// project. Armored files may hold several blocks. Keys of unsupported
+// algorithms are skipped.
+func ReadOpenPGPKeyring(r io.Reader) (OpenPGPKeyring, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := dearmorPGP(b)
+	if err != nil {
+		return nil, err
+	}
+	var keyring OpenPGPKeyring
+	for _, block := range blocks {
+		entities, err := openpgp.ReadKeyRing(bytes.NewReader(block))
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyring: %w", err)
+		}
+		keyring = append(keyring, entities...)
+	}
+	if len(keyring) == 0 {
+		return nil, errors.New("keyring has no supported OpenPGP keys")
+	}
+	return keyring, nil
+}
+
+// dearmorPGP returns the decoded ASCII armored blocks of b, or b itself if
+// it is not armored.
+func dearmorPGP(b []byte) ([][]byte, error) {
+	if !bytes.Contains(b, pgpArmorHeader) {
+		return [][]byte{b}, nil
+	}
+	var blocks [][]byte
+	for len(b) > 0 {
+		i := bytes.Index(b, pgpArmorHeader)
+		if i < 0 {
+			break
+		}
+		b = b[i:]
+		end := bytes.Index(b[len(pgpArmorHeader):], pgpArmorHeader)
+		chunk := b
+		if end >= 0 {
+			chunk, b = b[:len(pgpArmorHeader)+end], b[len(pgpArmorHeader)+end:]
+		} else {
+			b = nil
+		}
+		block, err := armor.Decode(bytes.NewReader(chunk))
+		if err != nil {
+			return nil, fmt.Errorf("invalid armor: %w", err)
+		}
+		body, err := io.ReadAll(block.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid armor: %w", err)
+		}
+		blocks = append(blocks, body)
+	}
+	return blocks, nil
+}
+
+// verifyPGPSignature returns nil if the detached signature sig, which may be
+// ASCII armored, is a signature of the document opened by open by a key of
+// the keyring which is valid for signing at the current time.
+func verifyPGPSignature(keyring OpenPGPKeyring, sig []byte, open func() (io.ReadCloser, error)) error {
+	blocks, err := dearmorPGP(sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if len(blocks) == 0 {
+		return errors.New("invalid signature: no OpenPGP signature found")
+	}
+	f, err := open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	doc := &pgpDocument{r: f}
+	_, err = openpgp.CheckDetachedSignature(openpgp.EntityList(keyring), doc, bytes.NewReader(blocks[0]), nil)
+	if doc.err != nil {
+		return doc.err
+	}
+	var sigErr pgperrors.SignatureError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, pgperrors.ErrUnknownIssuer):
+		return fmt.Errorf("%w: not signed by a trusted key", ErrBadSignature)
+	case errors.As(err, &sigErr), errors.Is(err, pgperrors.ErrKeyRevoked),
+		errors.Is(err, pgperrors.ErrKeyExpired), errors.Is(err, pgperrors.ErrSignatureExpired):
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	default:
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+}
+
+// pgpDocument reads a signed document and records any read error, so that
+// it is not mistaken for an invalid signature.
+type pgpDocument struct {
+	r   io.Reader
+	err error
+}
+
+func (d *pgpDocument) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		d.err = err
+	}
+	return n, err
+}
+
+// signatureCheck is the detached OpenPGP signature of a Request. See
+// Request.SetSignatureURL.
+type signatureCheck struct {
+	url           string
+	keyring       OpenPGPKeyring
+	deleteOnError bool
+}
+
+// SetSignatureURL specifies that the downloaded file must be signed by a key
+// of the given keyring. Its detached OpenPGP signature, as written by gpg
+// --detach-sign in the binary or ASCII armored format, is fetched from url
+// once the file is downloaded and any checksum is verified. If url is empty,
+// the signature is fetched from the URL of the request with .asc appended, or
+// else with .sig appended.
+//
+// If the signature is not made by a key of the keyring, the download fails
+// with ErrBadSignature. If deleteOnError is true, the downloaded file is then
+// deleted. The signature is requested with the headers of the request. If
+// keyring is nil, no signature is verified.
+func (r *Request) SetSignatureURL(keyring OpenPGPKeyring, url string, deleteOnError bool) {
+	r.signature = nil
+	if keyring != nil {
+		r.signature = &signatureCheck{url: url, keyring: keyring, deleteOnError: deleteOnError}
+	}
+}
+
+// checkSignature verifies the detached OpenPGP signature of the downloaded
+// file, if set by Request.SetSignatureURL.
+func (c *Client) checkSignature(resp *Response) stateFunc {
+	check := resp.Request.signature
+	if check == nil || resp.streamed || resp.special {
+		return c.closeResponse
+	}
+	resp.setPhase(PhaseVerifying)
+	sig, sigURL, err := c.fetchSignature(resp.Request)
+	if err != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+	resp.logf("verifying signature from %s", redactURLString(sigURL))
+	err = verifyPGPSignature(check.keyring, sig, resp.openUnsafe)
+	if errors.Is(err, ErrBadSignature) {
+		err = fmt.Errorf("%w (%s)", err, redactURLString(sigURL))
+	}
+	if err != nil {
+		resp.err = err
+		if check.deleteOnError && !resp.Request.NoStore {
+			if err := os.Remove(resp.Filename); err != nil {
+				resp.err = fmt.Errorf("cannot remove downloaded file with bad signature: %v", err)
+			}
+		}
+	}
+	return c.closeResponse
+}
+
+// fetchSignature fetches the detached signature of the given Request and
+// returns it with the URL it was fetched from.
+func (c *Client) fetchSignature(req *Request) ([]byte, string, error) {
+	urls := []string{req.signature.url}
+	if req.signature.url == "" {
+		u := req.URL().String()
+		urls = []string{u + ".asc", u + ".sig"}
+	}
+	var err error
+	for _, u := range urls {
+		var b []byte
+		if b, err = c.fetchSignatureFile(req, u); err == nil {
+			return b, u, nil
+		}
+		if !errors.Is(err, StatusCodeError(http.StatusNotFound)) {
+			break
+		}
+	}
+	return nil, "", fmt.Errorf("cannot fetch signature: %w", err)
+}
+
+// fetchSignatureFile fetches the signature file at the given URL with the
+// headers of the Request.
+func (c *Client) fetchSignatureFile(req *Request, u string) ([]byte, error) {
+	hreq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header = req.HTTPRequest.Header.Clone()
+	hresp, err := c.doHTTPRequest(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = hresp.Body.Close()
+	}()
+	if hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+		return nil, StatusCodeError(hresp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(hresp.Body, maxChecksumFileSize))
+}
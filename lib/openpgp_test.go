@@ -0,0 +1,257 @@
+package lib
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// OpenPGP keys and detached signatures of pgpTestContent made by gpg: an
+// armored Ed25519 signature, a binary RSA signature and an armored ECDSA
+// P-256 signature in text mode.
+const (
+	pgpTestContent = "hello, signed world\n"
+
+	pgpEd25519Key = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatJpNBYJKwYBBAHaRw8BAQdAxKxjvkhqc2D81zdxUji9TYHIoMrW1/S5uZ3m
+bbTTSAu0GFRlc3QgRWQgPGVkQGV4YW1wbGUuY29tPoiQBBMWCAA4FiEE+zD4xgCE
+flGe4GMaIMX7MJMHMDQFAmrSaTQCGwMFCwkIBwIGFQoJCAsCBBYCAwECHgECF4AA
+CgkQIMX7MJMHMDSEYgD/QP7GwHSc2oKdtrGO+TKR2KxyoF6kiOiHnSmuDqCqXXQB
+APcfw1oF8qIY5Ay6pv1dAzWjS4GfPhbeQI330h8paHQB
+=IuGT
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	pgpRSAKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mQENBGrSaTQBCACTV7lj5lfYffp/fZ8726IIDB/YM9XMWM7odCTv9GRBvhDOOjTb
+7dzdQYUm0FFi/oLGeenhIZwFK/ILmxO7VgHMdpKygidRT6LSGjqnJaH4w7DoT5v9
+nOb1yMtIFuHD4uAKUT9vOT/nYXBfAUagF9sGqrVFy7PwxTME/RNnLgJ/AvOjFRNO
+U/cRiMZ5wbwVPw2sFEYgz9NHPqBm4y/DnLPlRrn0/lmixiVFJA4ehsoSkM33IN1g
+nlZTOep0F3JV8Xx2aHQcfy5/deHbh2l7vBll7jw5yuOe5rR92Blcr2+Q/aSu3mvH
+cMshiDzDTz4dWBAHJtdPYckZg+G5B2nuJLWBABEBAAG0GlRlc3QgUlNBIDxyc2FA
+ZXhhbXBsZS5jb20+iQFOBBMBCgA4FiEEV25eCKLf0Cx3E1Sg3XlICDjPZNEFAmrS
+aTQCGwMFCwkIBwIGFQoJCAsCBBYCAwECHgECF4AACgkQ3XlICDjPZNFP6wgAkWsM
+K2XE2iSSlD8NNxtg2XNp+c63lt6GSYfoyn3k361m8v384p6rKWJ96V2bBD6jvEPI
+/MqeajwMQlYoA6pqofUxH/2u1P7poDBSAmNtPYXYcqjLd1SOTTm/fQTjgTQPGmpL
+eb1adK5gBG3Oyf7iQhXN0QmbZkx7TiWIsNQM/0U21GSiBqPIausCWx8UrgnmjE+d
+L7Y7faYpwtiNa97f6vrMjRVtxIRGcodcx1vUWJBpMm5mm20xHV4OHXnsnFGn4ZVm
+oiaPoulmjMxlW/ugavLMkKnPLqaZc9e/Uw8T2PLqBJpK7A/Ye+i9e8LbKmAzw2zy
+lwJd9LsVw3yVGf0twA==
+=QrBc
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	pgpECDSAKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mFIEatJpOBMIKoZIzj0DAQcCAwRn7kue+uJvTFB8o+u5ONhbJ3pVDXlWTJEjlbKR
+Ctw39TMXd7xGw4vJpK1A4isY2SVeH342vroibMVvGPu7PIjqtBhUZXN0IEVDIDxl
+Y0BleGFtcGxlLmNvbT6IkAQTEwgAOBYhBBtjBm4SiRNaU+/r1T1DewsH9ir1BQJq
+0mk4AhsDBQsJCAcCBhUKCQgLAgQWAgMBAh4BAheAAAoJED1DewsH9ir17ZkA/2Q2
+zUyovbavNcXpo5Lpc7lo07ijteOF9g6bLPWSCkcwAP49sbTfT9GcDH397bvQ8M49
+prK+33RhbToojK3h82i8kA==
+=Z95A
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	pgpEd25519Sig = `-----BEGIN PGP SIGNATURE-----
+
+iIUEABYIAC0WIQT7MPjGAIR+UZ7gYxogxfswkwcwNAUCatJpNQ8cZWRAZXhhbXBs
+ZS5jb20ACgkQIMX7MJMHMDSa3wD/cG/KHMCddvfcs2EKj7mZQpkX49A41s24UhxW
+1oBwZJwBAMT6VHb5X7VH83MW27wsmZmqEdwNn7Dn/YK5I1YnUJ8I
+=9Xvl
+-----END PGP SIGNATURE-----
+`
+
+	pgpRSASig = "iQFEBAABCgAuFiEEV25eCKLf0Cx3E1Sg3XlICDjPZNEFAmrSaTUQHHJzYUBleGFtcGxlLmNvbQAKCRDdeUgIOM9k0e7yB/9mQCz4hMXOSUEev0RfptS5BuxlqN0YcsqQrNe6lt8ZedAu/4Yj/Kjanz3O1yInwx8/fJiXJ/AkLcqKHTXamt8e0Yj7i+GFlBgM3LO/gqumNaqDP/KvQPmKM2ENfhOh1edw14I34xnEcRzWv6cHjEfTCRkvdC5rHActAtUDhImGcLGpFFIWpBFh8GQLOi+2g9N6v7jPG/jm4bFsTk/YggrwOYm1xYjokNTb+5uV3tAUzASxoDfmfJxaTs1TIPLE8f4curZchGx3hyPJL59UOKPEcH59RxdPsiz1ejw5gzHlC2EE57vl+OTDw/pYpbrynOBedzLjzujcd0bc+yoEx/o8"
+
+	pgpECDSASig = `-----BEGIN PGP SIGNATURE-----
+
+iIUEARMIAC0WIQQbYwZuEokTWlPv69U9Q3sLB/Yq9QUCatJpOA8cZWNAZXhhbXBs
+ZS5jb20ACgkQPUN7Cwf2KvXv1AEAyYtP47O6RccceeaUN+aNDPEA1iW0T5Xv4KHF
+le1Yw58A/imKhO8CMRjyNKvgAyFU3XP9/1lkAz9dan5Q1D2f+e9h
+=jSbd
+-----END PGP SIGNATURE-----
+`
+)
+
+// Keys and signatures of pgpTestContent made by gpg with keys which are not
+// valid for signing: a key which expired a day after it made its signature,
+// and a key which was revoked after making its signature.
+const (
+	pgpExpiredKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEXgvhABYJKwYBBAHaRw8BAQdADLRsxc7p3keFax/jdtZOYwZ4F6Q+94vwCMru
+vTZ8FIG0HUV4cGlyZWQgPGV4cGlyZWRAZXhhbXBsZS5jb20+iJYEExYIAD4WIQSK
+KXpTr+bHmgBp0zo/tAxG9eg2twUCXgvhAAIbAwUJAAFRgAULCQgHAgYVCgkICwIE
+FgIDAQIeAQIXgAAKCRA/tAxG9eg2t+6PAP9qjFBOAZ+BxA01s2gH5NXNI9Jt8IQF
+w+AtemcIfj3y9wD/SCmH+yE9onAsYXYGSchRuEzkR8LqDtZPPQbIaqCwdQk=
+=Hso9
+-----END PGP PUBLIC KEY BLOCK-----
+`
+	pgpExpiredSig = `-----BEGIN PGP SIGNATURE-----
+
+iIoEABYIADIWIQSKKXpTr+bHmgBp0zo/tAxG9eg2twUCXgvvEBQcZXhwaXJlZEBl
+eGFtcGxlLmNvbQAKCRA/tAxG9eg2t/vaAQDKX4f2ikOkdWZtNMHDNvV1k3W86yGJ
+N0huAl6ec1/DVgEA4Zk91EMYZsAL/rcYmLPBdy0XDd2vQCRWuDwZYG9v+wQ=
+=ttNa
+-----END PGP SIGNATURE-----
+`
+	pgpRevokedKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatJxKBYJKwYBBAHaRw8BAQdAEnxUwQDpBGwhph5JwvcZIHVBZETGtUpVaRP7
+Yb/wcgCIeAQgFggAIBYhBJ8cbPDqeTbiHGcNmVFc9ua+q9nNBQJq0nEoAh0AAAoJ
+EFFc9ua+q9nNOp4BANtIfZamd3aDpzH/MsOriMbmX2tQBkBRCL+44Ek4gwX1AQCP
+bw8KN1HeR5tMrUCO7aGHmJCgoW9mPrWumSV5LUpWBLQdUmV2b2tlZCA8cmV2b2tl
+ZEBleGFtcGxlLmNvbT6IkAQTFggAOBYhBJ8cbPDqeTbiHGcNmVFc9ua+q9nNBQJq
+0nEoAhsDBQsJCAcCBhUKCQgLAgQWAgMBAh4BAheAAAoJEFFc9ua+q9nN3KwBANiw
+pID/h6231yfLHn/un4NP7ICAnEwEsVEl8r+yL+U5AQCmmy76Tc9BHuT2ylvFx8aM
+mXBKM0ZGR4/rPF9TzSKcDw==
+=ZZ6q
+-----END PGP PUBLIC KEY BLOCK-----
+`
+	pgpRevokedSig = `-----BEGIN PGP SIGNATURE-----
+
+iIoEABYIADIWIQSfHGzw6nk24hxnDZlRXPbmvqvZzQUCatJxKBQccmV2b2tlZEBl
+eGFtcGxlLmNvbQAKCRBRXPbmvqvZzV6mAP9m6HjDfysHRnTmZdmdeVhsP4tW17qr
+RyEdg/kxwgpWngEA9tWpLOwI+K8NoOqeQwUcsodollemsaw303zcL069SgM=
+=E08A
+-----END PGP SIGNATURE-----
+`
+)
+
+func TestReadOpenPGPKeyring(t *testing.T) {
+	keyring, err := ReadOpenPGPKeyring(strings.NewReader(pgpEd25519Key + pgpRSAKey + pgpECDSAKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyring) != 3 {
+		t.Errorf("Expected 3 keys, got %d", len(keyring))
+	}
+	if _, err := ReadOpenPGPKeyring(strings.NewReader("not a key")); err == nil {
+		t.Error("Expected error for invalid keyring")
+	}
+}
+
+func TestVerifyPGPSignature(t *testing.T) {
+	keyring, _ := ReadOpenPGPKeyring(strings.NewReader(pgpEd25519Key + pgpRSAKey + pgpECDSAKey))
+	rsaSig, _ := base64.StdEncoding.DecodeString(pgpRSASig)
+	open := func(content string) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(content)), nil
+		}
+	}
+	for name, sig := range map[string][]byte{
+		"ed25519": []byte(pgpEd25519Sig),
+		"rsa":     rsaSig,
+		"ecdsa":   []byte(pgpECDSASig),
+	} {
+		if err := verifyPGPSignature(keyring, sig, open(pgpTestContent)); err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+		if err := verifyPGPSignature(keyring, sig, open("tampered")); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: expected ErrBadSignature for other content, got %v", name, err)
+		}
+	}
+
+	// the text signature is of the content with CRLF line endings
+	if err := verifyPGPSignature(keyring, []byte(pgpECDSASig), open(strings.ReplaceAll(pgpTestContent, "\n", "\r\n"))); err != nil {
+		t.Errorf("Unexpected error for CRLF text: %v", err)
+	}
+
+	untrusted, _ := ReadOpenPGPKeyring(strings.NewReader(pgpRSAKey))
+	if err := verifyPGPSignature(untrusted, []byte(pgpEd25519Sig), open(pgpTestContent)); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature for untrusted key, got %v", err)
+	}
+}
+
+func TestOpenPGPKeyring_VerifySignature(t *testing.T) {
+	keyring, _ := ReadOpenPGPKeyring(strings.NewReader(pgpEd25519Key))
+	var verifier SignatureVerifier = keyring
+	if err := verifier.VerifySignature([]byte(pgpTestContent), []byte(pgpEd25519Sig)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := verifier.VerifySignature([]byte("tampered"), []byte(pgpEd25519Sig)); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature for other content, got %v", err)
+	}
+}
+
+func TestClient_Do_SignatureURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file.txt", "/other.txt":
+			content := pgpTestContent
+			if r.URL.Query().Get("tampered") != "" {
+				content = "tampered"
+			}
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+		case "/file.txt.sig", "/other.txt.sig":
+			b, _ := base64.StdEncoding.DecodeString(pgpRSASig)
+			_, _ = w.Write(b)
+		case "/file.txt.asc":
+			_, _ = w.Write([]byte(pgpEd25519Sig))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	keyring, _ := ReadOpenPGPKeyring(strings.NewReader(pgpEd25519Key + pgpRSAKey))
+	dir := t.TempDir()
+	for _, path := range []string{"/file.txt", "/other.txt"} {
+		// the .asc signature is preferred, and .sig is fetched without it
+		req, _ := NewRequest(filepath.Join(dir, path), server.URL+path)
+		req.SetSignatureURL(keyring, "", true)
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if !hasEvent(resp, "verifying signature from "+server.URL+path+map[string]string{"/file.txt": ".asc", "/other.txt": ".sig"}[path]) {
+			t.Errorf("%s: expected signature event", path)
+		}
+	}
+
+	// a file with a bad signature is deleted
+	filename := filepath.Join(dir, "tampered.txt")
+	req, _ := NewRequest(filename, server.URL+"/file.txt?tampered=1")
+	req.SetSignatureURL(keyring, server.URL+"/file.txt.asc", true)
+	if err := DefaultClient.Do(req).Err(); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature, got %v", err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Error("Expected file with bad signature to be deleted")
+	}
+
+	// a missing signature fails the download
+	req, _ = NewRequest(filepath.Join(dir, "missing.txt"), server.URL+"/file.txt")
+	req.SetSignatureURL(keyring, server.URL+"/missing.asc", false)
+	if err := DefaultClient.Do(req).Err(); !errors.Is(err, StatusCodeError(http.StatusNotFound)) {
+		t.Errorf("Expected 404 error, got %v", err)
+	}
+}
+
+func TestVerifyPGPSignature_InvalidKeys(t *testing.T) {
+	open := func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(pgpTestContent)), nil
+	}
+	for name, test := range map[string]struct{ key, sig string }{
+		"expired": {pgpExpiredKey, pgpExpiredSig},
+		"revoked": {pgpRevokedKey, pgpRevokedSig},
+	} {
+		keyring, err := ReadOpenPGPKeyring(strings.NewReader(test.key))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := verifyPGPSignature(keyring, []byte(test.sig), open); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: expected ErrBadSignature, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PartialSuffixes are the suffixes of the files which grab leaves next to a
+// download while it is incomplete: resume states and staged files of atomic
+// batches. Applications which download to partial files of
+// their own, such as the grab command, may append their suffixes. See
+// Client.FindPartials.
+var PartialSuffixes = []string{
+	resumeStateSuffix,
+	stageSuffix,
+}
+
+// MinPartialAge is the least time for which FindPartials and CleanPartials
+// require a file, and the download it belongs to, not to have been modified.
+// Downloads in progress in other processes cannot be told apart from
+// interrupted ones other than by writing to their files, so shorter durations
+// would risk removing them.
+const MinPartialAge = time.Hour
+
+// A PartialFile is a file left by an interrupted download.
+type PartialFile struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// FindPartials returns the files below dir which were left by interrupted
+// downloads, as named by PartialSuffixes, and were not modified for olderThan.
+// A file is also kept while the file it belongs to, such as the partial file
+// of a resume state, was modified more recently, as its download may be in
+// progress in another process. olderThan is raised to MinPartialAge if it is
+// shorter. Files of downloads of the Client which are in progress are never
+// returned, however long they have stalled. Symbolic links are not followed.
+func (c *Client) FindPartials(dir string, olderThan time.Duration) ([]PartialFile, error) {
+	active := c.active.destinations()
+	cutoff := time.Now().Add(-max(olderThan, MinPartialAge))
+	var partials []PartialFile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		dest, ok := partialDestination(path)
+		if !ok || active[dest] {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.ModTime().After(cutoff) {
+			return nil
+		}
+		if fi, err := os.Lstat(dest); err == nil && fi.ModTime().After(cutoff) {
+			return nil
+		}
+		partials = append(partials, PartialFile{Path: path, Size: fi.Size(), ModTime: fi.ModTime()})
+		return nil
+	})
+	return partials, err
+}
+
+// CleanPartials removes the files below dir which were left by interrupted
+// downloads and not modified for olderThan, as found by FindPartials, and
+// returns the files which were removed. Their sizes add up to the space which
+// was reclaimed. If a file cannot be removed, the files removed so far are
+// returned with the error.
+func (c *Client) CleanPartials(dir string, olderThan time.Duration) ([]PartialFile, error) {
+	partials, err := c.FindPartials(dir, olderThan)
+	if err != nil {
+		return nil, err
+	}
+	var removed []PartialFile
+	for _, p := range partials {
+		if err := os.Remove(p.Path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed = append(removed, p)
+	}
+	return removed, nil
+}
+
+// partialDestination returns the absolute destination path of the download
+// which left the given file, and whether it is such a file. Files named only
+// by a suffix, such as those of a user's own .grab file, are checked to be
+// resume states.
+func partialDestination(path string) (string, bool) {
+	dir, name := filepath.Split(path)
+	for _, suffix := range PartialSuffixes {
+		base, ok := strings.CutSuffix(name, suffix)
+		if !ok || base == "" || base == "." {
+			continue
+		}
+		switch suffix {
+		case stageSuffix:
+			// staged files are hidden
+			if base, ok = strings.CutPrefix(base, "."); !ok {
+				continue
+			}
+		case resumeStateSuffix:
+			if s := loadResumeState(filepath.Join(dir, base)); s == nil || s.URL == "" {
+				continue
+			}
+		}
+		dest, err := filepath.Abs(filepath.Join(dir, base))
+		if err != nil {
+			return "", false
+		}
+		return dest, true
+	}
+	return "", false
+}
+
+// destinations returns the absolute destination paths of the downloads in
+// progress, as given by their requests.
+func (s *activeSet) destinations() map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make(map[string]bool, len(s.active))
+	for resp := range s.active {
+		if name, err := filepath.Abs(resp.Request.Filename); err == nil && resp.Request.Filename != "" {
+			paths[name] = true
+		}
+	}
+	return paths
+}
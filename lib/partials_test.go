@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestClient_CleanPartials(t *testing.T) {
+	suffixes := PartialSuffixes
+	PartialSuffixes = append(PartialSuffixes[:len(suffixes):len(suffixes)], ".grab-part")
+	defer func() {
+		PartialSuffixes = suffixes
+	}()
+
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	files := map[string]string{
+		"iso/image.iso.grab-part":    "partial",
+		"file.bin.grab":              `{"url":"https://example.com/file.bin","size":10,"written":4}`,
+		".staged.txt.grab-stage":     "staged",
+		"notes.grab":                 "not a resume state",
+		"complete.txt":               "complete",
+		"recent.txt.grab-part":       "recent",
+		"active.bin.grab":            `{"url":"http://example.com/active.bin","written":1}`,
+		"writing.bin":                "written by another process",
+		"writing.bin.grab":           `{"url":"https://example.com/writing.bin","written":1}`,
+		"sidecar/file.bin.grab.json": `{"etag":"x"}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if name != "recent.txt.grab-part" && name != "writing.bin" {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// the files of downloads in progress are kept
+	client := NewClient()
+	req, _ := NewRequest(filepath.Join(dir, "active.bin"), "http://example.com/active.bin")
+	client.active.add(&Response{Request: req})
+
+	removed, err := client.CleanPartials(dir, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	var reclaimed int64
+	for _, p := range removed {
+		rel, _ := filepath.Rel(dir, p.Path)
+		got = append(got, filepath.ToSlash(rel))
+		reclaimed += p.Size
+	}
+	sort.Strings(got)
+	want := []string{".staged.txt.grab-stage", "file.bin.grab", "iso/image.iso.grab-part"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v to be removed, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %v to be removed, got %v", want, got)
+		}
+	}
+	var expected int64
+	for _, name := range want {
+		expected += int64(len(files[name]))
+	}
+	if reclaimed != expected {
+		t.Errorf("Expected %d bytes reclaimed, got %d", expected, reclaimed)
+	}
+	for _, name := range want {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", name)
+		}
+	}
+	for _, name := range []string{"notes.grab", "complete.txt", "recent.txt.grab-part", "active.bin.grab", "writing.bin.grab", "sidecar/file.bin.grab.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to be kept: %v", name, err)
+		}
+	}
+}
+
+func TestClient_FindPartials_MinPartialAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.bin.grab")
+	if err := os.WriteFile(path, []byte(`{"url":"https://example.com/file.bin","written":1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient()
+	for _, tt := range []struct {
+		age   time.Duration
+		found bool
+	}{
+		{MinPartialAge / 2, false},
+		{MinPartialAge * 2, true},
+	} {
+		mtime := time.Now().Add(-tt.age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		partials, err := client.FindPartials(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found := len(partials) == 1; found != tt.found {
+			t.Errorf("Expected file modified %v ago to be found: %v, got %v", tt.age, tt.found, found)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package lib
+
+import "sync/atomic"
+
+// Phase describes the stage of a file transfer that a Response is in.
+type Phase int32
+
+const (
+	// PhaseResolving indicates that the local destination is being inspected
+	// to decide how the transfer should proceed.
+	PhaseResolving Phase = iota
+
+	// PhaseHead indicates that the size and capabilities of the remote file are
+	// being queried, typically with a HEAD request.
+	PhaseHead
+
+	// PhaseDownloading indicates that the file is being requested and its
+	// content transferred.
+	PhaseDownloading
+
+	// PhaseVerifying indicates that the checksum of the transferred file is
+	// being computed and compared.
+	PhaseVerifying
+
+	// PhaseFinalizing indicates that files and connections are being closed.
+	PhaseFinalizing
+
+	// PhaseComplete indicates that the transfer is complete, successfully or
+	// otherwise.
+	PhaseComplete
+)
+
+var phaseNames = [...]string{
+	PhaseResolving:   "resolving",
+	PhaseHead:        "head",
+	PhaseDownloading: "downloading",
+	PhaseVerifying:   "verifying",
+	PhaseFinalizing:  "finalizing",
+	PhaseComplete:    "complete",
+}
+
+func (p Phase) String() string {
+	if p < 0 || int(p) >= len(phaseNames) {
+		return "unknown"
+	}
+	return phaseNames[p]
+}
+
+// phaseBufferSize is the capacity of the channel returned by Response.Phases.
+// It exceeds the number of transitions of a transfer without retries, so that
+// sends rarely find it full.
+const phaseBufferSize = 16
+
+// setPhase records the current phase of the Response and publishes it to the
+// Phases channel if it changed. Sends never block the state machine: if the
+// receiver has fallen behind, such as during many retries, the newest
+// intermediate phases are dropped. The last slot of the channel is reserved
+// for PhaseComplete, so that it is always delivered after the phases which
+// were, starting with PhaseResolving.
+func (c *Response) setPhase(p Phase) {
+	if Phase(atomic.SwapInt32(&c.phase, int32(p))) == p || c.phases == nil {
+		return
+	}
+	if p != PhaseComplete && len(c.phases) >= cap(c.phases)-1 {
+		return
+	}
+	select {
+	case c.phases <- p:
+	default:
+	}
+}
+
+// Phase returns the current phase of the transfer.
+func (c *Response) Phase() Phase {
+	return Phase(atomic.LoadInt32(&c.phase))
+}
+
+// Phases returns a channel which receives every phase the transfer enters, in
+// order, starting with PhaseResolving and ending with PhaseComplete. The
+// channel is closed when the transfer is complete. Later phases are dropped if
+// the channel is not received from while a transfer is retried many times,
+// but PhaseResolving and PhaseComplete are always received.
+func (c *Response) Phases() <-chan Phase {
+	return c.phases
+}
@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPhase_String(t *testing.T) {
+	tests := map[Phase]string{
+		PhaseResolving:   "resolving",
+		PhaseHead:        "head",
+		PhaseDownloading: "downloading",
+		PhaseVerifying:   "verifying",
+		PhaseFinalizing:  "finalizing",
+		PhaseComplete:    "complete",
+		Phase(-1):        "unknown",
+		Phase(42):        "unknown",
+	}
+	for p, expect := range tests {
+		if got := p.String(); got != expect {
+			t.Errorf("Phase(%d).String(): expected %q, got %q", p, expect, got)
+		}
+	}
+}
+
+func TestResponse_Phases(t *testing.T) {
+	sum := sha256.Sum256([]byte("test content"))
+
+	tests := []struct {
+		name     string
+		existing bool
+		checksum bool
+		expect   []Phase
+	}{
+		{
+			name:   "new file",
+			expect: []Phase{PhaseResolving, PhaseDownloading, PhaseFinalizing, PhaseComplete},
+		},
+		{
+			name:     "existing file",
+			existing: true,
+			expect: []Phase{PhaseResolving, PhaseHead, PhaseResolving, PhaseDownloading,
+				PhaseFinalizing, PhaseComplete},
+		},
+		{
+			name:     "checksum",
+			checksum: true,
+			expect: []Phase{PhaseResolving, PhaseDownloading, PhaseVerifying, PhaseFinalizing,
+				PhaseComplete},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDirectoryWithCleanup(t, "grab-phase-test")
+			if tt.existing {
+				if err := os.WriteFile("file.txt", []byte("stale"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			testURL := "http://example.com/file.txt"
+			mockClient := newMockHTTPClient()
+			head := createMockHTTPResponse("200 OK", http.StatusOK, "", nil)
+			head.ContentLength = 12
+			head.Request, _ = http.NewRequest("HEAD", testURL, nil)
+			mockClient.addResponse("HEAD", testURL, head)
+			client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+			req, _ := NewRequest("file.txt", testURL)
+			if tt.checksum {
+				req.SetChecksum(sha256.New(), sum[:], false)
+			}
+			resp := client.Do(req)
+
+			var phases []Phase
+			for p := range resp.Phases() {
+				phases = append(phases, p)
+			}
+			if err := resp.Err(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(phases, tt.expect) {
+				t.Errorf("Expected phases %v, got %v", tt.expect, phases)
+			}
+			if resp.Phase() != PhaseComplete {
+				t.Errorf("Expected final phase %v, got %v", PhaseComplete, resp.Phase())
+			}
+		})
+	}
+}
+
+func TestResponse_setPhase_Full(t *testing.T) {
+	for size, expect := range map[int][]Phase{
+		2: {PhaseResolving, PhaseComplete},
+		3: {PhaseResolving, PhaseHead, PhaseComplete},
+	} {
+		// as sent by Client.Do
+		resp := &Response{phases: make(chan Phase, size)}
+		resp.phases <- PhaseResolving
+		for _, p := range []Phase{PhaseHead, PhaseDownloading, PhaseVerifying, PhaseComplete} {
+			resp.setPhase(p)
+		}
+		close(resp.phases)
+		var phases []Phase
+		for p := range resp.phases {
+			phases = append(phases, p)
+		}
+		if len(phases) == 0 || phases[0] != PhaseResolving {
+			t.Errorf("Expected PhaseResolving to be received first, got %v", phases)
+		}
+		if !reflect.DeepEqual(phases, expect) {
+			t.Errorf("Expected phases %v, got %v", expect, phases)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// politeness spaces the downloads of a batch, so that servers are not sent
+// requests more often than they allow, in the manner of a robots.txt
+// Crawl-delay.
+type politeness struct {
+	delay      time.Duration
+	hostDelay  time.Duration
+	hostDelays map[string]time.Duration
+
+	mu    sync.Mutex
+	next  time.Time            // earliest start of the next download
+	hosts map[string]time.Time // earliest start of a download by host
+}
+
+func newPoliteness(opts BatchOptions) *politeness {
+	p := &politeness{
+		delay:      opts.Delay,
+		hostDelay:  opts.HostDelay,
+		hostDelays: make(map[string]time.Duration, len(opts.HostDelays)),
+		hosts:      make(map[string]time.Time),
+	}
+	for host, d := range opts.HostDelays {
+		p.hostDelays[strings.ToLower(host)] = d
+	}
+	return p
+}
+
+// reserve reserves the earliest time at which a download from the given host
+// may start, and returns it.
+func (p *politeness) reserve(host string, now time.Time) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	start := now
+	if t := p.hosts[host]; t.After(start) {
+		start = t
+	}
+	if p.delay > 0 {
+		// downloads start in the order of their reservations
+		if p.next.After(start) {
+			start = p.next
+		}
+		p.next = start.Add(p.delay)
+	}
+	d, ok := p.hostDelays[host]
+	if !ok {
+		d = p.hostDelay
+	}
+	p.hosts[host] = start.Add(d)
+	return start
+}
+
+// ready returns the earliest time at which a download from the given host may
+// start, without reserving it.
+func (p *politeness) ready(host string) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	start := p.hosts[host]
+	if p.delay > 0 && p.next.After(start) {
+		start = p.next
+	}
+	return start
+}
+
+// politeHost returns the host by which the politeness delays of the given
+// Request are kept.
+func politeHost(req *Request) string {
+	return strings.ToLower(req.URL().Hostname())
+}
+
+// schedule calls send with the index of each of the given requests once the
+// politeness delays of their batch allow their downloads to start, until send
+// returns false or ctx is canceled. Requests are sent in order, except that a
+// request which must wait for its host is overtaken by later requests which
+// may start at once, so that no worker sits idle waiting for one host while
+// downloads from others could start. The start of a download is only reserved
+// once send has handed its request to a worker, so the delays hold between
+// the actual starts of downloads.
+func schedule(ctx context.Context, requests []*Request, send func(i int) bool) {
+	if !slices.ContainsFunc(requests, func(req *Request) bool { return req.politeness != nil }) {
+		for i := range requests {
+			if !send(i) {
+				return
+			}
+		}
+		return
+	}
+	pending := make([]int, len(requests))
+	for i := range pending {
+		pending[i] = i
+	}
+	for len(pending) > 0 {
+		// pick the first request which may start now, or else the one which
+		// may start first
+		next, wait, now := 0, time.Duration(math.MaxInt64), time.Now()
+		for j, i := range pending {
+			p := requests[i].politeness
+			if p == nil {
+				next, wait = j, 0
+				break
+			}
+			if d := p.ready(politeHost(requests[i])).Sub(now); d < wait {
+				next, wait = j, d
+				if d <= 0 {
+					break
+				}
+			}
+		}
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+		}
+		i := pending[next]
+		if !send(i) {
+			return
+		}
+		if p := requests[i].politeness; p != nil {
+			p.reserve(politeHost(requests[i]), time.Now())
+		}
+		pending = slices.Delete(pending, next, next+1)
+	}
+}
+
+// doBatchPolite executes the given requests like DoBatchWithOptions, spacing
+// the start of their downloads by the delays of the given options. The delays
+// are kept by the workers of the batch, which only receive a request once it
+// may start (see schedule).
+func (c *Client) doBatchPolite(ctx context.Context, opts BatchOptions, requests ...*Request) <-chan *Response {
+	p := newPoliteness(opts)
+	spaced := make([]*Request, len(requests))
+	for i, req := range requests {
+		spaced[i] = req.WithContext(req.Context())
+		spaced[i].politeness = p
+	}
+	opts.Delay, opts.HostDelay, opts.HostDelays = 0, 0, nil
+	return c.doBatchWithOptions(ctx, opts, spaced...)
+}
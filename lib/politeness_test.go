@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoliteness_Reserve(t *testing.T) {
+	p := newPoliteness(BatchOptions{
+		Delay:      time.Second,
+		HostDelay:  5 * time.Second,
+		HostDelays: map[string]time.Duration{"Fast.example.com": 0},
+	})
+	now := time.Unix(0, 0)
+	tests := []struct {
+		host  string
+		start time.Duration
+	}{
+		{"a.example.com", 0},
+		{"b.example.com", time.Second},
+		{"a.example.com", 5 * time.Second},
+		{"fast.example.com", 6 * time.Second},
+		{"fast.example.com", 7 * time.Second},
+		{"b.example.com", 8 * time.Second},
+	}
+	for i, test := range tests {
+		if got := p.reserve(test.host, now).Sub(now); got != test.start {
+			t.Errorf("%d: expected %s to start after %v, got %v", i, test.host, test.start, got)
+		}
+	}
+}
+
+func TestClient_DoBatchWithOptions_HostDelay(t *testing.T) {
+	var mu sync.Mutex
+	starts := make(map[string][]time.Time)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			host, _, _ := strings.Cut(r.Host, ":")
+			starts[host] = append(starts[host], time.Now())
+			mu.Unlock()
+		}
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":"):]
+
+	dir := t.TempDir()
+	var requests []*Request
+	for _, name := range []string{"a", "b", "c"} {
+		for _, host := range []string{"127.0.0.1", "localhost"} {
+			req, _ := NewRequest(dir+"/"+host+"-"+name, "http://"+host+port+"/"+name)
+			requests = append(requests, req)
+		}
+	}
+
+	const delay = 100 * time.Millisecond
+	opts := BatchOptions{
+		Workers:    6,
+		HostDelay:  delay,
+		HostDelays: map[string]time.Duration{"localhost": 0},
+	}
+	begin := time.Now()
+	for resp := range NewClient().DoBatchWithOptions(context.Background(), opts, requests...) {
+		if err := resp.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(begin); elapsed < 2*delay {
+		t.Errorf("Expected batch to take at least %v, took %v", 2*delay, elapsed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	slow := starts["127.0.0.1"]
+	sort.Slice(slow, func(i, j int) bool { return slow[i].Before(slow[j]) })
+	if len(slow) != 3 {
+		t.Fatalf("Expected 3 requests to 127.0.0.1, got %d", len(slow))
+	}
+	for i := 1; i < len(slow); i++ {
+		// allow for the time between the start of a download and its GET
+		if gap := slow[i].Sub(slow[i-1]); gap < delay*8/10 {
+			t.Errorf("Expected requests to be %v apart, got %v", delay, gap)
+		}
+	}
+	fast := starts["localhost"]
+	sort.Slice(fast, func(i, j int) bool { return fast[i].Before(fast[j]) })
+	if len(fast) != 3 || fast[2].Sub(fast[0]) > delay {
+		t.Errorf("Expected requests to localhost without delay, got %v", fast)
+	}
+}
+
+func TestClient_DoBatchWithOptions_HostDelayOvertake(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			host, _, _ := strings.Cut(r.Host, ":")
+			order = append(order, host+r.URL.Path)
+			mu.Unlock()
+		}
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":"):]
+
+	dir := t.TempDir()
+	var requests []*Request
+	for _, u := range []string{"127.0.0.1/a", "127.0.0.1/b", "localhost/c"} {
+		req, _ := NewRequest(dir+"/"+strings.ReplaceAll(u, "/", "-"), "http://"+strings.Replace(u, "/", port+"/", 1))
+		requests = append(requests, req)
+	}
+
+	// the single worker must not wait for 127.0.0.1 while the download from
+	// localhost could start
+	const delay = 500 * time.Millisecond
+	opts := BatchOptions{
+		Workers:    1,
+		Ordered:    true,
+		HostDelays: map[string]time.Duration{"127.0.0.1": delay},
+	}
+	begin := time.Now()
+	var filenames []string
+	for resp := range NewClient().DoBatchWithOptions(context.Background(), opts, requests...) {
+		if err := resp.Err(); err != nil {
+			t.Fatal(err)
+		}
+		filenames = append(filenames, resp.Filename)
+	}
+	if elapsed := time.Since(begin); elapsed < delay {
+		t.Errorf("Expected batch to take at least %v, took %v", delay, elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expect := []string{"127.0.0.1/a", "localhost/c", "127.0.0.1/b"}
+	if !slices.Equal(order, expect) {
+		t.Errorf("Expected downloads in order %v, got %v", expect, order)
+	}
+	for i, req := range requests {
+		if filenames[i] != req.Filename {
+			t.Errorf("Expected response %d for %s, got %s", i, req.Filename, filenames[i])
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// A Preflight is a request which is sent once before the first download of a
+// Client, such as to a login or consent page, to establish a session with the
+// remote server. Cookies set by its response, and by any redirects it
+// follows, are sent with all later requests to matching URLs. The response
+// headers named in Headers are sent with later requests to the host of the
+// response.
+type Preflight struct {
+	// Request is the HTTP request to send. Its body, if any, must be
+	// replayable via GetBody, as the request is sent again if it fails.
+	Request *http.Request
+
+	// Headers names the response headers which are copied into every later
+	// request to the same host, such as a session token returned in a header.
+	Headers []string
+}
+
+// preflightState records the session established by a Preflight request.
+type preflightState struct {
+	mu     sync.Mutex
+	done   bool
+	jar    http.CookieJar
+	header http.Header
+	host   string // canonical host which is sent header
+}
+
+// preflight sends the Preflight request of the Client, unless it was already
+// sent successfully.
+func (c *Client) preflight(req *http.Request) error {
+	if c.Preflight == nil || c.Preflight.Request == nil {
+		return nil
+	}
+	state := &c.preflightState
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.done {
+		return nil
+	}
+
+	preq := c.Preflight.Request.Clone(req.Context())
+	if preq.GetBody != nil {
+		body, err := preq.GetBody()
+		if err != nil {
+			return fmt.Errorf("preflight request failed: %w", err)
+		}
+		preq.Body = body
+	}
+	if c.UserAgent != "" && preq.Header.Get("User-Agent") == "" {
+		preq.Header.Set("User-Agent", expandUserAgent(c.UserAgent))
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+	hc := c.HTTPClient
+	if client, ok := hc.(*http.Client); ok {
+		// collect the cookies of redirects too
+		withJar := *client
+		withJar.Jar = jar
+		hc = &withJar
+	}
+	hresp, err := hc.Do(preq)
+	if err != nil {
+		return fmt.Errorf("preflight request failed: %w", err)
+	}
+	_ = hresp.Body.Close()
+	if hresp.StatusCode < 200 || hresp.StatusCode > 399 {
+		return fmt.Errorf("preflight request failed: %w", StatusCodeError(hresp.StatusCode))
+	}
+
+	u := preq.URL
+	if hresp.Request != nil {
+		u = hresp.Request.URL
+	}
+	if hc == c.HTTPClient {
+		jar.SetCookies(u, hresp.Cookies())
+	}
+	header := make(http.Header)
+	for _, name := range c.Preflight.Headers {
+		if v := hresp.Header.Values(name); len(v) > 0 {
+			header[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	state.jar, state.header, state.host, state.done = jar, header, canonicalHost(u), true
+	return nil
+}
+
+// preflightHeaders returns the headers of the session established by the
+// Preflight request which are sent to the given URL: none, unless it is on
+// the host of the Preflight response. They are added along with
+// Client.HostHeaders.
+func (c *Client) preflightHeaders(u *url.URL) http.Header {
+	if c.Preflight == nil {
+		return nil
+	}
+	state := &c.preflightState
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !state.done || !strings.EqualFold(canonicalHost(u), state.host) {
+		return nil
+	}
+	return state.header
+}
+
+// applyPreflight adds the cookies of the session established by the Preflight
+// request to the given request. Cookies already set on the request take
+// precedence.
+func (c *Client) applyPreflight(req *http.Request) {
+	state := &c.preflightState
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !state.done {
+		return
+	}
+	for _, cookie := range state.jar.Cookies(req.URL) {
+		if _, err := req.Cookie(cookie.Name); err == http.ErrNoCookie {
+			req.AddCookie(cookie)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClient_Preflight(t *testing.T) {
+	loginURL := "http://example.com/login"
+	fileURL := "http://example.com/file.txt"
+	login := createMockHTTPResponse("200 OK", http.StatusOK, "", map[string]string{
+		"Set-Cookie": "session=abc123; Path=/",
+		"X-Token":    "token",
+	})
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("POST", loginURL, login)
+	preq, _ := http.NewRequest("POST", loginURL, strings.NewReader("user=test"))
+	client := &Client{
+		HTTPClient: mockClient,
+		UserAgent:  "test-agent",
+		Preflight:  &Preflight{Request: preq, Headers: []string{"X-Token"}},
+	}
+
+	for i := 0; i < 2; i++ {
+		req, _ := NewRequest("", fileURL)
+		req.NoStore = true
+		if err := client.Do(req).Err(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	requests := mockClient.getRequests()
+	if requests[0].URL.String() != loginURL {
+		t.Fatalf("Expected preflight request first, got %s", requests[0].URL)
+	}
+	for _, r := range requests[1:] {
+		if r.URL.String() == loginURL {
+			t.Error("Expected preflight request to be sent once")
+			continue
+		}
+		if c, err := r.Cookie("session"); err != nil || c.Value != "abc123" {
+			t.Errorf("Expected session cookie on %s %s, got %v", r.Method, r.URL, r.Header.Get("Cookie"))
+		}
+		if r.Header.Get("X-Token") != "token" {
+			t.Errorf("Expected X-Token header on %s %s", r.Method, r.URL)
+		}
+	}
+}
+
+func TestClient_Preflight_Failure(t *testing.T) {
+	loginURL := "http://example.com/login"
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", loginURL, createErrorResponse(http.StatusForbidden, "Forbidden"))
+	preq, _ := http.NewRequest("GET", loginURL, nil)
+	client := &Client{
+		HTTPClient: mockClient,
+		UserAgent:  "test-agent",
+		Preflight:  &Preflight{Request: preq},
+	}
+
+	req, _ := NewRequest("", "http://example.com/file.txt")
+	req.NoStore = true
+	err := client.Do(req).Err()
+	var statusErr StatusCodeError
+	if !errors.As(err, &statusErr) || statusErr != http.StatusForbidden {
+		t.Errorf("Expected preflight StatusCodeError, got %v", err)
+	}
+	if client.preflightState.done {
+		t.Error("Expected failed preflight to be retried")
+	}
+}
+
+func TestClient_Preflight_RedirectScope(t *testing.T) {
+	var leaked string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("X-Token"); v != "" {
+			leaked = v
+		}
+		w.Write([]byte("data"))
+	}))
+	defer mirror.Close()
+	var cookie, token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			// the session cookie is set by the redirect
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc123", Path: "/"})
+			http.Redirect(w, r, "/welcome", http.StatusFound)
+		case "/welcome":
+			w.Header().Set("X-Token", "token")
+		default:
+			if c, err := r.Cookie("session"); err == nil {
+				cookie = c.Value
+			}
+			token = r.Header.Get("X-Token")
+			http.Redirect(w, r, mirror.URL+"/file.txt", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	preq, _ := http.NewRequest("GET", server.URL+"/login", nil)
+	client := NewClient()
+	client.Preflight = &Preflight{Request: preq, Headers: []string{"X-Token"}}
+	req, _ := NewRequest(t.TempDir(), server.URL+"/file.txt")
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cookie != "abc123" {
+		t.Errorf("Expected session cookie of the redirect, got %q", cookie)
+	}
+	if token != "token" {
+		t.Errorf("Expected X-Token header on the preflight host, got %q", token)
+	}
+	if leaked != "" {
+		t.Errorf("Expected X-Token header not to be sent to another host, got %q", leaked)
+	}
+}
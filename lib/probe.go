@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// HeadFallbackPolicy specifies how a Client discovers the size and resume
+// support of a remote file when the server rejects HEAD requests.
+type HeadFallbackPolicy int
+
+const (
+	// HeadFallbackNone proceeds directly with the download. The remote file
+	// size is unknown until the GET response is received and existing files
+	// are downloaded again in full.
+	HeadFallbackNone HeadFallbackPolicy = iota
+
+	// HeadFallbackRangeProbe sends a GET request for the first byte of the
+	// remote file and reads its metadata from the response headers. If the
+	// server ignores the range, the response body is closed without being
+	// read.
+	HeadFallbackRangeProbe
+)
+
+// headRejected returns true if the given status code of a HEAD response
+// indicates that the server does not support HEAD requests.
+func headRejected(statusCode int) bool {
+	switch statusCode {
+	case http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// contentRangeSize returns the complete length of the remote file given in a
+// Content-Range header, or -1 if the server reports the length as unknown.
+func contentRangeSize(contentRange string) (int64, error) {
+	var start, end, size int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size); err == nil {
+		return size, nil
+	}
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/*", &start, &end); err == nil {
+		return -1, nil
+	}
+	return 0, fmt.Errorf("invalid Content-Range header: %q", contentRange)
+}
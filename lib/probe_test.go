@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// headlessHTTPClient serves a single file, rejecting HEAD requests with the
+// given status code and honoring single Range requests unless ignoreRange is
+// set.
+type headlessHTTPClient struct {
+	content     string
+	headStatus  int
+	ignoreRange bool
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (c *headlessHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	c.mu.Unlock()
+
+	if req.Method == "HEAD" {
+		resp := createErrorResponse(c.headStatus, "")
+		resp.Request = req
+		return resp, nil
+	}
+
+	var start int64
+	end := int64(len(c.content)) - 1
+	rng := req.Header.Get("Range")
+	if _, err := fmt.Sscanf(rng, "bytes=%d-", &start); err != nil || c.ignoreRange {
+		resp := createSuccessResponse(c.content)
+		resp.Request = req
+		return resp, nil
+	}
+	if !strings.HasSuffix(rng, "-") {
+		_, _ = fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+	}
+	resp := createMockHTTPResponse("206 Partial Content", http.StatusPartialContent, c.content[start:end+1],
+		map[string]string{"Content-Range": fmt.Sprintf("bytes %d-%d/%d", start, end, len(c.content))})
+	resp.Request = req
+	return resp, nil
+}
+
+func (c *headlessHTTPClient) getRequests() []*http.Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*http.Request(nil), c.requests...)
+}
+
+func TestContentRangeSize(t *testing.T) {
+	tests := []struct {
+		contentRange string
+		expect       int64
+		expectError  bool
+	}{
+		{"bytes 0-0/1234", 1234, false},
+		{"bytes 0-0/*", -1, false},
+		{"bytes */1234", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		size, err := contentRangeSize(tt.contentRange)
+		if (err != nil) != tt.expectError {
+			t.Errorf("contentRangeSize(%q): unexpected error: %v", tt.contentRange, err)
+			continue
+		}
+		if size != tt.expect {
+			t.Errorf("contentRangeSize(%q): expected %d, got %d", tt.contentRange, tt.expect, size)
+		}
+	}
+}
+
+func TestClient_Do_HeadFallback(t *testing.T) {
+	content := "0123456789"
+
+	tests := []struct {
+		name        string
+		policy      HeadFallbackPolicy
+		headStatus  int
+		ignoreRange bool
+		expectProbe bool
+		expectRange string
+	}{
+		{
+			name:        "range probe",
+			policy:      HeadFallbackRangeProbe,
+			headStatus:  http.StatusMethodNotAllowed,
+			expectProbe: true,
+			expectRange: "bytes=4-",
+		},
+		{
+			name:        "range probe forbidden",
+			policy:      HeadFallbackRangeProbe,
+			headStatus:  http.StatusForbidden,
+			expectProbe: true,
+			expectRange: "bytes=4-",
+		},
+		{
+			name:        "range probe ignored",
+			policy:      HeadFallbackRangeProbe,
+			headStatus:  http.StatusNotImplemented,
+			ignoreRange: true,
+			expectProbe: true,
+		},
+		{
+			name:       "no fallback",
+			policy:     HeadFallbackNone,
+			headStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "other status",
+			policy:     HeadFallbackRangeProbe,
+			headStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDirectoryWithCleanup(t, "grab-probe-test")
+			if err := os.WriteFile("file.bin", []byte(content[:4]), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			httpClient := &headlessHTTPClient{
+				content:     content,
+				headStatus:  tt.headStatus,
+				ignoreRange: tt.ignoreRange,
+			}
+			client := &Client{HTTPClient: httpClient, UserAgent: "test-agent", HeadFallback: tt.policy}
+
+			req, _ := NewRequest("file.bin", "http://example.com/file.bin")
+			resp := client.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			requests := httpClient.getRequests()
+			expectRequests := 2
+			if tt.expectProbe {
+				expectRequests = 3
+			}
+			if len(requests) != expectRequests {
+				t.Fatalf("Expected %d requests, got %d", expectRequests, len(requests))
+			}
+			if tt.expectProbe {
+				if got := requests[1].Header.Get("Range"); got != "bytes=0-0" {
+					t.Errorf("Expected probe Range header %q, got %q", "bytes=0-0", got)
+				}
+			}
+			last := requests[len(requests)-1]
+			if got := last.Header.Get("Range"); got != tt.expectRange {
+				t.Errorf("Expected Range header %q, got %q", tt.expectRange, got)
+			}
+			if resp.DidResume != (tt.expectRange != "") {
+				t.Errorf("Expected DidResume to be %v", tt.expectRange != "")
+			}
+
+			f, _ := os.Open("file.bin")
+			defer func() { _ = f.Close() }()
+			b, _ := io.ReadAll(f)
+			if string(b) != content {
+				t.Errorf("Expected file content %q, got %q", content, string(b))
+			}
+		})
+	}
+}
+
+func TestClient_Do_NoHEAD(t *testing.T) {
+	content := "0123456789"
+	setupTestDirectoryWithCleanup(t, "grab-nohead-test")
+	if err := os.WriteFile("file.bin", []byte(content[:4]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	httpClient := &headlessHTTPClient{content: content, headStatus: http.StatusOK}
+	client := &Client{HTTPClient: httpClient, UserAgent: "test-agent", NoHEAD: true}
+
+	// resume an existing file, then download a new file
+	for _, name := range []string{"file.bin", "new.bin"} {
+		req, _ := NewRequest(name, "http://example.com/file.bin")
+		if err := client.Do(req).Err(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if b, _ := os.ReadFile(name); string(b) != content {
+			t.Errorf("Expected file content %q, got %q", content, string(b))
+		}
+	}
+
+	var ranges []string
+	for _, r := range httpClient.getRequests() {
+		if r.Method != "GET" {
+			t.Errorf("Expected only GET requests, got %s", r.Method)
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+	}
+	expect := []string{"bytes=0-0", "bytes=4-", ""}
+	if strings.Join(ranges, ",") != strings.Join(expect, ",") {
+		t.Errorf("Expected Range headers %q, got %q", expect, ranges)
+	}
+}
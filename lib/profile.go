@@ -0,0 +1,216 @@
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// ErrProfileNotFound indicates that a named profile is not defined in the
+// config file.
+var ErrProfileNotFound = errors.New("profile not found")
+
+// A Profile bundles the settings needed to download from a particular
+// service, such as an artifact repository behind a proxy which requires
+// authentication, so that they can be selected by name.
+//
+// Header values, Username, Password and BearerToken may refer to environment
+// variables as $VAR or ${VAR}, which are expanded when the profile is applied,
+// to keep secrets out of the config file.
+type Profile struct {
+	// Hosts are the hosts of the service, as patterns of Client.HostHeaders.
+	// Headers and credentials are only sent to these hosts, so they must be
+	// set if the profile has any.
+	Hosts []string `json:"hosts,omitempty"`
+
+	// Headers are sent with every request to Hosts.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Username and Password are sent using HTTP basic authentication.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// BearerToken is sent in the Authorization header. It takes precedence
+	// over Username and Password.
+	BearerToken string `json:"bearer_token,omitempty"`
+
+	// Proxy is the URL of the proxy to use for all requests. If empty, the
+	// proxy environment variables apply.
+	Proxy string `json:"proxy,omitempty"`
+
+	// CACertFile is the path of a PEM file of certificate authorities which
+	// are trusted in addition to the system roots.
+	CACertFile string `json:"ca_cert_file,omitempty"`
+
+	// ClientCertFile and ClientKeyFile are the paths of a PEM certificate and
+	// key presented to servers which require TLS client authentication.
+	ClientCertFile string `json:"client_cert_file,omitempty"`
+	ClientKeyFile  string `json:"client_key_file,omitempty"`
+
+	// InsecureSkipVerify disables verification of server certificates.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+
+	// RateLimit limits the transfer rate of each download in bytes per
+	// second. Zero means no limit.
+	RateLimit int64 `json:"rate_limit,omitempty"`
+}
+
+// Config is the contents of the grab config file.
+type Config struct {
+	// Profiles maps profile names to their settings.
+	Profiles map[string]*Profile `json:"profiles"`
+}
+
+// DefaultConfigPath returns the path of the config file in the user config
+// directory, such as ~/.config/grab/config.json on Linux.
+func DefaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "grab", "config.json"), nil
+}
+
+// LoadConfig reads the named JSON config file.
+func LoadConfig(name string) (*Config, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", name, err)
+	}
+	return cfg, nil
+}
+
+// Profile returns the named profile, or an error wrapping ErrProfileNotFound.
+func (c *Config) Profile(name string) (*Profile, error) {
+	p, ok := c.Profiles[name]
+	if !ok || p == nil {
+		return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+	return p, nil
+}
+
+// FromProfile applies the named profile of the config file at
+// DefaultConfigPath to the Client, as with ApplyProfile.
+func (c *Client) FromProfile(name string) error {
+	path, err := DefaultConfigPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	p, err := cfg.Profile(name)
+	if err != nil {
+		return err
+	}
+	return c.ApplyProfile(p)
+}
+
+// ApplyProfile configures the Client with the settings of the given profile.
+// Headers and authentication are added to Client.HostHeaders for each of the
+// hosts of the profile, so that they are not sent to other hosts, and the
+// rate limit sets Client.RateLimit. Proxy and TLS settings require an
+// HTTPClient created by NewClient, whose transport is replaced by a modified
+// copy.
+func (c *Client) ApplyProfile(p *Profile) error {
+	header := make(http.Header)
+	for name, value := range p.Headers {
+		header.Set(name, os.ExpandEnv(value))
+	}
+	switch {
+	case p.BearerToken != "":
+		header.Set("Authorization", "Bearer "+os.ExpandEnv(p.BearerToken))
+	case p.Username != "":
+		auth := os.ExpandEnv(p.Username) + ":" + os.ExpandEnv(p.Password)
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+	}
+	if len(header) > 0 && len(p.Hosts) == 0 {
+		return errors.New("profile headers and credentials require hosts")
+	}
+	if p.Proxy != "" || p.CACertFile != "" || p.ClientCertFile != "" || p.InsecureSkipVerify {
+		if err := c.applyProfileTransport(p); err != nil {
+			return err
+		}
+	}
+	if len(header) > 0 {
+		if c.HostHeaders == nil {
+			c.HostHeaders = make(map[string]http.Header)
+		}
+		for _, host := range p.Hosts {
+			h := c.HostHeaders[host]
+			if h == nil {
+				h = make(http.Header)
+				c.HostHeaders[host] = h
+			}
+			for name, values := range header {
+				h[name] = values
+			}
+		}
+	}
+	if p.RateLimit > 0 {
+		c.RateLimit = p.RateLimit
+	}
+	return nil
+}
+
+// applyProfileTransport replaces the transport of the Client with a copy
+// using the proxy and TLS settings of the given profile.
+func (c *Client) applyProfileTransport(p *Profile) error {
+	hc, ok := c.HTTPClient.(*http.Client)
+	if !ok {
+		return errors.New("profile proxy and TLS settings require a Client created with NewClient")
+	}
+	t, ok := hc.Transport.(*http.Transport)
+	if !ok {
+		return errors.New("profile proxy and TLS settings require a Client created with NewClient")
+	}
+	t = t.Clone()
+	if p.Proxy != "" {
+		u, err := url.Parse(p.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy %s: %v", p.Proxy, err)
+		}
+		WithProxyRules(ExplicitProxy(u, "set by profile"))(t)
+	}
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.InsecureSkipVerify = p.InsecureSkipVerify
+	if p.CACertFile != "" {
+		pem, err := os.ReadFile(p.CACertFile)
+		if err != nil {
+			return err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", p.CACertFile)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	if p.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.ClientCertFile, p.ClientKeyFile)
+		if err != nil {
+			return err
+		}
+		t.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	clone := *hc
+	clone.Transport = t
+	c.HTTPClient = &clone
+	return nil
+}
@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig_Profile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.json")
+	config := `{"profiles": {"artifactory": {"hosts": ["artifactory.example.com"], "headers": {"X-JFrog-Art-Api": "$GRAB_TEST_KEY"}, "proxy": "http://proxy.example.com:3128", "rate_limit": 1048576}}}`
+	if err := os.WriteFile(name, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(name)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := cfg.Profile("missing"); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("Expected ErrProfileNotFound, got %v", err)
+	}
+	p, err := cfg.Profile("artifactory")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	t.Setenv("GRAB_TEST_KEY", "secret")
+	client := NewClient()
+	if err := client.ApplyProfile(p); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := client.HostHeaders["artifactory.example.com"].Get("X-JFrog-Art-Api"); got != "secret" {
+		t.Errorf("Expected expanded header value, got %q", got)
+	}
+	if client.RateLimit != 1048576 {
+		t.Errorf("Expected rate limit 1048576, got %d", client.RateLimit)
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/file", nil)
+	proxy, err := client.HTTPClient.(*http.Client).Transport.(*http.Transport).Proxy(req)
+	if err != nil || proxy == nil || proxy.Host != "proxy.example.com:3128" {
+		t.Errorf("Expected profile proxy, got %v (%v)", proxy, err)
+	}
+	if DefaultClient.HTTPClient.(*http.Client).Transport == client.HTTPClient.(*http.Client).Transport {
+		t.Error("Expected transport to be copied")
+	}
+}
+
+func TestClient_ApplyProfile_Auth(t *testing.T) {
+	mockClient := newMockHTTPClient()
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+	if err := client.ApplyProfile(&Profile{Username: "user", Password: "pass"}); err == nil {
+		t.Error("Expected error applying credentials without hosts")
+	}
+	if err := client.ApplyProfile(&Profile{Hosts: []string{"example.com"}, Username: "user", Password: "pass"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	req, _ := NewRequest("", "http://example.com/file.txt")
+	req.NoStore = true
+	req.HTTPRequest.Header.Set("X-Request", "1")
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, r := range mockClient.getRequests() {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			t.Errorf("Expected basic auth on %s %s", r.Method, r.URL)
+		}
+		if r.Header.Get("X-Request") != "1" {
+			t.Errorf("Expected request header on %s %s", r.Method, r.URL)
+		}
+	}
+	if req.HTTPRequest.Header.Get("Authorization") != "" {
+		t.Error("Expected request headers to be left unchanged")
+	}
+
+	// credentials are not sent to other hosts
+	n := len(mockClient.getRequests())
+	req, _ = NewRequest("", "http://mirror.example.net/file.txt")
+	req.NoStore = true
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, r := range mockClient.getRequests()[n:] {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("Expected no credentials on %s %s", r.Method, r.URL)
+		}
+	}
+
+	if err := client.ApplyProfile(&Profile{InsecureSkipVerify: true}); err == nil {
+		t.Error("Expected error applying TLS settings to a custom HTTPClient")
+	}
+}
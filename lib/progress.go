@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"time"
+)
+
+// progressInterval is the interval at which ProgressSink.Update is called.
+var progressInterval = 100 * time.Millisecond
+
+// A ProgressSink receives the progress of a download, so that it can be shown
+// by a progress bar without polling the Response.
+//
+// Start is called once the transfer of the response body begins, Update is
+// called periodically while it is in progress, and Finish is called exactly
+// once when the download ends, with the final progress and the error of the
+// download, if any. Downloads which fail before the transfer begins, or which
+// are already complete, do not call the sink at all.
+//
+// Update is called from its own goroutine, but never concurrently with Start
+// or Finish. Finish is called before Response.Done is closed, so sinks should
+// return quickly.
+type ProgressSink interface {
+	Start(resp *Response, s Snapshot)
+	Update(resp *Response, s Snapshot)
+	Finish(resp *Response, s Snapshot, err error)
+}
+
+// ProgressFuncs implements ProgressSink with optional functions, for wiring
+// progress to libraries which have no adapter. Nil functions are not called.
+type ProgressFuncs struct {
+	OnStart  func(resp *Response, s Snapshot)
+	OnUpdate func(resp *Response, s Snapshot)
+	OnFinish func(resp *Response, s Snapshot, err error)
+}
+
+func (f ProgressFuncs) Start(resp *Response, s Snapshot) {
+	if f.OnStart != nil {
+		f.OnStart(resp, s)
+	}
+}
+
+func (f ProgressFuncs) Update(resp *Response, s Snapshot) {
+	if f.OnUpdate != nil {
+		f.OnUpdate(resp, s)
+	}
+}
+
+func (f ProgressFuncs) Finish(resp *Response, s Snapshot, err error) {
+	if f.OnFinish != nil {
+		f.OnFinish(resp, s, err)
+	}
+}
+
+// A Progressbar is the subset of the methods of *progressbar.ProgressBar of
+// github.com/schollz/progressbar/v3 which is used by ProgressbarSink.
+type Progressbar interface {
+	ChangeMax64(max int64)
+	Set64(n int64) error
+	Finish() error
+	Exit() error
+}
+
+// ProgressbarSink returns a ProgressSink which reports the progress of a
+// single download to a github.com/schollz/progressbar/v3 bar. A bar of unknown
+// size is shown as a spinner. The bar is finished if the download succeeds,
+// and left at its last position if it fails.
+func ProgressbarSink(bar Progressbar) ProgressSink {
+	return &progressbarSink{bar: bar}
+}
+
+type progressbarSink struct {
+	bar Progressbar
+}
+
+func (p *progressbarSink) Start(resp *Response, s Snapshot) {
+	p.bar.ChangeMax64(s.Size)
+	_ = p.bar.Set64(s.BytesComplete)
+}
+
+func (p *progressbarSink) Update(resp *Response, s Snapshot) {
+	if s.SizeEstimated && s.BytesComplete > s.Size {
+		p.bar.ChangeMax64(-1)
+	}
+	_ = p.bar.Set64(s.BytesComplete)
+}
+
+func (p *progressbarSink) Finish(resp *Response, s Snapshot, err error) {
+	_ = p.bar.Set64(s.BytesComplete)
+	if err != nil {
+		_ = p.bar.Exit()
+		return
+	}
+	_ = p.bar.Finish()
+}
+
+// An MPBBar is the subset of the methods of *mpb.Bar of
+// github.com/vbauerster/mpb/v8 which is used by MPBSink.
+type MPBBar interface {
+	SetTotal(total int64, complete bool)
+	SetCurrent(current int64)
+	Abort(drop bool)
+}
+
+// MPBSink returns a ProgressSink which reports the progress of a single
+// download to a github.com/vbauerster/mpb/v8 bar. The bar is completed if the
+// download succeeds, and aborted without being removed if it fails.
+func MPBSink(bar MPBBar) ProgressSink {
+	return mpbSink{bar: bar}
+}
+
+type mpbSink struct {
+	bar MPBBar
+}
+
+func (p mpbSink) Start(resp *Response, s Snapshot) {
+	if s.Size >= 0 {
+		p.bar.SetTotal(s.Size, false)
+	}
+	p.bar.SetCurrent(s.BytesComplete)
+}
+
+func (p mpbSink) Update(resp *Response, s Snapshot) {
+	p.bar.SetCurrent(s.BytesComplete)
+}
+
+func (p mpbSink) Finish(resp *Response, s Snapshot, err error) {
+	p.bar.SetCurrent(s.BytesComplete)
+	if err != nil {
+		p.bar.Abort(false)
+		return
+	}
+	// a negative total completes the bar at its current position
+	p.bar.SetTotal(-1, true)
+}
+
+// startProgress calls the Start method of the ProgressSink of the given
+// Response, if any, and reports the progress of the transfer to it until
+// finishProgress is called.
+func startProgress(resp *Response) {
+	sink := resp.Request.Progress
+	if sink == nil || resp.progressDone != nil {
+		return
+	}
+	sink.Start(resp, resp.Snapshot())
+	resp.progressDone = make(chan struct{})
+	resp.progressStopped = make(chan struct{})
+	go func() {
+		defer close(resp.progressStopped)
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-resp.progressDone:
+				return
+			case <-t.C:
+				sink.Update(resp, resp.Snapshot())
+			}
+		}
+	}()
+}
+
+// finishProgress stops the progress updates started by startProgress and
+// calls the Finish method of the ProgressSink.
+func finishProgress(resp *Response) {
+	if resp.progressDone == nil {
+		return
+	}
+	close(resp.progressDone)
+	<-resp.progressStopped
+	resp.Request.Progress.Finish(resp, resp.Snapshot(), resp.err)
+}
@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// progressServer serves a file of size bytes in ten chunks, pausing between
+// chunks so that progress updates are observed.
+func progressServer(t *testing.T, size int) *httptest.Server {
+	chunk := make([]byte, size/10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(chunk)*10))
+		for i := 0; i < 10; i++ {
+			_, _ = w.Write(chunk)
+			w.(http.Flusher).Flush()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRequest_Progress(t *testing.T) {
+	interval := progressInterval
+	progressInterval = time.Millisecond
+	defer func() { progressInterval = interval }()
+	server := progressServer(t, 10000)
+
+	var mu sync.Mutex
+	var calls []string
+	var updates int
+	var last Snapshot
+	sink := ProgressFuncs{
+		OnStart: func(resp *Response, s Snapshot) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, "start")
+			if s.Size != 10000 {
+				t.Errorf("Expected size 10000 at start, got %d", s.Size)
+			}
+		},
+		OnUpdate: func(resp *Response, s Snapshot) {
+			mu.Lock()
+			defer mu.Unlock()
+			if updates == 0 {
+				calls = append(calls, "update")
+			}
+			updates++
+		},
+		OnFinish: func(resp *Response, s Snapshot, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, "finish")
+			last = s
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		},
+	}
+
+	req, _ := NewRequest(t.TempDir(), server.URL+"/file.bin")
+	req.Progress = sink
+	resp := NewClient().Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 3 || calls[0] != "start" || calls[1] != "update" || calls[2] != "finish" {
+		t.Errorf("Expected start, update and finish, got %v", calls)
+	}
+	if last.BytesComplete != 10000 || last.Progress != 1 {
+		t.Errorf("Expected complete snapshot at finish, got %+v", last)
+	}
+}
+
+type fakeProgressbar struct {
+	max      int64
+	n        int64
+	finished bool
+	exited   bool
+}
+
+func (b *fakeProgressbar) ChangeMax64(max int64) { b.max = max }
+func (b *fakeProgressbar) Set64(n int64) error   { b.n = n; return nil }
+func (b *fakeProgressbar) Finish() error         { b.finished = true; return nil }
+func (b *fakeProgressbar) Exit() error           { b.exited = true; return nil }
+
+type fakeMPBBar struct {
+	total    int64
+	current  int64
+	complete bool
+	aborted  bool
+}
+
+func (b *fakeMPBBar) SetTotal(total int64, complete bool) {
+	if total < 0 {
+		total = b.current
+	}
+	b.total, b.complete = total, complete
+}
+func (b *fakeMPBBar) SetCurrent(current int64) { b.current = current }
+func (b *fakeMPBBar) Abort(drop bool)          { b.aborted = true }
+
+func TestProgressSinkAdapters(t *testing.T) {
+	server := progressServer(t, 1000)
+
+	bar := &fakeProgressbar{}
+	req, _ := NewRequest(t.TempDir(), server.URL+"/file.bin")
+	req.Progress = ProgressbarSink(bar)
+	if err := NewClient().Do(req).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if bar.max != 1000 || bar.n != 1000 || !bar.finished || bar.exited {
+		t.Errorf("Unexpected progressbar state: %+v", bar)
+	}
+
+	mpbBar := &fakeMPBBar{}
+	req, _ = NewRequest(t.TempDir(), server.URL+"/file.bin")
+	req.Progress = MPBSink(mpbBar)
+	if err := NewClient().Do(req).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if mpbBar.total != 1000 || mpbBar.current != 1000 || !mpbBar.complete || mpbBar.aborted {
+		t.Errorf("Unexpected mpb bar state: %+v", mpbBar)
+	}
+
+	// failed downloads leave the bars unfinished
+	failing := errors.New("hook failed")
+	bar = &fakeProgressbar{}
+	mpbBar = &fakeMPBBar{}
+	for _, sink := range []ProgressSink{ProgressbarSink(bar), MPBSink(mpbBar)} {
+		req, _ = NewRequest(t.TempDir(), server.URL+"/file.bin")
+		req.Progress = sink
+		req.AfterCopy = func(*Response) error { return failing }
+		if err := NewClient().Do(req).Err(); !errors.Is(err, failing) {
+			t.Fatalf("Expected hook error, got %v", err)
+		}
+	}
+	if bar.finished || !bar.exited {
+		t.Errorf("Expected progressbar to be exited, got %+v", bar)
+	}
+	if mpbBar.complete || !mpbBar.aborted {
+		t.Errorf("Expected mpb bar to be aborted, got %+v", mpbBar)
+	}
+}
@@ -0,0 +1,357 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// A ProxySource identifies how the proxy for a request was selected.
+type ProxySource int
+
+const (
+	// ProxySourceNone means that no proxy was configured for the request, so
+	// it connects directly.
+	ProxySourceNone ProxySource = iota
+
+	// ProxySourceEnvironment means that the proxy was selected by the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	ProxySourceEnvironment
+
+	// ProxySourceExplicit means that the proxy was set explicitly, such as
+	// with WithProxyURL or the proxy of a Profile.
+	ProxySourceExplicit
+
+	// ProxySourcePAC means that the proxy was selected by a proxy
+	// auto-config script.
+	ProxySourcePAC
+
+	// ProxySourceCustom means that the proxy was selected by a custom proxy
+	// function, for which no further explanation is available.
+	ProxySourceCustom
+)
+
+func (s ProxySource) String() string {
+	switch s {
+	case ProxySourceNone:
+		return "none"
+	case ProxySourceEnvironment:
+		return "environment"
+	case ProxySourceExplicit:
+		return "explicit"
+	case ProxySourcePAC:
+		return "pac"
+	case ProxySourceCustom:
+		return "custom"
+	}
+	return fmt.Sprintf("ProxySource(%d)", int(s))
+}
+
+// A ProxyDecision describes the proxy selected for a request and why it was
+// selected.
+type ProxyDecision struct {
+	// URL is the selected proxy, or nil if the request connects directly.
+	URL *url.URL
+
+	// Source identifies how the proxy was selected.
+	Source ProxySource
+
+	// Reason is a human readable explanation of the decision, such as the
+	// name of the environment variable the proxy was read from.
+	Reason string
+}
+
+func (d ProxyDecision) String() string {
+	target := "direct"
+	if d.URL != nil {
+		target = d.URL.Redacted()
+	}
+	if d.Reason == "" {
+		return fmt.Sprintf("%s (%v)", target, d.Source)
+	}
+	return fmt.Sprintf("%s (%v: %s)", target, d.Source, d.Reason)
+}
+
+// A ProxyRule selects the proxy for a request. It returns nil if it does not
+// apply to the request, deferring the decision to the next rule.
+//
+// Rules are combined with WithProxyRules, in order of precedence.
+type ProxyRule func(req *http.Request) (*ProxyDecision, error)
+
+// WithProxyRules selects the proxy of each request with the first of the given
+// rules which applies to it. If no rule applies, requests connect directly.
+//
+// For example, to bypass the proxy for internal hosts, then prefer an explicit
+// proxy over the environment variables:
+//
+//	lib.NewClient(lib.WithProxyRules(
+//		lib.BypassProxy("corp.example.com"),
+//		lib.ExplicitProxy(u, "set by administrator"),
+//		lib.EnvironmentProxy(),
+//	))
+//
+// Unlike a plain function given to WithProxy, the decision and its reason can
+// be inspected with Client.ProxyFor and Response.Proxy.
+func WithProxyRules(rules ...ProxyRule) TransportOption {
+	return WithProxy(proxyFunc(rules))
+}
+
+// proxyDecisionKey is the context key under which a *ProxyDecision is stored
+// to record the decision of a proxy function created by proxyFunc.
+type proxyDecisionKey struct{}
+
+// proxyFunc returns a proxy function for an http.Transport which selects the
+// proxy with the given rules. The decision is recorded in the context of the
+// request, if a recorder is present.
+func proxyFunc(rules []ProxyRule) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		d, err := selectProxy(rules, req)
+		if err != nil {
+			return nil, err
+		}
+		if rec, ok := req.Context().Value(proxyDecisionKey{}).(*ProxyDecision); ok {
+			*rec = d
+		}
+		return d.URL, nil
+	}
+}
+
+// selectProxy applies the given rules to the request in order and returns the
+// first decision.
+func selectProxy(rules []ProxyRule, req *http.Request) (ProxyDecision, error) {
+	for _, rule := range rules {
+		d, err := rule(req)
+		if err != nil {
+			return ProxyDecision{}, err
+		}
+		if d != nil {
+			return *d, nil
+		}
+	}
+	return ProxyDecision{Source: ProxySourceNone, Reason: "no proxy configured"}, nil
+}
+
+// EnvironmentProxy returns a ProxyRule which selects the proxy given by the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, as
+// http.ProxyFromEnvironment does. It does not apply to requests for which the
+// relevant variable is unset. This is the default rule of NewClient.
+func EnvironmentProxy() ProxyRule {
+	return func(req *http.Request) (*ProxyDecision, error) {
+		u, err := http.ProxyFromEnvironment(req)
+		if err != nil {
+			return nil, err
+		}
+		name, value := proxyEnv(req.URL.Scheme)
+		if u != nil {
+			return &ProxyDecision{URL: u, Source: ProxySourceEnvironment, Reason: "from " + name}, nil
+		}
+		if value == "" {
+			return nil, nil
+		}
+		return &ProxyDecision{
+			Source: ProxySourceEnvironment,
+			Reason: fmt.Sprintf("%s is set, but the host is excluded by NO_PROXY or is a loopback address", name),
+		}, nil
+	}
+}
+
+// proxyEnv returns the name and value of the environment variable which
+// specifies the proxy for the given URL scheme, preferring the upper case
+// name.
+func proxyEnv(scheme string) (string, string) {
+	name := "HTTP_PROXY"
+	if scheme == "https" {
+		name = "HTTPS_PROXY"
+	}
+	for _, n := range []string{name, strings.ToLower(name)} {
+		if v := os.Getenv(n); v != "" {
+			return n, v
+		}
+	}
+	return name, ""
+}
+
+// ExplicitProxy returns a ProxyRule which routes all requests through the proxy
+// at the given URL, or connects directly if u is nil. The reason describes
+// where the setting came from.
+func ExplicitProxy(u *url.URL, reason string) ProxyRule {
+	return func(req *http.Request) (*ProxyDecision, error) {
+		return &ProxyDecision{URL: u, Source: ProxySourceExplicit, Reason: reason}, nil
+	}
+}
+
+// BypassProxy returns a ProxyRule which connects directly to the given hosts
+// and their subdomains, ignoring any later rules. A host of "*" matches all
+// requests.
+func BypassProxy(hosts ...string) ProxyRule {
+	return func(req *http.Request) (*ProxyDecision, error) {
+		host := strings.ToLower(req.URL.Hostname())
+		for _, h := range hosts {
+			h = strings.ToLower(strings.TrimPrefix(h, "."))
+			if h == "*" || host == h || strings.HasSuffix(host, "."+h) {
+				return &ProxyDecision{
+					Source: ProxySourceExplicit,
+					Reason: fmt.Sprintf("host matches bypass entry %q", h),
+				}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// PACProxy returns a ProxyRule which selects the proxy returned by find, which
+// typically evaluates the FindProxyForURL function of a proxy auto-config
+// script. The result is in PAC format, such as "PROXY proxy:3128; DIRECT", of
+// which the first entry is used.
+func PACProxy(find func(u *url.URL) (string, error)) ProxyRule {
+	return func(req *http.Request) (*ProxyDecision, error) {
+		result, err := find(req.URL)
+		if err != nil {
+			return nil, fmt.Errorf("proxy auto-config: %w", err)
+		}
+		u, err := parsePACResult(result)
+		if err != nil {
+			return nil, err
+		}
+		return &ProxyDecision{
+			URL:    u,
+			Source: ProxySourcePAC,
+			Reason: fmt.Sprintf("FindProxyForURL returned %q", result),
+		}, nil
+	}
+}
+
+// parsePACResult returns the proxy of the first entry of the result of a
+// FindProxyForURL function, or nil if it is DIRECT.
+func parsePACResult(result string) (*url.URL, error) {
+	entry, _, _ := strings.Cut(result, ";")
+	fields := strings.Fields(entry)
+	if len(fields) == 1 && strings.EqualFold(fields[0], "DIRECT") {
+		return nil, nil
+	}
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("proxy auto-config: invalid result %q", result)
+	}
+	var scheme string
+	switch strings.ToUpper(fields[0]) {
+	case "PROXY", "HTTP":
+		scheme = "http"
+	case "HTTPS":
+		scheme = "https"
+	case "SOCKS", "SOCKS5":
+		scheme = "socks5"
+	default:
+		return nil, fmt.Errorf("proxy auto-config: unsupported proxy type %q", fields[0])
+	}
+	return &url.URL{Scheme: scheme, Host: fields[1]}, nil
+}
+
+// ProxyFor returns the proxy which the Client selects for the given request,
+// and why.
+//
+// The decision can only be explained for a Client whose HTTPClient is an
+// *http.Client using an *http.Transport. Proxies chosen by a function given to
+// WithProxy are reported with ProxySourceCustom.
+func (c *Client) ProxyFor(req *http.Request) (ProxyDecision, error) {
+	hc, ok := c.HTTPClient.(*http.Client)
+	if !ok {
+		return ProxyDecision{Source: ProxySourceCustom, Reason: "HTTPClient is not an *http.Client"}, nil
+	}
+	rt := hc.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return ProxyDecision{Source: ProxySourceCustom, Reason: "transport is not an *http.Transport"}, nil
+	}
+	return explainProxy(t.Proxy, req)
+}
+
+// explainProxy returns the proxy which the given proxy function of an
+// http.Transport selects for the request, and why.
+func explainProxy(proxy func(*http.Request) (*url.URL, error), req *http.Request) (ProxyDecision, error) {
+	if proxy == nil {
+		return ProxyDecision{Source: ProxySourceNone, Reason: "transport has no proxy function"}, nil
+	}
+	if reflect.ValueOf(proxy).Pointer() == reflect.ValueOf(http.ProxyFromEnvironment).Pointer() {
+		return selectProxy([]ProxyRule{EnvironmentProxy()}, req)
+	}
+
+	rec := &ProxyDecision{Source: -1}
+	u, err := proxy(req.WithContext(context.WithValue(req.Context(), proxyDecisionKey{}, rec)))
+	if err != nil {
+		return ProxyDecision{}, err
+	}
+	if rec.Source == -1 {
+		return ProxyDecision{URL: u, Source: ProxySourceCustom, Reason: "selected by a custom proxy function"}, nil
+	}
+	return *rec, nil
+}
+
+// OverrideProxy replaces the transport of the Client with a copy which applies
+// the given rules before its current proxy settings, such as those of the
+// environment or a Profile. Requests to which none of the rules apply use the
+// current settings.
+//
+// The Client must have been created with NewClient.
+func (c *Client) OverrideProxy(rules ...ProxyRule) error {
+	hc, ok := c.HTTPClient.(*http.Client)
+	if !ok {
+		return errors.New("proxy overrides require a Client created with NewClient")
+	}
+	t, ok := hc.Transport.(*http.Transport)
+	if !ok {
+		return errors.New("proxy overrides require a Client created with NewClient")
+	}
+	t = t.Clone()
+	prev := t.Proxy
+	rules = append(rules[:len(rules):len(rules)], func(req *http.Request) (*ProxyDecision, error) {
+		d, err := explainProxy(prev, req)
+		if err != nil {
+			return nil, err
+		}
+		return &d, nil
+	})
+	WithProxyRules(rules...)(t)
+	clone := *hc
+	clone.Transport = t
+	c.HTTPClient = &clone
+	return nil
+}
+
+// Proxy returns the proxy which was selected for the download, and why. It is
+// only valid once a request has been sent to the remote server.
+func (c *Response) Proxy() ProxyDecision {
+	return c.proxy
+}
+
+// recordProxy records the proxy which the Client selects for the given
+// request in the Response, logging it if it changed.
+func (c *Client) recordProxy(resp *Response, req *http.Request) {
+	d, err := c.ProxyFor(req)
+	if err != nil {
+		// the transport reports the same error when the request is sent
+		return
+	}
+	if !resp.proxyKnown || d.String() != resp.proxy.String() {
+		resp.logf("proxy: %v", d)
+	}
+	resp.proxy = d
+	resp.proxyKnown = true
+}
+
+// proxyFor returns the proxy which the Client's transport selects for the
+// given request, if it is known.
+func (c *Client) proxyFor(req *http.Request) *url.URL {
+	d, err := c.ProxyFor(req)
+	if err != nil {
+		return nil
+	}
+	return d.URL
+}
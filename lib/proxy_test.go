@@ -0,0 +1,194 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWithProxyRules_Precedence(t *testing.T) {
+	proxyURL, _ := url.Parse("http://proxy.example.com:3128")
+	client := NewClient(WithProxyRules(
+		BypassProxy(".corp.example.com"),
+		ExplicitProxy(proxyURL, "set by test"),
+	))
+
+	tests := []struct {
+		url    string
+		proxy  string
+		source ProxySource
+		reason string
+	}{
+		{"http://example.com/file", proxyURL.String(), ProxySourceExplicit, "set by test"},
+		{"https://corp.example.com/file", "", ProxySourceExplicit, "bypass"},
+		{"https://files.corp.example.com/file", "", ProxySourceExplicit, "bypass"},
+		{"https://notcorp.example.com/file", proxyURL.String(), ProxySourceExplicit, "set by test"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.url, nil)
+		d, err := client.ProxyFor(req)
+		if err != nil {
+			t.Fatalf("%s: %v", test.url, err)
+		}
+		got := ""
+		if d.URL != nil {
+			got = d.URL.String()
+		}
+		if got != test.proxy || d.Source != test.source || !strings.Contains(d.Reason, test.reason) {
+			t.Errorf("%s: expected %q (%v: %s), got %v", test.url, test.proxy, test.source, test.reason, d)
+		}
+	}
+}
+
+func TestWithProxyRules_NoMatch(t *testing.T) {
+	client := NewClient(WithProxyRules(BypassProxy("internal")))
+	req, _ := http.NewRequest("GET", "http://example.com/file", nil)
+	d, err := client.ProxyFor(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.URL != nil || d.Source != ProxySourceNone {
+		t.Errorf("Expected direct connection, got %v", d)
+	}
+}
+
+func TestPACProxy(t *testing.T) {
+	tests := []struct {
+		result string
+		proxy  string
+		err    bool
+	}{
+		{"PROXY proxy.example.com:3128; DIRECT", "http://proxy.example.com:3128", false},
+		{"HTTPS secure.example.com:443", "https://secure.example.com:443", false},
+		{"SOCKS5 socks.example.com:1080", "socks5://socks.example.com:1080", false},
+		{"DIRECT", "", false},
+		{"GOPHER gopher.example.com:70", "", true},
+		{"", "", true},
+	}
+	for _, test := range tests {
+		rule := PACProxy(func(u *url.URL) (string, error) { return test.result, nil })
+		req, _ := http.NewRequest("GET", "http://example.com/file", nil)
+		d, err := rule(req)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected error, got %v", test.result, d)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.result, err)
+			continue
+		}
+		got := ""
+		if d.URL != nil {
+			got = d.URL.String()
+		}
+		if got != test.proxy || d.Source != ProxySourcePAC {
+			t.Errorf("%q: expected %q, got %v", test.result, test.proxy, d)
+		}
+	}
+
+	failed := PACProxy(func(u *url.URL) (string, error) { return "", errors.New("script error") })
+	req, _ := http.NewRequest("GET", "http://example.com/file", nil)
+	if _, err := failed(req); err == nil {
+		t.Error("Expected error from failing PAC script")
+	}
+}
+
+func TestClient_ProxyFor_Custom(t *testing.T) {
+	proxyURL, _ := url.Parse("http://proxy.example.com:3128")
+	req, _ := http.NewRequest("GET", "http://example.com/file", nil)
+
+	client := NewClient(WithProxy(http.ProxyURL(proxyURL)))
+	d, err := client.ProxyFor(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.URL == nil || d.URL.String() != proxyURL.String() || d.Source != ProxySourceCustom {
+		t.Errorf("Expected custom proxy %v, got %v", proxyURL, d)
+	}
+
+	client = NewClient(WithProxyURL(nil))
+	if d, _ = client.ProxyFor(req); d.URL != nil || d.Source != ProxySourceNone {
+		t.Errorf("Expected direct connection, got %v", d)
+	}
+
+	client = &Client{HTTPClient: newMockHTTPClient()}
+	if d, _ = client.ProxyFor(req); d.Source != ProxySourceCustom {
+		t.Errorf("Expected unknown proxy of custom HTTPClient, got %v", d)
+	}
+}
+
+func TestResponse_Proxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("test content"))
+	}))
+	defer server.Close()
+
+	proxyURL, _ := url.Parse("http://proxy.example.com:3128")
+	client := NewClient(WithProxyRules(BypassProxy("127.0.0.1"), ExplicitProxy(proxyURL, "set by test")))
+
+	req, _ := NewRequest("", server.URL+"/file.txt")
+	req.NoStore = true
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	d := resp.Proxy()
+	if d.URL != nil || d.Source != ProxySourceExplicit || !strings.Contains(d.Reason, "127.0.0.1") {
+		t.Errorf("Expected bypassed proxy, got %v", d)
+	}
+	var logged bool
+	for _, e := range resp.Events() {
+		logged = logged || strings.Contains(e.String(), "proxy: direct")
+	}
+	if !logged {
+		t.Errorf("Expected proxy decision to be logged, got %v", resp.Events())
+	}
+}
+
+func TestClient_OverrideProxy(t *testing.T) {
+	profileURL, _ := url.Parse("http://profile.example.com:3128")
+	overrideURL, _ := url.Parse("http://override.example.com:3128")
+	client := NewClient(WithProxyRules(ExplicitProxy(profileURL, "set by profile")))
+	if err := client.OverrideProxy(BypassProxy("internal.example.com"), func(req *http.Request) (*ProxyDecision, error) {
+		if req.URL.Scheme != "https" {
+			return nil, nil
+		}
+		return &ProxyDecision{URL: overrideURL, Source: ProxySourceExplicit, Reason: "https override"}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url    string
+		proxy  string
+		reason string
+	}{
+		{"https://internal.example.com/file", "", "bypass"},
+		{"https://example.com/file", overrideURL.String(), "https override"},
+		{"http://example.com/file", profileURL.String(), "set by profile"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.url, nil)
+		d, err := client.ProxyFor(req)
+		if err != nil {
+			t.Fatalf("%s: %v", test.url, err)
+		}
+		got := ""
+		if d.URL != nil {
+			got = d.URL.String()
+		}
+		if got != test.proxy || !strings.Contains(d.Reason, test.reason) {
+			t.Errorf("%s: expected %q (%s), got %v", test.url, test.proxy, test.reason, d)
+		}
+	}
+
+	client = &Client{HTTPClient: newMockHTTPClient()}
+	if err := client.OverrideProxy(BypassProxy("*")); err == nil {
+		t.Error("Expected error for custom HTTPClient")
+	}
+}
@@ -0,0 +1,393 @@
+// Package proxyhandler provides an http.Handler which serves files from a local
+// read-through cache, fetching cache misses from the remote server with a grab
+// Client.
+//
+// The handler can be used as a drop-in download cache on a local network:
+//
+//	h := proxyhandler.New("/var/cache/grab", nil)
+//	log.Fatal(http.ListenAndServe("127.0.0.1:8080", h))
+//
+// Clients may either use the server as an HTTP proxy, or request a URL
+// explicitly via the url query parameter:
+//
+//	curl http://localhost:8080/?url=https://example.com/example.zip
+//
+// The handler fetches any URL it is asked for, so it should only listen on
+// addresses reachable by trusted clients.
+package proxyhandler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sebrandon1/grab/lib"
+)
+
+// A Handler serves requested URLs from a cache directory, downloading any
+// missing files with Client before serving them.
+//
+// Cached files are stored by the SHA-256 digest of their content, so that the
+// same file requested at several URLs is only stored once. An index maps each
+// URL to the digest of its content and the validators of the remote file, its
+// ETag and Last-Modified time. Cached files are revalidated with a HEAD
+// request before they are served, and downloaded again if the remote file
+// changed or has no validators.
+//
+// Concurrent requests for the same URL are coalesced, so that the remote file
+// is only downloaded once.
+type Handler struct {
+	// Client is the grab Client used to fetch cache misses. If nil,
+	// lib.DefaultClient is used.
+	Client *lib.Client
+
+	// Dir is the directory in which cached files and the index are stored.
+	Dir string
+
+	mu       sync.Mutex
+	inflight map[string]*call
+}
+
+// call is an in-flight fetch of a single URL that other requests for the same
+// URL may wait on.
+type call struct {
+	done  chan struct{}
+	entry *entry
+	err   error
+
+	// header is the header of an upstream response which failed with a
+	// redirect, which is passed through to the client.
+	header http.Header
+}
+
+// entry is the index entry of a cached URL.
+type entry struct {
+	Digest       string `json:"digest"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// New returns a Handler which caches files in the given directory. If client
+// is nil, lib.DefaultClient is used.
+func New(dir string, client *lib.Client) *Handler {
+	return &Handler{
+		Client: client,
+		Dir:    dir,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target, err := targetURL(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e := h.lookup(target)
+	if e != nil && e.validated() || r.Method == http.MethodHead {
+		remote, err := h.verify(target)
+		switch {
+		case err != nil && r.Method == http.MethodHead && isRedirect(err):
+			// the Location of the redirect is only known to a GET request,
+			// whose body is not read as it fails
+		case err != nil:
+			h.error(w, err, nil)
+			return
+		case e != nil && e.current(remote):
+			h.serve(w, r, e)
+			return
+		case r.Method == http.MethodHead:
+			serveHead(w, remote)
+			return
+		}
+	}
+
+	c := h.fetch(target)
+	if c.err != nil {
+		h.error(w, c.err, c.header)
+		return
+	}
+	h.serve(w, r, c.entry)
+}
+
+// client returns the Client of the Handler.
+func (h *Handler) client() *lib.Client {
+	if h.Client == nil {
+		return lib.DefaultClient
+	}
+	return h.Client
+}
+
+// targetURL returns the remote URL requested by r, either as the absolute
+// request URI of a proxy request, or via the url query parameter.
+func targetURL(r *http.Request) (string, error) {
+	u := r.URL
+	if !u.IsAbs() {
+		s := r.URL.Query().Get("url")
+		if s == "" {
+			return "", fmt.Errorf("no url requested")
+		}
+		var err error
+		u, err = url.Parse(s)
+		if err != nil {
+			return "", err
+		}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported url scheme: %q", u.Scheme)
+	}
+	return u.String(), nil
+}
+
+// digestPath returns the location in the cache directory of the file with
+// the given hex encoded digest under the given subdirectory.
+func (h *Handler) digestPath(dir, digest string) string {
+	return filepath.Join(h.Dir, dir, digest[:2], digest)
+}
+
+// indexPath returns the location of the index entry of the given URL, which
+// is named by the SHA-256 digest of the URL.
+func (h *Handler) indexPath(target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return h.digestPath("index", hex.EncodeToString(sum[:]))
+}
+
+// lookup returns the index entry of the given URL, or nil if it is not
+// cached.
+func (h *Handler) lookup(target string) *entry {
+	b, err := os.ReadFile(h.indexPath(target))
+	if err != nil {
+		return nil
+	}
+	var e entry
+	if err := json.Unmarshal(b, &e); err != nil || len(e.Digest) != sha256.Size*2 {
+		return nil
+	}
+	if _, err := os.Stat(h.digestPath("objects", e.Digest)); err != nil {
+		return nil
+	}
+	return &e
+}
+
+// validated reports whether the entry records a validator of the remote
+// file, by which it can be revalidated.
+func (e *entry) validated() bool {
+	return e.ETag != "" || e.LastModified != ""
+}
+
+// current reports whether the remote file described by the result of a HEAD
+// request is the one the entry was cached from. The ETag is compared if one
+// was recorded, otherwise the Last-Modified time.
+func (e *entry) current(remote *lib.VerifyResult) bool {
+	if e.ETag != "" {
+		return remote.ETag == e.ETag
+	}
+	t, err := http.ParseTime(e.LastModified)
+	return err == nil && !remote.RemoteModTime.IsZero() && remote.RemoteModTime.Equal(t)
+}
+
+// verify requests the metadata of the remote file of the given URL with
+// Client.Verify, without fetching its content. The local file named by the
+// request is only compared by Verify, and need not exist.
+func (h *Handler) verify(target string) (*lib.VerifyResult, error) {
+	req, err := lib.NewRequest(h.indexPath(target), target)
+	if err != nil {
+		return nil, err
+	}
+	req.IgnoreRemoteChecksum = true
+	return h.client().Verify(req)
+}
+
+// serve writes the cached file of the given entry to w.
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, e *entry) {
+	f, err := os.Open(h.digestPath("objects", e.Digest))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if e.ETag != "" {
+		w.Header().Set("ETag", e.ETag)
+	}
+	modtime := fi.ModTime()
+	if t, err := http.ParseTime(e.LastModified); err == nil {
+		modtime = t
+	}
+	http.ServeContent(w, r, "", modtime, f)
+}
+
+// serveHead answers a HEAD request with the metadata of the remote file,
+// without fetching its content.
+func serveHead(w http.ResponseWriter, remote *lib.VerifyResult) {
+	if remote.RemoteSize >= 0 {
+		w.Header().Set("Content-Length", fmt.Sprint(remote.RemoteSize))
+	}
+	if remote.ETag != "" {
+		w.Header().Set("ETag", remote.ETag)
+	}
+	if !remote.RemoteModTime.IsZero() {
+		w.Header().Set("Last-Modified", remote.RemoteModTime.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// isRedirect reports whether err is a redirect status of the upstream server.
+func isRedirect(err error) bool {
+	var sce lib.StatusCodeError
+	return errors.As(err, &sce) && sce >= 300 && sce <= 399
+}
+
+// error writes the failure to fetch a URL to w. Redirects of the upstream
+// server are passed through with the Location in header.
+func (h *Handler) error(w http.ResponseWriter, err error, header http.Header) {
+	status := http.StatusBadGateway
+	var sce lib.StatusCodeError
+	if errors.As(err, &sce) {
+		status = int(sce)
+	}
+	if isRedirect(err) {
+		if loc := header.Get("Location"); loc != "" {
+			w.Header().Set("Location", loc)
+		}
+		w.WriteHeader(status)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// fetch downloads target into the cache. If a fetch of the same URL is
+// already in progress, fetch waits for it to complete instead.
+func (h *Handler) fetch(target string) *call {
+	h.mu.Lock()
+	if c, ok := h.inflight[target]; ok {
+		h.mu.Unlock()
+		<-c.done
+		return c
+	}
+	c := &call{done: make(chan struct{})}
+	if h.inflight == nil {
+		h.inflight = make(map[string]*call)
+	}
+	h.inflight[target] = c
+	h.mu.Unlock()
+
+	h.download(target, c)
+
+	h.mu.Lock()
+	delete(h.inflight, target)
+	h.mu.Unlock()
+	close(c.done)
+	return c
+}
+
+// download fetches target to a temporary file, moves it into place by the
+// digest of its content once complete, so that partial downloads are never
+// served, and records it in the index.
+func (h *Handler) download(target string, c *call) {
+	tmp := h.indexPath(target) + ".tmp"
+	req, err := lib.NewRequest(tmp, target)
+	if err != nil {
+		c.err = err
+		return
+	}
+	req.NoResume = true
+	resp := h.client().Do(req)
+	if c.err = resp.Err(); c.err != nil {
+		if resp.HTTPResponse != nil {
+			c.header = resp.HTTPResponse.Header
+		}
+		_ = os.Remove(tmp)
+		return
+	}
+	defer func() {
+		_ = os.Remove(tmp)
+	}()
+
+	digest, err := fileDigest(tmp)
+	if err != nil {
+		c.err = err
+		return
+	}
+	object := h.digestPath("objects", digest)
+	if err := os.MkdirAll(filepath.Dir(object), 0755); err != nil {
+		c.err = err
+		return
+	}
+	if err := os.Rename(tmp, object); err != nil {
+		c.err = err
+		return
+	}
+	e := &entry{
+		Digest:       digest,
+		ETag:         resp.HTTPResponse.Header.Get("ETag"),
+		LastModified: resp.HTTPResponse.Header.Get("Last-Modified"),
+	}
+	if _, err := http.ParseTime(e.LastModified); err != nil {
+		e.LastModified = ""
+	}
+	if c.err = writeEntry(h.indexPath(target), e); c.err == nil {
+		c.entry = e
+	}
+}
+
+// fileDigest returns the hex encoded SHA-256 digest of the named file.
+func fileDigest(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeEntry replaces the index entry at path by renaming a temporary file
+// over it, so that readers never see a partial entry.
+func writeEntry(path string, e *entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,262 @@
+package proxyhandler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sebrandon1/grab/lib"
+)
+
+// countingHTTPClient implements lib.HTTPClient, serving a fixed body and
+// header and counting the requests it receives.
+type countingHTTPClient struct {
+	body    string
+	header  http.Header
+	status  int
+	release chan struct{}
+	count   int64
+	gets    int64
+}
+
+func (c *countingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&c.count, 1)
+	body := c.body
+	if req.Method == http.MethodGet {
+		atomic.AddInt64(&c.gets, 1)
+	} else {
+		body = ""
+	}
+	if c.release != nil {
+		<-c.release
+	}
+	status := c.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := c.header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(c.body)),
+		Header:        header,
+		Request:       req,
+	}, nil
+}
+
+func newTestHandler(t *testing.T, hc *countingHTTPClient) *Handler {
+	t.Helper()
+	return New(t.TempDir(), &lib.Client{HTTPClient: hc, UserAgent: "test-agent"})
+}
+
+func TestHandler_CacheMissAndHit(t *testing.T) {
+	hc := &countingHTTPClient{body: "cached content", header: http.Header{"Etag": {`"v1"`}}}
+	h := newTestHandler(t, hc)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/?url=http://example.com/file.txt", nil)
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d (%s)", i, rec.Code, rec.Body.String())
+		}
+		if rec.Body.String() != hc.body {
+			t.Errorf("Request %d: expected body %q, got %q", i, hc.body, rec.Body.String())
+		}
+	}
+
+	// the cached file is revalidated by a HEAD request
+	if n := atomic.LoadInt64(&hc.gets); n != 1 {
+		t.Errorf("Expected 1 upstream GET request, got %d", n)
+	}
+	if n := atomic.LoadInt64(&hc.count); n != 2 {
+		t.Errorf("Expected 2 upstream requests, got %d", n)
+	}
+}
+
+func TestHandler_Revalidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  http.Header
+		changed http.Header
+		gets    int64
+	}{
+		{"etag changed", http.Header{"Etag": {`"v1"`}}, http.Header{"Etag": {`"v2"`}}, 2},
+		{"etag unchanged", http.Header{"Etag": {`"v1"`}}, http.Header{"Etag": {`"v1"`}}, 1},
+		{"last modified changed", http.Header{"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, http.Header{"Last-Modified": {"Tue, 03 Jan 2006 15:04:05 GMT"}}, 2},
+		{"last modified unchanged", http.Header{"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, http.Header{"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, 1},
+		{"no validators", nil, nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc := &countingHTTPClient{body: "old content", header: tt.header}
+			h := newTestHandler(t, hc)
+			get := func() string {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest("GET", "/?url=http://example.com/file.txt", nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("Expected status 200, got %d (%s)", rec.Code, rec.Body.String())
+				}
+				return rec.Body.String()
+			}
+			get()
+			hc.body, hc.header = "new content", tt.changed
+			want := "new content"
+			if tt.gets == 1 {
+				want = "old content"
+			}
+			if got := get(); got != want {
+				t.Errorf("Expected body %q, got %q", want, got)
+			}
+			if n := atomic.LoadInt64(&hc.gets); n != tt.gets {
+				t.Errorf("Expected %d upstream GET requests, got %d", tt.gets, n)
+			}
+		})
+	}
+}
+
+func TestHandler_ContentAddressed(t *testing.T) {
+	hc := &countingHTTPClient{body: "shared content", header: http.Header{"Etag": {`"v1"`}}}
+	h := newTestHandler(t, hc)
+	for _, target := range []string{"/?url=http://example.com/a", "/?url=http://mirror.example.com/a"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != hc.body {
+			t.Fatalf("Expected cached content, got %d (%s)", rec.Code, rec.Body.String())
+		}
+	}
+	objects, _ := filepath.Glob(filepath.Join(h.Dir, "objects", "*", "*"))
+	if len(objects) != 1 {
+		t.Errorf("Expected identical content to be stored once, got %d files", len(objects))
+	}
+}
+
+func TestHandler_Head(t *testing.T) {
+	hc := &countingHTTPClient{body: "remote content", header: http.Header{"Etag": {`"v1"`}}}
+	h := newTestHandler(t, hc)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("HEAD", "/?url=http://example.com/file.txt", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Length"); got != "14" {
+		t.Errorf("Expected Content-Length 14, got %q", got)
+	}
+	if got := rec.Header().Get("ETag"); got != `"v1"` {
+		t.Errorf("Expected ETag of remote file, got %q", got)
+	}
+	if n := atomic.LoadInt64(&hc.gets); n != 0 {
+		t.Errorf("Expected HEAD request not to fetch the file, got %d GET requests", n)
+	}
+}
+
+func TestHandler_Redirect(t *testing.T) {
+	hc := &countingHTTPClient{status: http.StatusFound, header: http.Header{"Location": {"http://example.com/moved"}}}
+	h := newTestHandler(t, hc)
+	for _, method := range []string{"GET", "HEAD"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/?url=http://example.com/file.txt", nil))
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "http://example.com/moved" {
+			t.Errorf("%s: expected redirect to be passed through, got %d to %q", method, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestHandler_ProxyRequest(t *testing.T) {
+	hc := &countingHTTPClient{body: "proxied content"}
+	h := newTestHandler(t, hc)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://example.com/file.txt", nil)
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	if rec.Body.String() != hc.body {
+		t.Errorf("Expected body %q, got %q", hc.body, rec.Body.String())
+	}
+}
+
+func TestHandler_CoalescesConcurrentRequests(t *testing.T) {
+	hc := &countingHTTPClient{body: "coalesced", release: make(chan struct{})}
+	h := newTestHandler(t, hc)
+
+	const n = 5
+	var wg sync.WaitGroup
+	codes := make([]int, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/?url=http://example.com/big.iso", nil)
+			h.ServeHTTP(rec, req)
+			codes[i] = rec.Code
+		}(i)
+	}
+
+	// wait until the first fetch has reached the upstream server
+	for atomic.LoadInt64(&hc.count) == 0 {
+		runtime.Gosched()
+	}
+	close(hc.release)
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Request %d: expected status 200, got %d", i, code)
+		}
+	}
+	if n := atomic.LoadInt64(&hc.count); n != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", n)
+	}
+}
+
+func TestHandler_BadRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		status int
+	}{
+		{name: "missing url", method: "GET", target: "/", status: http.StatusBadRequest},
+		{name: "unsupported scheme", method: "GET", target: "/?url=file:///etc/passwd", status: http.StatusBadRequest},
+		{name: "bad method", method: "POST", target: "/?url=http://example.com/a", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, &countingHTTPClient{})
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_UpstreamStatusError(t *testing.T) {
+	hc := &countingHTTPClient{status: http.StatusNotFound}
+	h := newTestHandler(t, hc)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/?url=http://example.com/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// quarantineAgent identifies grab as the quarantining application.
+const quarantineAgent = "grab"
+
+// quarantineValue returns the value of the com.apple.quarantine extended
+// attribute for a file downloaded at the given time. The flags mark the file
+// as downloaded from the internet, so Gatekeeper checks it before it is first
+// opened.
+func quarantineValue(t time.Time) string {
+	return fmt.Sprintf("0081;%08x;%s;", t.Unix(), quarantineAgent)
+}
+
+// zoneIdentifier returns the content of the Zone.Identifier alternate data
+// stream for a file downloaded from the given URL, placing it in the internet
+// zone. Any credentials in the URL are removed.
+func zoneIdentifier(u *url.URL) string {
+	s := "[ZoneTransfer]\r\nZoneId=3\r\n"
+	if u != nil {
+		clean := *u
+		clean.User = nil
+		s += "HostUrl=" + clean.String() + "\r\n"
+	}
+	return s
+}
+
+// applyQuarantine marks a downloaded file as originating from the internet if
+// Request.Quarantine is set. Marking the file is best-effort, as not every
+// file system supports the metadata, so a failure is logged as an event of
+// the Response rather than failing the download.
+func applyQuarantine(resp *Response) {
+	if !resp.Request.Quarantine || resp.Request.NoStore || resp.special {
+		return
+	}
+	if err := quarantine(resp.Filename, resp.EffectiveURL()); err != nil {
+		resp.logf("cannot quarantine %s: %v", resp.Filename, err)
+	}
+}
@@ -0,0 +1,14 @@
+package lib
+
+import (
+	"net/url"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// quarantine sets the com.apple.quarantine extended attribute of the given
+// file.
+func quarantine(filename string, _ *url.URL) error {
+	return unix.Setxattr(filename, "com.apple.quarantine", []byte(quarantineValue(time.Now())), 0)
+}
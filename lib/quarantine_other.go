@@ -0,0 +1,10 @@
+//go:build !darwin && !windows
+
+package lib
+
+import "net/url"
+
+// quarantine is a no-op on platforms without quarantine metadata.
+func quarantine(string, *url.URL) error {
+	return nil
+}
@@ -0,0 +1,12 @@
+package lib
+
+import (
+	"net/url"
+	"os"
+)
+
+// quarantine writes the Zone.Identifier alternate data stream of the given
+// file.
+func quarantine(filename string, u *url.URL) error {
+	return os.WriteFile(filename+":Zone.Identifier", []byte(zoneIdentifier(u)), 0644)
+}
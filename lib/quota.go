@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded indicates that a download was canceled, or never started,
+// because its batch exceeded BatchOptions.HardQuota.
+var ErrQuotaExceeded = errors.New("batch quota exceeded")
+
+// quotaInterval is how often the bytes transferred by a batch with a quota are
+// counted.
+const quotaInterval = 100 * time.Millisecond
+
+// quota tracks the bytes transferred by a batch against its byte budgets.
+type quota struct {
+	soft, hard  int64
+	onSoftQuota func(int64)
+
+	mu        sync.Mutex
+	responses map[*Response]struct{}
+	warned    bool
+	exceeded  bool
+}
+
+// hook returns a BeforeCopy hook which calls the given hook, if any, and
+// registers the transfer with the quota, or vetoes it if the hard quota was
+// exceeded. A Response is registered once, even though BeforeCopy runs again
+// for each retry of its transfer.
+func (q *quota) hook(f Hook) Hook {
+	return func(resp *Response) error {
+		q.mu.Lock()
+		exceeded := q.exceeded
+		if !exceeded {
+			q.responses[resp] = struct{}{}
+		}
+		q.mu.Unlock()
+		if exceeded {
+			return ErrQuotaExceeded
+		}
+		if f != nil {
+			return f(resp)
+		}
+		return nil
+	}
+}
+
+// check counts the bytes transferred so far, calling OnSoftQuota once the
+// soft quota is reached and canceling the batch once the hard quota is
+// reached.
+func (q *quota) check(cancel context.CancelCauseFunc) {
+	q.mu.Lock()
+	var used int64
+	for resp := range q.responses {
+		used += resp.transfer.N()
+	}
+	warn := q.soft > 0 && used >= q.soft && !q.warned
+	if warn {
+		q.warned = true
+	}
+	exceeded := q.hard > 0 && used >= q.hard && !q.exceeded
+	if exceeded {
+		q.exceeded = true
+	}
+	q.mu.Unlock()
+
+	if warn && q.onSoftQuota != nil {
+		go q.onSoftQuota(used)
+	}
+	if exceeded {
+		cancel(ErrQuotaExceeded)
+	}
+}
+
+// doBatchQuota executes the given requests like DoBatchWithOptions, while
+// counting the bytes they transfer against the quotas of the batch.
+func (c *Client) doBatchQuota(ctx context.Context, opts BatchOptions, requests ...*Request) <-chan *Response {
+	q := &quota{
+		soft:        opts.SoftQuota,
+		hard:        opts.HardQuota,
+		onSoftQuota: opts.OnSoftQuota,
+		responses:   make(map[*Response]struct{}),
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	limited := make([]*Request, len(requests))
+	for i, req := range requests {
+		limited[i] = req.WithContext(req.Context())
+		limited[i].BeforeCopy = q.hook(req.BeforeCopy)
+	}
+	opts.SoftQuota, opts.HardQuota = 0, 0
+
+	inner := c.doBatchWithOptions(ctx, opts, limited...)
+	respch := make(chan *Response, len(requests))
+	go func() {
+		defer cancel(nil)
+		t := time.NewTicker(quotaInterval)
+		defer t.Stop()
+		for {
+			select {
+			case resp, ok := <-inner:
+				if !ok {
+					q.check(cancel)
+					close(respch)
+					return
+				}
+				respch <- resp
+			case <-t.C:
+				q.check(cancel)
+			}
+		}
+	}()
+	return respch
+}
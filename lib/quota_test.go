@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_DoBatchWithOptions_Quota(t *testing.T) {
+	mockClient := newMockHTTPClient()
+	var reqs []*Request
+	for i := 0; i < 3; i++ {
+		url := fmt.Sprintf("http://example.com/file%d", i)
+		resp := createSuccessResponse("")
+		// each file of 1000 bytes takes about 200ms
+		resp.Body = io.NopCloser(&mockReader{data: []byte(strings.Repeat("x", 1000)), readDelay: 20 * time.Millisecond})
+		resp.ContentLength = 1000
+		mockClient.addResponse("GET", url, resp)
+		req, _ := NewRequest("", url)
+		req.NoStore = true
+		req.BufferSize = 100
+		reqs = append(reqs, req)
+	}
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+	var warned int64
+	opts := BatchOptions{
+		Workers:     1,
+		SoftQuota:   500,
+		OnSoftQuota: func(bytes int64) { atomic.StoreInt64(&warned, bytes) },
+		HardQuota:   1500,
+	}
+	var errs []error
+	for resp := range client.DoBatchWithOptions(context.Background(), opts, reqs...) {
+		errs = append(errs, resp.Err())
+	}
+	// the third request is either skipped or fails immediately
+	if len(errs) < 2 {
+		t.Fatalf("Expected at least 2 started requests, got %d", len(errs))
+	}
+	if errs[0] != nil {
+		t.Errorf("Expected first file to complete, got %v", errs[0])
+	}
+	for _, err := range errs[1:] {
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt64(&warned); n < 500 {
+		t.Errorf("Expected soft quota callback, got %d bytes", n)
+	}
+}
+
+func TestClient_DoBatchWithOptions_QuotaRetry(t *testing.T) {
+	content := make([]byte, 100000)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && requests.Add(1) == 1 {
+			// drop the connection half way through the transfer
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Header().Set("Accept-Ranges", "bytes")
+			_, _ = w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.RetryPolicy = &RetryPolicy{MaxAttempts: 2, InitialBackoff: 10 * time.Millisecond}
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file.bin"), server.URL)
+	warned := make(chan int64, 1)
+	opts := BatchOptions{
+		// more than either attempt transfers, but less than both
+		SoftQuota:   int64(len(content)) * 3 / 5,
+		OnSoftQuota: func(bytes int64) { warned <- bytes },
+	}
+	for resp := range client.DoBatchWithOptions(context.Background(), opts, req) {
+		if err := resp.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("Expected a retried transfer, got %d requests", n)
+	}
+	select {
+	case n := <-warned:
+		t.Errorf("Expected the retried transfer to be counted once, got soft quota callback at %d bytes", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// rangeHeader returns the value of the Range header for the byte range set via
+// Request.SetRange.
+func (r *Request) rangeHeader() string {
+	if r.rangeLength < 0 {
+		return fmt.Sprintf("bytes=%d-", r.rangeStart)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.rangeStart, r.rangeStart+r.rangeLength-1)
+}
+
+// applyRange validates the response to a ranged request and computes the size
+// of the transfer relative to the requested window.
+//
+// If the server ignored the Range header and responded with the entire file,
+// the response body is wrapped so that only the requested window is read.
+func applyRange(resp *Response) error {
+	req := resp.Request
+	hresp := resp.HTTPResponse
+	if hresp.StatusCode == http.StatusPartialContent {
+		if cr := hresp.Header.Get("Content-Range"); cr != "" {
+			var start int64
+			if _, err := fmt.Sscanf(cr, "bytes %d-", &start); err == nil && start != req.rangeStart {
+				return ErrBadLength
+			}
+		}
+		resp.sizeUnsafe = hresp.ContentLength
+		return nil
+	}
+	if hresp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	// the server sent the entire file
+	var body io.Reader = &skipReader{r: hresp.Body, skip: req.rangeStart}
+	if req.rangeLength >= 0 {
+		body = io.LimitReader(body, req.rangeLength)
+	}
+	hresp.Body = &readCloser{Reader: body, Closer: hresp.Body}
+
+	resp.sizeUnsafe = req.rangeLength
+	if hresp.ContentLength >= 0 {
+		avail := hresp.ContentLength - req.rangeStart
+		if avail < 0 {
+			avail = 0
+		}
+		if req.rangeLength < 0 || avail < req.rangeLength {
+			resp.sizeUnsafe = avail
+		}
+	}
+	return nil
+}
+
+// skipReader discards the first skip bytes of the underlying reader.
+type skipReader struct {
+	r    io.Reader
+	skip int64
+}
+
+func (c *skipReader) Read(p []byte) (int, error) {
+	if c.skip > 0 {
+		n, err := io.CopyN(io.Discard, c.r, c.skip)
+		c.skip -= n
+		if err != nil {
+			return 0, err
+		}
+	}
+	return c.r.Read(p)
+}
+
+// readCloser combines a Reader with the Closer of another stream.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// parseRangeHeader returns the byte range [start, end) of a file of the given
+// size requested by a Range header, and whether it can be satisfied, for the
+// transports which serve other protocols as HTTP responses.
+func parseRangeHeader(h string, size int64) (start, end int64, ok bool) {
+	if h == "" {
+		return 0, size, true
+	}
+	spec, found := strings.CutPrefix(h, "bytes=")
+	first, last, dash := strings.Cut(spec, "-")
+	if !found || !dash || strings.Contains(spec, ",") {
+		return 0, size, true // ignore ranges which are not understood
+	}
+	var err error
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		return max(size-n, 0), size, true
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil || start >= size {
+		return 0, 0, false
+	}
+	end = size
+	if last != "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < start {
+			return 0, 0, false
+		}
+		end = min(n+1, size)
+	}
+	return start, end, true
+}
@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestRequest_SetRange_Header(t *testing.T) {
+	tests := []struct {
+		off, length int64
+		expect      string
+	}{
+		{0, 10, "bytes=0-9"},
+		{100, 1, "bytes=100-100"},
+		{1024, -1, "bytes=1024-"},
+	}
+	for _, tt := range tests {
+		req, _ := NewRequest("", "http://example.com/file.bin")
+		req.SetRange(tt.off, tt.length)
+		if got := req.rangeHeader(); got != tt.expect {
+			t.Errorf("SetRange(%d, %d): expected %q, got %q", tt.off, tt.length, tt.expect, got)
+		}
+	}
+}
+
+func TestRequest_SetRange_Invalid(t *testing.T) {
+	tests := []struct {
+		off, length int64
+	}{
+		{-1, 10},
+		{-1, -1},
+		{0, 0},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("SetRange(%d, %d): expected panic", tt.off, tt.length)
+				}
+			}()
+			req, _ := NewRequest("", "http://example.com/file.bin")
+			req.SetRange(tt.off, tt.length)
+		}()
+	}
+}
+
+func TestClient_Do_Range(t *testing.T) {
+	content := "0123456789"
+
+	tests := []struct {
+		name        string
+		off, length int64
+		response    *http.Response
+		expect      string
+		expectSize  int64
+		expectError error
+	}{
+		{
+			name: "partial content",
+			off:  2, length: 3,
+			response: createMockHTTPResponse("206 Partial Content", http.StatusPartialContent, "234",
+				map[string]string{"Content-Range": "bytes 2-4/10"}),
+			expect:     "234",
+			expectSize: 3,
+		},
+		{
+			name: "server ignores range",
+			off:  2, length: 3,
+			response:   createSuccessResponse(content),
+			expect:     "234",
+			expectSize: 3,
+		},
+		{
+			name: "server ignores open ended range",
+			off:  7, length: -1,
+			response:   createSuccessResponse(content),
+			expect:     "789",
+			expectSize: 3,
+		},
+		{
+			name: "range beyond end of file",
+			off:  8, length: 5,
+			response:   createSuccessResponse(content),
+			expect:     "89",
+			expectSize: 2,
+		},
+		{
+			name: "mismatched content range",
+			off:  2, length: 3,
+			response: createMockHTTPResponse("206 Partial Content", http.StatusPartialContent, "567",
+				map[string]string{"Content-Range": "bytes 5-7/10"}),
+			expectError: ErrBadLength,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testURL := "http://example.com/file.bin"
+			mockClient := newMockHTTPClient()
+			mockClient.addResponse("GET", testURL, tt.response)
+			client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+			req, _ := NewRequest("", testURL)
+			req.NoStore = true
+			req.SetRange(tt.off, tt.length)
+
+			resp := client.Do(req)
+			err := resp.Err()
+			if !errors.Is(err, tt.expectError) {
+				t.Fatalf("Expected error %v, got %v", tt.expectError, err)
+			}
+
+			requests := mockClient.getRequests()
+			if len(requests) != 1 {
+				t.Fatalf("Expected a single GET request, got %d requests", len(requests))
+			}
+			if got := requests[0].Header.Get("Range"); got != req.rangeHeader() {
+				t.Errorf("Expected Range header %q, got %q", req.rangeHeader(), got)
+			}
+			if req.HTTPRequest.Header.Get("Range") != "" {
+				t.Error("Range header should not be set on the caller's HTTPRequest")
+			}
+			if err != nil {
+				return
+			}
+
+			b, _ := resp.Bytes()
+			if string(b) != tt.expect {
+				t.Errorf("Expected content %q, got %q", tt.expect, string(b))
+			}
+			if resp.Size() != tt.expectSize {
+				t.Errorf("Expected size %d, got %d", tt.expectSize, resp.Size())
+			}
+			if resp.Progress() != 1 {
+				t.Errorf("Expected progress 1, got %v", resp.Progress())
+			}
+		})
+	}
+}
+
+func TestParseRangeHeader(t *testing.T) {
+	tests := []struct {
+		header     string
+		start, end int64
+		ok         bool
+	}{
+		{"", 0, 100, true},
+		{"bytes=10-", 10, 100, true},
+		{"bytes=10-19", 10, 20, true},
+		{"bytes=-30", 70, 100, true},
+		{"bytes=90-200", 90, 100, true},
+		{"bytes=100-", 0, 0, false},
+	}
+	for _, test := range tests {
+		start, end, ok := parseRangeHeader(test.header, 100)
+		if start != test.start || end != test.end || ok != test.ok {
+			t.Errorf("%q: expected %d-%d %v, got %d-%d %v", test.header, test.start, test.end, test.ok, start, end, ok)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package lib
 
-import "context"
+import (
+	"context"
+	"sync"
+	"time"
+)
 
 // RateLimiter is an interface that must be satisfied by any third-party rate
 // limiters that may be used to limit download transfer speeds.
@@ -10,3 +14,134 @@ import "context"
 type RateLimiter interface {
 	WaitN(ctx context.Context, n int) (err error)
 }
+
+// bucketLimiter is a token bucket RateLimiter which allows bursts of up to
+// one second of transfer.
+type bucketLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter which limits transfers to the given
+// number of bytes per second, averaged over one second. It panics if the rate
+// is not positive.
+func NewRateLimiter(bytesPerSecond int64) RateLimiter {
+	if bytesPerSecond <= 0 {
+		panic("grab: rate limit must be positive")
+	}
+	r := float64(bytesPerSecond)
+	return &bucketLimiter{rate: r, tokens: r, last: time.Now()}
+}
+
+func (c *bucketLimiter) WaitN(ctx context.Context, n int) error {
+	c.mu.Lock()
+	now := time.Now()
+	c.tokens = min(c.rate, c.tokens+now.Sub(c.last).Seconds()*c.rate)
+	c.last = now
+	c.tokens -= float64(n)
+	wait := time.Duration(-c.tokens / c.rate * float64(time.Second))
+	c.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// fairIdle is the time after its last transfer after which a share of a
+// FairLimiter no longer counts towards the division of the rate.
+const fairIdle = 200 * time.Millisecond
+
+// A FairLimiter limits the total transfer rate of several streams, such as
+// concurrent batches of downloads, and divides the rate between the streams
+// which are transferring in proportion to their weights. A stream which
+// starts later is not starved by the streams which started first, and the
+// share of a stream which stops is divided between the others.
+type FairLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	active map[*fairShare]struct{}
+}
+
+// NewFairLimiter returns a FairLimiter which limits the total transfer rate
+// of its shares to the given number of bytes per second. It panics if the
+// rate is not positive.
+func NewFairLimiter(bytesPerSecond int64) *FairLimiter {
+	if bytesPerSecond <= 0 {
+		panic("grab: rate limit must be positive")
+	}
+	return &FairLimiter{rate: float64(bytesPerSecond), active: make(map[*fairShare]struct{})}
+}
+
+// Share returns a RateLimiter for a stream with the given weight, which may
+// be used by several transfers of the stream concurrently. A weight less than
+// one is treated as one.
+func (f *FairLimiter) Share(weight int) RateLimiter {
+	return &fairShare{f: f, weight: float64(max(weight, 1))}
+}
+
+// fairShare is the RateLimiter of a stream of a FairLimiter, which is a token
+// bucket whose rate is its share of the rate of the FairLimiter.
+type fairShare struct {
+	f      *FairLimiter
+	weight float64
+	tokens float64
+	last   time.Time // time of the last call of WaitN
+	until  time.Time // end of the last wait
+}
+
+func (s *fairShare) WaitN(ctx context.Context, n int) error {
+	f := s.f
+	f.mu.Lock()
+	now := time.Now()
+	f.active[s] = struct{}{}
+	total := 0.0
+	for a := range f.active {
+		if a != s && now.Sub(a.until) > fairIdle {
+			delete(f.active, a)
+			continue
+		}
+		total += a.weight
+	}
+	rate := f.rate * s.weight / total
+	if !s.last.IsZero() {
+		// new shares start without a burst, which would exceed the total rate
+		s.tokens = min(rate, s.tokens+now.Sub(s.last).Seconds()*rate)
+	}
+	s.last = now
+	s.tokens -= float64(n)
+	wait := time.Duration(-s.tokens / rate * float64(time.Second))
+	s.until = now.Add(max(wait, 0))
+	f.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// chainLimiter is a RateLimiter which waits for all of its limiters in turn.
+type chainLimiter []RateLimiter
+
+func (c chainLimiter) WaitN(ctx context.Context, n int) error {
+	for _, lim := range c {
+		if err := lim.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	lim := NewRateLimiter(1000)
+	ctx := context.Background()
+	start := time.Now()
+	// the first second of transfer is allowed as a burst
+	if err := lim.WaitN(ctx, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := lim.WaitN(ctx, 200); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("Expected to wait about 200ms, waited %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := lim.WaitN(ctx, 1000); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestNewRateLimiter_NonPositive(t *testing.T) {
+	for _, rate := range []int64{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected NewRateLimiter(%d) to panic", rate)
+				}
+			}()
+			NewRateLimiter(rate)
+		}()
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected NewFairLimiter(%d) to panic", rate)
+				}
+			}()
+			NewFairLimiter(rate)
+		}()
+	}
+}
+
+func TestFairLimiter(t *testing.T) {
+	f := NewFairLimiter(100000)
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+
+	// two streams transfer concurrently with weights 1 and 3
+	counts := make(chan int64, 2)
+	for _, weight := range []int{1, 3} {
+		go func() {
+			lim := f.Share(weight)
+			var n int64
+			for lim.WaitN(ctx, 1000) == nil {
+				n += 1000
+			}
+			counts <- n
+		}()
+	}
+	a, b := <-counts, <-counts
+	low, high := min(a, b), max(a, b)
+	if total := low + high; total < 120000 || total > 180000 {
+		t.Errorf("Expected about 150000 bytes in total, got %d", total)
+	}
+	if ratio := float64(high) / float64(low); ratio < 2.2 || ratio > 3.8 {
+		t.Errorf("Expected a ratio of about 3, got %d:%d", low, high)
+	}
+}
+
+func TestClient_DoBatch_BatchRateLimit(t *testing.T) {
+	content := strings.Repeat("x", 50000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+	client := NewClient()
+	client.BatchRateLimit = 100000
+	client.BufferSize = 4096
+
+	// a batch started later with a higher priority finishes first
+	done := make(chan int, 2)
+	for i, priority := range []int{1, 4} {
+		req, _ := NewRequest(t.TempDir(), server.URL+"/file.bin")
+		go func() {
+			for resp := range client.DoBatchWithOptions(context.Background(), BatchOptions{Priority: priority}, req) {
+				if err := resp.Err(); err != nil {
+					t.Error(err)
+				}
+			}
+			done <- priority
+		}()
+		if i == 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	start := time.Now()
+	if first := <-done; first != 4 {
+		t.Errorf("Expected the batch with priority 4 to finish first")
+	}
+	<-done
+	if d := time.Since(start); d < 500*time.Millisecond {
+		t.Errorf("Expected the batches to share the rate limit, finished in %v", d)
+	}
+}
+
+func TestClient_DoBatch_RateLimiter(t *testing.T) {
+	content := strings.Repeat("x", 50000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+	client := NewClient()
+	client.RateLimiter = NewRateLimiter(100000)
+	client.BufferSize = 4096
+
+	// 200KB over four workers, less a burst of 100KB, takes about a second
+	dir := t.TempDir()
+	reqs := make([]*Request, 4)
+	for i := range reqs {
+		reqs[i], _ = NewRequest(dir, server.URL+"/file"+strconv.Itoa(i))
+	}
+	start := time.Now()
+	for resp := range client.DoBatch(context.Background(), 4, reqs...) {
+		if err := resp.Err(); err != nil {
+			t.Error(err)
+		}
+	}
+	if d := time.Since(start); d < 800*time.Millisecond {
+		t.Errorf("Expected the workers to share the rate limit, finished in %v", d)
+	}
+}
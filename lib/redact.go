@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// RedactURL returns the given URL in a form which is safe to log or store:
+// any password is replaced as by url.URL.Redacted and the query and fragment,
+// which often carry tokens such as presigned signatures, are dropped.
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	r := *u
+	r.RawQuery = ""
+	r.ForceQuery = false
+	r.Fragment = ""
+	r.RawFragment = ""
+	return r.Redacted()
+}
+
+// redactURLString redacts a URL given as a string, as RedactURL does. Strings
+// which do not parse as a URL are reduced to the text before any query.
+func redactURLString(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		s, _, _ = strings.Cut(s, "?")
+		return s
+	}
+	return RedactURL(u)
+}
+
+// RedactError returns the message of the given error with the URL of any
+// url.Error in its chain redacted as by RedactURL, such as to log why a
+// download failed.
+func RedactError(err error) string {
+	msg := err.Error()
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && urlErr.URL != "" {
+		msg = strings.ReplaceAll(msg, urlErr.URL, redactURLString(urlErr.URL))
+	}
+	return msg
+}
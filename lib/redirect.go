@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// redirectChain returns the URLs of all requests made to obtain the given
+// response, in order, starting with the original request and ending with the
+// final request.
+func redirectChain(hresp *http.Response) []*url.URL {
+	var chain []*url.URL
+	for hresp != nil && hresp.Request != nil {
+		chain = append([]*url.URL{hresp.Request.URL}, chain...)
+		hresp = hresp.Request.Response
+	}
+	return chain
+}
+
+// recordRedirects records on the Response any redirects which were followed to
+// obtain the given HTTP response.
+func recordRedirects(resp *Response, hresp *http.Response) {
+	chain := redirectChain(hresp)
+	if len(chain) < 2 {
+		return
+	}
+	for _, u := range chain[:len(chain)-1] {
+		resp.logf("redirected from %s", RedactURL(u))
+	}
+	resp.redirects = append(resp.redirects, chain[:len(chain)-1]...)
+}
+
+// guessFilenameFromRedirects returns a filename for the given HTTP response,
+// falling back to the URLs which redirected to it, latest first, if no
+// filename can be determined using the final URL.
+func guessFilenameFromRedirects(hresp *http.Response, redirects []*url.URL) (string, error) {
+	filename, err := guessFilename(hresp)
+	if err != ErrNoFilename {
+		return filename, err
+	}
+	for i := len(redirects) - 1; i >= 0; i-- {
+		filename, err = guessFilename(&http.Response{
+			Header:  make(http.Header),
+			Request: &http.Request{URL: redirects[i]},
+		})
+		if err == nil {
+			return filename, nil
+		}
+	}
+	return "", ErrNoFilename
+}
+
+// maxRedirectsKey is the context key under which Request.MaxRedirects is
+// carried to the HTTP requests sent for a transfer.
+type maxRedirectsKey struct{}
+
+// limitRedirects returns a copy of the given HTTPClient which stops after the
+// number of redirects carried by the context of req, or hc itself if no limit
+// applies. The CheckRedirect policy of hc, if any, is still consulted.
+func limitRedirects(hc HTTPClient, req *http.Request) HTTPClient {
+	max, ok := req.Context().Value(maxRedirectsKey{}).(int)
+	if !ok || max == 0 {
+		return hc
+	}
+	client, ok := hc.(*http.Client)
+	if !ok {
+		return hc
+	}
+	if max < 0 {
+		max = 0
+	}
+	check := client.CheckRedirect
+	limited := *client
+	limited.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			chain := make([]*url.URL, 0, len(via)+1)
+			for _, r := range via {
+				chain = append(chain, r.URL)
+			}
+			return &TooManyRedirectsError{Max: max, Chain: append(chain, next.URL)}
+		}
+		if check != nil {
+			return check(next, via)
+		}
+		return nil
+	}
+	return &limited
+}
@@ -0,0 +1,201 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// redirectingHTTPClient simulates an http.Client following redirects, before
+// passing the final request to the wrapped HTTPClient.
+type redirectingHTTPClient struct {
+	redirects map[string]string // url -> redirect target
+	next      HTTPClient
+}
+
+func (c *redirectingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	for {
+		target, ok := c.redirects[req.URL.String()]
+		if !ok {
+			return c.next.Do(req)
+		}
+		redirect := &http.Response{
+			Status:     "302 Found",
+			StatusCode: http.StatusFound,
+			Header:     http.Header{"Location": {target}},
+			Request:    req,
+		}
+		next, err := http.NewRequestWithContext(req.Context(), req.Method, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		next.Header = req.Header.Clone()
+		next.Response = redirect
+		req = next
+	}
+}
+
+func TestRedirectChain(t *testing.T) {
+	client := &redirectingHTTPClient{
+		redirects: map[string]string{
+			"http://example.com/a": "http://example.com/b",
+			"http://example.com/b": "http://example.com/c",
+		},
+		next: newMockHTTPClient(),
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/a", nil)
+	hresp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	chain := redirectChain(hresp)
+	expect := []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"}
+	if len(chain) != len(expect) {
+		t.Fatalf("Expected chain %v, got %v", expect, chain)
+	}
+	for i, u := range chain {
+		if u.String() != expect[i] {
+			t.Errorf("Expected chain[%d] = %q, got %q", i, expect[i], u.String())
+		}
+	}
+}
+
+func TestClient_Do_FilenameFromRedirect(t *testing.T) {
+	tests := []struct {
+		name            string
+		url             string
+		redirects       map[string]string
+		expectFilename  string
+		expectChain     []string
+		expectEffective string
+	}{
+		{
+			name: "filename from redirect target",
+			url:  "http://example.com/releases/latest/download/",
+			redirects: map[string]string{
+				"http://example.com/releases/latest/download/": "http://cdn.example.com/grab_1.0.tar.gz",
+			},
+			expectFilename:  "grab_1.0.tar.gz",
+			expectChain:     []string{"http://example.com/releases/latest/download/"},
+			expectEffective: "http://cdn.example.com/grab_1.0.tar.gz",
+		},
+		{
+			name: "filename from original url when target has none",
+			url:  "http://example.com/grab.zip",
+			redirects: map[string]string{
+				"http://example.com/grab.zip": "http://cdn.example.com/",
+			},
+			expectFilename:  "grab.zip",
+			expectChain:     []string{"http://example.com/grab.zip"},
+			expectEffective: "http://cdn.example.com/",
+		},
+		{
+			name:            "no redirects",
+			url:             "http://example.com/grab.zip",
+			expectFilename:  "grab.zip",
+			expectEffective: "http://example.com/grab.zip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			client := &Client{
+				HTTPClient: &redirectingHTTPClient{redirects: tt.redirects, next: newMockHTTPClient()},
+				UserAgent:  "test-agent",
+			}
+
+			req, _ := NewRequest(dir, tt.url)
+			resp := client.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			expectPath := filepath.Join(dir, tt.expectFilename)
+			if resp.Filename != expectPath {
+				t.Errorf("Expected filename %q, got %q", expectPath, resp.Filename)
+			}
+			if _, err := os.Stat(expectPath); err != nil {
+				t.Errorf("Expected downloaded file: %v", err)
+			}
+
+			if resp.EffectiveURL().String() != tt.expectEffective {
+				t.Errorf("Expected effective URL %q, got %q", tt.expectEffective, resp.EffectiveURL())
+			}
+
+			chain := resp.Redirects()
+			if len(chain) != len(tt.expectChain) {
+				t.Fatalf("Expected redirects %v, got %v", tt.expectChain, chain)
+			}
+			for i, u := range chain {
+				if u.String() != tt.expectChain[i] {
+					t.Errorf("Expected redirect %q, got %q", tt.expectChain[i], u.String())
+				}
+			}
+		})
+	}
+}
+
+func TestResponse_EffectiveURL_NoResponse(t *testing.T) {
+	req, _ := NewRequest("", "http://example.com/file.txt")
+	resp := &Response{Request: req}
+	if resp.EffectiveURL().String() != "http://example.com/file.txt" {
+		t.Errorf("Expected requested URL, got %q", resp.EffectiveURL())
+	}
+}
+
+// loopTransport is a http.RoundTripper which redirects every request between
+// two URLs.
+type loopTransport struct{}
+
+func (loopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := "http://a.example.com/file"
+	if req.URL.Host == "a.example.com" {
+		target = "http://b.example.com/file"
+	}
+	return &http.Response{
+		Status:     "302 Found",
+		StatusCode: http.StatusFound,
+		Header:     http.Header{"Location": {target}},
+		Body:       io.NopCloser(http.NoBody),
+		Request:    req,
+	}, nil
+}
+
+func TestRequest_MaxRedirects(t *testing.T) {
+	tests := []struct {
+		name        string
+		max         int
+		expectChain int
+	}{
+		{"limited", 3, 5},
+		{"above default limit", 15, 17},
+		{"no redirects", -1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{HTTPClient: &http.Client{Transport: loopTransport{}}}
+			req, _ := NewRequest("", "http://a.example.com/file")
+			req.NoStore = true
+			req.MaxRedirects = tt.max
+			err := client.Do(req).Err()
+			if !errors.Is(err, ErrTooManyRedirects) {
+				t.Fatalf("Expected ErrTooManyRedirects, got %v", err)
+			}
+			var redirErr *TooManyRedirectsError
+			if !errors.As(err, &redirErr) {
+				t.Fatalf("Expected *TooManyRedirectsError, got %T", err)
+			}
+			if len(redirErr.Chain) != tt.expectChain {
+				t.Errorf("Expected chain of %d URLs, got %v", tt.expectChain, redirErr.Chain)
+			}
+			if redirErr.Chain[0].String() != "http://a.example.com/file" {
+				t.Errorf("Expected chain to start with requested URL, got %v", redirErr.Chain[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoBlockManifest indicates that a file cannot be repaired because no block
+// manifest was given by Request.BlockManifest or Request.BlockManifestURL.
+var ErrNoBlockManifest = errors.New("no block manifest")
+
+// blockHashes maps the algorithms supported by block manifests to their
+// hashes.
+var blockHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// A BlockManifest lists the checksums of consecutive fixed size blocks of a
+// file, so that corrupted parts of a local copy can be found and downloaded
+// again by Client.Repair.
+type BlockManifest struct {
+	// Algorithm is the hash algorithm of the blocks: md5, sha1, sha256 or
+	// sha512.
+	Algorithm string `json:"algorithm"`
+
+	// BlockSize is the size of each block in bytes. The last block may be
+	// shorter.
+	BlockSize int64 `json:"block_size"`
+
+	// Size is the size of the file in bytes.
+	Size int64 `json:"size"`
+
+	// Blocks lists the hex encoded checksum of each block.
+	Blocks []string `json:"blocks"`
+}
+
+// NewBlockManifest reads r to the end and returns a manifest of its blocks of
+// the given size, using the given hash algorithm.
+func NewBlockManifest(r io.Reader, algorithm string, blockSize int64) (*BlockManifest, error) {
+	newHash, ok := blockHashes[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported block hash algorithm: %s", algorithm)
+	}
+	if blockSize < 1 {
+		return nil, fmt.Errorf("invalid block size: %d", blockSize)
+	}
+	m := &BlockManifest{Algorithm: strings.ToLower(algorithm), BlockSize: blockSize}
+	for {
+		h := newHash()
+		n, err := io.CopyN(h, r, blockSize)
+		if n > 0 {
+			m.Blocks = append(m.Blocks, hex.EncodeToString(h.Sum(nil)))
+			m.Size += n
+		}
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ParseBlockManifest parses a block manifest in JSON format, as written by
+// encoding/json from a BlockManifest, and validates it.
+func ParseBlockManifest(b []byte) (*BlockManifest, error) {
+	var m BlockManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("invalid block manifest: %v", err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// validate checks that the manifest is consistent.
+func (m *BlockManifest) validate() error {
+	m.Algorithm = strings.ToLower(m.Algorithm)
+	if _, ok := blockHashes[m.Algorithm]; !ok {
+		return fmt.Errorf("invalid block manifest: unsupported algorithm %q", m.Algorithm)
+	}
+	if m.BlockSize < 1 || m.Size < 0 {
+		return errors.New("invalid block manifest: bad block size or file size")
+	}
+	if want := (m.Size + m.BlockSize - 1) / m.BlockSize; int64(len(m.Blocks)) != want {
+		return fmt.Errorf("invalid block manifest: expected %d blocks, got %d", want, len(m.Blocks))
+	}
+	return nil
+}
+
+// block returns the offset and length of the block at index i.
+func (m *BlockManifest) block(i int) (int64, int64) {
+	off := int64(i) * m.BlockSize
+	return off, min(m.BlockSize, m.Size-off)
+}
+
+// verify reports whether the block at index i of f matches the manifest.
+func (m *BlockManifest) verify(f io.ReaderAt, i int) (bool, error) {
+	off, n := m.block(i)
+	want, err := hex.DecodeString(m.Blocks[i])
+	if err != nil {
+		return false, nil
+	}
+	h := blockHashes[m.Algorithm]()
+	copied, err := io.Copy(h, io.NewSectionReader(f, off, n))
+	if err != nil {
+		return false, err
+	}
+	return copied == n && bytes.Equal(h.Sum(nil), want), nil
+}
+
+// blockManifest returns the block manifest given by Request.BlockManifest,
+// or fetched from Request.BlockManifestURL.
+func (c *Client) blockManifest(req *Request) (*BlockManifest, error) {
+	m := req.BlockManifest
+	if m == nil {
+		if req.BlockManifestURL == "" {
+			return nil, ErrNoBlockManifest
+		}
+		b, _, err := c.fetchDocument(req.Context(), req.BlockManifestURL)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch block manifest: %w", err)
+		}
+		return ParseBlockManifest(b)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// A RepairResult describes the outcome of Client.Repair.
+type RepairResult struct {
+	// Filename is the path of the repaired file.
+	Filename string
+
+	// Blocks is the number of blocks in the file.
+	Blocks int
+
+	// Repaired lists the indexes of the blocks which were corrupted or
+	// missing and were downloaded again.
+	Repaired []int
+
+	// Requests is the number of ranged requests sent to download the
+	// repaired blocks, after coalescing adjacent blocks.
+	Requests int
+
+	// BytesTransferred is the number of bytes downloaded.
+	BytesTransferred int64
+}
+
+// Repair verifies an existing local copy of the requested file against the
+// block manifest given by Request.BlockManifest, or fetched from
+// Request.BlockManifestURL, and downloads only the corrupted or missing blocks
+// again, coalescing adjacent blocks into a single ranged request. The file is
+// truncated to the size given by the manifest.
+//
+// Request.Filename must name the local file, or a directory in which the file
+// is named after the request URL. A missing file is downloaded in full. If a
+// block still does not match the manifest once it is downloaded again,
+// ErrBadChecksum is returned.
+func (c *Client) Repair(req *Request) (*RepairResult, error) {
+	m, err := c.blockManifest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	filename, err := repairFilename(req)
+	if err != nil {
+		return nil, err
+	}
+	if !req.NoCreateDirectories {
+		if err := mkdirp(filename); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	result := &RepairResult{Filename: filename, Blocks: len(m.Blocks)}
+	for i := range m.Blocks {
+		ok, err := m.verify(f, i)
+		if err != nil {
+			return result, err
+		}
+		if !ok {
+			result.Repaired = append(result.Repaired, i)
+		}
+	}
+	if err := f.Truncate(m.Size); err != nil {
+		return result, err
+	}
+
+	for _, run := range coalesceBlocks(result.Repaired) {
+		off, _ := m.block(run[0])
+		last, n := m.block(run[1])
+		length := last + n - off
+		result.Requests++
+		transferred, err := c.repairRange(req, f, off, length)
+		result.BytesTransferred += transferred
+		if err != nil {
+			return result, err
+		}
+		for i := run[0]; i <= run[1]; i++ {
+			ok, err := m.verify(f, i)
+			if err != nil {
+				return result, err
+			}
+			if !ok {
+				return result, fmt.Errorf("block %d: %w", i, ErrBadChecksum)
+			}
+		}
+	}
+	return result, f.Sync()
+}
+
+// repairFilename returns the path of the local file to be repaired for the
+// given request.
+func repairFilename(req *Request) (string, error) {
+	filename := req.Filename
+	if filename != "" && !isDirName(filename) && !isDir(filename) {
+		return filename, nil
+	}
+	name := path.Base(req.URL().Path)
+	if name == "." || name == "/" {
+		return "", ErrNoFilename
+	}
+	return filepath.Join(filename, name), nil
+}
+
+// coalesceBlocks groups the given sorted block indexes into runs of adjacent
+// blocks, each given by its first and last index.
+func coalesceBlocks(blocks []int) [][2]int {
+	var runs [][2]int
+	for _, i := range blocks {
+		if len(runs) > 0 && runs[len(runs)-1][1] == i-1 {
+			runs[len(runs)-1][1] = i
+			continue
+		}
+		runs = append(runs, [2]int{i, i})
+	}
+	return runs
+}
+
+// repairRange downloads the given byte range of the requested file into f at
+// the same offset, and returns the number of bytes transferred.
+func (c *Client) repairRange(req *Request, f *os.File, off, length int64) (int64, error) {
+	r := &Request{
+		Label:        req.Label,
+		Tag:          req.Tag,
+		HTTPRequest:  req.HTTPRequest.Clone(req.Context()),
+		NoStore:      true,
+		BufferSize:   req.BufferSize,
+		RateLimiter:  req.RateLimiter,
+		MaxRedirects: req.MaxRedirects,
+		Mirrors:      req.Mirrors,
+		RefreshURL:   req.RefreshURL,
+
+		IgnoreRemoteChecksum: true,
+		Size:                 length,
+		ctx:                  req.Context(),
+	}
+	r.SetRange(off, length)
+	r.writer = func(*Response) (io.Writer, error) {
+		return io.NewOffsetWriter(f, off), nil
+	}
+	resp := c.Do(r)
+	err := resp.Err()
+	return resp.BytesComplete(), err
+}
@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewBlockManifest(t *testing.T) {
+	m, err := NewBlockManifest(strings.NewReader("0123456789"), "SHA256", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Algorithm != "sha256" || m.Size != 10 || len(m.Blocks) != 3 {
+		t.Errorf("Unexpected manifest: %+v", m)
+	}
+	b, _ := json.Marshal(m)
+	if _, err := ParseBlockManifest(b); err != nil {
+		t.Errorf("Cannot parse generated manifest: %v", err)
+	}
+
+	if _, err := NewBlockManifest(strings.NewReader(""), "crc64", 4); err == nil {
+		t.Error("Expected error for unsupported algorithm")
+	}
+	m.Blocks = m.Blocks[:2]
+	b, _ = json.Marshal(m)
+	if _, err := ParseBlockManifest(b); err == nil {
+		t.Error("Expected error for missing blocks")
+	}
+}
+
+func TestCoalesceBlocks(t *testing.T) {
+	got := coalesceBlocks([]int{0, 1, 2, 5, 7, 8})
+	want := [][2]int{{0, 2}, {5, 5}, {7, 8}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := coalesceBlocks(nil); len(got) != 0 {
+		t.Errorf("Expected no runs, got %v", got)
+	}
+}
+
+func TestClient_Repair(t *testing.T) {
+	content := make([]byte, 10*1024+100)
+	rand.New(rand.NewSource(1)).Read(content)
+	manifest, err := NewBlockManifest(bytes.NewReader(content), "sha1", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestJSON, _ := json.Marshal(manifest)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/file.bin.blocks" {
+			_, _ = w.Write(manifestJSON)
+			return
+		}
+		requests.Add(1)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "file.bin")
+	corrupt := bytes.Clone(content[:9*1024+10])
+	corrupt[1024+7] ^= 0xff // block 1
+	corrupt[2048] ^= 0xff   // block 2
+	corrupt[5*1024] ^= 0xff // block 5
+	if err := os.WriteFile(filename, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient()
+	req, _ := NewRequest(dir, server.URL+"/file.bin")
+	req.BlockManifestURL = server.URL + "/file.bin.blocks"
+	result, err := client.Repair(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 5, 9, 10}; !reflect.DeepEqual(result.Repaired, want) {
+		t.Errorf("Expected repaired blocks %v, got %v", want, result.Repaired)
+	}
+	if result.Requests != 3 || int(requests.Load()) != 3 {
+		t.Errorf("Expected 3 ranged requests, got %d (server saw %d)", result.Requests, requests.Load())
+	}
+	if want := int64(2*1024 + 1024 + 1024 + 100); result.BytesTransferred != want {
+		t.Errorf("Expected %d bytes transferred, got %d", want, result.BytesTransferred)
+	}
+	if result.Filename != filename {
+		t.Errorf("Expected filename %s, got %s", filename, result.Filename)
+	}
+	if got, _ := os.ReadFile(filename); !bytes.Equal(got, content) {
+		t.Error("Repaired file does not match remote file")
+	}
+
+	// an intact file needs no requests
+	requests.Store(0)
+	req, _ = NewRequest(filename, server.URL+"/file.bin")
+	req.BlockManifest = manifest
+	result, err = client.Repair(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Repaired) != 0 || requests.Load() != 0 {
+		t.Errorf("Expected no repairs, got %v (%d requests)", result.Repaired, requests.Load())
+	}
+}
+
+func TestClient_Repair_Mismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader("abcdefgh"))
+	}))
+	defer server.Close()
+
+	manifest, _ := NewBlockManifest(strings.NewReader("abcdXXXX"), "md5", 4)
+	filename := filepath.Join(t.TempDir(), "file.bin")
+	req, _ := NewRequest(filename, server.URL+"/file.bin")
+	req.BlockManifest = manifest
+	if _, err := NewClient().Repair(req); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("Expected ErrBadChecksum, got %v", err)
+	}
+
+	req, _ = NewRequest(filename, server.URL+"/file.bin")
+	if _, err := NewClient().Repair(req); !errors.Is(err, ErrNoBlockManifest) {
+		t.Errorf("Expected ErrNoBlockManifest, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A BatchReport is a machine-readable record of the outcome of a batch of
+// file transfers, such as for collecting the results of mirror jobs in a
+// central place. See Client.ReportStore.
+type BatchReport struct {
+	// Start and End are the times at which the first transfer started and the
+	// last transfer ended.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Requested, Succeeded, Failed and Skipped count the transfers of the
+	// batch, as in BatchSummary.
+	Requested int `json:"requested"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+
+	// Bytes is the total number of bytes transferred, excluding any bytes
+	// which were resumed from previous downloads.
+	Bytes int64 `json:"bytes"`
+
+	// Files lists the outcome of each transfer.
+	Files []FileReport `json:"files"`
+}
+
+// A FileReport is the outcome of a single transfer of a BatchReport.
+type FileReport struct {
+	URL      string    `json:"url"`
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	Bytes    int64     `json:"bytes"`
+	Resumed  bool      `json:"resumed,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Error    string    `json:"error,omitempty"`
+	Worker   int       `json:"worker,omitempty"`
+
+	// Events are the events recorded during the transfer, which are stored
+	// as a separate log by a ReportStore.
+	Events []Event `json:"-"`
+}
+
+// NewBatchReport blocks until all of the given Responses have completed and
+// returns a report of their outcome.
+func NewBatchReport(responses ...*Response) *BatchReport {
+	summary := Summarize(responses...)
+	r := &BatchReport{
+		Start:     summary.start,
+		End:       summary.end,
+		Requested: summary.Requested,
+		Succeeded: summary.Succeeded,
+		Failed:    summary.Failed,
+		Skipped:   summary.Skipped,
+		Bytes:     summary.Bytes,
+		Files:     make([]FileReport, 0, len(responses)),
+	}
+	for _, resp := range responses {
+		f := FileReport{
+			URL:      RedactURL(resp.Request.URL()),
+			Filename: resp.Filename,
+			Size:     resp.Size(),
+			Bytes:    resp.BytesComplete(),
+			Resumed:  resp.DidResume,
+			Start:    resp.Start,
+			End:      resp.End,
+			Worker:   resp.Worker(),
+			Events:   redactEvents(resp.Events(), resp.Request.URL()),
+		}
+		if err := resp.Err(); err != nil {
+			f.Error = RedactError(err)
+		}
+		r.Files = append(r.Files, f)
+	}
+	return r
+}
+
+// redactEvents redacts any mention of the given URL in the messages of the
+// given events as by RedactURL, as reports are stored remotely, and returns
+// the events.
+func redactEvents(events []Event, u *url.URL) []Event {
+	s, redacted := u.String(), RedactURL(u)
+	for i, e := range events {
+		events[i].Message = strings.ReplaceAll(e.Message, s, redacted)
+	}
+	return events
+}
+
+// Log returns the events of all transfers of the report as text, with the
+// events of each transfer following a line with its URL and batch worker.
+func (r *BatchReport) Log() []byte {
+	var buf bytes.Buffer
+	for _, f := range r.Files {
+		if f.Worker > 0 {
+			fmt.Fprintf(&buf, "== %s (worker %d)\n", f.URL, f.Worker)
+		} else {
+			fmt.Fprintf(&buf, "== %s\n", f.URL)
+		}
+		for _, e := range f.Events {
+			fmt.Fprintf(&buf, "%s %s\n", e.Time.UTC().Format(time.RFC3339Nano), e.Message)
+		}
+	}
+	return buf.Bytes()
+}
+
+// reportBatch forwards the responses of a batch of n requests, and uploads a
+// report of the batch to the ReportStore of the Client once all of them have
+// completed, after closing the returned channel.
+func (c *Client) reportBatch(ctx context.Context, n int, in <-chan *Response) <-chan *Response {
+	out := make(chan *Response, n)
+	go func() {
+		var responses []*Response
+		for resp := range in {
+			responses = append(responses, resp)
+			out <- resp
+		}
+		close(out)
+		report := NewBatchReport(responses...)
+		// the report of a canceled batch is uploaded as well, within a
+		// bounded time
+		timeout := c.ReportStore.Timeout
+		if timeout <= 0 {
+			timeout = defaultReportTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		key, err := c.ReportStore.upload(ctx, c.HTTPClient, report)
+		if c.ReportStore.OnUpload != nil {
+			c.ReportStore.OnUpload(key, err)
+		}
+	}()
+	return out
+}
+
+// upload stores the report as a JSON object named Prefix followed by the time
+// of the end of the batch and a random suffix, such as
+// "reports/20240102T150405Z-1a2b3c4d.json", and its events as an object of
+// the same name with the extension ".log". It returns the key of the report.
+func (s *ReportStore) upload(ctx context.Context, client HTTPClient, r *BatchReport) (string, error) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	end := r.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	name := s.Prefix + end.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- s.put(ctx, client, name+".json", "application/json", b)
+	}()
+	go func() {
+		errs <- s.put(ctx, client, name+".log", "text/plain; charset=utf-8", r.Log())
+	}()
+	err = errors.Join(<-errs, <-errs)
+	if err != nil {
+		return name + ".json", fmt.Errorf("cannot upload report: %w", err)
+	}
+	return name + ".json", nil
+}
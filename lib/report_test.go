@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_DoBatch_ReportStore(t *testing.T) {
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("hello"))
+	}))
+	defer files.Close()
+	store, objects := newObjectServer(t)
+
+	uploaded := make(chan string, 1)
+	client := NewClient()
+	client.ReportStore = &ReportStore{
+		Endpoint:        store.URL,
+		Bucket:          "mirror",
+		Prefix:          "reports/",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		OnUpload: func(key string, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			uploaded <- key
+		},
+	}
+	dir := t.TempDir()
+	var requests []*Request
+	for _, path := range []string{"/a.txt", "/b.txt", "/missing?token=secret"} {
+		req, _ := NewRequest(dir, files.URL+path)
+		requests = append(requests, req)
+	}
+	n := 0
+	for range client.DoBatch(context.Background(), 2, requests...) {
+		n++
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 responses, got %d", n)
+	}
+
+	// the report is uploaded after the channel is closed
+	var key string
+	select {
+	case key = <-uploaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected report to be uploaded")
+	}
+	if !strings.HasPrefix(key, "reports/") || !strings.HasSuffix(key, ".json") {
+		t.Errorf("Unexpected report name: %s", key)
+	}
+	var report BatchReport
+	if err := json.Unmarshal(objects()["/mirror/"+key], &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Requested != 3 || report.Succeeded != 2 || report.Failed != 1 || len(report.Files) != 3 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	for _, f := range report.Files {
+		if strings.Contains(f.URL, "secret") || strings.Contains(f.Error, "secret") {
+			t.Errorf("Expected redacted URL in report, got %s (%s)", f.URL, f.Error)
+		}
+	}
+	log := objects()["/mirror/"+strings.TrimSuffix(key, ".json")+".log"]
+	if bytes.Contains(log, []byte("secret")) {
+		t.Errorf("Expected redacted URLs in log, got:\n%s", log)
+	}
+	if !bytes.Contains(log, []byte("== "+files.URL+"/missing (worker ")) {
+		t.Errorf("Expected events of each transfer in log, got:\n%s", log)
+	}
+}
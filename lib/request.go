@@ -3,8 +3,10 @@ package lib
 import (
 	"context"
 	"hash"
+	"io"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 // A Hook is a user provided callback function that can be called by grab at
@@ -18,6 +20,18 @@ import (
 // download from a callback, simply return a non-nil error.
 type Hook func(*Response) error
 
+// A StoreHook is a user provided callback function which is called with the
+// absolute path at which a download is about to be stored and returns the path
+// that should be used instead. Returning the given path unchanged accepts it.
+// If a StoreHook returns an error, the request is canceled and the same error
+// is returned on the Response object. Return ErrSkipped to skip a download.
+type StoreHook func(resp *Response, path string) (string, error)
+
+// A Filter transforms the content of a download while it is transferred, such
+// as to decrypt or decompress it, by wrapping the Reader of the response body.
+// Errors returned by the wrapping Reader fail the download.
+type Filter func(io.Reader) io.Reader
+
 // A Request represents an HTTP file transfer request to be sent by a Client.
 type Request struct {
 	// Label is an arbitrary string which may used to label a Request with a
@@ -51,9 +65,47 @@ type Request struct {
 	// completed in full, it will not be restarted.
 	NoResume bool
 
+	// UnmodifiedSince, if set, specifies that the download fails with a
+	// ModifiedError if the remote file was modified after this time, so that
+	// exactly the expected snapshot of a file is fetched, such as by
+	// reproducible builds. An If-Unmodified-Since header is sent, and the
+	// Last-Modified header of each response is checked for servers which
+	// ignore it. Responses without a Last-Modified header are accepted.
+	UnmodifiedSince time.Time
+
+	// ResumeOverlap specifies the number of bytes at the end of a partially
+	// completed download which should be requested again from the remote server
+	// when resuming. The bytes sent by the server are compared with the local
+	// file before the transfer continues and ErrResumeMismatch is returned if
+	// they differ, catching servers which serve different content for the same
+	// URL. A typical value is 64KB. If zero, resumed downloads are not verified.
+	ResumeOverlap int64
+
+	// ResumeIfMatch specifies that a resumed download should send the ETag of
+	// the partial file in an If-Match header, so that a server whose file has
+	// changed replies with 412 Precondition Failed and the whole file is
+	// downloaded again. This is stricter than the If-Range handling of some
+	// servers, which may append the tail of a different file. The ETag is
+	// taken from ResumeCheckpoint, the resume state or the Sidecar of the
+	// client, which record it when the partial file was written. Files
+	// without a recorded ETag, or with a weak one, are resumed as usual.
+	ResumeIfMatch bool
+
+	// SingleRequest specifies that the file should be downloaded using a single
+	// GET request, skipping the HEAD request and any attempt to resume an
+	// existing file, which is overwritten. This halves the number of requests
+	// for small files, for which resuming is of little benefit.
+	SingleRequest bool
+
 	// NoStore specifies that grab should not write to the local file system.
 	// Instead, the download will be stored in memory and accessible only via
 	// Response.Open or Response.Bytes.
+	//
+	// If a NoStore download fails after receiving part of the file, the bytes
+	// are kept with the Request and the next download of the same Request
+	// resumes from them, unless NoResume is set. The last ResumeOverlap bytes,
+	// or 4KB if zero, are requested again and compared before the transfer
+	// continues.
 	NoStore bool
 
 	// NoCreateDirectories specifies that any missing directories in the given
@@ -66,15 +118,64 @@ type Request struct {
 	// status code to be within the 2XX range (after following redirects).
 	IgnoreBadStatusCodes bool
 
+	// AcceptHTML specifies that an HTML response should be saved even though
+	// the file extension of the URL or Filename shows that another type of
+	// file was requested. Otherwise, small HTML pages sent in place of such
+	// files, such as download pages which redirect after a delay, fail with an
+	// InterstitialError rather than being saved under the name of the
+	// requested file.
+	AcceptHTML bool
+
 	// IgnoreRemoteTime specifies that grab should not attempt to set the
 	// timestamp of the local file to match the remote file.
 	IgnoreRemoteTime bool
 
+	// PreserveMode specifies that grab should set the permissions of the local
+	// file to match the remote file, if advertised by a backend for a protocol
+	// other than HTTP via FileModeHeader. The header is ignored in the
+	// responses of HTTP servers. Only permission bits are preserved.
+	PreserveMode bool
+
+	// Quarantine specifies that the downloaded file should be marked as
+	// originating from the internet, as web browsers do, so that the
+	// operating system prompts the user before it is opened or executed. On
+	// macOS the com.apple.quarantine extended attribute is set and on Windows
+	// a Zone.Identifier alternate data stream is written. It has no effect on
+	// other platforms. If the file cannot be marked, such as on a file system
+	// without extended attributes, the failure is logged as an event and the
+	// download still succeeds.
+	Quarantine bool
+
+	// IgnoreRemoteChecksum specifies that grab should not validate the
+	// downloaded file using checksums advertised by the remote server in
+	// response headers or trailers (such as x-goog-hash or x-amz-checksum-*).
+	// Remote checksums are only used if no checksum was set via SetChecksum,
+	// and not if the body was decompressed by the transport, as they describe
+	// the compressed object.
+	IgnoreRemoteChecksum bool
+
+	// VerifyServerDigest specifies that the downloaded file should also be
+	// validated using the checksums of HTTP standards which the remote server
+	// may send, in the Repr-Digest (RFC 9530), Digest (RFC 3230) and
+	// Content-MD5 headers. These are not used by default, as some servers send
+	// them for other content than the body, such as before compression.
+	// Content-MD5 describes only the body of a response, so it is not used
+	// for resumed transfers.
+	VerifyServerDigest bool
+
 	// Size specifies the expected size of the file transfer if known. If the
 	// server response size does not match, the transfer is cancelled and
 	// ErrBadLength returned.
 	Size int64
 
+	// EstimateSize is an optional callback which returns an estimate of the
+	// total size of a transfer whose size was not reported by the remote
+	// server, such as a response using chunked transfer encoding. It is called
+	// by Response.Snapshot and may return zero if no estimate is available. The
+	// estimate is only used to report progress; unlike Size, it is not
+	// validated.
+	EstimateSize func(*Response) int64
+
 	// BufferSize specifies the size in bytes of the buffer that is used for
 	// transferring the requested file. Larger buffers may result in faster
 	// throughput but will use more memory and result in less frequent updates
@@ -87,6 +188,89 @@ type Request struct {
 	// polled.
 	RateLimiter RateLimiter
 
+	// Progress, if set, receives the progress of the download while it is
+	// transferred, such as to drive a progress bar. See ProgressbarSink and
+	// MPBSink for adapters of popular progress bar libraries.
+	Progress ProgressSink
+
+	// Filters are applied, in order, to the response body while it is
+	// transferred, so that only the transformed content is stored. Progress,
+	// Size and the transfer rate are measured before filtering, while a
+	// checksum set via SetChecksum is validated against the stored content.
+	// Filtered downloads are never resumed and any existing file is
+	// overwritten. Remote checksums are not used, and filtered downloads
+	// cannot be added to tar archives by Client.DoArchive, since the size of
+	// the stored content is unknown.
+	Filters []Filter
+
+	// MaxRedirects specifies the maximum number of redirects which may be
+	// followed for this request, overriding the redirect limit of the client's
+	// HTTPClient. If more redirects are sent, such as by a redirect loop between
+	// misconfigured mirrors, a *TooManyRedirectsError listing the redirect chain
+	// is returned. If negative, no redirects are followed. If zero, the policy
+	// of the client's HTTPClient applies. MaxRedirects is only enforced if the
+	// client's HTTPClient is an *http.Client.
+	MaxRedirects int
+
+	// MirrorListURL is the URL of a list of mirrors of the file, in a format
+	// accepted by ParseMirrorList, such as those published by Linux
+	// distributions. The list is fetched before the download and its mirrors,
+	// ranked by Client.Country and their priority, are tried first, followed
+	// by the URL and Mirrors, unless all are ranked by latency as configured
+	// by Client.RankMirrorsByLatency. If the list cannot be fetched, the download
+	// proceeds without it.
+	MirrorListURL string
+
+	// MirrorPath is the path of the file below the base URL of each mirror of
+	// the MirrorListURL. If empty, the path of the URL below any mirror in the
+	// list which is a prefix of it is used, or else the file name of the URL.
+	MirrorPath string
+
+	// Metadata is stored alongside the downloaded file by the Sidecar of the
+	// Client, if any, in addition to the provenance of the download.
+	Metadata map[string]string
+
+	// Destinations lists further files or writers which receive the
+	// downloaded content in the same pass as the file, such as a copy on a
+	// network share. A destination which fails is abandoned without failing
+	// the download or the other destinations; see
+	// Response.DestinationErrors. Resumed bytes are copied from the file
+	// first, so each destination receives the whole content.
+	Destinations []Destination
+
+	// Mirrors lists alternate URLs of the same file. If the request to the URL
+	// fails with a connection error or an error status before the transfer
+	// starts, each mirror is tried in turn, resuming any existing file as
+	// usual.
+	Mirrors []string
+
+	// BlockManifest lists the checksums of the blocks of the requested file,
+	// which Client.Repair uses to find the corrupted parts of a local copy.
+	// Client.Do verifies each block as it is written and aborts the transfer
+	// with a BlockMismatchError at the first corrupted block, rather than
+	// once the whole file was transferred.
+	BlockManifest *BlockManifest
+
+	// BlockManifestURL is the URL of a block manifest in the JSON format
+	// accepted by ParseBlockManifest, which is fetched by Client.Do and
+	// Client.Repair if BlockManifest is nil.
+	BlockManifestURL string
+
+	// RefreshURL is an optional callback which returns a new URL for the
+	// requested file if the server responds with status 403 Forbidden, such as
+	// when a presigned S3 or GCS URL has expired during a long download. The
+	// request is sent again to the new URL, resuming the transfer if possible.
+	// If the new URL is also forbidden, StatusCodeError(403) is returned.
+	RefreshURL func(ctx context.Context) (string, error)
+
+	// BeforeStore is a user provided callback that is called once the
+	// destination path of a download is resolved, before the local file is
+	// examined or created. It may rename the file, move it to another
+	// directory or veto the download. If the path is known before any request
+	// is sent, Response.HTTPResponse is nil when BeforeStore is called.
+	// BeforeStore is not called if NoStore is set.
+	BeforeStore StoreHook
+
 	// BeforeCopy is a user provided callback that is called immediately before
 	// a request starts downloading. If BeforeCopy returns an error, the request
 	// is cancelled and the same error is returned on the Response object.
@@ -99,11 +283,97 @@ type Request struct {
 	// the Response object.
 	AfterCopy Hook
 
+	// OnDone is a user provided callback that is called exactly once when the
+	// request reaches a terminal state, successfully or otherwise, after
+	// Response.Done is closed. It runs on its own goroutine, so it may call
+	// blocking Response methods such as Response.Err. A panic in OnDone is
+	// recovered and discarded, so it cannot crash the program or affect other
+	// transfers.
+	OnDone func(*Response)
+
+	// OnCheckpoint is a user provided callback which is called with a
+	// Checkpoint of the download every CheckpointBytes bytes or
+	// CheckpointInterval, whichever comes first, and once more if the
+	// transfer is interrupted, so that the progress of the download can be
+	// persisted outside of grab. The destination file is synced before each
+	// checkpoint. OnCheckpoint is called from the goroutine of the transfer,
+	// which waits for it to return. Checkpoints are not taken for ranged or
+	// filtered downloads.
+	OnCheckpoint func(resp *Response, c Checkpoint)
+
+	// OnEvent is a user provided callback which is called with each event of
+	// the transfer as it is recorded, such as to keep a complete log of long
+	// transfers, of which Response.Events only returns the most recent. It is
+	// called from the goroutine which records the event and should return
+	// quickly.
+	OnEvent func(resp *Response, e Event)
+
+	// CheckpointBytes and CheckpointInterval configure how often OnCheckpoint
+	// is called. Zero disables either trigger.
+	CheckpointBytes    int64
+	CheckpointInterval time.Duration
+
+	// ResumeCheckpoint is a Checkpoint of a previous download of the same
+	// file, such as one taken on another machine. If the existing file is
+	// resumed from the offset of the checkpoint, the checksum set by
+	// SetChecksum continues from its hash state rather than hashing the
+	// existing file again. If the validators of the remote file differ from
+	// those of the checkpoint, the existing file is overwritten.
+	ResumeCheckpoint *Checkpoint
+
+	// ChecksumHeader specifies the headers sent when the checksum file given
+	// by SetChecksumURL is fetched, such as the separate credentials which
+	// artifact managers often require for it. If nil, the checksum file is
+	// requested without the headers of the request. Headers of the Client
+	// are added as usual.
+	ChecksumHeader http.Header
+
 	// hash, checksum and deleteOnError - set via SetChecksum.
 	hash          hash.Hash
 	checksum      []byte
 	deleteOnError bool
 
+	// hashAlgorithm names the algorithm of hash for the ChecksumCache of the
+	// Client, or is empty if it cannot be named.
+	hashAlgorithm string
+
+	// checksumURL is the URL of the checksum file - set via SetChecksumURL.
+	checksumURL string
+
+	// signature is the detached signature of the file - set via
+	// SetSignatureURL.
+	signature *signatureCheck
+
+	// rangeStart, rangeLength and hasRange - set via SetRange.
+	rangeStart  int64
+	rangeLength int64
+	hasRange    bool
+
+	// writer returns the destination of a NoStore transfer in place of the
+	// in-memory buffer - set by Client.DoArchive.
+	writer func(*Response) (io.Writer, error)
+
+	// memory holds the bytes of an interrupted NoStore download - allocated
+	// by Client.Do.
+	memory *memoryPartial
+
+	// politeness spaces the downloads of a batch - set by
+	// Client.DoBatchWithOptions.
+	politeness *politeness
+
+	// devices aborts the downloads of a batch to a full device - set by
+	// Client.DoBatchWithOptions.
+	devices *deviceWatch
+
+	// batchShare limits the transfer rate of a batch to its share of
+	// Client.BatchRateLimit - set by Client.DoBatchWithOptions.
+	batchShare RateLimiter
+
+	// staged defers closing Response.Done until the staged file of an atomic
+	// batch has been moved into place or discarded - set by
+	// Client.DoBatchWithOptions.
+	staged bool
+
 	// Context for cancellation and timeout - set via WithContext
 	ctx context.Context
 }
@@ -166,12 +436,75 @@ func (r *Request) URL() *url.URL {
 // If deleteOnError is true, the downloaded file will be deleted automatically
 // if it fails checksum validation.
 //
+// The checksum is computed as the file is written, so that validation adds
+// little time once the transfer completes. If an AfterCopy hook is set, the
+// file is hashed again once it returns, so that any changes it made are
+// validated. If the transfer is interrupted and Client.ResumeState is set, the
+// state of a hash which implements encoding.BinaryMarshaler, such as SHA-256,
+// is recorded in the resume state of the file, so that the existing part of
+// the file need not be hashed again when the transfer is resumed. The state
+// is only used if the file is unchanged since it was saved; otherwise the
+// existing part of the file is hashed again.
+//
 // To prevent corruption of the computed checksum, the given hash must not be
 // used by any other request or goroutines.
 //
 // To disable checksum validation, call SetChecksum with a nil hash.
 func (r *Request) SetChecksum(h hash.Hash, sum []byte, deleteOnError bool) {
+	r.setChecksum(checksumAlgorithm(h), h, sum, deleteOnError)
+}
+
+// setChecksum is like SetChecksum, but also names the algorithm of the hash,
+// by which the checksum of the file is cached. If algorithm is empty, the
+// checksum is not cached.
+func (r *Request) setChecksum(algorithm string, h hash.Hash, sum []byte, deleteOnError bool) {
+	r.checksumURL = ""
 	r.hash = h
+	r.hashAlgorithm = algorithm
 	r.checksum = sum
 	r.deleteOnError = deleteOnError
 }
+
+// SetChecksumURL is like SetChecksum, but the expected checksum is fetched
+// from the given URL before the download starts, such as from a .sha256 file
+// published next to the requested file. The file may contain a single hex
+// encoded checksum, or lines in the format written by sha256sum, of which the
+// entry matching the file name of the request URL is used. If the checksum
+// cannot be fetched, the download fails before any request for the file is
+// sent; if the file has no matching checksum, ErrNoChecksum is returned.
+//
+// The checksum file is requested without the headers of the request, which
+// may hold credentials for another host, unless ChecksumHeader is set.
+func (r *Request) SetChecksumURL(h hash.Hash, url string, deleteOnError bool) {
+	r.SetChecksum(h, nil, deleteOnError)
+	r.checksumURL = url
+	if h == nil {
+		r.checksumURL = ""
+	}
+}
+
+// SetRange specifies that only length bytes of the remote file, starting at
+// offset off, should be transferred. If length is less than zero, the range
+// extends to the end of the remote file.
+//
+// Ranged requests are never resumed and any existing file at the destination
+// path is overwritten with the requested range. Response.Size and progress are
+// reported relative to the requested range. If Size is set, it must match the
+// size of the requested range.
+//
+// If the remote server ignores the range and responds with the entire file,
+// only the requested range is stored.
+//
+// SetRange panics if off is negative or length is zero, as no such range can
+// be requested.
+func (r *Request) SetRange(off, length int64) {
+	if off < 0 {
+		panic("grab: range offset must not be negative")
+	}
+	if length == 0 {
+		panic("grab: range length must not be zero")
+	}
+	r.rangeStart = off
+	r.rangeLength = length
+	r.hasRange = true
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"sync/atomic"
 	"time"
@@ -54,6 +55,11 @@ type Response struct {
 	// errors. Errors are available via Response.Err
 	Done chan struct{}
 
+	// finished is closed once the transfer is finalized, before Done is
+	// closed, which for the staged file of an atomic batch waits until the
+	// batch has been committed.
+	finished chan struct{}
+
 	// ctx is a Context that controls cancelation of an inprogress transfer
 	ctx context.Context
 
@@ -61,6 +67,10 @@ type Response struct {
 	// Response.
 	cancel context.CancelFunc
 
+	// abort cancels the context of this Response with a cause, such as a
+	// stall or a full device.
+	abort context.CancelCauseFunc
+
 	// fi is the FileInfo for the destination file if it already existed before
 	// transfer started.
 	fi os.FileInfo
@@ -77,10 +87,79 @@ type Response struct {
 	// enabled.
 	storeBuffer bytes.Buffer
 
+	// buffered holds the bytes of an interrupted NoStore download which this
+	// download resumes, until they are copied to storeBuffer.
+	buffered []byte
+
 	// bytesCompleted specifies the number of bytes which were already
 	// transferred before this transfer began.
 	bytesResumed int64
 
+	// storeApproved indicates that the destination path was passed to the
+	// Request.BeforeStore hook.
+	storeApproved bool
+
+	// refreshed indicates that the URL was refreshed via Request.RefreshURL
+	// since the last successful request.
+	refreshed bool
+
+	// streamed indicates that the transfer is written to Request.writer and
+	// that its checksum, if any, is computed as it is written.
+	streamed bool
+
+	// special indicates that the destination is a named pipe or device,
+	// which is written once from the start and never resumed.
+	special bool
+
+	// buffer is the share of Client.MaxBufferedBytes reserved before the GET
+	// request, if any.
+	buffer *bufferReservation
+
+	// releaseDevice releases the capacity on the destination device which
+	// was acquired before the GET request, if any.
+	releaseDevice func()
+
+	// timing records the timing of the GET request which transferred the
+	// file.
+	timing *timingTrace
+
+	// checkpoint calls Request.OnCheckpoint as the transfer progresses.
+	checkpoint *checkpointer
+
+	// destinations writes the transfer to Request.Destinations.
+	destinations []*destWriter
+
+	// hashed indicates that the checksum of the stored file, if any, is
+	// computed as it is written.
+	hashed bool
+
+	// blocks is the block manifest against which the file is verified as it
+	// is written, if any.
+	blocks *BlockManifest
+
+	// probed indicates that HTTPResponse is the response to a ranged GET
+	// request sent in place of a HEAD request and that its body is closed.
+	probed bool
+
+	// singleRequest specifies that the file is downloaded with a single GET
+	// request, without attempting to resume.
+	singleRequest bool
+
+	// attempts is the number of the current attempt of the transfer, as
+	// retried by Client.RetryPolicy.
+	attempts int
+
+	// worker is the ID of the batch worker which sent the request, or zero.
+	worker int
+
+	// standbyFailovers is the number of times the transfer was resumed from
+	// a warm standby mirror.
+	standbyFailovers int
+
+	// resumeOverlap specifies the number of bytes already transferred which
+	// were requested again to verify a resumed transfer.
+	resumeOverlap int64
+
 	// transfer is responsible for copying data from the remote server to a local
 	// file, tracking progress and allowing for cancelation.
 	transfer *transfer
@@ -88,6 +167,45 @@ type Response struct {
 	// bufferSize specifies the size in bytes of the transfer buffer.
 	bufferSize int
 
+	// mirrors lists the candidate URLs of the transfer, starting with the
+	// original URL, followed by Request.Mirrors and any mirrors advertised by
+	// the remote server.
+	mirrors []string
+
+	// mirrorNext is the index in mirrors of the next URL to try if a request
+	// fails.
+	mirrorNext int
+
+	// interstitialFollowed indicates that the redirect of an interstitial
+	// page was followed.
+	interstitialFollowed bool
+
+	// progressDone is closed to stop the updates of Request.Progress, and
+	// progressStopped is closed once they have stopped.
+	progressDone    chan struct{}
+	progressStopped chan struct{}
+
+	// proxy describes the proxy selected for the most recent request, and
+	// proxyKnown whether it was recorded.
+	proxy      ProxyDecision
+	proxyKnown bool
+
+	// mirrorRanking lists the candidate URLs ranked by latency.
+	mirrorRanking []MirrorLatency
+
+	// redirects lists the URLs which were redirected while communicating with
+	// the remote server.
+	redirects []*url.URL
+
+	// phase is the current Phase of the transfer, accessed atomically.
+	phase int32
+
+	// phases receives each Phase as it is entered.
+	phases chan Phase
+
+	// events records the most recent significant events of the transfer.
+	events eventLog
+
 	// Error contains any error that may have occurred during the file transfer.
 	// This should not be read until IsComplete returns true.
 	err error
@@ -104,6 +222,17 @@ func (c *Response) IsComplete() bool {
 	}
 }
 
+// isFinished reports whether the transfer has been finalized by
+// closeResponse, which may be before Done is closed.
+func (c *Response) isFinished() bool {
+	select {
+	case <-c.finished:
+		return true
+	default:
+		return false
+	}
+}
+
 // Cancel cancels the file transfer by canceling the underlying Context for
 // this Response. Cancel blocks until the transfer is closed and returns any
 // error - typically context.Canceled.
@@ -139,16 +268,35 @@ func (c *Response) BytesComplete() int64 {
 	return c.bytesResumed + c.transfer.N()
 }
 
-// BytesPerSecond returns the number of bytes per second transferred using a
-// simple moving average of the last five seconds. If the download is already
-// complete, the average bytes/sec for the life of the download is returned.
+// BytesPerSecond returns the number of bytes per second transferred, as a
+// moving average with the half-life of Client.RateHalfLife. If the download is
+// already complete, the average bytes/sec for the life of the download is
+// returned.
 func (c *Response) BytesPerSecond() float64 {
 	if c.IsComplete() {
-		return float64(c.transfer.N()) / c.Duration().Seconds()
+		return c.AverageBytesPerSecond()
 	}
 	return c.transfer.BPS()
 }
 
+// AverageBytesPerSecond returns the number of bytes transferred per second
+// since the download started, excluding any bytes resumed from a previous
+// download.
+func (c *Response) AverageBytesPerSecond() float64 {
+	secs := c.Duration().Seconds()
+	if secs <= 0 {
+		return 0
+	}
+	return float64(c.transfer.N()) / secs
+}
+
+// PeakBytesPerSecond returns the highest number of bytes transferred in any
+// one second of the download. If the transfer took less than a second, or
+// never ran at its peak for a whole second, the average rate is returned.
+func (c *Response) PeakBytesPerSecond() float64 {
+	return max(c.transfer.peakBPS(), c.AverageBytesPerSecond())
+}
+
 // Progress returns the ratio of total bytes that have been downloaded. Multiply
 // the returned value by 100 to return the percentage completed.
 func (c *Response) Progress() float64 {
@@ -171,20 +319,69 @@ func (c *Response) Duration() time.Duration {
 	return time.Since(c.Start)
 }
 
+// Timing returns the breakdown of the duration of the GET request which
+// transferred the file into DNS, connect, TLS, time to first byte and transfer
+// durations. It is zero if no GET request was sent, such as when the file was
+// already complete.
+func (c *Response) Timing() Timing {
+	var end time.Time
+	if c.IsComplete() {
+		end = c.End
+	}
+	return c.timing.timing(end)
+}
+
 // ETA returns the estimated time at which the the download will complete, given
 // the current BytesPerSecond. If the transfer has already completed, the actual
-// end time will be returned.
+// end time will be returned. If the size of the file or the transfer rate is
+// unknown, the zero time is returned.
 func (c *Response) ETA() time.Time {
 	if c.IsComplete() {
 		return c.End
 	}
-	bt := c.BytesComplete()
-	bps := c.transfer.BPS()
-	if bps == 0 {
+	size := c.Size()
+	if size < 0 {
+		return time.Time{}
+	}
+	return eta(size-c.BytesComplete(), c.transfer.BPS())
+}
+
+// eta returns the time at which the given number of remaining bytes will have
+// been transferred at the given rate, or the zero time if the rate is zero.
+func eta(remaining int64, bps float64) time.Time {
+	if bps <= 0 {
 		return time.Time{}
 	}
-	secs := float64(c.Size()-bt) / bps
-	return time.Now().Add(time.Duration(secs) * time.Second)
+	secs := float64(max(remaining, 0)) / bps
+	return time.Now().Add(time.Duration(secs * float64(time.Second)))
+}
+
+// EffectiveURL returns the final URL from which the file was requested, after
+// following any redirects. If no response has been received from the remote
+// server, the requested URL is returned.
+//
+// EffectiveURL should not be called until Client.Do has returned.
+func (c *Response) EffectiveURL() *url.URL {
+	if c.HTTPResponse != nil && c.HTTPResponse.Request != nil {
+		return c.HTTPResponse.Request.URL
+	}
+	return c.Request.URL()
+}
+
+// Worker returns the ID of the batch worker which sent the request, numbered
+// from one, or zero if the request was not sent by a batch worker. Hooks may
+// use it to attribute their logs to a worker.
+func (c *Response) Worker() int {
+	return c.worker
+}
+
+// Redirects returns the URLs which responded with a redirect while
+// communicating with the remote server, in the order they were requested.
+// The final URL is available via HTTPResponse.Request.URL.
+//
+// Redirects should not be called until Client.Do has returned.
+func (c *Response) Redirects() []*url.URL {
+	return c.redirects
 }
 
 // Open blocks the calling goroutine until the underlying file transfer is
@@ -238,6 +435,9 @@ func (c *Response) requestMethod() string {
 }
 
 func (c *Response) checksumUnsafe() ([]byte, error) {
+	if c.streamed || c.hashed {
+		return c.Request.hash.Sum(nil), nil
+	}
 	f, err := c.openUnsafe()
 	if err != nil {
 		return nil, err
@@ -245,6 +445,7 @@ func (c *Response) checksumUnsafe() ([]byte, error) {
 	defer func() {
 		_ = f.Close()
 	}()
+	c.Request.hash.Reset()
 	t := newTransfer(c.Request.Context(), nil, c.Request.hash, f, nil)
 	if _, err = t.copy(); err != nil {
 		return nil, err
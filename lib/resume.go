@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// verifyResumeOverlap reads the bytes which were requested again from the
+// remote server to verify a resumed transfer and compares them with the tail
+// of the partially downloaded file, or with the bytes buffered by an
+// interrupted NoStore download. ErrResumeMismatch is returned if they differ.
+func verifyResumeOverlap(resp *Response) error {
+	local := make([]byte, resp.resumeOverlap)
+	if resp.buffered != nil {
+		copy(local, resp.buffered[resp.bytesResumed-resp.resumeOverlap:])
+	} else if err := readFileAt(resp.Filename, local, resp.bytesResumed-resp.resumeOverlap); err != nil {
+		return err
+	}
+
+	remote := make([]byte, resp.resumeOverlap)
+	if _, err := io.ReadFull(resp.HTTPResponse.Body, remote); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return ErrResumeMismatch
+		}
+		return err
+	}
+	if !bytes.Equal(local, remote) {
+		return ErrResumeMismatch
+	}
+	return nil
+}
+
+// readFileAt reads len(b) bytes from the named file starting at byte offset
+// off.
+func readFileAt(name string, b []byte, off int64) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	_, err = f.ReadAt(b, off)
+	return err
+}
@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newResumeMockClient returns a mock HTTPClient for a 10 byte remote file
+// which supports ranged requests, responding to the resumed GET request with
+// the given Content-Range and body.
+func newResumeMockClient(testURL, contentRange, body string) *mockHTTPClient {
+	mockClient := newMockHTTPClient()
+	head := createMockHTTPResponse("200 OK", http.StatusOK, "", map[string]string{
+		"Accept-Ranges": "bytes",
+	})
+	head.ContentLength = 10
+	head.Request, _ = http.NewRequest("HEAD", testURL, nil)
+	mockClient.addResponse("HEAD", testURL, head)
+
+	get := createMockHTTPResponse("206 Partial Content", http.StatusPartialContent, body, map[string]string{
+		"Content-Range": contentRange,
+	})
+	get.Request, _ = http.NewRequest("GET", testURL, nil)
+	mockClient.addResponse("GET", testURL, get)
+	return mockClient
+}
+
+func TestClient_Do_ResumeOverlap(t *testing.T) {
+	tests := []struct {
+		name         string
+		overlap      int64
+		contentRange string
+		body         string
+		expectRange  string
+		expectError  error
+		expectFile   string
+	}{
+		{
+			name:         "no overlap",
+			contentRange: "bytes 5-9/10",
+			body:         "56789",
+			expectRange:  "bytes=5-",
+			expectFile:   "0123456789",
+		},
+		{
+			name:         "matching overlap",
+			overlap:      2,
+			contentRange: "bytes 3-9/10",
+			body:         "3456789",
+			expectRange:  "bytes=3-",
+			expectFile:   "0123456789",
+		},
+		{
+			name:         "overlap larger than local file",
+			overlap:      64 * 1024,
+			contentRange: "bytes 0-9/10",
+			body:         "0123456789",
+			expectRange:  "bytes=0-",
+			expectFile:   "0123456789",
+		},
+		{
+			name:         "mismatched overlap",
+			overlap:      2,
+			contentRange: "bytes 3-9/10",
+			body:         "XX56789",
+			expectRange:  "bytes=3-",
+			expectError:  ErrResumeMismatch,
+			expectFile:   "01234",
+		},
+		{
+			name:         "short overlap",
+			overlap:      2,
+			contentRange: "bytes 3-9/10",
+			body:         "3",
+			expectRange:  "bytes=3-",
+			expectError:  ErrResumeMismatch,
+			expectFile:   "01234",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testURL := "http://example.com/file.bin"
+			filename := filepath.Join(t.TempDir(), "file.bin")
+			if err := os.WriteFile(filename, []byte("01234"), 0644); err != nil {
+				t.Fatalf("Failed to create partial file: %v", err)
+			}
+
+			mockClient := newResumeMockClient(testURL, tt.contentRange, tt.body)
+			client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+			req, _ := NewRequest(filename, testURL)
+			req.ResumeOverlap = tt.overlap
+			resp := client.Do(req)
+			if err := resp.Err(); !errors.Is(err, tt.expectError) {
+				t.Fatalf("Expected error %v, got %v", tt.expectError, err)
+			}
+
+			requests := mockClient.getRequests()
+			last := requests[len(requests)-1]
+			if got := last.Header.Get("Range"); got != tt.expectRange {
+				t.Errorf("Expected Range %q, got %q", tt.expectRange, got)
+			}
+
+			b, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("Failed to read file: %v", err)
+			}
+			if string(b) != tt.expectFile {
+				t.Errorf("Expected file content %q, got %q", tt.expectFile, string(b))
+			}
+			if tt.expectError == nil {
+				if resp.Size() != 10 || resp.BytesComplete() != 10 {
+					t.Errorf("Expected size and bytes complete of 10, got %d and %d", resp.Size(), resp.BytesComplete())
+				}
+			}
+		})
+	}
+}
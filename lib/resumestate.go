@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"os"
+	"time"
+)
+
+// resumeStateSuffix is appended to the name of a partially downloaded file to
+// name the file which records where it was downloaded from.
+const resumeStateSuffix = ".grab"
+
+// resumeState records the remote file of a partial download, so that a later
+// download to the same file, perhaps by another process or from another URL,
+// only resumes it if the remote file is unchanged. See Client.ResumeState.
+type resumeState struct {
+	// URL is the URL of the remote file, redacted as by RedactURL, as the file
+	// may be read by others. It is only recorded for display.
+	URL string `json:"url"`
+
+	// URLHash is the hex encoded SHA-256 digest of the full URL, including
+	// its query, which identifies the remote file (see urlHash).
+	URLHash string `json:"url_sha256"`
+
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Size         int64     `json:"size"`
+	Written      int64     `json:"written"`
+	Updated      time.Time `json:"updated"`
+
+	// Hash is the state of the checksum of the partial file, if any.
+	Hash *hashState `json:"hash,omitempty"`
+}
+
+// keepsResumeState reports whether the resume state of the Response is
+// recorded, which requires Client.ResumeState and a file which may later be
+// resumed.
+func (c *Client) keepsResumeState(resp *Response) bool {
+	req := resp.Request
+	return c.ResumeState && resp.Filename != "" && !req.NoStore && !req.NoResume && !req.hasRange &&
+		!resp.singleRequest && !resp.streamed && !resp.special && len(req.Filters) == 0
+}
+
+// saveResumeState records the remote file of the Response, the number of
+// bytes written to its file and the state of its checksum, if any, if
+// enabled. The validators of a resumed file are kept if the response which
+// resumed it has none.
+func (c *Client) saveResumeState(resp *Response, written int64, hash *hashState) {
+	if !c.keepsResumeState(resp) {
+		return
+	}
+	s := resumeState{
+		URL:     RedactURL(resp.Request.URL()),
+		URLHash: urlHash(resp.Request.URL()),
+		Size:    resp.Size(),
+		Written: written,
+		Hash:    hash,
+		Updated: time.Now().UTC(),
+	}
+	if hresp := resp.HTTPResponse; hresp != nil {
+		s.ETag = hresp.Header.Get("ETag")
+		s.LastModified = hresp.Header.Get("Last-Modified")
+	}
+	if old := loadResumeState(resp.Filename); old != nil && resp.DidResume {
+		if s.ETag == "" {
+			s.ETag = old.ETag
+		}
+		if s.LastModified == "" {
+			s.LastModified = old.LastModified
+		}
+		if s.Size < 0 {
+			s.Size = old.Size
+		}
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	if err := writeFilePrivate(resp.Filename+resumeStateSuffix, b); err != nil {
+		resp.logf("cannot save resume state: %v", err)
+	}
+}
+
+// loadResumeState returns the recorded resume state of the given file, or nil
+// if there is none or it cannot be read.
+func loadResumeState(filename string) *resumeState {
+	b, err := os.ReadFile(filename + resumeStateSuffix)
+	if err != nil {
+		return nil
+	}
+	var s resumeState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil
+	}
+	return &s
+}
+
+// removeResumeState removes the resume state of a completed download.
+func (c *Client) removeResumeState(resp *Response) {
+	if !c.ResumeState || resp.Filename == "" || resp.Request.NoStore {
+		return
+	}
+	if err := os.Remove(resp.Filename + resumeStateSuffix); err != nil && !os.IsNotExist(err) {
+		resp.logf("cannot remove resume state: %v", err)
+	}
+}
+
+// resumeStateChanged reports whether the remote file of the given Response
+// differs from the one the existing partial file was downloaded from, as
+// recorded in its resume state, or whether the partial file is not the one
+// recorded, as its size differs from the bytes written. The validators and
+// size of the remote file are compared, and unless a validator matches, the
+// file is only resumed from the same URL, including its query.
+func (c *Client) resumeStateChanged(resp *Response) bool {
+	if !c.keepsResumeState(resp) || resp.HTTPResponse == nil {
+		return false
+	}
+	s := loadResumeState(resp.Filename)
+	if s == nil {
+		return false
+	}
+	if resp.fi != nil && resp.fi.Size() != s.Written {
+		resp.logf("resume state records %d bytes written, not %d", s.Written, resp.fi.Size())
+		return true
+	}
+	h := resp.HTTPResponse.Header
+	if s.ETag != "" && h.Get("ETag") != "" {
+		return s.ETag != h.Get("ETag")
+	}
+	validated := false
+	if s.LastModified != "" && h.Get("Last-Modified") != "" {
+		if s.LastModified != h.Get("Last-Modified") {
+			return true
+		}
+		validated = true
+	}
+	if size := resp.HTTPResponse.ContentLength; s.Size >= 0 && size >= 0 && s.Size != size {
+		return true
+	}
+	return !validated && s.URLHash != urlHash(resp.Request.URL())
+}
+
+// urlHash returns the hex encoded SHA-256 digest of the given URL. URLs which
+// differ only in their query, such as download.php?id=1 and id=2, name
+// different files, so the URL cannot be compared once redacted; its digest
+// can, without revealing any credentials it holds.
+func urlHash(u *url.URL) string {
+	sum := sha256.Sum256([]byte(u.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,227 @@
+package lib
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_Do_ResumeState(t *testing.T) {
+	content := make([]byte, 100000)
+	rand.New(rand.NewSource(7)).Read(content)
+	changed := bytes.Clone(content)
+	changed[10] ^= 0xff
+
+	var fail atomic.Bool
+	fail.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, etag := content, `"v1"`
+		if r.URL.Path == "/changed" {
+			body, etag = changed, `"v2"`
+		}
+		w.Header().Set("ETag", etag)
+		if r.Method == http.MethodGet && r.Header.Get("Range") == "" && fail.Load() {
+			// drop the connection half way through the transfer
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Header().Set("Accept-Ranges", "bytes")
+			_, _ = w.Write(body[:len(body)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.ResumeState = true
+	filename := filepath.Join(t.TempDir(), "file.bin")
+	req, _ := NewRequest(filename, server.URL+"/file?token=secret")
+	if err := client.Do(req).Err(); err == nil {
+		t.Fatal("Expected interrupted transfer to fail")
+	}
+	s := loadResumeState(filename)
+	if s == nil {
+		t.Fatal("Expected resume state to be saved")
+	}
+	if fi, err := os.Stat(filename + resumeStateSuffix); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected resume state only readable by its owner, got %v", fi.Mode())
+	}
+	if s.URL != server.URL+"/file" || s.ETag != `"v1"` || s.Size != int64(len(content)) || s.Written != int64(len(content)/2) {
+		t.Errorf("Unexpected resume state: %+v", s)
+	}
+
+	// a different remote file at another URL is not resumed
+	fail.Store(false)
+	req, _ = NewRequest(filename, server.URL+"/changed")
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if resp.DidResume {
+		t.Error("Expected changed remote file to be downloaded again")
+	}
+	if !hasEvent(resp, "overwriting existing file (50000 bytes): remote file changed since partial download") {
+		t.Error("Expected overwrite event")
+	}
+	if got, _ := os.ReadFile(filename); !bytes.Equal(got, changed) {
+		t.Error("Downloaded file does not match content")
+	}
+	if _, err := os.Stat(filename + resumeStateSuffix); !os.IsNotExist(err) {
+		t.Error("Expected resume state to be removed once complete")
+	}
+}
+
+func TestClient_Do_ResumeStateSameFile(t *testing.T) {
+	content := []byte("0123456789")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// the same remote file is resumed from a new URL
+	filename := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(filename, content[:4], 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := `{"url":"https://example.com/old","etag":"\"v1\"","size":10,"written":4}`
+	if err := os.WriteFile(filename+resumeStateSuffix, []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient()
+	client.ResumeState = true
+	req, _ := NewRequest(filename, server.URL+"/new")
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.DidResume {
+		t.Error("Expected unchanged remote file to be resumed")
+	}
+	if got, _ := os.ReadFile(filename); !bytes.Equal(got, content) {
+		t.Error("Downloaded file does not match content")
+	}
+}
+
+func TestClient_Do_ResumeStateQuery(t *testing.T) {
+	files := map[string][]byte{"1": []byte("aaaaaaaaaa"), "2": []byte("bbbbbbbbbb")}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server sends neither validators nor the size of the file, so
+		// only the URL identifies it
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodHead {
+			return
+		}
+		body := files[r.URL.Query().Get("id")]
+		if r.Header.Get("Range") != "" {
+			w.Header().Set("Content-Range", "bytes 4-9/*")
+			w.WriteHeader(http.StatusPartialContent)
+			body = body[4:]
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	filename := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(filename, files["1"][:4], 0644); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(server.URL + "/download.php?id=1")
+	state := `{"url":"` + RedactURL(u) + `","url_sha256":"` + urlHash(u) + `","size":-1,"written":4}`
+	if err := os.WriteFile(filename+resumeStateSuffix, []byte(state), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient()
+	client.ResumeState = true
+	req, _ := NewRequest(filename, server.URL+"/download.php?id=2")
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if resp.DidResume {
+		t.Error("Expected partial file of another query to be overwritten")
+	}
+	if got, _ := os.ReadFile(filename); !bytes.Equal(got, files["2"]) {
+		t.Errorf("Downloaded file does not match content, got %q", got)
+	}
+}
+
+func TestClient_Do_ResumeStateWritten(t *testing.T) {
+	content := []byte("0123456789")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// the partial file was written past the recorded state by another writer
+	filename := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(filename, []byte("0123xx"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(server.URL + "/file")
+	state := `{"url":"` + server.URL + `/file","url_sha256":"` + urlHash(u) + `","etag":"\"v1\"","size":10,"written":4}`
+	if err := os.WriteFile(filename+resumeStateSuffix, []byte(state), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient()
+	client.ResumeState = true
+	req, _ := NewRequest(filename, server.URL+"/file")
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if resp.DidResume || !hasEvent(resp, "resume state records 4 bytes written, not 6") {
+		t.Errorf("Expected partial file not matching its resume state to be overwritten, events: %v", resp.Events())
+	}
+	if got, _ := os.ReadFile(filename); !bytes.Equal(got, content) {
+		t.Error("Downloaded file does not match content")
+	}
+}
+
+func TestClient_Do_ResumeStateMerge(t *testing.T) {
+	content := []byte("0123456789")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server sends no validators, and drops the resumed transfer
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "10")
+			return
+		}
+		w.Header().Set("Content-Range", "bytes 4-9/10")
+		w.Header().Set("Content-Length", "6")
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(content[4:6])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer server.Close()
+
+	filename := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(filename, content[:4], 0644); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(server.URL + "/file")
+	state := `{"url":"` + server.URL + `/file","url_sha256":"` + urlHash(u) + `","etag":"\"v1\"","size":10,"written":4}`
+	if err := os.WriteFile(filename+resumeStateSuffix, []byte(state), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient()
+	client.ResumeState = true
+	req, _ := NewRequest(filename, server.URL+"/file")
+	if err := client.Do(req).Err(); err == nil {
+		t.Fatal("Expected interrupted transfer to fail")
+	}
+	s := loadResumeState(filename)
+	if s == nil || s.ETag != `"v1"` || s.Written != 6 {
+		t.Errorf("Expected recorded ETag to be kept, got %+v", s)
+	}
+}
@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Defaults of a RetryPolicy.
+const (
+	defaultRetryInitialBackoff = time.Second
+	defaultRetryMaxBackoff     = 30 * time.Second
+	defaultRetryMultiplier     = 2
+)
+
+// DefaultRetryableStatusCodes are the status codes retried by a RetryPolicy
+// which does not list its own: 408, 429, 500, 502, 503 and 504.
+var DefaultRetryableStatusCodes = []int{408, 429, 500, 502, 503, 504}
+
+// A RetryPolicy retries downloads which fail with a transient error, such as
+// a dropped connection or a 503 response, after an exponentially growing
+// delay. A download which fails during the transfer is resumed from the
+// bytes already written, if the server supports it. Mirrors are tried before
+// each retry as usual. See Client.RetryPolicy.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of each download,
+	// including the first. If less than 2, downloads are not retried.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry. Default: 1s.
+	InitialBackoff time.Duration
+
+	// MaxBackoff limits the delay before each retry, unless the server
+	// requests a longer delay with a Retry-After header. Default: 30s.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor by which the delay grows after each retry.
+	// Default: 2.
+	Multiplier float64
+
+	// Jitter is the fraction, from 0 to 1, by which each delay is shortened
+	// at random, so that clients which failed together do not retry
+	// together. Zero means no jitter.
+	Jitter float64
+
+	// RetryableStatusCodes lists the status codes of responses which are
+	// retried. Default: DefaultRetryableStatusCodes.
+	RetryableStatusCodes []int
+
+	// Retryable, if set, reports whether a download which failed with the
+	// given error is retried, in place of the default, which retries
+	// connection errors, timeouts, truncated responses and the status codes
+	// of RetryableStatusCodes.
+	Retryable func(err error) bool
+}
+
+// retryable reports whether a download which failed with the given error is
+// retried.
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	var statusErr StatusCodeError
+	if errors.As(err, &statusErr) {
+		codes := p.RetryableStatusCodes
+		if codes == nil {
+			codes = DefaultRetryableStatusCodes
+		}
+		return slices.Contains(codes, int(statusErr))
+	}
+	return isTransient(err)
+}
+
+// isTransient reports whether the given error is a connection error, timeout
+// or truncated response, which may not recur if the request is sent again.
+func isTransient(err error) bool {
+	var connErr *ConnError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrBadChecksum), errors.Is(err, ErrBadLength), errors.Is(err, context.Canceled):
+		return false
+	case errors.As(err, &connErr), errors.As(err, &netErr), errors.Is(err, ErrIncompleteBody),
+		errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before the given retry, counted from one, or the
+// delay requested by the server, if longer.
+func (p *RetryPolicy) backoff(retry int, retryAfter time.Duration) time.Duration {
+	d, maxd, mult := p.InitialBackoff, p.MaxBackoff, p.Multiplier
+	if d <= 0 {
+		d = defaultRetryInitialBackoff
+	}
+	if maxd <= 0 {
+		maxd = defaultRetryMaxBackoff
+	}
+	if mult < 1 {
+		mult = defaultRetryMultiplier
+	}
+	delay := min(float64(d)*math.Pow(mult, float64(retry-1)), float64(maxd))
+	if p.Jitter > 0 {
+		delay -= delay * min(p.Jitter, 1) * rand.Float64()
+	}
+	return max(time.Duration(delay), retryAfter)
+}
+
+// retry reports whether the failed attempt of the Response is retried under
+// the RetryPolicy of the Client, and if so, waits before the next attempt and
+// restores the original URL if mirrors were tried. The error and HTTP
+// response of the failed attempt are kept until they are replaced by the next
+// attempt.
+func (c *Client) retry(resp *Response) bool {
+	p := c.RetryPolicy
+	ctx := resp.Request.Context()
+	if p == nil || resp.attempts >= p.MaxAttempts || ctx.Err() != nil {
+		return false
+	}
+	err := resp.err
+	if err == nil && resp.HTTPResponse != nil {
+		err = StatusCodeError(resp.HTTPResponse.StatusCode)
+	}
+	if err == nil || !p.retryable(err) {
+		return false
+	}
+	var retryAfter time.Duration
+	if hint := resp.ThrottleHint(); hint != nil {
+		retryAfter = hint.RetryAfter
+	}
+	delay := p.backoff(resp.attempts, retryAfter)
+	resp.logf("attempt %d failed: %s; retrying in %v", resp.attempts, RedactError(err), delay)
+	_ = resp.closeResponseBody()
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+	}
+	resp.attempts++
+	if resp.mirrorNext > 1 {
+		// try the mirrors again, starting with the URL
+		restoreURL(resp)
+	}
+	return true
+}
+
+// restoreURL replaces the URL of the request with the first candidate URL,
+// ahead of the mirrors.
+func restoreURL(resp *Response) {
+	u, err := url.Parse(resp.mirrors[0])
+	if err != nil {
+		return
+	}
+	resp.Request.HTTPRequest.URL = u
+	resp.Request.HTTPRequest.Host = u.Host
+	resp.mirrorNext = 1
+}
+
+// retryTransfer retries a transfer which failed mid-way, if allowed by the
+// RetryPolicy of the Client, resuming the partially written file, and
+// continues with copyFile. Transfers streamed to a writer of the caller, pipe
+// or device cannot be retried.
+func (c *Client) retryTransfer(resp *Response) stateFunc {
+	if resp.streamed || resp.special || !c.retry(resp) {
+		return c.closeResponse
+	}
+	err := resp.err
+	resp.err = nil
+	closeWriter(resp)
+	if resp.err != nil {
+		return c.closeResponse
+	}
+	// keep the bytes received in memory to resume from
+	resp.err = err
+	saveMemory(resp)
+	resp.storeBuffer = bytes.Buffer{}
+
+	resp.err = nil
+	resp.HTTPResponse = nil
+	resp.fi = nil
+	resp.DidResume = false
+	resp.bytesResumed = 0
+	resp.resumeOverlap = 0
+	resp.hashed = false
+	resp.optionsKnown = false
+	resp.Request.HTTPRequest.Header.Del("Range")
+	c.run(resp, c.statFileInfo)
+	if resp.isFinished() {
+		return nil
+	}
+	return c.copyFile
+}
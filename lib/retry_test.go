@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		if got := p.backoff(i+1, 0); got != want {
+			t.Errorf("Retry %d: expected %v, got %v", i+1, want, got)
+		}
+	}
+	if got := p.backoff(1, 10*time.Second); got != 10*time.Second {
+		t.Errorf("Expected Retry-After delay of 10s, got %v", got)
+	}
+	p.Jitter = 0.5
+	for range 100 {
+		if got := p.backoff(1, 0); got < 500*time.Millisecond || got > time.Second {
+			t.Fatalf("Expected jittered delay of 0.5s to 1s, got %v", got)
+		}
+	}
+}
+
+func TestClient_Do_RetryStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		failures    int32
+		maxAttempts int
+		wantErr     error
+		wantReqs    int32
+	}{
+		{"recovers", http.StatusServiceUnavailable, 2, 3, nil, 3},
+		{"exhausted", http.StatusBadGateway, 5, 2, StatusCodeError(http.StatusBadGateway), 2},
+		{"permanent", http.StatusNotFound, 5, 3, StatusCodeError(http.StatusNotFound), 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) <= test.failures {
+					w.WriteHeader(test.status)
+					return
+				}
+				_, _ = w.Write([]byte("content"))
+			}))
+			defer server.Close()
+
+			client := NewClient()
+			client.RetryPolicy = &RetryPolicy{MaxAttempts: test.maxAttempts, InitialBackoff: 10 * time.Millisecond}
+			req, _ := NewRequest(filepath.Join(t.TempDir(), "file.txt"), server.URL)
+			resp := client.Do(req)
+			if err := resp.Err(); err != test.wantErr {
+				t.Errorf("Expected error %v, got %v", test.wantErr, err)
+			}
+			if n := requests.Load(); n != test.wantReqs {
+				t.Errorf("Expected %d requests, got %d", test.wantReqs, n)
+			}
+		})
+	}
+}
+
+func TestClient_Do_RetryTransfer(t *testing.T) {
+	content := make([]byte, 100000)
+	rand.New(rand.NewSource(5)).Read(content)
+
+	for _, noStore := range []bool{false, true} {
+		t.Run("NoStore="+strconv.FormatBool(noStore), func(t *testing.T) {
+			var (
+				requests atomic.Int32
+				mu       sync.Mutex
+				ranges   []string
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					mu.Lock()
+					ranges = append(ranges, r.Header.Get("Range"))
+					mu.Unlock()
+				}
+				if r.Method == http.MethodGet && requests.Add(1) == 1 {
+					// drop the connection half way through the transfer
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					w.Header().Set("Accept-Ranges", "bytes")
+					_, _ = w.Write(content[:len(content)/2])
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				}
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+			}))
+			defer server.Close()
+
+			client := NewClient()
+			client.RetryPolicy = &RetryPolicy{MaxAttempts: 2, InitialBackoff: 10 * time.Millisecond}
+			filename := filepath.Join(t.TempDir(), "file.bin")
+			req, _ := NewRequest(filename, server.URL)
+			req.NoStore = noStore
+			resp := client.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(ranges) != 2 || ranges[1] == "" {
+				t.Errorf("Expected a resumed second request, got ranges %q", ranges)
+			}
+			got, err := resp.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Error("Downloaded content does not match")
+			}
+			if !noStore {
+				if _, err := os.Stat(filename); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_Do_RetryCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.RetryPolicy = &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour}
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file.txt"), server.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.Do(req.WithContext(ctx)).Err()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Expected backoff to be interrupted by the context")
+	}
+}
@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// DefaultAllowedSchemes are the URL schemes of plain HTTP downloads, which are
+// allowed if Client.AllowedSchemes is nil.
+var DefaultAllowedSchemes = []string{"http", "https"}
+
+// torrentSource returns the location of the .torrent file of a torrent: URL,
+// which is either a URL or the path of a local file.
+func torrentSource(u *url.URL) string {
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return u.Path
+}
+
+// torrentFetcherKey is the context key of the function with which the
+// BitTorrent backend fetches the .torrent file of a torrent: URL. It is set by
+// the Client to its own request path, so that the fetch is subject to
+// AllowedSchemes, HostHeaders and the other settings of the Client.
+type torrentFetcherKey struct{}
+
+// withTorrentFetcher returns the given request with the Client set as the
+// fetcher of the .torrent file, if it is a torrent: URL of a remote file.
+func (c *Client) withTorrentFetcher(req *http.Request) *http.Request {
+	if !strings.EqualFold(req.URL.Scheme, "torrent") || isTorrentFile(req.URL) {
+		return req
+	}
+	fetch := func(req *http.Request) (*http.Response, error) {
+		return c.doHTTPRequest(req)
+	}
+	return req.WithContext(context.WithValue(req.Context(), torrentFetcherKey{}, fetch))
+}
+
+// isTorrentFile reports whether the given URL is a torrent: URL of a local
+// .torrent file.
+func isTorrentFile(u *url.URL) bool {
+	return strings.EqualFold(u.Scheme, "torrent") && !strings.Contains(torrentSource(u), "://")
+}
+
+// allowScheme returns ErrSchemeNotAllowed if the scheme of the given URL is
+// not listed by Client.AllowedSchemes, or DefaultAllowedSchemes if it is nil,
+// or if it is a torrent: URL of a local file and Client.TorrentFiles is not
+// set.
+func (c *Client) allowScheme(u *url.URL) error {
+	if isTorrentFile(u) && !c.TorrentFiles {
+		return fmt.Errorf("%w: %s (local torrent files are not allowed)", ErrSchemeNotAllowed, u.Redacted())
+	}
+	allowed := c.AllowedSchemes
+	if allowed == nil {
+		allowed = DefaultAllowedSchemes
+	}
+	if slices.ContainsFunc(allowed, func(s string) bool {
+		return strings.EqualFold(s, u.Scheme)
+	}) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrSchemeNotAllowed, u.Redacted())
+}
+
+// checkScheme fails the Request if its URL has a scheme which is not allowed,
+// before continuing with next.
+func (c *Client) checkScheme(next stateFunc) stateFunc {
+	return func(resp *Response) stateFunc {
+		if resp.err = c.allowScheme(resp.Request.URL()); resp.err != nil {
+			return c.closeResponse
+		}
+		return next
+	}
+}
+
+// restrictRedirects returns a copy of the given HTTPClient which does not
+// follow redirects to URLs whose scheme is not allowed, or to local torrent
+// files.
+func (c *Client) restrictRedirects(hc HTTPClient) HTTPClient {
+	client, ok := hc.(*http.Client)
+	if !ok {
+		return hc
+	}
+	check := client.CheckRedirect
+	restricted := *client
+	restricted.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		if isTorrentFile(next.URL) {
+			return fmt.Errorf("%w: redirect to %s", ErrSchemeNotAllowed, next.URL.Redacted())
+		}
+		if err := c.allowScheme(next.URL); err != nil {
+			return err
+		}
+		if check != nil {
+			return check(next, via)
+		}
+		// the default policy of http.Client
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &restricted
+}
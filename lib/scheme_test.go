@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClient_AllowedSchemes(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "magnet:?xt=urn:btih:0000000000000000000000000000000000000000", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	// only DefaultAllowedSchemes are allowed by default
+	client := NewClient()
+	dir := t.TempDir()
+
+	req, _ := NewRequest(filepath.Join(dir, "file"), server.URL+"/file")
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatalf("Expected http URL to be allowed, got %v", err)
+	}
+
+	for _, u := range []string{
+		"ipfs://bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy",
+		"magnet:?xt=urn:btih:0000000000000000000000000000000000000000",
+		"data:,hello",
+		"ftp://127.0.0.1:1/file",
+		server.URL + "/redirect",
+	} {
+		requests.Store(0)
+		req, _ := NewRequest(filepath.Join(dir, "other"), u)
+		req.NoResume = true
+		if err := client.Do(req).Err(); !errors.Is(err, ErrSchemeNotAllowed) {
+			t.Errorf("%s: expected ErrSchemeNotAllowed, got %v", u, err)
+		}
+		if u != server.URL+"/redirect" && requests.Load() != 0 {
+			t.Errorf("%s: expected no request to be sent", u)
+		}
+	}
+
+	// other schemes are allowed explicitly
+	client.AllowedSchemes = []string{"http", "https", "data"}
+	req, _ = NewRequest(filepath.Join(dir, "data"), "data:,hello")
+	if err := client.Do(req).Err(); err != nil {
+		t.Errorf("Expected data URL to be allowed, got %v", err)
+	}
+}
+
+func TestClient_TorrentFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "torrent:/etc/passwd", http.StatusFound)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	// local torrent files require an explicit opt-in
+	client := NewClient()
+	req, _ := NewRequest(filepath.Join(dir, "image.iso"), "torrent:"+filepath.Join(dir, "image.iso.torrent"))
+	if err := client.Do(req).Err(); !errors.Is(err, ErrSchemeNotAllowed) {
+		t.Errorf("Expected ErrSchemeNotAllowed for a local torrent file, got %v", err)
+	}
+
+	// redirects to local torrent files are never followed
+	client.TorrentFiles = true
+	req, _ = NewRequest(filepath.Join(dir, "file"), server.URL+"/file")
+	if err := client.Do(req).Err(); !errors.Is(err, ErrSchemeNotAllowed) {
+		t.Errorf("Expected ErrSchemeNotAllowed for a redirect to a local torrent file, got %v", err)
+	}
+}
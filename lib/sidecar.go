@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSidecarUnsupported indicates that a Sidecar cannot store metadata on the
+// current platform or file system.
+var ErrSidecarUnsupported = errors.New("sidecar not supported on this platform")
+
+// Keys of the provenance metadata stored by a Client with a Sidecar.
+const (
+	MetadataURL          = "url"
+	MetadataDownloaded   = "downloaded"
+	MetadataSize         = "size"
+	MetadataETag         = "etag"
+	MetadataLastModified = "last-modified"
+)
+
+// A Sidecar stores metadata alongside a file, such as the provenance of a
+// download or the state of a package manager. Implementations must replace
+// the metadata of a file atomically, so that readers never see a partial
+// update, and must be safe for concurrent use.
+type Sidecar interface {
+	// Read returns the metadata of the named file. If the file has no
+	// metadata, an error satisfying errors.Is(err, fs.ErrNotExist) is
+	// returned.
+	Read(filename string) (map[string]string, error)
+
+	// Write replaces the metadata of the named file.
+	Write(filename string, meta map[string]string) error
+
+	// Remove removes the metadata of the named file, if any.
+	Remove(filename string) error
+}
+
+var (
+	sidecarsMu sync.RWMutex
+	sidecars   = map[string]Sidecar{
+		"json":  JSONSidecar{},
+		"xattr": XattrSidecar{},
+	}
+)
+
+// RegisterSidecar makes a Sidecar available by the given name, such as for
+// selection in a configuration file. The built-in "json" and "xattr" sidecars
+// may be replaced.
+func RegisterSidecar(name string, s Sidecar) {
+	sidecarsMu.Lock()
+	defer sidecarsMu.Unlock()
+	sidecars[name] = s
+}
+
+// LookupSidecar returns the Sidecar registered with the given name.
+func LookupSidecar(name string) (Sidecar, bool) {
+	sidecarsMu.RLock()
+	defer sidecarsMu.RUnlock()
+	s, ok := sidecars[name]
+	return s, ok
+}
+
+// Sidecars returns the names of all registered sidecars in sorted order.
+func Sidecars() []string {
+	sidecarsMu.RLock()
+	defer sidecarsMu.RUnlock()
+	names := make([]string, 0, len(sidecars))
+	for name := range sidecars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// defaultJSONSidecarSuffix is the default suffix of JSONSidecar files.
+const defaultJSONSidecarSuffix = ".grab.json"
+
+// JSONSidecar stores metadata as a JSON object in a file next to each file.
+type JSONSidecar struct {
+	// Suffix is appended to the name of a file to name its metadata file.
+	// Default: ".grab.json".
+	Suffix string
+}
+
+func (c JSONSidecar) path(filename string) string {
+	if c.Suffix == "" {
+		return filename + defaultJSONSidecarSuffix
+	}
+	return filename + c.Suffix
+}
+
+func (c JSONSidecar) Read(filename string) (map[string]string, error) {
+	name := c.path(filename)
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var meta map[string]string
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata file %s: %v", name, err)
+	}
+	return meta, nil
+}
+
+// Write replaces the metadata file by renaming a temporary file over it. The
+// file is only readable by its owner, as metadata may describe private
+// downloads.
+func (c JSONSidecar) Write(filename string, meta map[string]string) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return writeFilePrivate(c.path(filename), b)
+}
+
+func (c JSONSidecar) Remove(filename string) error {
+	if err := os.Remove(c.path(filename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// defaultXattrPrefix is the default prefix of the extended attributes written
+// by XattrSidecar.
+const defaultXattrPrefix = "user.grab."
+
+// XattrSidecar stores metadata as an extended attribute of each file, so that
+// it moves with the file. The metadata is stored as a JSON object in a single
+// attribute, named by Prefix without its trailing dot, such as "user.grab",
+// so that it is replaced atomically. Metadata stored by earlier versions as
+// one attribute per key is still read. Only Linux and macOS are supported,
+// and the file system must support extended attributes.
+type XattrSidecar struct {
+	// Prefix names the attribute of the metadata, and was prepended to each
+	// metadata key by earlier versions. On Linux, it must begin with "user.".
+	// Default: "user.grab.".
+	Prefix string
+}
+
+func (c XattrSidecar) prefix() string {
+	if c.Prefix == "" {
+		return defaultXattrPrefix
+	}
+	return c.Prefix
+}
+
+// attr returns the name of the attribute which stores the metadata.
+func (c XattrSidecar) attr() string {
+	return strings.TrimSuffix(c.prefix(), ".")
+}
+
+// provenance returns the provenance metadata of the given completed Response,
+// merged with Request.Metadata. The URL is redacted as by RedactURL.
+func provenance(resp *Response) map[string]string {
+	meta := map[string]string{
+		MetadataURL:        RedactURL(resp.Request.URL()),
+		MetadataDownloaded: resp.End.UTC().Format(time.RFC3339),
+		MetadataSize:       strconv.FormatInt(resp.Size(), 10),
+	}
+	if resp.End.IsZero() {
+		meta[MetadataDownloaded] = time.Now().UTC().Format(time.RFC3339)
+	}
+	if hresp := resp.HTTPResponse; hresp != nil {
+		if v := hresp.Header.Get("ETag"); v != "" {
+			meta[MetadataETag] = v
+		}
+		if v := hresp.Header.Get("Last-Modified"); v != "" {
+			meta[MetadataLastModified] = v
+		}
+	}
+	for k, v := range resp.Request.Metadata {
+		meta[k] = v
+	}
+	return meta
+}
+
+// moveSidecar moves the metadata stored by the given Sidecar for the file
+// from to the file to, after the file itself was renamed. Metadata which
+// moves with the file, such as that of an XattrSidecar, is left as it is.
+func moveSidecar(s Sidecar, from, to string) error {
+	meta, err := s.Read(from)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrSidecarUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.Write(to, meta); err != nil {
+		return err
+	}
+	return s.Remove(from)
+}
+
+// writeSidecar stores the provenance of a completed download using the
+// Sidecar of the client, if any.
+func (c *Client) writeSidecar(resp *Response) error {
+	if c.Sidecar == nil || resp.Request.NoStore || resp.special || resp.Filename == "" {
+		return nil
+	}
+	if err := c.Sidecar.Write(resp.Filename, provenance(resp)); err != nil {
+		return fmt.Errorf("cannot write metadata of %q: %w", resp.Filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package lib
+
+func (c XattrSidecar) Read(string) (map[string]string, error) {
+	return nil, ErrSidecarUnsupported
+}
+
+func (c XattrSidecar) Write(string, map[string]string) error {
+	return ErrSidecarUnsupported
+}
+
+func (c XattrSidecar) Remove(string) error {
+	return ErrSidecarUnsupported
+}
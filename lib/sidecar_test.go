@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSidecars(t *testing.T) {
+	for _, name := range Sidecars() {
+		t.Run(name, func(t *testing.T) {
+			sidecar, ok := LookupSidecar(name)
+			if !ok {
+				t.Fatalf("Expected sidecar %s to be registered", name)
+			}
+			filename := filepath.Join(t.TempDir(), "file.txt")
+			if err := os.WriteFile(filename, []byte("content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := sidecar.Read(filename); !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, ErrSidecarUnsupported) {
+				t.Errorf("Expected fs.ErrNotExist, got %v", err)
+			}
+			if err := sidecar.Write(filename, map[string]string{"a": "1", "b": "2"}); errors.Is(err, ErrSidecarUnsupported) {
+				t.Skipf("Sidecar not supported: %v", err)
+			} else if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			expect := map[string]string{"a": "1", "c": "3"}
+			if err := sidecar.Write(filename, expect); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			meta, err := sidecar.Read(filename)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(meta, expect) {
+				t.Errorf("Expected %v, got %v", expect, meta)
+			}
+			if err := sidecar.Remove(filename); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, err := sidecar.Read(filename); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Expected metadata to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestClient_Do_Sidecar(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "grab-sidecar-test")
+
+	testURL := "http://example.com/file.txt"
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", testURL, createMockHTTPResponse("200 OK", http.StatusOK, "content", map[string]string{
+		"ETag": `"abc"`,
+	}))
+	sidecar := JSONSidecar{Suffix: ".meta"}
+	RegisterSidecar("test", sidecar)
+	if s, ok := LookupSidecar("test"); !ok || s != sidecar {
+		t.Fatal("Expected registered sidecar")
+	}
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent", Sidecar: sidecar}
+
+	req, _ := NewRequest("file.txt", testURL)
+	req.Metadata = map[string]string{"package": "example"}
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	meta, err := sidecar.Read("file.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if meta[MetadataURL] != testURL || meta[MetadataETag] != `"abc"` || meta[MetadataSize] != "7" ||
+		meta[MetadataDownloaded] == "" || meta["package"] != "example" {
+		t.Errorf("Unexpected metadata: %v", meta)
+	}
+}
@@ -0,0 +1,129 @@
+//go:build linux || darwin
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+func (c XattrSidecar) Read(filename string) (map[string]string, error) {
+	value, err := getxattr(filename, c.attr())
+	if err == nil {
+		var meta map[string]string
+		if err := json.Unmarshal([]byte(value), &meta); err != nil {
+			return nil, fmt.Errorf("invalid metadata of %s: %v", filename, err)
+		}
+		return meta, nil
+	}
+	if !errors.Is(err, errNoXattr) {
+		return nil, err
+	}
+
+	// metadata of earlier versions, with an attribute for each key
+	names, err := c.names(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no metadata for %s: %w", filename, fs.ErrNotExist)
+	}
+	meta := make(map[string]string, len(names))
+	for _, name := range names {
+		value, err := getxattr(filename, name)
+		if err != nil {
+			return nil, err
+		}
+		meta[strings.TrimPrefix(name, c.prefix())] = value
+	}
+	return meta, nil
+}
+
+// Write replaces the metadata attribute of the file, which is atomic, and
+// removes any attributes of earlier versions.
+func (c XattrSidecar) Write(filename string, meta map[string]string) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := unix.Setxattr(filename, c.attr(), b, 0); err != nil {
+		return xattrError(err)
+	}
+	names, err := c.names(filename)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := unix.Removexattr(filename, name); err != nil {
+			return xattrError(err)
+		}
+	}
+	return nil
+}
+
+func (c XattrSidecar) Remove(filename string) error {
+	names, err := c.names(filename)
+	if err != nil {
+		return err
+	}
+	if err := unix.Removexattr(filename, c.attr()); err != nil && !errors.Is(err, errNoXattr) {
+		return xattrError(err)
+	}
+	for _, name := range names {
+		if err := unix.Removexattr(filename, name); err != nil {
+			return xattrError(err)
+		}
+	}
+	return nil
+}
+
+// names returns the names of the attributes of the given file which have the
+// prefix of the sidecar, other than the metadata attribute, as written by
+// earlier versions.
+func (c XattrSidecar) names(filename string) ([]string, error) {
+	size, err := unix.Listxattr(filename, nil)
+	if err != nil {
+		return nil, xattrError(err)
+	}
+	buf := make([]byte, size)
+	if size > 0 {
+		if size, err = unix.Listxattr(filename, buf); err != nil {
+			return nil, xattrError(err)
+		}
+	}
+	var names []string
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if s := string(name); strings.HasPrefix(s, c.prefix()) && s != c.attr() {
+			names = append(names, s)
+		}
+	}
+	return names, nil
+}
+
+// getxattr returns the value of the named attribute of the given file.
+func getxattr(filename, name string) (string, error) {
+	size, err := unix.Getxattr(filename, name, nil)
+	if err != nil {
+		return "", xattrError(err)
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Getxattr(filename, name, buf); err != nil {
+		return "", xattrError(err)
+	}
+	return string(buf[:size]), nil
+}
+
+// xattrError wraps errors of file systems without extended attributes in
+// ErrSidecarUnsupported.
+func xattrError(err error) error {
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return fmt.Errorf("%w: %v", ErrSidecarUnsupported, err)
+	}
+	return err
+}
@@ -0,0 +1,6 @@
+package lib
+
+import "golang.org/x/sys/unix"
+
+// errNoXattr is the error of reading an attribute which a file does not have.
+const errNoXattr = unix.ENODATA
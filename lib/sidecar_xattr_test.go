@@ -0,0 +1,46 @@
+//go:build linux || darwin
+
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestXattrSidecar_Legacy(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(filename, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// metadata of earlier versions has an attribute for each key
+	if err := unix.Setxattr(filename, "user.grab.url", []byte("http://example.com/file.txt"), 0); err != nil {
+		t.Skipf("Extended attributes not supported: %v", err)
+	}
+	s := XattrSidecar{}
+	meta, err := s.Read(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := map[string]string{"url": "http://example.com/file.txt"}; !reflect.DeepEqual(meta, expect) {
+		t.Errorf("Expected %v, got %v", expect, meta)
+	}
+
+	// metadata is replaced as a single attribute
+	expect := map[string]string{"etag": `"abc"`}
+	if err := s.Write(filename, expect); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := s.names(filename); err != nil || len(names) != 0 {
+		t.Errorf("Expected attributes of earlier versions to be removed, got %v (%v)", names, err)
+	}
+	if _, err := getxattr(filename, "user.grab"); err != nil {
+		t.Errorf("Expected metadata attribute, got %v", err)
+	}
+	if meta, err = s.Read(filename); err != nil || !reflect.DeepEqual(meta, expect) {
+		t.Errorf("Expected %v, got %v (%v)", expect, meta, err)
+	}
+}
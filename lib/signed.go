@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// ErrBadSignature indicates that the signature of a checksum manifest given
+// to FetchVerified, or of a file given by Request.SetSignatureURL, was not
+// made by any key of the trusted keyring.
+var ErrBadSignature = errors.New("bad signature")
+
+// fetchVerifiedWorkers is the number of artifacts which FetchVerified
+// downloads concurrently.
+const fetchVerifiedWorkers = 4
+
+// A SignatureVerifier verifies the detached signature of a checksum manifest
+// against the keys it trusts. Keyring and OpenPGPKeyring are
+// SignatureVerifiers. See FetchVerified.
+type SignatureVerifier interface {
+	// VerifySignature returns nil if sig is a signature of msg by a trusted
+	// key. Otherwise it returns an error, which wraps ErrBadSignature if the
+	// signature is valid but not made by a trusted key.
+	VerifySignature(msg, sig []byte) error
+}
+
+// A Keyring lists the Ed25519 public keys which are trusted to sign checksum
+// manifests. See FetchVerified.
+type Keyring []ed25519.PublicKey
+
+// VerifySignature implements SignatureVerifier for Ed25519 signatures, given
+// raw, base64 encoded or in the format of OpenBSD signify.
+func (k Keyring) VerifySignature(msg, sig []byte) error {
+	sig, err := parseSignature(sig)
+	if err != nil {
+		return err
+	}
+	for _, key := range k {
+		if len(key) == ed25519.PublicKeySize && ed25519.Verify(key, msg, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: not signed by a trusted key", ErrBadSignature)
+}
+
+// parseSignature returns the Ed25519 signature of a signature file, which
+// holds either the raw 64 byte signature, or its base64 encoding, or a
+// signature in the format of OpenBSD signify, whose comment line is ignored.
+func parseSignature(b []byte) ([]byte, error) {
+	if len(b) == ed25519.SignatureSize {
+		return b, nil
+	}
+	var text strings.Builder
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); !strings.HasPrefix(line, "untrusted comment:") {
+			text.WriteString(line)
+		}
+	}
+	sig, err := base64.StdEncoding.DecodeString(text.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %v", err)
+	}
+	switch {
+	case len(sig) == ed25519.SignatureSize:
+		return sig, nil
+	case len(sig) == 10+ed25519.SignatureSize && string(sig[:2]) == "Ed":
+		// signify: algorithm, key number and signature
+		return sig[10:], nil
+	}
+	return nil, fmt.Errorf("invalid signature: %d bytes", len(sig))
+}
+
+// manifestEntry is an artifact listed by a checksum manifest.
+type manifestEntry struct {
+	name string
+	sum  []byte
+}
+
+// manifestHash returns a new hash for checksums of the given size, for the
+// SHA-256 and SHA-512 checksums of signed manifests.
+func manifestHash(size int) hash.Hash {
+	switch size {
+	case sha256.Size:
+		return sha256.New()
+	case sha512.Size:
+		return sha512.New()
+	}
+	return nil
+}
+
+// parseManifest returns the artifacts listed by a checksum manifest in the
+// format written by sha256sum and sha512sum, as parsed by parseChecksums. The
+// name of each artifact must be a relative path which stays within the
+// destination directory.
+func parseManifest(b []byte) ([]manifestEntry, error) {
+	checksums, err := parseChecksums(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	var entries []manifestEntry
+	seen := make(map[string]bool)
+	for _, e := range checksums {
+		if e.name == "" {
+			return nil, fmt.Errorf("invalid manifest: missing file name for %x", e.sum)
+		}
+		if manifestHash(len(e.sum)) == nil {
+			return nil, fmt.Errorf("invalid checksum in manifest: %x", e.sum)
+		}
+		if !filepath.IsLocal(filepath.FromSlash(e.name)) {
+			return nil, fmt.Errorf("unsafe file name in manifest: %q", e.name)
+		}
+		if seen[e.name] {
+			return nil, fmt.Errorf("duplicate file name in manifest: %q", e.name)
+		}
+		seen[e.name] = true
+		entries = append(entries, manifestEntry{name: e.name, sum: e.sum})
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("manifest lists no files")
+	}
+	return entries, nil
+}
+
+// FetchVerified fetches a checksum manifest, in the format written by
+// sha256sum or sha512sum, and its detached signature, and verifies that the
+// manifest was signed by a key trusted by the verifier, such as the Ed25519
+// keys of a Keyring or the OpenPGP keys of an OpenPGPKeyring. It then
+// downloads each file listed by the manifest from the URL of the manifest to
+// the same relative path below dstDir, verifying its checksum, and returns
+// the responses of the downloads in the order of the manifest. Files which fail
+// verification are deleted.
+//
+// No file is downloaded unless the signature is valid. If any download fails,
+// the responses are returned with the error of the first failed download. If
+// ctx is canceled, the responses of files which were not started are nil.
+func (c *Client) FetchVerified(ctx context.Context, manifestURL, sigURL string, verifier SignatureVerifier, dstDir string) ([]*Response, error) {
+	manifest, base, err := c.fetchDocument(ctx, manifestURL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch manifest: %w", err)
+	}
+	sig, _, err := c.fetchDocument(ctx, sigURL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch signature: %w", err)
+	}
+	if err := verifier.VerifySignature(manifest, sig); err != nil {
+		return nil, fmt.Errorf("%s: %w", redactURLString(manifestURL), err)
+	}
+	entries, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	reqs := make([]*Request, len(entries))
+	for i, e := range entries {
+		u := base.ResolveReference(&url.URL{Path: e.name})
+		req, err := NewRequest(filepath.Join(dstDir, filepath.FromSlash(e.name)), u.String())
+		if err != nil {
+			return nil, err
+		}
+		req.SetChecksum(manifestHash(len(e.sum)), e.sum, true)
+		reqs[i] = req
+	}
+	index := make(map[string]int, len(reqs))
+	for i, req := range reqs {
+		index[req.Filename] = i
+	}
+	responses := make([]*Response, len(reqs))
+	for resp := range c.DoBatch(ctx, fetchVerifiedWorkers, reqs...) {
+		<-resp.Done
+		responses[index[resp.Request.Filename]] = resp
+	}
+	for _, resp := range responses {
+		if resp == nil {
+			return responses, ctx.Err()
+		}
+		if err := resp.Err(); err != nil {
+			return responses, err
+		}
+	}
+	return responses, nil
+}
+
+// FetchVerified fetches and verifies a signed checksum manifest and downloads
+// the files it lists using DefaultClient. See Client.FetchVerified.
+func FetchVerified(ctx context.Context, manifestURL, sigURL string, keyring Keyring, dstDir string) ([]*Response, error) {
+	return DefaultClient.FetchVerified(ctx, manifestURL, sigURL, keyring, dstDir)
+}
@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClient_FetchVerified(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	files := map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo"}
+	var manifest strings.Builder
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		sum := sha256.Sum256([]byte(files[name]))
+		fmt.Fprintf(&manifest, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+	}
+	sig := ed25519.Sign(priv, []byte(manifest.String()))
+	signify := append([]byte("Ed12345678"), sig...)
+
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/release/"), "/corrupt/")
+		switch name {
+		case "SHA256SUMS":
+			_, _ = w.Write([]byte(manifest.String()))
+		case "SHA256SUMS.sig":
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(sig)))
+		case "SHA256SUMS.signify":
+			fmt.Fprintf(w, "untrusted comment: signature\n%s\n", base64.StdEncoding.EncodeToString(signify))
+		default:
+			downloads.Add(1)
+			content := files[name]
+			if strings.HasPrefix(r.URL.Path, "/corrupt/") {
+				content = "corrupt"
+			}
+			_, _ = w.Write([]byte(content))
+		}
+	}))
+	defer server.Close()
+	base := server.URL + "/release/"
+
+	for _, sigName := range []string{"SHA256SUMS.sig", "SHA256SUMS.signify"} {
+		t.Run(sigName, func(t *testing.T) {
+			dir := t.TempDir()
+			responses, err := NewClient().FetchVerified(context.Background(), base+"SHA256SUMS", base+sigName, Keyring{otherPub, pub}, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(responses) != 2 || responses[1].Filename != filepath.Join(dir, "sub", "b.txt") {
+				t.Fatalf("Unexpected responses: %v", responses)
+			}
+			for name, content := range files {
+				if got, _ := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name))); string(got) != content {
+					t.Errorf("Expected %s to contain %q, got %q", name, content, got)
+				}
+			}
+		})
+	}
+
+	downloads.Store(0)
+	_, err := NewClient().FetchVerified(context.Background(), base+"SHA256SUMS", base+"SHA256SUMS.sig", Keyring{otherPub}, t.TempDir())
+	if !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature, got %v", err)
+	}
+	if n := downloads.Load(); n != 0 {
+		t.Errorf("Expected no downloads with a bad signature, got %d", n)
+	}
+
+	// corrupted artifacts are deleted
+	dir := t.TempDir()
+	corrupt := server.URL + "/corrupt/"
+	responses, err := NewClient().FetchVerified(context.Background(), corrupt+"SHA256SUMS", corrupt+"SHA256SUMS.sig", Keyring{pub}, dir)
+	if !errors.Is(err, ErrBadChecksum) || len(responses) != 2 {
+		t.Errorf("Expected ErrBadChecksum, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); !os.IsNotExist(err) {
+		t.Error("Expected corrupted file to be deleted")
+	}
+}
+
+func TestKeyring_VerifySignature(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	msg := []byte("manifest")
+	sig := ed25519.Sign(priv, msg)
+	var verifier SignatureVerifier = Keyring{otherPub, pub}
+	if err := verifier.VerifySignature(msg, sig); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := verifier.VerifySignature([]byte("tampered"), sig); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature for other content, got %v", err)
+	}
+	if err := verifier.VerifySignature(msg, []byte("short")); err == nil || errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected invalid signature error, got %v", err)
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	sum := strings.Repeat("ab", sha256.Size)
+	tests := []struct {
+		manifest string
+		wantErr  bool
+	}{
+		{sum + "  a.txt\n# comment\n" + sum + " *dir/b.txt\n", false},
+		{sum + "  a b.txt\n" + sum + "  dir/b.txt\n", false},
+		{sum + "  a.txt\n" + sum + "\n", true},
+		{sum + "  ../etc/passwd\n", true},
+		{sum + "  /etc/passwd\n", true},
+		{sum + "  a.txt\n" + sum + "  a.txt\n", true},
+		{strings.Repeat("ab", 16) + "  a.txt\n", true},
+		{"", true},
+	}
+	for _, test := range tests {
+		entries, err := parseManifest([]byte(test.manifest))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: expected error %v, got %v", test.manifest, test.wantErr, err)
+		}
+		if err == nil && (len(entries) != 2 || entries[1].name != "dir/b.txt") {
+			t.Errorf("%q: unexpected entries %v", test.manifest, entries)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxSitemapDepth is the maximum nesting of sitemap indexes followed by
+// Client.Sitemap, guarding against indexes which refer to each other.
+const maxSitemapDepth = 4
+
+// ErrSitemapDepth indicates that sitemap indexes were nested too deeply.
+var ErrSitemapDepth = errors.New("sitemap indexes nested too deeply")
+
+// A SitemapURL is a page or file listed in a sitemap.
+type SitemapURL struct {
+	// Loc is the absolute URL of the page or file.
+	Loc string
+
+	// LastMod is the time at which the page or file was last modified, or the
+	// zero time if unknown.
+	LastMod time.Time
+}
+
+// sitemapDocument matches both a sitemap <urlset> and a sitemap index, as only
+// the elements of the document type in use are present.
+type sitemapDocument struct {
+	URLs []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// ParseSitemap reads a sitemap or sitemap index, as defined by
+// sitemaps.org, and returns the URLs it lists and the URLs of any sitemaps it
+// refers to. Gzip compressed sitemaps are decompressed. Relative URLs are
+// resolved against base, which may be nil.
+func ParseSitemap(r io.Reader, base *url.URL) (urls []SitemapURL, sitemaps []string, err error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing sitemap: %w", err)
+		}
+		defer func() {
+			_ = zr.Close()
+		}()
+		r = zr
+	} else {
+		r = br
+	}
+	var doc sitemapDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("error parsing sitemap: %w", err)
+	}
+	for _, u := range doc.URLs {
+		loc, ok := resolveURL(base, u.Loc)
+		if !ok {
+			continue
+		}
+		urls = append(urls, SitemapURL{
+			Loc:     loc,
+			LastMod: parseSitemapTime(u.LastMod),
+		})
+	}
+	for _, s := range doc.Sitemaps {
+		if loc, ok := resolveURL(base, s.Loc); ok {
+			sitemaps = append(sitemaps, loc)
+		}
+	}
+	return urls, sitemaps, nil
+}
+
+// Sitemap downloads the sitemap at the given URL and returns all URLs it
+// lists. If it is a sitemap index, the sitemaps it refers to are downloaded in
+// turn and their URLs returned in order. Each URL is returned once.
+func (c *Client) Sitemap(ctx context.Context, urlStr string) ([]SitemapURL, error) {
+	var urls []SitemapURL
+	seen := make(map[string]bool)
+	visited := make(map[string]bool)
+	var visit func(urlStr string, depth int) error
+	visit = func(urlStr string, depth int) error {
+		if depth > maxSitemapDepth {
+			return ErrSitemapDepth
+		}
+		if visited[urlStr] {
+			return nil
+		}
+		visited[urlStr] = true
+		b, base, err := c.fetchDocument(ctx, urlStr)
+		if err != nil {
+			return err
+		}
+		found, sitemaps, err := ParseSitemap(bytes.NewReader(b), base)
+		if err != nil {
+			return err
+		}
+		for _, u := range found {
+			if !seen[u.Loc] {
+				seen[u.Loc] = true
+				urls = append(urls, u)
+			}
+		}
+		for _, s := range sitemaps {
+			if err := visit(s, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(urlStr, 0); err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+// parseSitemapTime parses the W3C datetime formats permitted for <lastmod>,
+// returning the zero time if s cannot be parsed.
+func parseSitemapTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
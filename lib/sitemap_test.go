@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSitemapIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>http://example.com/sitemap-docs.xml</loc></sitemap>
+  <sitemap><loc>/sitemap-files.xml.gz</loc></sitemap>
+</sitemapindex>`
+
+const testSitemapDocs = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://example.com/docs/</loc><lastmod>2006-01-02</lastmod></url>
+  <url><loc>http://example.com/docs/install.html</loc><lastmod>2006-01-02T15:04:05Z</lastmod></url>
+</urlset>`
+
+const testSitemapFiles = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://example.com/files/release.tar.gz</loc></url>
+  <url><loc>http://example.com/docs/</loc></url>
+</urlset>`
+
+func gzipString(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestParseSitemap(t *testing.T) {
+	urls, sitemaps, err := ParseSitemap(strings.NewReader(testSitemapDocs), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(sitemaps) != 0 {
+		t.Errorf("Expected no sitemaps, got %v", sitemaps)
+	}
+	expect := []SitemapURL{
+		{Loc: "http://example.com/docs/", LastMod: time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{Loc: "http://example.com/docs/install.html", LastMod: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+	}
+	if len(urls) != len(expect) {
+		t.Fatalf("Expected %v, got %v", expect, urls)
+	}
+	for i, u := range urls {
+		if u.Loc != expect[i].Loc || !u.LastMod.Equal(expect[i].LastMod) {
+			t.Errorf("Expected %v, got %v", expect[i], u)
+		}
+	}
+}
+
+func TestParseSitemap_Gzip(t *testing.T) {
+	urls, _, err := ParseSitemap(strings.NewReader(gzipString(t, testSitemapFiles)), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(urls) != 2 || urls[0].Loc != "http://example.com/files/release.tar.gz" {
+		t.Errorf("Expected URLs of compressed sitemap, got %v", urls)
+	}
+}
+
+func TestClient_Sitemap_Index(t *testing.T) {
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", "http://example.com/sitemap.xml", createSuccessResponse(testSitemapIndex))
+	mockClient.addResponse("GET", "http://example.com/sitemap-docs.xml", createSuccessResponse(testSitemapDocs))
+	mockClient.addResponse("GET", "http://example.com/sitemap-files.xml.gz", createSuccessResponse(gzipString(t, testSitemapFiles)))
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+	urls, err := client.Sitemap(context.Background(), "http://example.com/sitemap.xml")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := []string{
+		"http://example.com/docs/",
+		"http://example.com/docs/install.html",
+		"http://example.com/files/release.tar.gz",
+	}
+	if len(urls) != len(expect) {
+		t.Fatalf("Expected %v, got %v", expect, urls)
+	}
+	for i, u := range urls {
+		if u.Loc != expect[i] {
+			t.Errorf("Expected %q, got %q", expect[i], u.Loc)
+		}
+	}
+}
+
+func TestClient_Sitemap_Depth(t *testing.T) {
+	mockClient := newMockHTTPClient()
+	for i := 0; i <= maxSitemapDepth; i++ {
+		index := `<sitemapindex><sitemap><loc>http://example.com/sitemap-` + string(rune('a'+i+1)) + `.xml</loc></sitemap></sitemapindex>`
+		mockClient.addResponse("GET", "http://example.com/sitemap-"+string(rune('a'+i))+".xml", createSuccessResponse(index))
+	}
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+	_, err := client.Sitemap(context.Background(), "http://example.com/sitemap-a.xml")
+	if !errors.Is(err, ErrSitemapDepth) {
+		t.Errorf("Expected ErrSitemapDepth, got %v", err)
+	}
+}
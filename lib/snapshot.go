@@ -0,0 +1,99 @@
+package lib
+
+import "time"
+
+// Snapshot describes the progress of a file transfer at a point in time.
+type Snapshot struct {
+	// BytesComplete is the number of bytes which have been copied to the
+	// destination, including any bytes resumed from a previous download.
+	BytesComplete int64
+
+	// Size is the total size of the file transfer, or -1 if it is unknown.
+	Size int64
+
+	// SizeEstimated indicates that Size was not reported by the remote server
+	// but was given by Request.Size or estimated by Request.EstimateSize.
+	SizeEstimated bool
+
+	// Progress is the ratio of Size which has been downloaded, or -1 if Size
+	// is unknown. An estimated Size may be exceeded, in which case Progress
+	// is greater than 1.
+	Progress float64
+
+	// BytesPerSecond is the current transfer rate, as measured by
+	// Response.BytesPerSecond.
+	BytesPerSecond float64
+
+	// Elapsed is the time since the download started.
+	Elapsed time.Duration
+
+	// ETA is the estimated time at which the transfer completes, at the
+	// current rate, or zero if Size is unknown or nothing is transferring.
+	// If the size is estimated, so is the ETA.
+	ETA time.Time
+}
+
+// Percent returns Progress as a percentage, or -1 if Size is unknown.
+func (s Snapshot) Percent() float64 {
+	if s.Progress < 0 {
+		return -1
+	}
+	return s.Progress * 100
+}
+
+// Remaining returns the estimated time until the transfer completes, or zero
+// if the ETA is unknown.
+func (s Snapshot) Remaining() time.Duration {
+	if s.ETA.IsZero() {
+		return 0
+	}
+	return max(time.Until(s.ETA), 0)
+}
+
+// SizeKnown returns true if the total size of the transfer is known or
+// estimated.
+func (s Snapshot) SizeKnown() bool {
+	return s.Size >= 0
+}
+
+// Snapshot returns the current progress, rate and estimated completion time
+// of the transfer, so that progress displays need not compute them from the
+// counters of the Response.
+//
+// For responses without a Content-Length, such as those using chunked
+// transfer encoding, the size is taken from Request.Size if set, or else from
+// Request.EstimateSize. If neither is available, Size and Progress are -1
+// rather than zero, so that an unknown total can be told apart from an empty
+// file.
+func (c *Response) Snapshot() Snapshot {
+	s := Snapshot{
+		BytesComplete:  c.BytesComplete(),
+		Size:           c.Size(),
+		Progress:       -1,
+		BytesPerSecond: c.BytesPerSecond(),
+		Elapsed:        c.Duration(),
+	}
+	if s.Size < 0 {
+		if c.Request.Size > 0 {
+			s.Size = c.Request.Size
+			s.SizeEstimated = true
+		} else if f := c.Request.EstimateSize; f != nil {
+			if n := f(c); n > 0 {
+				s.Size = n
+				s.SizeEstimated = true
+			}
+		}
+	}
+	switch {
+	case s.Size > 0:
+		s.Progress = float64(s.BytesComplete) / float64(s.Size)
+	case s.Size == 0:
+		s.Progress = 1
+	}
+	if c.IsComplete() {
+		s.ETA = c.End
+	} else if s.Size >= 0 {
+		s.ETA = eta(s.Size-s.BytesComplete, s.BytesPerSecond)
+	}
+	return s
+}
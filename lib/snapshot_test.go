@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponse_Snapshot(t *testing.T) {
+	tests := []struct {
+		name           string
+		size           int64
+		requestSize    int64
+		estimate       int64
+		expectSize     int64
+		expectEstimate bool
+		expectProgress float64
+	}{
+		{name: "known size", size: 20, expectSize: 20, expectProgress: 0.25},
+		{name: "unknown size", size: -1, expectSize: -1, expectProgress: -1},
+		{name: "request size", size: -1, requestSize: 10, expectSize: 10, expectEstimate: true, expectProgress: 0.5},
+		{name: "estimated size", size: -1, estimate: 4, expectSize: 4, expectEstimate: true, expectProgress: 1.25},
+		{name: "no estimate", size: -1, estimate: 0, expectSize: -1, expectProgress: -1},
+		{name: "empty file", size: 0, expectSize: 0, expectProgress: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := NewRequest("", "http://example.com/file.txt")
+			req.Size = tt.requestSize
+			if tt.estimate != 0 || tt.name == "no estimate" {
+				req.EstimateSize = func(*Response) int64 { return tt.estimate }
+			}
+			resp := &Response{Request: req, sizeUnsafe: tt.size, bytesResumed: 5}
+			if tt.size == 0 {
+				resp.bytesResumed = 0
+			}
+
+			s := resp.Snapshot()
+			if s.Size != tt.expectSize {
+				t.Errorf("Expected size %d, got %d", tt.expectSize, s.Size)
+			}
+			if s.SizeKnown() != (tt.expectSize >= 0) {
+				t.Errorf("Expected SizeKnown to be %v", tt.expectSize >= 0)
+			}
+			if s.SizeEstimated != tt.expectEstimate {
+				t.Errorf("Expected SizeEstimated to be %v", tt.expectEstimate)
+			}
+			if s.Progress != tt.expectProgress {
+				t.Errorf("Expected progress %v, got %v", tt.expectProgress, s.Progress)
+			}
+		})
+	}
+}
+
+func TestResponse_SnapshotRate(t *testing.T) {
+	content := strings.Repeat("x", 300000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.BufferSize = 4096
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file.bin"), server.URL)
+	req.RateLimiter = NewRateLimiter(100000)
+	resp := client.Do(req)
+	time.Sleep(600 * time.Millisecond)
+	s := resp.Snapshot()
+	if s.BytesPerSecond <= 0 || s.Elapsed < 500*time.Millisecond {
+		t.Errorf("Expected a transfer rate after %v, got %v", s.Elapsed, s.BytesPerSecond)
+	}
+	if s.ETA.Before(time.Now()) || s.Remaining() <= 0 || s.Remaining() > 5*time.Second {
+		t.Errorf("Expected an ETA within 5s, got %v", s.Remaining())
+	}
+	if p := s.Percent(); p <= 0 || p >= 100 {
+		t.Errorf("Expected a percentage between 0 and 100, got %v", p)
+	}
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if s := resp.Snapshot(); !s.ETA.Equal(resp.End) || s.Remaining() != 0 {
+		t.Errorf("Expected the ETA of a complete transfer to be its end, got %v", s.ETA)
+	}
+}
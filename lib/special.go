@@ -0,0 +1,16 @@
+package lib
+
+import "os"
+
+// specialModes are the file modes of destinations which are written as a
+// stream rather than stored as a regular file.
+const specialModes = os.ModeNamedPipe | os.ModeDevice | os.ModeCharDevice | os.ModeSocket
+
+// isSpecialFile reports whether the given destination is a named pipe or
+// device, such as one created by mkfifo or /dev/null. Such destinations are
+// opened for writing without being created, truncated or sought, and the
+// download is written to them from start to end once. They are never resumed,
+// retried mid-transfer, renamed, removed or given metadata.
+func isSpecialFile(fi os.FileInfo) bool {
+	return fi.Mode()&specialModes != 0
+}
@@ -0,0 +1,91 @@
+//go:build unix
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClient_Do_NamedPipe(t *testing.T) {
+	content := make([]byte, 100000)
+	rand.New(rand.NewSource(8)).Read(content)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	sum := sha256.Sum256(content)
+	for _, test := range []struct {
+		name    string
+		sum     []byte
+		wantErr error
+	}{
+		{"match", sum[:], nil},
+		{"mismatch", make([]byte, sha256.Size), ErrBadChecksum},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "pipe")
+			if err := syscall.Mkfifo(filename, 0600); err != nil {
+				t.Skip(err)
+			}
+			got := make(chan []byte)
+			go func() {
+				f, err := os.Open(filename)
+				if err != nil {
+					got <- nil
+					return
+				}
+				defer f.Close()
+				b, _ := io.ReadAll(f)
+				got <- b
+			}()
+
+			client := NewClient()
+			client.ResumeState = true
+			req, _ := NewRequest(filename, server.URL+"/file.bin")
+			req.SetChecksum(sha256.New(), test.sum, true)
+			resp := client.Do(req)
+			if err := resp.Err(); err != test.wantErr {
+				t.Errorf("Expected error %v, got %v", test.wantErr, err)
+			}
+			if b := <-got; !bytes.Equal(b, content) {
+				t.Errorf("Expected %d bytes from the pipe, got %d", len(content), len(b))
+			}
+			if fi, err := os.Stat(filename); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+				t.Errorf("Expected the pipe to be kept, got %v", err)
+			}
+			matches, _ := filepath.Glob(filename + ".*")
+			if len(matches) > 0 {
+				t.Errorf("Expected no files next to the pipe, got %q", matches)
+			}
+		})
+	}
+}
+
+func TestClient_Do_Device(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Last-Modified", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	req, _ := NewRequest(os.DevNull, server.URL)
+	resp := NewClient().Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if resp.DidResume || resp.BytesComplete() != 7 {
+		t.Errorf("Expected 7 bytes written from the start, got %d", resp.BytesComplete())
+	}
+}
@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// speedSampleInterval is the interval at which SpeedTest samples the number of
+// bytes transferred.
+const speedSampleInterval = 100 * time.Millisecond
+
+// SpeedResult describes the throughput measured by Client.SpeedTest.
+type SpeedResult struct {
+	// Latency is the time from sending the request until the response
+	// headers were received, including DNS resolution, connection setup and
+	// any redirects.
+	Latency time.Duration
+
+	// Bytes is the number of bytes received.
+	Bytes int64
+
+	// Duration is the time spent receiving the response body.
+	Duration time.Duration
+
+	// Average is the mean transfer rate in bytes per second over Duration.
+	Average float64
+
+	// Sustained is the mean transfer rate in bytes per second after the first
+	// second of the transfer, excluding connection ramp-up. It equals Average
+	// if the transfer lasted less than two seconds.
+	Sustained float64
+
+	// Peak is the highest transfer rate in bytes per second measured over
+	// any one second window.
+	Peak float64
+}
+
+func (r *SpeedResult) String() string {
+	return fmt.Sprintf("latency %v, %s in %v, avg %s/s, sustained %s/s, peak %s/s",
+		r.Latency.Round(time.Millisecond),
+		formatBytes(float64(r.Bytes)),
+		r.Duration.Round(time.Millisecond),
+		formatBytes(r.Average),
+		formatBytes(r.Sustained),
+		formatBytes(r.Peak))
+}
+
+// SpeedTest measures the throughput of downloading the given URL for at most
+// the given duration. The content is discarded rather than stored. The
+// transfer ending early, because the file is smaller than could be received in
+// the given duration, is not an error.
+func (c *Client) SpeedTest(ctx context.Context, urlStr string, d time.Duration) (*SpeedResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	req, err := NewRequest("speedtest", urlStr)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.NoStore = true
+	req.SingleRequest = true
+	req.IgnoreRemoteChecksum = true
+	req.writer = func(*Response) (io.Writer, error) {
+		return io.Discard, nil
+	}
+
+	start := time.Now()
+	resp := c.Do(req)
+	result := &SpeedResult{Latency: time.Since(start)}
+
+	// sample progress until the transfer completes
+	samples := []int64{resp.BytesComplete()}
+	t := time.NewTicker(speedSampleInterval)
+	defer t.Stop()
+	bodyStart := time.Now()
+	for done := false; !done; {
+		select {
+		case <-resp.Done:
+			done = true
+		case <-t.C:
+			samples = append(samples, resp.BytesComplete())
+		}
+	}
+	result.Duration = time.Since(bodyStart)
+	result.Bytes = resp.BytesComplete()
+	if err := resp.Err(); err != nil && (resp.HTTPResponse == nil || !errors.Is(err, context.DeadlineExceeded)) {
+		return result, err
+	}
+
+	secs := result.Duration.Seconds()
+	if secs > 0 {
+		result.Average = float64(result.Bytes) / secs
+	}
+	result.Sustained = result.Average
+	window := int(time.Second / speedSampleInterval)
+	if len(samples) > 2*window && secs > 1 {
+		result.Sustained = float64(result.Bytes-samples[window]) / (secs - 1)
+	}
+	for i := window; i < len(samples); i++ {
+		if bps := float64(samples[i] - samples[i-window]); bps > result.Peak {
+			result.Peak = bps
+		}
+	}
+	if result.Peak < result.Average {
+		// too short for a full window
+		result.Peak = result.Average
+	}
+	return result, nil
+}
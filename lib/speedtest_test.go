@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_SpeedTest(t *testing.T) {
+	testURL := "http://example.com/"
+	content := strings.Repeat("x", 64*1024)
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", testURL, createSuccessResponse(content))
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+	result, err := client.SpeedTest(context.Background(), testURL, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Bytes != int64(len(content)) {
+		t.Errorf("Expected %d bytes, got %d", len(content), result.Bytes)
+	}
+	if result.Average <= 0 || result.Peak < result.Average {
+		t.Errorf("Expected positive average and peak rates, got %+v", result)
+	}
+}
+
+func TestClient_SpeedTest_Deadline(t *testing.T) {
+	testURL := "http://example.com/"
+	resp := createSuccessResponse("")
+	resp.Body = io.NopCloser(&slowReader{n: 1 << 20, delay: 10 * time.Millisecond})
+	resp.ContentLength = 1 << 20
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", testURL, resp)
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent", BufferSize: 1024}
+
+	result, err := client.SpeedTest(context.Background(), testURL, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected the deadline to end the test without error, got %v", err)
+	}
+	if result.Bytes == 0 || result.Bytes >= 1<<20 {
+		t.Errorf("Expected a partial transfer, got %d bytes", result.Bytes)
+	}
+	if result.Duration > time.Second {
+		t.Errorf("Expected the test to stop at its deadline, took %v", result.Duration)
+	}
+}
+
+// slowReader returns n zero bytes, sleeping before each read.
+type slowReader struct {
+	n     int
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := min(len(p), r.n)
+	clear(p[:n])
+	r.n -= n
+	return n, nil
+}
@@ -0,0 +1,186 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrBatchAborted indicates that a file of an atomic batch was downloaded
+// successfully, but discarded because another file of the batch failed.
+var ErrBatchAborted = errors.New("batch aborted: another file in the batch failed")
+
+const (
+	// stageSuffix is appended to the name of a staged file of an atomic batch.
+	stageSuffix = ".grab-stage"
+
+	// backupSuffix is appended to the name of an existing file while it is
+	// replaced by a file of an atomic batch.
+	backupSuffix = ".grab-backup"
+)
+
+// stagePath returns the hidden path next to the given destination path at
+// which a file of an atomic batch is staged, so that it is renamed into place
+// on the same file system.
+func stagePath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+stageSuffix)
+}
+
+// staging records the destination of each staged file of an atomic batch.
+type staging struct {
+	mu      sync.Mutex
+	paths   map[*Response]string
+	sidecar Sidecar // moves the metadata of staged files, if set
+}
+
+// hook returns a BeforeStore hook which calls the given hook, if any, and
+// stages the file at the resulting path. As the staged file is new, a
+// Request with SkipExisting fails with ErrFileExists here if its destination
+// exists.
+func (s *staging) hook(f StoreHook) StoreHook {
+	return func(resp *Response, path string) (string, error) {
+		if f != nil {
+			var err error
+			if path, err = f(resp, path); err != nil {
+				return "", err
+			}
+		}
+		if resp.Request.SkipExisting {
+			if _, err := os.Lstat(path); err == nil {
+				resp.logf("destination %s exists, skipping", path)
+				return "", ErrFileExists
+			}
+		}
+		s.mu.Lock()
+		s.paths[resp] = path
+		s.mu.Unlock()
+		return stagePath(path), nil
+	}
+}
+
+// doBatchAtomic executes the given requests like DoBatchWithOptions, staging
+// each file next to its destination. Once all transfers have completed, the
+// staged files are moved into place if all of them succeeded, or discarded
+// otherwise, before any response is sent.
+func (c *Client) doBatchAtomic(ctx context.Context, opts BatchOptions, requests ...*Request) <-chan *Response {
+	s := &staging{paths: make(map[*Response]string), sidecar: c.Sidecar}
+	staged := make([]*Request, len(requests))
+	for i, req := range requests {
+		staged[i] = req.WithContext(req.Context())
+		staged[i].BeforeStore = s.hook(req.BeforeStore)
+		staged[i].staged = true
+	}
+	opts.Atomic = false
+
+	respch := make(chan *Response, len(requests))
+	go func() {
+		var responses []*Response
+		for resp := range c.doBatchWithOptions(ctx, opts, staged...) {
+			<-resp.finished
+			responses = append(responses, resp)
+		}
+		// the outcome of each response is final once it is released
+		s.finish(responses, len(responses) == len(requests))
+		for _, resp := range responses {
+			releaseResponse(resp)
+			respch <- resp
+		}
+		close(respch)
+	}()
+	return respch
+}
+
+// finish moves all staged files into place if ok is true and every transfer
+// succeeded or was skipped, or discards them and their metadata otherwise. If
+// a staged file cannot be moved into place, any files already moved are
+// restored to their previous state.
+func (s *staging) finish(responses []*Response, ok bool) {
+	for _, resp := range responses {
+		if resp.err != nil && !errors.Is(resp.err, ErrFileExists) {
+			ok = false
+		}
+	}
+	if ok {
+		err := s.commit(responses)
+		if err == nil {
+			return
+		}
+		for _, resp := range responses {
+			resp.err = fmt.Errorf("%w: %v", ErrBatchAborted, err)
+		}
+	}
+	for _, resp := range responses {
+		if _, staged := s.paths[resp]; !staged {
+			continue
+		}
+		if err := os.Remove(resp.Filename); err != nil && !os.IsNotExist(err) && resp.err == nil {
+			resp.err = err
+			continue
+		}
+		if s.sidecar != nil {
+			_ = s.sidecar.Remove(resp.Filename)
+		}
+		if resp.err == nil {
+			resp.err = ErrBatchAborted
+		}
+	}
+}
+
+// commit renames the staged file of each response to its destination,
+// keeping a backup of any existing file until all files are in place, and
+// then moves the metadata of the staged files.
+func (s *staging) commit(responses []*Response) error {
+	type move struct {
+		resp   *Response
+		path   string
+		backup bool
+	}
+	var moved []move
+	var err error
+	for _, resp := range responses {
+		path, staged := s.paths[resp]
+		if !staged {
+			continue
+		}
+		m := move{resp: resp, path: path}
+		if _, err = os.Stat(path); err == nil {
+			if err = os.Rename(path, path+backupSuffix); err != nil {
+				break
+			}
+			m.backup = true
+		}
+		if err = os.Rename(resp.Filename, path); err != nil {
+			if m.backup {
+				_ = os.Rename(path+backupSuffix, path)
+			}
+			break
+		}
+		moved = append(moved, m)
+	}
+	if err != nil {
+		// restore the previous state
+		for _, m := range moved {
+			_ = os.Rename(m.path, m.resp.Filename)
+			if m.backup {
+				_ = os.Rename(m.path+backupSuffix, m.path)
+			}
+		}
+		return err
+	}
+	for _, m := range moved {
+		staged := m.resp.Filename
+		m.resp.Filename = m.path
+		if m.backup {
+			_ = os.Remove(m.path + backupSuffix)
+		}
+		if s.sidecar != nil {
+			if err := moveSidecar(s.sidecar, staged, m.path); err != nil {
+				m.resp.err = fmt.Errorf("cannot move metadata of %q: %w", m.path, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listDir returns the names of the files in the given directory.
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestClient_DoBatchWithOptions_Atomic(t *testing.T) {
+	tests := []struct {
+		name      string
+		fail      bool
+		expectOld bool
+	}{
+		{"commit", false, false},
+		{"discard", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			existing := filepath.Join(dir, "a.txt")
+			if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			mockClient := newMockHTTPClient()
+			if tt.fail {
+				mockClient.addResponse("GET", "http://example.com/c.txt", createErrorResponse(404, "Not Found"))
+			}
+			client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+			var reqs []*Request
+			for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+				req, _ := NewRequest(filepath.Join(dir, name), "http://example.com/"+name)
+				reqs = append(reqs, req)
+			}
+
+			var responses []*Response
+			for resp := range client.DoBatchWithOptions(context.Background(), BatchOptions{Atomic: true}, reqs...) {
+				responses = append(responses, resp)
+			}
+			if len(responses) != len(reqs) {
+				t.Fatalf("Expected %d responses, got %d", len(reqs), len(responses))
+			}
+
+			for _, resp := range responses {
+				err := resp.Err()
+				switch {
+				case !tt.fail && err != nil:
+					t.Errorf("Unexpected error for %s: %v", resp.Request.URL(), err)
+				case !tt.fail && filepath.Dir(resp.Filename) != dir:
+					t.Errorf("Expected committed filename in %s, got %s", dir, resp.Filename)
+				case tt.fail && filepath.Base(resp.Request.URL().Path) == "c.txt" && !IsStatusCodeError(err):
+					t.Errorf("Expected StatusCodeError for failed file, got %v", err)
+				case tt.fail && filepath.Base(resp.Request.URL().Path) != "c.txt" && !errors.Is(err, ErrBatchAborted):
+					t.Errorf("Expected ErrBatchAborted, got %v", err)
+				}
+			}
+
+			expect := []string{"a.txt", "b.txt", "c.txt"}
+			if tt.fail {
+				expect = []string{"a.txt"}
+			}
+			if names := listDir(t, dir); len(names) != len(expect) {
+				t.Errorf("Expected files %v, got %v", expect, names)
+			}
+			b, _ := os.ReadFile(existing)
+			if got := string(b) == "old"; got != tt.expectOld {
+				t.Errorf("Expected existing file replaced: %v, got content %q", !tt.expectOld, b)
+			}
+		})
+	}
+}
+
+func TestClient_DoBatchWithOptions_AtomicSidecar(t *testing.T) {
+	dir := t.TempDir()
+	client := &Client{HTTPClient: newMockHTTPClient(), UserAgent: "test-agent", Sidecar: JSONSidecar{}}
+	var reqs []*Request
+	for _, name := range []string{"a.txt", "b.txt"} {
+		req, _ := NewRequest(filepath.Join(dir, name), "http://example.com/"+name+"?token=secret")
+		reqs = append(reqs, req)
+	}
+	for resp := range client.DoBatchWithOptions(context.Background(), BatchOptions{Atomic: true}, reqs...) {
+		if err := resp.Err(); err != nil {
+			t.Errorf("Unexpected error for %s: %v", resp.Request.URL(), err)
+		}
+	}
+
+	// the metadata moves with the staged files
+	expect := []string{"a.txt", "a.txt.grab.json", "b.txt", "b.txt.grab.json"}
+	if names := listDir(t, dir); !reflect.DeepEqual(names, expect) {
+		t.Errorf("Expected files %v, got %v", expect, names)
+	}
+	meta, err := JSONSidecar{}.Read(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta[MetadataURL] != "http://example.com/a.txt" {
+		t.Errorf("Expected redacted URL in metadata, got %s", meta[MetadataURL])
+	}
+	fi, err := os.Stat(filepath.Join(dir, "a.txt.grab.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Errorf("Expected metadata file mode 0600, got %v", mode)
+	}
+}
+
+func TestClient_DoBatchWithOptions_AtomicDone(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{HTTPClient: newMockHTTPClient(), UserAgent: "test-agent", Sidecar: JSONSidecar{}}
+	var mu sync.Mutex
+	done := make(map[string]string)
+	var reqs []*Request
+	for _, name := range []string{"a.txt", "b.txt"} {
+		req, _ := NewRequest(filepath.Join(dir, name), "http://example.com/"+name)
+		req.SkipExisting = true
+		req.OnDone = func(resp *Response) {
+			mu.Lock()
+			defer mu.Unlock()
+			done[name] = resp.Filename
+		}
+		reqs = append(reqs, req)
+	}
+	for resp := range client.DoBatchWithOptions(context.Background(), BatchOptions{Atomic: true}, reqs...) {
+		err := resp.Err()
+		switch filepath.Base(resp.Request.URL().Path) {
+		case "a.txt":
+			if !errors.Is(err, ErrFileExists) {
+				t.Errorf("Expected ErrFileExists for existing file, got %v", err)
+			}
+		default:
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			// the response is complete once the file is in place
+			if resp.Filename != filepath.Join(dir, "b.txt") {
+				t.Errorf("Expected committed filename, got %s", resp.Filename)
+			}
+		}
+	}
+	if b, _ := os.ReadFile(existing); string(b) != "old" {
+		t.Errorf("Expected existing file to be skipped, got %q", b)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		got := done["b.txt"]
+		mu.Unlock()
+		if got != "" || time.Now().After(deadline) {
+			if got != filepath.Join(dir, "b.txt") {
+				t.Errorf("Expected OnDone with committed filename, got %q", got)
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClient_DoBatchWithOptions_AtomicDiscardSidecar(t *testing.T) {
+	dir := t.TempDir()
+	mockClient := newMockHTTPClient()
+	mockClient.addResponse("GET", "http://example.com/b.txt", createErrorResponse(404, "Not Found"))
+	client := &Client{HTTPClient: mockClient, UserAgent: "test-agent", Sidecar: JSONSidecar{}}
+	var reqs []*Request
+	for _, name := range []string{"a.txt", "b.txt"} {
+		req, _ := NewRequest(filepath.Join(dir, name), "http://example.com/"+name)
+		reqs = append(reqs, req)
+	}
+	for range client.DoBatchWithOptions(context.Background(), BatchOptions{Atomic: true}, reqs...) {
+	}
+	// neither the staged files nor their metadata are left behind
+	if names := listDir(t, dir); len(names) != 0 {
+		t.Errorf("Expected no files, got %v", names)
+	}
+}
+
+func TestStaging_CommitRollback(t *testing.T) {
+	dir := t.TempDir()
+	s := &staging{paths: make(map[*Response]string)}
+	var responses []*Response
+	for _, name := range []string{"a.txt", "b.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		resp := &Response{Filename: stagePath(path)}
+		if err := os.WriteFile(resp.Filename, []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		s.paths[resp] = path
+		responses = append(responses, resp)
+	}
+	// the second staged file cannot be moved into place
+	if err := os.Remove(responses[1].Filename); err != nil {
+		t.Fatal(err)
+	}
+
+	s.finish(responses, true)
+	for _, resp := range responses {
+		if !errors.Is(resp.err, ErrBatchAborted) {
+			t.Errorf("Expected ErrBatchAborted, got %v", resp.err)
+		}
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if b, _ := os.ReadFile(filepath.Join(dir, name)); string(b) != "old" {
+			t.Errorf("Expected %s to be restored, got %q", name, b)
+		}
+	}
+	if names := listDir(t, dir); len(names) != 2 {
+		t.Errorf("Expected staged and backup files to be removed, got %v", names)
+	}
+}
@@ -0,0 +1,228 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultStandbyInterval is the default interval at which the connection to a
+// standby mirror is kept warm, shorter than the idle timeout of most servers.
+const defaultStandbyInterval = 30 * time.Second
+
+// A standby keeps a warm connection to a secondary mirror while a large file
+// is transferred from another URL, so that if the transfer fails, it can be
+// resumed from the mirror without the delay of a new connection and TLS
+// handshake. The connection is kept in the idle pool of the HTTPClient by
+// sending a HEAD request to the mirror at regular intervals.
+type standby struct {
+	mu  sync.Mutex
+	url *url.URL // the warm mirror, or nil
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startStandby starts keeping a standby mirror warm during the transfer of
+// the Response, if enabled by Client.StandbyThreshold and the transfer can be
+// resumed from another mirror. It returns nil otherwise.
+func (c *Client) startStandby(resp *Response) *standby {
+	req := resp.Request
+	if c.StandbyThreshold <= 0 || resp.Size() < c.StandbyThreshold || len(resp.mirrors) < 2 ||
+		resp.standbyFailovers >= len(resp.mirrors) || req.NoStore || req.NoResume || req.hasRange ||
+		resp.singleRequest || resp.special || len(req.Filters) > 0 {
+		return nil
+	}
+
+	// candidates are all URLs other than the one transferring
+	current := req.URL().String()
+	active := resp.mirrors[max(resp.mirrorNext-1, 0)]
+	var candidates []string
+	for _, m := range resp.mirrors {
+		if m != current && m != active {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	s := &standby{cancel: cancel, done: make(chan struct{})}
+	go s.run(ctx, c, resp, req.HTTPRequest.Clone(ctx), candidates)
+	return s
+}
+
+// run warms a connection to the first of the candidate URLs which accepts
+// ranged requests for the file, and keeps it warm until canceled.
+func (s *standby) run(ctx context.Context, c *Client, resp *Response, hreq *http.Request, candidates []string) {
+	defer close(s.done)
+	interval := c.StandbyInterval
+	if interval <= 0 {
+		interval = defaultStandbyInterval
+	}
+	size := resp.Size()
+	for {
+		var warm *url.URL
+		for _, m := range candidates {
+			if u, err := c.warmStandby(hreq, m, size); err == nil {
+				warm = u
+				break
+			}
+		}
+		s.mu.Lock()
+		if warm != nil && (s.url == nil || s.url.String() != warm.String()) {
+			resp.logf("warm standby mirror: %s", RedactURL(warm))
+		}
+		s.url = warm
+		s.mu.Unlock()
+
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// warmStandby sends a HEAD request for the file of the given request to a
+// mirror, leaving an idle connection to it in the pool of the HTTPClient, and
+// returns the URL of the mirror if it serves a file of the given size with
+// support for ranged requests.
+func (c *Client) warmStandby(req *http.Request, mirror string, size int64) (*url.URL, error) {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return nil, err
+	}
+	hreq := req.Clone(req.Context())
+	hreq.Method = http.MethodHead
+	hreq.URL = u
+	hreq.Host = u.Host
+	hreq.Header.Del("Range")
+	hresp, err := c.doHTTPRequest(hreq)
+	if err != nil {
+		return nil, err
+	}
+	_ = hresp.Body.Close()
+	switch {
+	case hresp.StatusCode != http.StatusOK:
+		return nil, StatusCodeError(hresp.StatusCode)
+	case hresp.Header.Get("Accept-Ranges") != "bytes":
+		return nil, fmt.Errorf("mirror %s does not accept ranges", mirror)
+	case hresp.ContentLength >= 0 && hresp.ContentLength != size:
+		return nil, ErrBadLength
+	}
+	return u, nil
+}
+
+// ifRangeValidator returns the strong ETag of a response for an If-Range
+// header, or else its Last-Modified date, or an empty string if it has
+// neither.
+func ifRangeValidator(h http.Header) string {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
+
+// restartStandby discards the partially written file of a transfer resumed
+// from a standby mirror if the mirror sent the whole file in place of the
+// requested range, as it does when its file does not match the If-Range
+// validator of the original response.
+func restartStandby(resp *Response) {
+	h := resp.Request.HTTPRequest.Header
+	if resp.standbyFailovers == 0 || !resp.DidResume || resp.Request.NoStore ||
+		h.Get("If-Range") == "" || resp.HTTPResponse.StatusCode != http.StatusOK {
+		return
+	}
+	resp.logf("standby mirror sent a different file, overwriting existing file (%d bytes)", resp.bytesResumed)
+	h.Del("Range")
+	h.Del("If-Range")
+	resp.DidResume = false
+	resp.bytesResumed = 0
+	resp.resumeOverlap = 0
+}
+
+// ready returns the URL of the warm standby mirror, or nil if there is none.
+func (s *standby) ready() *url.URL {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.url
+}
+
+// close stops keeping the standby mirror warm.
+func (s *standby) close() {
+	if s == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+// defaultStandbyResumeOverlap is the minimum number of bytes at the end of the
+// partially written file which are requested again from a standby mirror and
+// compared, if Request.ResumeOverlap is smaller.
+const defaultStandbyResumeOverlap = 4 << 10
+
+// failoverStandby resumes a transfer which failed mid-way with a transient
+// error from the given warm standby mirror, with a ranged request for the
+// end of the partially written file, and continues with copyFile. The request
+// carries the ETag or Last-Modified date of the original response in an
+// If-Range header, so that a mirror whose file differs sends it whole.
+func (c *Client) failoverStandby(resp *Response, u *url.URL) stateFunc {
+	err := resp.err
+	resp.err = nil
+	closeWriter(resp)
+	if resp.err != nil {
+		return c.closeResponse
+	}
+	_ = resp.closeResponseBody()
+	fi, statErr := os.Stat(resp.Filename)
+	if statErr != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+	// the validator of the file is kept across failovers, so that every
+	// mirror is held to the file of the original request
+	h := resp.Request.HTTPRequest.Header
+	validator := h.Get("If-Range")
+	if validator == "" && resp.HTTPResponse != nil {
+		validator = ifRangeValidator(resp.HTTPResponse.Header)
+	}
+	resp.standbyFailovers++
+	resp.fi = fi
+	resp.HTTPResponse = nil
+	resp.Request.HTTPRequest.URL = u
+	resp.Request.HTTPRequest.Host = u.Host
+	resp.DidResume = fi.Size() > 0
+	resp.bytesResumed = fi.Size()
+	resp.hashed = false
+
+	// the tail of the file is always requested again, as the mirror may serve
+	// a different file under the same name
+	resp.resumeOverlap = min(max(resp.Request.ResumeOverlap, defaultStandbyResumeOverlap), fi.Size())
+	resp.logf("%s; resuming at byte %d from standby mirror %s", RedactError(err), fi.Size()-resp.resumeOverlap, RedactURL(u))
+	if resp.DidResume {
+		h.Set("Range", fmt.Sprintf("bytes=%d-", fi.Size()-resp.resumeOverlap))
+		if validator != "" {
+			h.Set("If-Range", validator)
+		}
+	} else {
+		h.Del("Range")
+		h.Del("If-Range")
+	}
+	c.run(resp, c.getRequest)
+	if resp.isFinished() {
+		return nil
+	}
+	return c.copyFile
+}
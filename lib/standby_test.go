@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_Do_Standby(t *testing.T) {
+	content := make([]byte, 200000)
+	rand.New(rand.NewSource(6)).Read(content)
+
+	var conns, resumed atomic.Int32
+	mirror := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the tail of the partial file is requested again to be compared
+		if r.Header.Get("Range") == "bytes=95904-" && r.Header.Get("If-Range") == `"v1"` {
+			resumed.Add(1)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	mirror.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	mirror.Start()
+	defer mirror.Close()
+
+	var current atomic.Pointer[Response]
+	warm := "warm standby mirror: " + mirror.URL + "/file.bin"
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// drop the connection half way through, once the standby is warm
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Accept-Ranges", "bytes")
+		_, _ = w.Write(content[:100000])
+		w.(http.Flusher).Flush()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if resp := current.Load(); resp != nil && hasEvent(resp, warm) {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		panic(http.ErrAbortHandler)
+	}))
+	defer primary.Close()
+
+	client := NewClient()
+	client.StandbyThreshold = 100000
+	filename := filepath.Join(t.TempDir(), "file.bin")
+	req, _ := NewRequest(filename, primary.URL+"/file.bin")
+	req.Mirrors = []string{mirror.URL + "/file.bin"}
+	resp := client.Do(req)
+	current.Store(resp)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filename); !bytes.Equal(got, content) {
+		t.Error("Downloaded file does not match content")
+	}
+	if resumed.Load() != 1 {
+		t.Error("Expected transfer to resume from the standby mirror")
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("Expected the warm connection to be reused, got %d connections", n)
+	}
+}
+
+func TestClient_Do_StandbyChanged(t *testing.T) {
+	content := make([]byte, 200000)
+	rand.New(rand.NewSource(7)).Read(content)
+	stale := bytes.Repeat([]byte{'x'}, len(content))
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer mirror.Close()
+
+	var current atomic.Pointer[Response]
+	warm := "warm standby mirror: " + mirror.URL + "/file.bin"
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// serve half of an older version of the file
+		w.Header().Set("Content-Length", strconv.Itoa(len(stale)))
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write(stale[:100000])
+		w.(http.Flusher).Flush()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if resp := current.Load(); resp != nil && hasEvent(resp, warm) {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		panic(http.ErrAbortHandler)
+	}))
+	defer primary.Close()
+
+	client := NewClient()
+	client.StandbyThreshold = 100000
+	filename := filepath.Join(t.TempDir(), "file.bin")
+	req, _ := NewRequest(filename, primary.URL+"/file.bin")
+	req.Mirrors = []string{mirror.URL + "/file.bin"}
+	resp := client.Do(req)
+	current.Store(resp)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filename); !bytes.Equal(got, content) {
+		t.Error("Expected the file of the mirror to replace the partial file")
+	}
+	if resp.DidResume {
+		t.Error("Expected the download not to be resumed")
+	}
+}
+
+func TestClient_Do_StandbyThreshold(t *testing.T) {
+	var heads atomic.Int32
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+		}
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer mirror.Close()
+
+	client := NewClient()
+	client.StandbyThreshold = 1 << 20
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file.txt"), mirror.URL+"/a")
+	req.Mirrors = []string{mirror.URL + "/b"}
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if heads.Load() != 0 {
+		t.Error("Expected no standby for a file below the threshold")
+	}
+}
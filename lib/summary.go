@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BatchSummary summarizes the outcome of a batch of file transfers.
+type BatchSummary struct {
+	// Requested is the number of transfers in the batch.
+	Requested int
+
+	// Succeeded is the number of transfers which completed without error.
+	Succeeded int
+
+	// Failed is the number of transfers which completed with an error.
+	Failed int
+
+	// Skipped is the number of transfers which were not attempted because the
+	// destination file already existed or a BeforeStore hook skipped them.
+	Skipped int
+
+	// Bytes is the total number of bytes transferred, excluding any bytes which
+	// were resumed from previous downloads.
+	Bytes int64
+
+	// Elapsed is the wall-clock time between the start of the first transfer
+	// and the end of the last transfer.
+	Elapsed time.Duration
+
+	// AvgRate is the average transfer rate of the batch in bytes per second,
+	// measured over Elapsed.
+	AvgRate float64
+
+	start time.Time
+	end   time.Time
+}
+
+// Summarize blocks until all of the given Responses have completed and
+// returns a summary of their outcome.
+func Summarize(responses ...*Response) BatchSummary {
+	var s BatchSummary
+	for _, resp := range responses {
+		s.Add(resp)
+	}
+	return s
+}
+
+// Add blocks until the given Response has completed and adds its outcome to
+// the summary.
+func (s *BatchSummary) Add(resp *Response) {
+	err := resp.Err()
+	s.Requested++
+	switch {
+	case err == nil:
+		s.Succeeded++
+	case errors.Is(err, ErrFileExists), errors.Is(err, ErrSkipped):
+		s.Skipped++
+	default:
+		s.Failed++
+	}
+	s.Bytes += resp.transfer.N()
+
+	if s.start.IsZero() || resp.Start.Before(s.start) {
+		s.start = resp.Start
+	}
+	if resp.End.After(s.end) {
+		s.end = resp.End
+	}
+	s.Elapsed = s.end.Sub(s.start)
+	if s.Elapsed > 0 {
+		s.AvgRate = float64(s.Bytes) / s.Elapsed.Seconds()
+	}
+}
+
+// String returns a human readable, single line summary of the batch.
+func (s BatchSummary) String() string {
+	return fmt.Sprintf("%d requested, %d succeeded, %d failed, %d skipped; %s in %s (%s/s)",
+		s.Requested, s.Succeeded, s.Failed, s.Skipped,
+		formatBytes(float64(s.Bytes)),
+		s.Elapsed.Round(time.Millisecond),
+		formatBytes(s.AvgRate))
+}
+
+// formatBytes formats the given number of bytes using binary unit prefixes.
+func formatBytes(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	exp := 0
+	for n >= unit*unit && exp < 5 {
+		n /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", n/unit, "KMGTPE"[exp])
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// completedResponse returns a finalized Response with the given outcome.
+func completedResponse(start time.Time, d time.Duration, n int64, err error) *Response {
+	resp := &Response{
+		Start:    start,
+		End:      start.Add(d),
+		Done:     make(chan struct{}),
+		finished: make(chan struct{}),
+		transfer: &transfer{n: n},
+		err:      err,
+	}
+	close(resp.Done)
+	return resp
+}
+
+func TestSummarize(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := Summarize(
+		completedResponse(start, 2*time.Second, 1024, nil),
+		completedResponse(start.Add(time.Second), 3*time.Second, 3072, nil),
+		completedResponse(start, time.Second, 0, ErrFileExists),
+		completedResponse(start, time.Second, 10, errors.New("network error")),
+	)
+
+	if s.Requested != 4 {
+		t.Errorf("Expected 4 requested, got %d", s.Requested)
+	}
+	if s.Succeeded != 2 || s.Failed != 1 || s.Skipped != 1 {
+		t.Errorf("Expected 2 succeeded, 1 failed, 1 skipped, got %+v", s)
+	}
+	if s.Bytes != 4106 {
+		t.Errorf("Expected 4106 bytes, got %d", s.Bytes)
+	}
+	if s.Elapsed != 4*time.Second {
+		t.Errorf("Expected elapsed 4s, got %v", s.Elapsed)
+	}
+	if s.AvgRate != 4106.0/4 {
+		t.Errorf("Expected average rate %v, got %v", 4106.0/4, s.AvgRate)
+	}
+}
+
+func TestSummarize_Empty(t *testing.T) {
+	s := Summarize()
+	if s.Requested != 0 || s.Elapsed != 0 || s.AvgRate != 0 {
+		t.Errorf("Expected empty summary, got %+v", s)
+	}
+}
+
+func TestBatchSummary_String(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := Summarize(completedResponse(start, time.Second, 2*1024*1024, nil))
+	str := s.String()
+	for _, want := range []string{"1 requested", "1 succeeded", "2.0 MiB", "1s"} {
+		if !strings.Contains(str, want) {
+			t.Errorf("Expected summary %q to contain %q", str, want)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n      float64
+		expect string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{1024 * 1024, "1.0 MiB"},
+		{5 * 1024 * 1024 * 1024, "5.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.expect {
+			t.Errorf("formatBytes(%v) = %q, expected %q", tt.n, got, tt.expect)
+		}
+	}
+}
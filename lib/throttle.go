@@ -0,0 +1,185 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// throttleIntervals is the number of consecutive one second intervals
+	// which must transfer at the same rate for a transfer to be considered
+	// throttled.
+	throttleIntervals = 5
+
+	// throttleTolerance is the maximum relative deviation from the mean rate
+	// of any interval of a throttled transfer.
+	throttleTolerance = 0.02
+)
+
+// ThrottleHint describes evidence that a transfer was throttled by the remote
+// server rather than limited by the local network.
+type ThrottleHint struct {
+	// Reason describes how throttling was detected.
+	Reason string
+
+	// Cap is the measured transfer rate limit in bytes per second, or zero if
+	// unknown.
+	Cap float64
+
+	// RetryAfter is the delay requested by the server before retrying, or zero
+	// if none was given.
+	RetryAfter time.Duration
+}
+
+func (c *ThrottleHint) String() string {
+	s := "throttled by server: " + c.Reason
+	if c.Cap > 0 {
+		s += fmt.Sprintf(" (cap %s/s)", formatBytes(c.Cap))
+	}
+	if c.RetryAfter > 0 {
+		s += fmt.Sprintf(" (retry after %v)", c.RetryAfter)
+	}
+	return s
+}
+
+// ThrottleHint returns evidence that the transfer was throttled by the remote
+// server, or nil if none was detected.
+//
+// Throttling is reported if the server responded with status 429, or 503 with
+// a Retry-After header, or if the transfer rate was held constant for several
+// seconds, which is typical of a rate limit imposed by the origin. Transfers
+// limited by Request.RateLimiter, Client.RateLimit, Client.BatchRateLimit or
+// Client.RateLimiter are never reported as throttled.
+func (c *Response) ThrottleHint() *ThrottleHint {
+	if hresp := c.HTTPResponse; hresp != nil {
+		retryAfter := parseRetryAfter(hresp.Header.Get("Retry-After"), time.Now())
+		switch {
+		case hresp.StatusCode == http.StatusTooManyRequests:
+			return &ThrottleHint{Reason: "too many requests", RetryAfter: retryAfter}
+		case hresp.StatusCode == http.StatusServiceUnavailable && retryAfter > 0:
+			return &ThrottleHint{Reason: "service unavailable", RetryAfter: retryAfter}
+		}
+	}
+	if c.transfer == nil || c.transfer.lim != nil {
+		return nil
+	}
+	if bps, ok := c.transfer.rates.steady(throttleIntervals, throttleTolerance); ok {
+		return &ThrottleHint{Reason: "constant transfer rate", Cap: bps}
+	}
+	return nil
+}
+
+// parseRetryAfter returns the delay given by a Retry-After header in either
+// delay-seconds or HTTP-date form, relative to now.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(v); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// rateWindow counts the bytes transferred in consecutive fixed intervals.
+type rateWindow struct {
+	mu       sync.Mutex
+	interval time.Duration
+	start    time.Time
+	n        int64
+	counts   []int64
+	total    int
+	peak     int64 // bytes of the busiest completed interval
+}
+
+func newRateWindow(interval time.Duration, size int) *rateWindow {
+	return &rateWindow{
+		interval: interval,
+		counts:   make([]int64, 0, size),
+	}
+}
+
+// Add records n bytes transferred at time t.
+func (c *rateWindow) Add(t time.Time, n int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.start.IsZero() {
+		c.start = t
+	}
+	for t.Sub(c.start) >= c.interval {
+		if len(c.counts) == cap(c.counts) {
+			copy(c.counts, c.counts[1:])
+			c.counts = c.counts[:len(c.counts)-1]
+		}
+		c.counts = append(c.counts, c.n)
+		c.peak = max(c.peak, c.n)
+		c.total++
+		c.n = 0
+		c.start = c.start.Add(c.interval)
+	}
+	c.n += n
+}
+
+// last returns the number of intervals completed so far and the rate in bytes
+// per second of the latest of them.
+func (c *rateWindow) last() (intervals int, bps float64) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.counts) == 0 {
+		return 0, 0
+	}
+	return c.total, float64(c.counts[len(c.counts)-1]) / c.interval.Seconds()
+}
+
+// peakBPS returns the rate in bytes per second of the busiest completed
+// interval.
+func (c *rateWindow) peakBPS() float64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return float64(c.peak) / c.interval.Seconds()
+}
+
+// steady returns the mean rate in bytes per second of the last k completed
+// intervals if none of them deviates from it by more than the given
+// tolerance.
+func (c *rateWindow) steady(k int, tolerance float64) (bps float64, ok bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.counts) < k {
+		return 0, false
+	}
+	counts := c.counts[len(c.counts)-k:]
+	var sum int64
+	for _, n := range counts {
+		sum += n
+	}
+	mean := float64(sum) / float64(k)
+	if mean == 0 {
+		return 0, false
+	}
+	for _, n := range counts {
+		if math.Abs(float64(n)-mean)/mean > tolerance {
+			return 0, false
+		}
+	}
+	return mean / c.interval.Seconds(), true
+}
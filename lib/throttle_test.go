@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateWindow_Steady(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts []int64
+		expect bool
+	}{
+		{"constant", []int64{4096, 4096, 4096, 4096, 4096, 4096}, true},
+		{"within tolerance", []int64{100, 4000, 4096, 4100, 4050, 4096, 4080}, true},
+		{"variable", []int64{4096, 2048, 4096, 8192, 4096, 4096}, false},
+		{"too short", []int64{4096, 4096, 4096}, false},
+		{"stalled", []int64{0, 0, 0, 0, 0, 0}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newRateWindow(time.Second, throttleIntervals+1)
+			start := time.Now()
+			for i, n := range tt.counts {
+				// two writes per interval
+				w.Add(start.Add(time.Duration(i)*time.Second), n/2)
+				w.Add(start.Add(time.Duration(i)*time.Second+500*time.Millisecond), n-n/2)
+			}
+			// complete the last interval
+			w.Add(start.Add(time.Duration(len(tt.counts))*time.Second), 0)
+
+			bps, ok := w.steady(throttleIntervals, throttleTolerance)
+			if ok != tt.expect {
+				t.Fatalf("Expected steady to be %v, got %v (%.0f B/s)", tt.expect, ok, bps)
+			}
+			if ok && (bps < 4000 || bps > 4100) {
+				t.Errorf("Expected rate near 4096 B/s, got %.0f", bps)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		expect time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-1", 0},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second},
+		{now.Add(-30 * time.Second).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.expect {
+			t.Errorf("parseRetryAfter(%q): expected %v, got %v", tt.value, tt.expect, got)
+		}
+	}
+}
+
+func TestResponse_ThrottleHint(t *testing.T) {
+	tests := []struct {
+		name             string
+		response         *http.Response
+		expectHint       bool
+		expectRetryAfter time.Duration
+	}{
+		{
+			name: "too many requests",
+			response: createMockHTTPResponse("429 Too Many Requests", http.StatusTooManyRequests, "",
+				map[string]string{"Retry-After": "5"}),
+			expectHint:       true,
+			expectRetryAfter: 5 * time.Second,
+		},
+		{
+			name: "service unavailable with retry after",
+			response: createMockHTTPResponse("503 Service Unavailable", http.StatusServiceUnavailable, "",
+				map[string]string{"Retry-After": "60"}),
+			expectHint:       true,
+			expectRetryAfter: time.Minute,
+		},
+		{
+			name:     "service unavailable",
+			response: createErrorResponse(http.StatusServiceUnavailable, ""),
+		},
+		{
+			name:     "success",
+			response: createSuccessResponse("test content"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testURL := "http://example.com/file.txt"
+			mockClient := newMockHTTPClient()
+			mockClient.addResponse("GET", testURL, tt.response)
+			client := &Client{HTTPClient: mockClient, UserAgent: "test-agent"}
+
+			req, _ := NewRequest("", testURL)
+			req.NoStore = true
+			resp := client.Do(req)
+			resp.Wait()
+
+			hint := resp.ThrottleHint()
+			if (hint != nil) != tt.expectHint {
+				t.Fatalf("Expected hint %v, got %v", tt.expectHint, hint)
+			}
+			if hint != nil && hint.RetryAfter != tt.expectRetryAfter {
+				t.Errorf("Expected RetryAfter %v, got %v", tt.expectRetryAfter, hint.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestThrottleHint_String(t *testing.T) {
+	hint := &ThrottleHint{Reason: "constant transfer rate", Cap: 1024 * 1024}
+	expect := "throttled by server: constant transfer rate (cap 1.0 MiB/s)"
+	if got := hint.String(); got != expect {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+}
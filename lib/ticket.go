@@ -0,0 +1,204 @@
+package lib
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ticketVersion is the version of the ticket format written by NewTicket.
+const ticketVersion = 1
+
+// A Ticket describes an interrupted download such that another machine can
+// continue it, such as an ephemeral CI runner which restores the partially
+// downloaded file from a cache. It is created from a Checkpoint by NewTicket,
+// marshaled to JSON by encoding/json and read by ParseTicket.
+//
+// A Ticket never contains credentials. Auth lists the credentials which the
+// original request sent, so that the consumer knows which to supply.
+type Ticket struct {
+	// Version is the version of the ticket format.
+	Version int `json:"version"`
+
+	// URL is the URL of the request, and Mirrors its alternate URLs, without
+	// any user info or query, which may hold access tokens.
+	URL     string   `json:"url"`
+	Mirrors []string `json:"mirrors,omitempty"`
+
+	// Filename is the base name of the destination file.
+	Filename string `json:"filename,omitempty"`
+
+	// Offset is the number of bytes of the file which were downloaded, and
+	// Size the size of the remote file, or -1 if unknown.
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+
+	// ETag and LastModified are the validators of the remote file, if sent by
+	// the server.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+
+	// Auth describes the credentials sent by the original request, such as
+	// "Authorization: Bearer", "Cookie", "URL user info" or "URL query",
+	// without their values.
+	Auth []string `json:"auth,omitempty"`
+
+	// HashAlgorithm is the algorithm of the checksum of the original
+	// request: md5, sha1, sha256 or sha512. HashState is its state after
+	// hashing the first Offset bytes, and Digest the expected checksum in
+	// hex.
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
+	HashState     []byte `json:"hash_state,omitempty"`
+	Digest        string `json:"digest,omitempty"`
+
+	// Created is when the checkpoint of the ticket was taken.
+	Created time.Time `json:"created"`
+}
+
+// NewTicket returns a Ticket which continues the download of the given Request
+// from the given Checkpoint, as passed to Request.OnCheckpoint.
+func NewTicket(req *Request, c Checkpoint) *Ticket {
+	t := &Ticket{
+		Version:      ticketVersion,
+		URL:          ticketURL(req.URL().String()),
+		Offset:       c.Offset,
+		Size:         c.Size,
+		ETag:         c.ETag,
+		LastModified: c.LastModified,
+		Auth:         authHints(req.HTTPRequest),
+		Created:      c.Time,
+	}
+	for _, m := range req.Mirrors {
+		t.Mirrors = append(t.Mirrors, ticketURL(m))
+	}
+	if c.Filename != "" {
+		t.Filename = filepath.Base(c.Filename)
+	}
+	if name := hashAlgorithm(req.hash); name != "" {
+		t.HashAlgorithm = name
+		t.HashState = c.HashState
+		if req.checksum != nil {
+			t.Digest = hex.EncodeToString(req.checksum)
+		}
+	}
+	return t
+}
+
+// ParseTicket parses a ticket in JSON format, as written by encoding/json from
+// a Ticket, and validates it.
+func ParseTicket(b []byte) (*Ticket, error) {
+	var t Ticket
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("invalid ticket: %v", err)
+	}
+	if t.Version != ticketVersion {
+		return nil, fmt.Errorf("unsupported ticket version: %d", t.Version)
+	}
+	if t.URL == "" || t.Offset < 0 {
+		return nil, fmt.Errorf("invalid ticket: missing URL or bad offset")
+	}
+	if t.HashAlgorithm != "" {
+		if _, ok := blockHashes[t.HashAlgorithm]; !ok {
+			return nil, fmt.Errorf("invalid ticket: unsupported hash algorithm %q", t.HashAlgorithm)
+		}
+	}
+	if _, err := hex.DecodeString(t.Digest); err != nil {
+		return nil, fmt.Errorf("invalid ticket: bad digest: %v", err)
+	}
+	return &t, nil
+}
+
+// Request returns a Request which continues the download of the ticket into
+// dst, a file or directory. The partially downloaded file must exist at the
+// destination for the download to resume; otherwise it starts over. The
+// credentials listed by Auth must be added to the request by the caller.
+func (t *Ticket) Request(dst string) (*Request, error) {
+	if t.Filename != "" && (dst == "" || isDirName(dst) || isDir(dst)) {
+		dst = filepath.Join(dst, t.Filename)
+	}
+	req, err := NewRequest(dst, t.URL)
+	if err != nil {
+		return nil, err
+	}
+	req.Mirrors = t.Mirrors
+	if t.HashAlgorithm != "" && t.Digest != "" {
+		sum, _ := hex.DecodeString(t.Digest)
+		req.setChecksum(t.HashAlgorithm, blockHashes[t.HashAlgorithm](), sum, false)
+	}
+	req.ResumeCheckpoint = &Checkpoint{
+		URL:          t.URL,
+		Filename:     dst,
+		Offset:       t.Offset,
+		Size:         t.Size,
+		ETag:         t.ETag,
+		LastModified: t.LastModified,
+		HashState:    t.HashState,
+		Time:         t.Created,
+	}
+	return req, nil
+}
+
+// ticketURL returns the given URL without user info, query or fragment.
+// Strings which do not parse as a URL are reduced to the text before any
+// query.
+func ticketURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		s, _, _ = strings.Cut(s, "?")
+		return s
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+// authHints describes the credentials sent by the given request, without
+// their values.
+func authHints(hreq *http.Request) []string {
+	var hints []string
+	if hreq.URL.User != nil {
+		hints = append(hints, "URL user info")
+	}
+	if hreq.URL.RawQuery != "" {
+		hints = append(hints, "URL query")
+	}
+	for name := range hreq.Header {
+		lower := strings.ToLower(name)
+		switch {
+		case lower == "authorization" || lower == "proxy-authorization":
+			scheme, _, _ := strings.Cut(hreq.Header.Get(name), " ")
+			hints = append(hints, name+": "+scheme)
+		case lower == "cookie" || strings.Contains(lower, "token") ||
+			strings.Contains(lower, "key") || strings.Contains(lower, "secret") ||
+			strings.Contains(lower, "auth"):
+			hints = append(hints, name)
+		}
+	}
+	sort.Strings(hints)
+	return hints
+}
+
+// hashAlgorithm returns the name of the block hash algorithm of h, or an
+// empty string if it is not one of them.
+func hashAlgorithm(h hash.Hash) string {
+	if h == nil {
+		return ""
+	}
+	for name, newHash := range blockHashes {
+		if g := newHash(); reflect.TypeOf(g) == reflect.TypeOf(h) && g.Size() == h.Size() {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timing breaks down the duration of the request which transferred a file,
+// for comparing the performance of mirrors and networks. Durations of phases
+// which did not occur, such as DNS for a reused connection, are zero. If the
+// request was redirected, the durations of all requests are added up.
+type Timing struct {
+	// DNS is the time spent resolving host names.
+	DNS time.Duration `json:"dns"`
+
+	// Connect is the time spent establishing TCP connections.
+	Connect time.Duration `json:"connect"`
+
+	// TLS is the time spent in TLS handshakes.
+	TLS time.Duration `json:"tls"`
+
+	// TTFB is the time from sending the request, including connecting, until
+	// the first byte of the response was received.
+	TTFB time.Duration `json:"ttfb"`
+
+	// Transfer is the time from the first byte of the response until the
+	// body was read, or until now if the transfer is in progress.
+	Transfer time.Duration `json:"transfer"`
+
+	// ReusedConn reports whether the response was received on a reused
+	// connection.
+	ReusedConn bool `json:"reused_conn"`
+}
+
+// String returns the durations of the phases which occurred, such as
+// "dns 2ms, connect 15ms, tls 31ms, ttfb 90ms, transfer 1.2s".
+func (t Timing) String() string {
+	var parts []string
+	for _, p := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"dns", t.DNS}, {"connect", t.Connect}, {"tls", t.TLS}, {"ttfb", t.TTFB}, {"transfer", t.Transfer},
+	} {
+		if p.d > 0 {
+			parts = append(parts, fmt.Sprintf("%s %v", p.name, roundDuration(p.d)))
+		}
+	}
+	if t.ReusedConn {
+		parts = append(parts, "reused connection")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// roundDuration rounds d to a precision suitable for display.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Microsecond)
+}
+
+// timingTraceKey is the context key of the timingTrace of a request.
+type timingTraceKey struct{}
+
+// timingTrace records the timing of a request and its redirects.
+type timingTrace struct {
+	mu         sync.Mutex
+	start      time.Time
+	dnsStart   time.Time
+	dialStart  time.Time
+	tlsStart   time.Time
+	firstByte  time.Time
+	dns        time.Duration
+	connect    time.Duration
+	tls        time.Duration
+	reusedConn bool
+}
+
+// withTimingTrace returns a copy of the given request whose timing is
+// recorded by a timingTrace, which requestTiming retrieves from its response.
+func withTimingTrace(req *http.Request) *http.Request {
+	t := &timingTrace{start: time.Now()}
+	ctx := httptrace.WithClientTrace(req.Context(), t.clientTrace())
+	return req.WithContext(context.WithValue(ctx, timingTraceKey{}, t))
+}
+
+// requestTiming returns the timingTrace of the request of the given
+// response, or nil if its timing was not recorded.
+func requestTiming(hresp *http.Response) *timingTrace {
+	if hresp == nil || hresp.Request == nil {
+		return nil
+	}
+	t, _ := hresp.Request.Context().Value(timingTraceKey{}).(*timingTrace)
+	return t
+}
+
+func (t *timingTrace) clientTrace() *httptrace.ClientTrace {
+	record := func(f func(now time.Time)) {
+		now := time.Now()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		f(now)
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			record(func(now time.Time) { t.dnsStart = now })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			record(func(now time.Time) { t.dns += now.Sub(t.dnsStart) })
+		},
+		ConnectStart: func(network, addr string) {
+			record(func(now time.Time) { t.dialStart = now })
+		},
+		ConnectDone: func(network, addr string, err error) {
+			record(func(now time.Time) { t.connect += now.Sub(t.dialStart) })
+		},
+		TLSHandshakeStart: func() {
+			record(func(now time.Time) { t.tlsStart = now })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			record(func(now time.Time) { t.tls += now.Sub(t.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			record(func(time.Time) { t.reusedConn = info.Reused })
+		},
+		GotFirstResponseByte: func() {
+			record(func(now time.Time) { t.firstByte = now })
+		},
+	}
+}
+
+// timing returns the recorded Timing, with the transfer ending at end, or now
+// if end is zero.
+func (t *timingTrace) timing(end time.Time) Timing {
+	if t == nil {
+		return Timing{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timing := Timing{DNS: t.dns, Connect: t.connect, TLS: t.tls, ReusedConn: t.reusedConn}
+	if !t.firstByte.IsZero() {
+		timing.TTFB = t.firstByte.Sub(t.start)
+		if end.IsZero() {
+			end = time.Now()
+		}
+		timing.Transfer = max(end.Sub(t.firstByte), 0)
+	}
+	return timing
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponse_Timing(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader("hello"))
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.HTTPClient = server.Client()
+	req, _ := NewRequest(filepath.Join(t.TempDir(), "file.bin"), server.URL+"/file.bin")
+	req.SingleRequest = true
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	timing := resp.Timing()
+	if timing.Connect <= 0 || timing.TLS <= 0 || timing.TTFB < timing.Connect+timing.TLS {
+		t.Errorf("Unexpected timing of a new connection: %+v", timing)
+	}
+	if timing.ReusedConn {
+		t.Error("Expected a new connection")
+	}
+	if timing.Transfer > resp.Duration() {
+		t.Errorf("Transfer %v exceeds duration %v", timing.Transfer, resp.Duration())
+	}
+
+	// a second request reuses the connection
+	req, _ = NewRequest(filepath.Join(t.TempDir(), "file.bin"), server.URL+"/file.bin")
+	req.SingleRequest = true
+	resp = client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if timing := resp.Timing(); !timing.ReusedConn || timing.Connect != 0 || timing.TLS != 0 {
+		t.Errorf("Unexpected timing of a reused connection: %+v", timing)
+	}
+}
+
+func TestTiming_String(t *testing.T) {
+	timing := Timing{Connect: 1500 * time.Microsecond, TTFB: 20 * time.Millisecond, Transfer: 1234 * time.Millisecond}
+	if got, want := timing.String(), "connect 2ms, ttfb 20ms, transfer 1.23s"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
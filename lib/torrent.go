@@ -0,0 +1,630 @@
+//go:build torrent
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTorrentFileSize is the largest .torrent file which is read.
+const maxTorrentFileSize = 16 << 20
+
+// maxTorrentPeers is the number of peers from which pieces are downloaded
+// concurrently.
+const maxTorrentPeers = 30
+
+// torrentWindowBytes limits the memory used by pieces which have been
+// downloaded ahead of the position of the reader.
+const torrentWindowBytes = 64 << 20
+
+// registerTorrent registers the BitTorrent backend with the given Transport,
+// so that magnet: links and torrent: URLs of .torrent files are downloaded
+// from peers. The content of the torrent is served like an HTTP response,
+// with support for ranges, so that progress, resuming and checksums work as
+// with any other download.
+//
+// A torrent: URL gives the location of a .torrent file, such as
+// torrent:https://example.com/image.iso.torrent or, if Client.TorrentFiles is
+// set, torrent:/tmp/image.torrent. A remote .torrent file is requested by the
+// Client like any other URL, so its scheme must be allowed by
+// Client.AllowedSchemes, and Client.HostHeaders are sent with it.
+// Only torrents of a single file are supported. Peers are found through the
+// HTTP and UDP trackers of the torrent; DHT is not supported, so magnet links
+// without trackers fail.
+func registerTorrent(t *http.Transport) {
+	rt := &torrentTransport{
+		client: &http.Client{Transport: t},
+		infos:  make(map[[20]byte]*torrentInfo),
+	}
+	_, _ = rand.Read(rt.peerID[8:])
+	copy(rt.peerID[:], "-GB0001-")
+	t.RegisterProtocol("magnet", rt)
+	t.RegisterProtocol("torrent", rt)
+}
+
+// torrentInfo is the metainfo of a single file torrent.
+type torrentInfo struct {
+	hash        [20]byte
+	name        string
+	length      int64
+	pieceLength int64
+	pieces      [][20]byte
+	trackers    []string
+}
+
+// pieceSize returns the size of the piece with the given index.
+func (info *torrentInfo) pieceSize(i int) int64 {
+	return min(info.pieceLength, info.length-int64(i)*info.pieceLength)
+}
+
+// torrentTransport is an http.RoundTripper which downloads torrents.
+type torrentTransport struct {
+	client *http.Client // announces to trackers, and fetches .torrent files outside of a Client
+	peerID [20]byte
+
+	mu    sync.Mutex
+	infos map[[20]byte]*torrentInfo // metainfo fetched for magnet links
+}
+
+func (t *torrentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	info, err := t.resolve(req)
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	resp.Header.Set("Accept-Ranges", "bytes")
+	resp.Header.Set("Content-Type", "application/octet-stream")
+	resp.Header.Set("ETag", `"`+hex.EncodeToString(info.hash[:])+`"`)
+	resp.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.name}))
+
+	start, end, ok := parseRangeHeader(req.Header.Get("Range"), info.length)
+	if !ok {
+		resp.Status = "416 Requested Range Not Satisfiable"
+		resp.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", info.length))
+		return resp, nil
+	}
+	if start > 0 || end < info.length {
+		resp.Status = "206 Partial Content"
+		resp.StatusCode = http.StatusPartialContent
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, info.length))
+	}
+	resp.ContentLength = end - start
+	resp.Header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	if req.Method != http.MethodHead && end > start {
+		resp.Body = t.download(req.Context(), info, start, end)
+	}
+	return resp, nil
+}
+
+// resolve returns the metainfo of the torrent of the given request, fetching
+// it from the .torrent file of a torrent: URL, or from peers for a magnet
+// link.
+func (t *torrentTransport) resolve(req *http.Request) (*torrentInfo, error) {
+	u := req.URL
+	if u.Scheme == "torrent" {
+		src := torrentSource(u)
+		var b []byte
+		var err error
+		if strings.Contains(src, "://") {
+			b, err = t.fetchTorrentFile(req.Context(), src)
+		} else {
+			b, err = os.ReadFile(src)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read torrent file: %w", err)
+		}
+		return parseTorrentFile(b)
+	}
+
+	hash, name, trackers, err := parseMagnet(u)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	info := t.infos[hash]
+	t.mu.Unlock()
+	if info == nil {
+		if info, err = t.fetchMetadata(req.Context(), hash, trackers); err != nil {
+			return nil, err
+		}
+		t.mu.Lock()
+		t.infos[hash] = info
+		t.mu.Unlock()
+	}
+	if info.name == "" && name != "" {
+		named := *info
+		named.name = name
+		return &named, nil
+	}
+	return info, nil
+}
+
+// fetchTorrentFile downloads the .torrent file at the given URL, through the
+// Client which requested the torrent: URL, if any.
+func (t *torrentTransport) fetchTorrentFile(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	fetch := t.client.Do
+	if f, ok := ctx.Value(torrentFetcherKey{}).(func(*http.Request) (*http.Response, error)); ok {
+		fetch = f
+	}
+	resp, err := fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, StatusCodeError(resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxTorrentFileSize))
+}
+
+// parseMagnet returns the info hash, display name and trackers of a magnet
+// link. Magnet links without trackers are rejected, as peers are only found
+// through trackers.
+func parseMagnet(u *url.URL) (hash [20]byte, name string, trackers []string, err error) {
+	q, err := url.ParseQuery(u.RawQuery)
+	if u.Opaque != "" {
+		q, err = url.ParseQuery(strings.TrimPrefix(u.Opaque, "?"))
+	}
+	if err != nil {
+		return hash, "", nil, fmt.Errorf("invalid magnet link: %w", err)
+	}
+	for _, xt := range q["xt"] {
+		s, ok := strings.CutPrefix(xt, "urn:btih:")
+		if !ok {
+			continue
+		}
+		var b []byte
+		switch len(s) {
+		case 40:
+			b, err = hex.DecodeString(s)
+		case 32:
+			b, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+		default:
+			err = errors.New("bad length")
+		}
+		if err != nil {
+			return hash, "", nil, fmt.Errorf("invalid magnet info hash %s: %w", s, err)
+		}
+		if len(q["tr"]) == 0 {
+			return hash, "", nil, errors.New("magnet link has no trackers (tr=), and DHT is not supported")
+		}
+		copy(hash[:], b)
+		return hash, q.Get("dn"), q["tr"], nil
+	}
+	return hash, "", nil, errors.New("magnet link has no BitTorrent info hash")
+}
+
+// parseTorrentFile parses a .torrent file.
+func parseTorrentFile(b []byte) (*torrentInfo, error) {
+	v, rest, err := bdecode(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid torrent file: %w", err)
+	}
+	root, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid torrent file: not a dictionary")
+	}
+	raw := bdictValue(b[:len(b)-len(rest)], "info")
+	if raw == nil {
+		return nil, errors.New("invalid torrent file: no info dictionary")
+	}
+	info, err := parseTorrentInfo(raw)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := root["announce"].(string); ok {
+		info.trackers = append(info.trackers, s)
+	}
+	tiers, _ := root["announce-list"].([]any)
+	for _, tier := range tiers {
+		list, _ := tier.([]any)
+		for _, tr := range list {
+			if s, ok := tr.(string); ok && !contains(info.trackers, s) {
+				info.trackers = append(info.trackers, s)
+			}
+		}
+	}
+	return info, nil
+}
+
+// parseTorrentInfo parses the bencoded info dictionary of a torrent.
+func parseTorrentInfo(raw []byte) (*torrentInfo, error) {
+	v, _, err := bdecode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid torrent info: %w", err)
+	}
+	d, _ := v.(map[string]any)
+	if _, ok := d["files"]; ok {
+		return nil, errors.New("torrents of multiple files are not supported")
+	}
+	info := &torrentInfo{hash: sha1.Sum(raw)}
+	info.name, _ = d["name"].(string)
+	info.length, _ = d["length"].(int64)
+	info.pieceLength, _ = d["piece length"].(int64)
+	pieces, _ := d["pieces"].(string)
+	if info.length <= 0 || info.pieceLength <= 0 || len(pieces)%20 != 0 ||
+		int64(len(pieces)/20) != (info.length+info.pieceLength-1)/info.pieceLength {
+		return nil, errors.New("invalid torrent info: bad length or pieces")
+	}
+	info.pieces = make([][20]byte, len(pieces)/20)
+	for i := range info.pieces {
+		copy(info.pieces[i][:], pieces[i*20:])
+	}
+	return info, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// torrentDownload downloads the pieces of a byte range of a torrent from its
+// peers, and returns the bytes of the range in order. Pieces are downloaded
+// concurrently within a window ahead of the reader.
+type torrentDownload struct {
+	t      *torrentTransport
+	info   *torrentInfo
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	pos, end int64 // position of the reader and end of the range
+	last     int   // index of the last piece of the range
+	window   int   // number of pieces held ahead of the reader
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	next     int            // index of the piece at pos
+	assigned map[int]bool   // pieces being downloaded by a peer
+	done     map[int][]byte // verified pieces not yet read
+	peers    map[string]bool
+	active   int // running peer connections
+	err      error
+}
+
+// download starts the download of the byte range [start, end) of a torrent.
+func (t *torrentTransport) download(ctx context.Context, info *torrentInfo, start, end int64) *torrentDownload {
+	ctx, cancel := context.WithCancel(ctx)
+	d := &torrentDownload{
+		t:        t,
+		info:     info,
+		ctx:      ctx,
+		cancel:   cancel,
+		pos:      start,
+		end:      end,
+		next:     int(start / info.pieceLength),
+		last:     int((end - 1) / info.pieceLength),
+		window:   max(4, int(torrentWindowBytes/info.pieceLength)),
+		assigned: make(map[int]bool),
+		done:     make(map[int][]byte),
+		peers:    make(map[string]bool),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	go func() {
+		<-ctx.Done()
+		d.fail(ctx.Err())
+	}()
+	go d.announce()
+	return d
+}
+
+// announce finds peers through the trackers of the torrent and connects to
+// new peers, until the download is complete.
+func (d *torrentDownload) announce() {
+	left := d.end - d.pos
+	for {
+		addrs, interval, err := announceAll(d.ctx, d.t.client, d.info.trackers, d.info.hash, d.t.peerID, left)
+		d.mu.Lock()
+		for _, addr := range addrs {
+			if d.active < maxTorrentPeers && !d.peers[addr] {
+				d.peers[addr] = true
+				d.active++
+				go d.runPeer(addr)
+			}
+		}
+		if d.active == 0 && d.err == nil && d.next <= d.last {
+			if err == nil {
+				err = errors.New("no peers found")
+			}
+			d.err = fmt.Errorf("cannot download torrent: %w", err)
+			d.cond.Broadcast()
+		}
+		finished := d.err != nil || d.next > d.last
+		d.mu.Unlock()
+		if finished {
+			return
+		}
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(min(interval, time.Minute)):
+		}
+	}
+}
+
+// runPeer downloads pieces from the peer at the given address until the
+// download is complete or the peer fails.
+func (d *torrentDownload) runPeer(addr string) {
+	defer func() {
+		d.mu.Lock()
+		d.active--
+		delete(d.peers, addr) // may be retried after the next announce
+		d.mu.Unlock()
+	}()
+	p, err := dialPeer(d.ctx, addr, d.info.hash, d.t.peerID)
+	if err != nil {
+		return
+	}
+	defer p.close()
+	p.pieces = len(d.info.pieces)
+	if err := p.writeMessage(msgInterested, nil); err != nil {
+		return
+	}
+	for {
+		i, ok := d.claim(p.has)
+		if !ok {
+			return
+		}
+		if i < 0 {
+			// wait for the peer to announce more pieces
+			if _, _, err := p.receive(); err != nil {
+				return
+			}
+			continue
+		}
+		b, err := p.downloadPiece(i, d.info.pieceLength, d.info.pieceSize(i))
+		if err == nil && sha1.Sum(b) != d.info.pieces[i] {
+			err = fmt.Errorf("piece %d failed verification", i)
+		}
+		d.mu.Lock()
+		delete(d.assigned, i)
+		if err == nil {
+			d.done[i] = b
+		}
+		d.cond.Broadcast()
+		d.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// claim assigns the next piece within the window which is not yet
+// downloaded, and which the peer has, to the peer. It returns -1 if the peer
+// has none of the remaining pieces of the window, waits while all of them are
+// being downloaded by other peers, and returns false if the download is
+// complete or failed.
+func (d *torrentDownload) claim(has func(int) bool) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for d.err == nil {
+		remaining := false
+		for i := d.next; i <= d.last && i < d.next+d.window; i++ {
+			if d.assigned[i] || d.done[i] != nil {
+				continue
+			}
+			remaining = true
+			if has(i) {
+				d.assigned[i] = true
+				return i, true
+			}
+		}
+		if remaining {
+			return -1, true
+		}
+		if d.next+d.window > d.last {
+			return 0, false
+		}
+		d.cond.Wait()
+	}
+	return 0, false
+}
+
+// fail stops the download with the given error.
+func (d *torrentDownload) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		d.err = err
+	}
+	d.cond.Broadcast()
+}
+
+func (d *torrentDownload) Read(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pos >= d.end {
+		return 0, io.EOF
+	}
+	for d.done[d.next] == nil {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.cond.Wait()
+	}
+	piece := d.done[d.next]
+	off := d.pos - int64(d.next)*d.info.pieceLength
+	n := copy(b, piece[off:min(int64(len(piece)), off+d.end-d.pos)])
+	d.pos += int64(n)
+	if d.pos >= d.end || d.pos-int64(d.next)*d.info.pieceLength >= int64(len(piece)) {
+		delete(d.done, d.next)
+		d.next++
+		d.cond.Broadcast()
+	}
+	return n, nil
+}
+
+func (d *torrentDownload) Close() error {
+	d.cancel()
+	return nil
+}
+
+// bdecode decodes the bencoded value at the start of b, and returns it with
+// the remaining bytes. Dictionaries are decoded to map[string]any, lists to
+// []any, integers to int64 and strings to string.
+func bdecode(b []byte) (any, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	switch c := b[0]; {
+	case c == 'i':
+		end := bytes.IndexByte(b, 'e')
+		if end < 0 {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		n, err := strconv.ParseInt(string(b[1:end]), 10, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		return n, b[end+1:], nil
+	case c == 'l':
+		list := []any{}
+		b = b[1:]
+		for len(b) > 0 && b[0] != 'e' {
+			v, rest, err := bdecode(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			list, b = append(list, v), rest
+		}
+		if len(b) == 0 {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		return list, b[1:], nil
+	case c == 'd':
+		dict := map[string]any{}
+		b = b[1:]
+		for len(b) > 0 && b[0] != 'e' {
+			k, rest, err := bdecode(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, nil, errors.New("bencode: dictionary key is not a string")
+			}
+			v, rest, err := bdecode(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			dict[key], b = v, rest
+		}
+		if len(b) == 0 {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		return dict, b[1:], nil
+	case c >= '0' && c <= '9':
+		colon := bytes.IndexByte(b, ':')
+		if colon < 0 {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		n, err := strconv.Atoi(string(b[:colon]))
+		if err != nil || n < 0 || n > len(b)-colon-1 {
+			return nil, nil, errors.New("bencode: bad string length")
+		}
+		return string(b[colon+1 : colon+1+n]), b[colon+1+n:], nil
+	}
+	return nil, nil, fmt.Errorf("bencode: unexpected %q", b[0])
+}
+
+// bdictValue returns the bencoded value of the given key of the bencoded
+// dictionary b, or nil if it has no such key.
+func bdictValue(b []byte, key string) []byte {
+	if len(b) == 0 || b[0] != 'd' {
+		return nil
+	}
+	b = b[1:]
+	for len(b) > 0 && b[0] != 'e' {
+		k, rest, err := bdecode(b)
+		if err != nil {
+			return nil
+		}
+		_, next, err := bdecode(rest)
+		if err != nil {
+			return nil
+		}
+		if k == key {
+			return rest[:len(rest)-len(next)]
+		}
+		b = next
+	}
+	return nil
+}
+
+// bencode returns the bencoding of v, which may be a map[string]any, []any,
+// int, int64, string or []byte.
+func bencode(v any) []byte {
+	var buf bytes.Buffer
+	var encode func(v any)
+	encode = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			buf.WriteByte('d')
+			for _, k := range keys {
+				encode(k)
+				encode(v[k])
+			}
+			buf.WriteByte('e')
+		case []any:
+			buf.WriteByte('l')
+			for _, e := range v {
+				encode(e)
+			}
+			buf.WriteByte('e')
+		case int:
+			fmt.Fprintf(&buf, "i%de", v)
+		case int64:
+			fmt.Fprintf(&buf, "i%de", v)
+		case string:
+			fmt.Fprintf(&buf, "%d:%s", len(v), v)
+		case []byte:
+			fmt.Fprintf(&buf, "%d:%s", len(v), v)
+		}
+	}
+	encode(v)
+	return buf.Bytes()
+}
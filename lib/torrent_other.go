@@ -0,0 +1,9 @@
+//go:build !torrent
+
+package lib
+
+import "net/http"
+
+// registerTorrent does nothing, as the BitTorrent backend is only included in
+// builds with the torrent build tag.
+func registerTorrent(t *http.Transport) {}
@@ -0,0 +1,264 @@
+//go:build torrent
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Messages of the BitTorrent peer wire protocol.
+const (
+	msgChoke         = 0
+	msgUnchoke       = 1
+	msgInterested    = 2
+	msgHave          = 4
+	msgBitfield      = 5
+	msgRequest       = 6
+	msgPiece         = 7
+	msgExtended      = 20
+	extHandshake     = 0
+	utMetadataID     = 1 // the ID of ut_metadata in our extended handshake
+	torrentBlockSize = 16 << 10
+	maxMessageSize   = 1 << 20
+	maxMetadataSize  = 8 << 20
+	maxRequests      = 16 // pipelined block requests per peer
+)
+
+// peerTimeout limits the time to connect to a peer and to wait for each of
+// its messages.
+var peerTimeout = 30 * time.Second
+
+// peerConn is a connection to a BitTorrent peer.
+type peerConn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	choked   bool
+	bitfield []byte
+	ext      map[string]int64 // extended messages supported by the peer
+	metaSize int64            // size of the metainfo announced by the peer
+	pieces   int              // number of pieces of the torrent, if known
+}
+
+// dialPeer connects to the peer at the given address and exchanges the
+// handshake for the torrent with the given info hash.
+func dialPeer(ctx context.Context, addr string, infoHash, peerID [20]byte) (*peerConn, error) {
+	d := net.Dialer{Timeout: peerTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	p := &peerConn{conn: conn, r: bufio.NewReader(conn), choked: true}
+	if err := p.handshake(infoHash, peerID); err != nil {
+		stop()
+		_ = conn.Close()
+		return nil, fmt.Errorf("peer %s: %w", addr, err)
+	}
+	return p, nil
+}
+
+func (p *peerConn) handshake(infoHash, peerID [20]byte) error {
+	var hs []byte
+	hs = append(hs, 19)
+	hs = append(hs, "BitTorrent protocol"...)
+	reserved := make([]byte, 8)
+	reserved[5] = 0x10 // extension protocol (BEP 10)
+	hs = append(hs, reserved...)
+	hs = append(hs, infoHash[:]...)
+	hs = append(hs, peerID[:]...)
+	_ = p.conn.SetDeadline(time.Now().Add(peerTimeout))
+	if _, err := p.conn.Write(hs); err != nil {
+		return err
+	}
+	reply := make([]byte, len(hs))
+	if _, err := io.ReadFull(p.r, reply); err != nil {
+		return err
+	}
+	if !bytes.Equal(reply[:20], hs[:20]) || !bytes.Equal(reply[28:48], infoHash[:]) {
+		return errors.New("bad handshake")
+	}
+	if reply[25]&0x10 != 0 {
+		m := map[string]any{"m": map[string]any{"ut_metadata": utMetadataID}}
+		return p.writeMessage(msgExtended, append([]byte{extHandshake}, bencode(m)...))
+	}
+	return nil
+}
+
+func (p *peerConn) close() {
+	_ = p.conn.Close()
+}
+
+// has reports whether the peer has the piece with the given index.
+func (p *peerConn) has(i int) bool {
+	return i/8 < len(p.bitfield) && p.bitfield[i/8]&(0x80>>(i%8)) != 0
+}
+
+func (p *peerConn) writeMessage(id byte, payload []byte) error {
+	b := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(1+len(payload)))
+	b[4] = id
+	copy(b[5:], payload)
+	_ = p.conn.SetWriteDeadline(time.Now().Add(peerTimeout))
+	_, err := p.conn.Write(b)
+	return err
+}
+
+// receive reads the next message from the peer, other than keep-alives, and
+// updates the state of the peer from choke, unchoke, have, bitfield and
+// extended handshake messages.
+func (p *peerConn) receive() (id byte, payload []byte, err error) {
+	for {
+		_ = p.conn.SetReadDeadline(time.Now().Add(peerTimeout))
+		var size uint32
+		if err := binary.Read(p.r, binary.BigEndian, &size); err != nil {
+			return 0, nil, err
+		}
+		if size == 0 {
+			continue // keep-alive
+		}
+		if size > maxMessageSize {
+			return 0, nil, fmt.Errorf("message of %d bytes too large", size)
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(p.r, b); err != nil {
+			return 0, nil, err
+		}
+		id, payload = b[0], b[1:]
+		switch id {
+		case msgChoke:
+			p.choked = true
+		case msgUnchoke:
+			p.choked = false
+		case msgHave:
+			// the pieces of the peer are unknown while fetching metadata
+			if len(payload) == 4 && p.pieces > 0 {
+				i := binary.BigEndian.Uint32(payload)
+				if i >= uint32(p.pieces) {
+					return 0, nil, fmt.Errorf("have message for piece %d of %d", i, p.pieces)
+				}
+				for int(i/8) >= len(p.bitfield) {
+					p.bitfield = append(p.bitfield, 0)
+				}
+				p.bitfield[i/8] |= 0x80 >> (i % 8)
+			}
+		case msgBitfield:
+			p.bitfield = payload
+		case msgExtended:
+			if len(payload) > 0 && payload[0] == extHandshake {
+				v, _, err := bdecode(payload[1:])
+				if err != nil {
+					return 0, nil, err
+				}
+				d, _ := v.(map[string]any)
+				m, _ := d["m"].(map[string]any)
+				p.ext = make(map[string]int64)
+				for k, v := range m {
+					if n, ok := v.(int64); ok {
+						p.ext[k] = n
+					}
+				}
+				p.metaSize, _ = d["metadata_size"].(int64)
+			}
+		}
+		return id, payload, nil
+	}
+}
+
+// downloadPiece downloads the piece with the given index and size in blocks,
+// waiting for the peer to unchoke the connection.
+func (p *peerConn) downloadPiece(index int, pieceLength, size int64) ([]byte, error) {
+	buf := make([]byte, size)
+	var requested, received int64
+	for received < size {
+		for !p.choked && requested < size && requested-received < maxRequests*torrentBlockSize {
+			n := min(torrentBlockSize, size-requested)
+			req := make([]byte, 12)
+			binary.BigEndian.PutUint32(req, uint32(index))
+			binary.BigEndian.PutUint32(req[4:], uint32(requested))
+			binary.BigEndian.PutUint32(req[8:], uint32(n))
+			if err := p.writeMessage(msgRequest, req); err != nil {
+				return nil, err
+			}
+			requested += n
+		}
+		id, payload, err := p.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case msgChoke:
+			// pending requests are discarded by the peer
+			requested = received
+		case msgPiece:
+			if len(payload) < 8 || int(binary.BigEndian.Uint32(payload)) != index {
+				continue
+			}
+			begin := int64(binary.BigEndian.Uint32(payload[4:]))
+			block := payload[8:]
+			if begin != received || begin+int64(len(block)) > size {
+				return nil, fmt.Errorf("unexpected block at %d of piece %d", begin, index)
+			}
+			received += int64(copy(buf[begin:], block))
+		}
+	}
+	return buf, nil
+}
+
+// fetchMetadata downloads the metainfo of the torrent from the peer with the
+// ut_metadata extension (BEP 9), and verifies it against the info hash.
+func (p *peerConn) fetchMetadata(infoHash [20]byte) (*torrentInfo, error) {
+	for p.ext == nil {
+		if _, _, err := p.receive(); err != nil {
+			return nil, err
+		}
+	}
+	id := p.ext["ut_metadata"]
+	if id == 0 || p.metaSize <= 0 || p.metaSize > maxMetadataSize {
+		return nil, errors.New("peer does not send metadata")
+	}
+	meta := make([]byte, 0, p.metaSize)
+	for piece := 0; int64(len(meta)) < p.metaSize; piece++ {
+		req := bencode(map[string]any{"msg_type": 0, "piece": piece})
+		if err := p.writeMessage(msgExtended, append([]byte{byte(id)}, req...)); err != nil {
+			return nil, err
+		}
+		for {
+			msg, payload, err := p.receive()
+			if err != nil {
+				return nil, err
+			}
+			if msg != msgExtended || len(payload) == 0 || payload[0] != utMetadataID {
+				continue
+			}
+			v, data, err := bdecode(payload[1:])
+			if err != nil {
+				return nil, err
+			}
+			d, _ := v.(map[string]any)
+			if t, _ := d["msg_type"].(int64); t != 1 {
+				return nil, errors.New("peer rejected metadata request")
+			}
+			if n, _ := d["piece"].(int64); n != int64(piece) {
+				continue
+			}
+			if int64(len(meta)+len(data)) > p.metaSize {
+				return nil, errors.New("metadata exceeds announced size")
+			}
+			meta = append(meta, data...)
+			break
+		}
+	}
+	if sha1.Sum(meta) != infoHash {
+		return nil, errors.New("metadata does not match info hash")
+	}
+	return parseTorrentInfo(meta)
+}
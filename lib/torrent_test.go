@@ -0,0 +1,239 @@
+//go:build torrent
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newSeeder starts a peer which seeds the given content in pieces of the
+// given length, and a tracker which returns it, and returns the bencoded info
+// dictionary of the torrent and the URL of the tracker.
+func newSeeder(t *testing.T, name string, content []byte, pieceLength int) ([]byte, string) {
+	var pieces []byte
+	for off := 0; off < len(content); off += pieceLength {
+		sum := sha1.Sum(content[off:min(off+pieceLength, len(content))])
+		pieces = append(pieces, sum[:]...)
+	}
+	info := bencode(map[string]any{
+		"name":         name,
+		"length":       len(content),
+		"piece length": pieceLength,
+		"pieces":       string(pieces),
+	})
+	infoHash := sha1.Sum(info)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go seed(conn, infoHash, info, content, pieceLength)
+		}
+	}()
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("info_hash") != string(infoHash[:]) {
+			_, _ = w.Write(bencode(map[string]any{"failure reason": "unknown torrent"}))
+			return
+		}
+		addr := l.Addr().(*net.TCPAddr)
+		peer := append(addr.IP.To4(), byte(addr.Port>>8), byte(addr.Port))
+		_, _ = w.Write(bencode(map[string]any{"interval": 1800, "peers": string(peer)}))
+	}))
+	t.Cleanup(tracker.Close)
+	return info, tracker.URL + "/announce"
+}
+
+// seed serves the pieces and metadata of a torrent to a peer.
+func seed(conn net.Conn, infoHash [20]byte, info, content []byte, pieceLength int) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	hs := make([]byte, 68)
+	if _, err := io.ReadFull(conn, hs); err != nil || !bytes.Equal(hs[28:48], infoHash[:]) {
+		return
+	}
+	copy(hs[48:], "-SEED000-0000000000")
+	_, _ = conn.Write(hs)
+	p := &peerConn{conn: conn, r: bufio.NewReader(conn)}
+	_ = p.writeMessage(msgExtended, append([]byte{extHandshake}, bencode(map[string]any{
+		"m": map[string]any{"ut_metadata": 3}, "metadata_size": len(info),
+	})...))
+	bitfield := make([]byte, (len(content)/pieceLength+8)/8)
+	for i := range bitfield {
+		bitfield[i] = 0xff
+	}
+	_ = p.writeMessage(msgBitfield, bitfield)
+	_ = p.writeMessage(msgUnchoke, nil)
+	for {
+		id, payload, err := p.receive()
+		if err != nil {
+			return
+		}
+		switch id {
+		case msgRequest:
+			index := int(binary.BigEndian.Uint32(payload))
+			begin := int(binary.BigEndian.Uint32(payload[4:]))
+			n := int(binary.BigEndian.Uint32(payload[8:]))
+			off := index*pieceLength + begin
+			_ = p.writeMessage(msgPiece, append(payload[:8:8], content[off:off+n]...))
+		case msgExtended:
+			if payload[0] != 3 {
+				continue
+			}
+			v, _, _ := bdecode(payload[1:])
+			piece := int(v.(map[string]any)["piece"].(int64))
+			data := info[piece*torrentBlockSize : min((piece+1)*torrentBlockSize, len(info))]
+			msg := bencode(map[string]any{"msg_type": 1, "piece": piece, "total_size": len(info)})
+			_ = p.writeMessage(msgExtended, append(append([]byte{byte(p.ext["ut_metadata"])}, msg...), data...))
+		}
+	}
+}
+
+func TestClient_Do_Magnet(t *testing.T) {
+	content := make([]byte, 300000)
+	rand.New(rand.NewSource(3)).Read(content)
+	info, tracker := newSeeder(t, "image.iso", content, 32<<10)
+	infoHash := sha1.Sum(info)
+
+	magnet := "magnet:?xt=urn:btih:" + hex.EncodeToString(infoHash[:]) + "&dn=image.iso&tr=" + url.QueryEscape(tracker)
+	client := NewClient()
+	client.AllowedSchemes = []string{"magnet"}
+	resp := client.Do(mustNewRequest(t, t.TempDir(), magnet))
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(resp.Filename) != "image.iso" || resp.Size() != int64(len(content)) {
+		t.Errorf("Unexpected response for %s of %d bytes", resp.Filename, resp.Size())
+	}
+	if got, _ := os.ReadFile(resp.Filename); !bytes.Equal(got, content) {
+		t.Error("Downloaded file does not match torrent")
+	}
+}
+
+func TestClient_Do_TorrentFile(t *testing.T) {
+	content := make([]byte, 200000)
+	rand.New(rand.NewSource(4)).Read(content)
+	info, tracker := newSeeder(t, "image.iso", content, 16<<10)
+	dir := t.TempDir()
+	torrent := filepath.Join(dir, "image.iso.torrent")
+	b := []byte("d8:announce" + strconv.Itoa(len(tracker)) + ":" + tracker + "4:info")
+	b = append(append(b, info...), 'e')
+	if err := os.WriteFile(torrent, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// an existing partial file is resumed
+	dst := filepath.Join(dir, "image.iso")
+	if err := os.WriteFile(dst, content[:50000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient()
+	client.AllowedSchemes = []string{"torrent"}
+	client.TorrentFiles = true
+	resp := client.Do(mustNewRequest(t, dst, "torrent:"+torrent))
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.DidResume {
+		t.Error("Expected download to resume")
+	}
+	if got, _ := os.ReadFile(dst); !bytes.Equal(got, content) {
+		t.Error("Downloaded file does not match torrent")
+	}
+}
+
+func TestClient_Do_TorrentURL(t *testing.T) {
+	content := make([]byte, 100000)
+	rand.New(rand.NewSource(6)).Read(content)
+	info, tracker := newSeeder(t, "image.iso", content, 16<<10)
+	b := []byte("d8:announce" + strconv.Itoa(len(tracker)) + ":" + tracker + "4:info")
+	b = append(append(b, info...), 'e')
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		_, _ = w.Write(b)
+	}))
+	defer server.Close()
+
+	// the .torrent file is fetched like any other request of the Client
+	client := NewClient()
+	client.AllowedSchemes = []string{"torrent"}
+	resp := client.Do(mustNewRequest(t, t.TempDir(), "torrent:"+server.URL+"/image.iso.torrent"))
+	if err := resp.Err(); !errors.Is(err, ErrSchemeNotAllowed) {
+		t.Errorf("Expected ErrSchemeNotAllowed, got %v", err)
+	}
+
+	client.AllowedSchemes = []string{"torrent", "http"}
+	client.HostHeaders = map[string]http.Header{"127.0.0.1": {"Authorization": {"Bearer token"}}}
+	resp = client.Do(mustNewRequest(t, t.TempDir(), "torrent:"+server.URL+"/image.iso.torrent"))
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer token" {
+		t.Errorf("Expected host header in request for .torrent file, got %q", auth)
+	}
+	if got, _ := os.ReadFile(resp.Filename); !bytes.Equal(got, content) {
+		t.Error("Downloaded file does not match torrent")
+	}
+}
+
+func TestClient_Do_MagnetNoTracker(t *testing.T) {
+	client := NewClient()
+	client.AllowedSchemes = []string{"magnet"}
+	magnet := "magnet:?xt=urn:btih:" + strings.Repeat("ab", 20) + "&dn=image.iso"
+	err := client.Do(mustNewRequest(t, t.TempDir(), magnet)).Err()
+	if err == nil || !strings.Contains(err.Error(), "no trackers") {
+		t.Errorf("Expected error for magnet link without trackers, got %v", err)
+	}
+}
+
+func TestPeerConn_HaveOutOfRange(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer conn.Close()
+	defer remote.Close()
+	p := &peerConn{conn: conn, r: bufio.NewReader(conn), pieces: 10}
+	go func() {
+		// a have message for piece 1<<31 would otherwise grow the bitfield
+		// to 256MiB
+		peer := &peerConn{conn: remote, r: bufio.NewReader(remote)}
+		_ = peer.writeMessage(msgHave, []byte{0x80, 0, 0, 0})
+	}()
+	if _, _, err := p.receive(); err == nil {
+		t.Error("Expected error for have message of piece out of range")
+	}
+	if len(p.bitfield) != 0 {
+		t.Errorf("Expected bitfield to be unchanged, got %d bytes", len(p.bitfield))
+	}
+}
+
+func mustNewRequest(t *testing.T, dst, urlStr string) *Request {
+	req, err := NewRequest(dst, urlStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
@@ -0,0 +1,247 @@
+//go:build torrent
+
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultAnnounceInterval is the interval between announces to trackers which
+// do not specify one.
+const defaultAnnounceInterval = 30 * time.Second
+
+// announceAll announces the torrent with the given info hash to all given
+// trackers concurrently, and returns the addresses of the peers returned by
+// any of them, the shortest announce interval, and the last error if no
+// tracker returned peers.
+func announceAll(ctx context.Context, client *http.Client, trackers []string, infoHash, peerID [20]byte, left int64) ([]string, time.Duration, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		addrs    []string
+		interval = defaultAnnounceInterval
+		lastErr  = errors.New("torrent has no trackers")
+	)
+	seen := make(map[string]bool)
+	for _, tr := range trackers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peers, n, err := announce(ctx, client, tr, infoHash, peerID, left)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				lastErr = fmt.Errorf("tracker %s: %w", tr, err)
+				return
+			}
+			if n > 0 {
+				interval = min(interval, n)
+			}
+			for _, addr := range peers {
+				if !seen[addr] {
+					seen[addr] = true
+					addrs = append(addrs, addr)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(addrs) > 0 {
+		lastErr = nil
+	}
+	return addrs, interval, lastErr
+}
+
+// announce announces the torrent to an HTTP or UDP tracker and returns the
+// addresses of the peers it returned and its announce interval.
+func announce(ctx context.Context, client *http.Client, tracker string, infoHash, peerID [20]byte, left int64) ([]string, time.Duration, error) {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, peerTimeout)
+	defer cancel()
+	switch u.Scheme {
+	case "http", "https":
+		return announceHTTP(ctx, client, u, infoHash, peerID, left)
+	case "udp":
+		return announceUDP(ctx, u.Host, infoHash, peerID, left)
+	}
+	return nil, 0, fmt.Errorf("unsupported tracker scheme %q", u.Scheme)
+}
+
+func announceHTTP(ctx context.Context, client *http.Client, u *url.URL, infoHash, peerID [20]byte, left int64) ([]string, time.Duration, error) {
+	q := url.Values{}
+	q.Set("info_hash", string(infoHash[:]))
+	q.Set("peer_id", string(peerID[:]))
+	q.Set("port", "6881")
+	q.Set("uploaded", "0")
+	q.Set("downloaded", "0")
+	q.Set("left", strconv.FormatInt(left, 10))
+	q.Set("compact", "1")
+	q.Set("event", "started")
+	announceURL := u.String()
+	if u.RawQuery != "" {
+		announceURL += "&" + q.Encode()
+	} else {
+		announceURL += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, announceURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, StatusCodeError(resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxMessageSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	v, _, err := bdecode(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	d, _ := v.(map[string]any)
+	if reason, ok := d["failure reason"].(string); ok {
+		return nil, 0, errors.New(reason)
+	}
+	interval, _ := d["interval"].(int64)
+	var addrs []string
+	switch peers := d["peers"].(type) {
+	case string:
+		addrs = compactPeers([]byte(peers))
+	case []any:
+		for _, p := range peers {
+			p, _ := p.(map[string]any)
+			ip, _ := p["ip"].(string)
+			port, _ := p["port"].(int64)
+			if ip != "" && port > 0 {
+				addrs = append(addrs, net.JoinHostPort(ip, strconv.FormatInt(port, 10)))
+			}
+		}
+	}
+	return addrs, time.Duration(interval) * time.Second, nil
+}
+
+// announceUDP announces the torrent to a UDP tracker (BEP 15).
+func announceUDP(ctx context.Context, host string, infoHash, peerID [20]byte, left int64) ([]string, time.Duration, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", host)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	roundTrip := func(req []byte, action uint32) ([]byte, error) {
+		txID := make([]byte, 4)
+		_, _ = rand.Read(txID)
+		copy(req[12:], txID)
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		resp := make([]byte, 2048)
+		n, err := conn.Read(resp)
+		if err != nil {
+			return nil, err
+		}
+		resp = resp[:n]
+		if len(resp) < 8 || string(resp[4:8]) != string(txID) {
+			return nil, errors.New("bad tracker response")
+		}
+		if got := binary.BigEndian.Uint32(resp); got != action {
+			return nil, fmt.Errorf("tracker error: %s", strings.TrimSpace(string(resp[8:])))
+		}
+		return resp[8:], nil
+	}
+
+	connect := make([]byte, 16)
+	binary.BigEndian.PutUint64(connect, 0x41727101980) // protocol ID
+	binary.BigEndian.PutUint32(connect[8:], 0)         // connect
+	resp, err := roundTrip(connect, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(resp) < 8 {
+		return nil, 0, errors.New("bad tracker response")
+	}
+
+	req := make([]byte, 98)
+	copy(req, resp[:8])                    // connection ID
+	binary.BigEndian.PutUint32(req[8:], 1) // announce
+	copy(req[16:], infoHash[:])
+	copy(req[36:], peerID[:])
+	binary.BigEndian.PutUint64(req[64:], uint64(left))
+	binary.BigEndian.PutUint32(req[80:], 2)          // started
+	binary.BigEndian.PutUint32(req[92:], 0xffffffff) // default number of peers
+	binary.BigEndian.PutUint16(req[96:], 6881)
+	resp, err = roundTrip(req, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(resp) < 12 {
+		return nil, 0, errors.New("bad tracker response")
+	}
+	interval := time.Duration(binary.BigEndian.Uint32(resp)) * time.Second
+	return compactPeers(resp[12:]), interval, nil
+}
+
+// compactPeers returns the addresses of the peers in the compact format of
+// trackers, of six bytes per IPv4 peer.
+func compactPeers(b []byte) []string {
+	var addrs []string
+	for ; len(b) >= 6; b = b[6:] {
+		ip := net.IP(b[:4])
+		port := binary.BigEndian.Uint16(b[4:])
+		addrs = append(addrs, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	return addrs
+}
+
+// fetchMetadata fetches the metainfo of the torrent of a magnet link from the
+// peers returned by its trackers.
+func (t *torrentTransport) fetchMetadata(ctx context.Context, infoHash [20]byte, trackers []string) (*torrentInfo, error) {
+	addrs, _, err := announceAll(ctx, t.client, trackers, infoHash, t.peerID, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find peers: %w", err)
+	}
+	for _, addr := range addrs {
+		var p *peerConn
+		p, err = dialPeer(ctx, addr, infoHash, t.peerID)
+		if err == nil {
+			var info *torrentInfo
+			info, err = p.fetchMetadata(infoHash)
+			p.close()
+			if err == nil {
+				info.trackers = trackers
+				return info, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, fmt.Errorf("cannot fetch metadata from %d peers: %w", len(addrs), err)
+}
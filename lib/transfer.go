@@ -16,16 +16,50 @@ type transfer struct {
 	n     int64 // must be 64bit aligned on 386
 	ctx   context.Context
 	gauge gauge
+	rates *rateWindow
 	lim   RateLimiter
 	w     io.Writer
 	r     io.Reader
 	b     []byte
+
+	// raw counts the bytes read from the source if filters were applied, in
+	// which case progress is measured before filtering.
+	raw *countingReader
+
+	// maxBuf enables buffer auto-tuning and limits the size to which the
+	// buffer may grow. If zero, the buffer size is fixed.
+	maxBuf int
+
+	// grow, if set, is called before the buffer is grown by the given number
+	// of bytes and may veto it.
+	grow func(extra int) bool
+
+	// wrote, if set, is called after each write with the number of bytes
+	// written so far.
+	wrote func(written int64)
+
+	// tuned is the number of rate intervals completed when the buffer size
+	// was last considered.
+	tuned int
+
+	// limited is set while the transfer waits for its rate limiter, so that
+	// the wait is not mistaken for a stall.
+	limited atomic.Bool
 }
 
+// bufferFillsPerSecond is the rate of reads filling the whole buffer above
+// which an auto-tuned buffer is grown, as the per-read overhead then limits
+// throughput.
+const bufferFillsPerSecond = 1000
+
+// defaultMaxBufferSize is the default limit of auto-tuned buffers.
+const defaultMaxBufferSize = 4 << 20
+
 func newTransfer(ctx context.Context, lim RateLimiter, dst io.Writer, src io.Reader, buf []byte) *transfer {
 	return &transfer{
 		ctx:   ctx,
-		gauge: nil, // no-op gauge for now
+		gauge: newEWMAGauge(0, time.Now()),
+		rates: newRateWindow(time.Second, throttleIntervals+1),
 		lim:   lim,
 		w:     dst,
 		r:     src,
@@ -33,14 +67,38 @@ func newTransfer(ctx context.Context, lim RateLimiter, dst io.Writer, src io.Rea
 	}
 }
 
+// countingReader counts the bytes read from the underlying Reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// filter applies the given filters, in order, to the source of the transfer.
+func (c *transfer) filter(filters []Filter) {
+	c.raw = &countingReader{r: c.r}
+	c.r = c.raw
+	for _, f := range filters {
+		c.r = f(c.r)
+	}
+}
+
 // copy behaves similarly to io.CopyBuffer except that it checks for cancelation
 // of the given context.Context, reports progress in a thread-safe manner and
-// tracks the transfer rate.
-func (c *transfer) copy() (written int64, err error) {
+// tracks the transfer rate. It returns the number of bytes received from the
+// source, which differs from the number of bytes written if filters were
+// applied.
+func (c *transfer) copy() (received int64, err error) {
 	// start the transfer
 	if c.b == nil {
 		c.b = make([]byte, 32*1024)
 	}
+	var written int64
 	for {
 		select {
 		case <-c.ctx.Done():
@@ -54,7 +112,19 @@ func (c *transfer) copy() (written int64, err error) {
 			nw, ew := c.w.Write(c.b[0:nr])
 			if nw > 0 {
 				written += int64(nw)
-				atomic.StoreInt64(&c.n, written)
+			}
+			n := written
+			if c.raw != nil {
+				n = atomic.LoadInt64(&c.raw.n)
+			}
+			if n > received {
+				atomic.StoreInt64(&c.n, n)
+				now := time.Now()
+				c.rates.Add(now, n-received)
+				if c.gauge != nil {
+					c.gauge.Sample(now, n-received)
+				}
+				received = n
 			}
 			if ew != nil {
 				err = ew
@@ -64,9 +134,17 @@ func (c *transfer) copy() (written int64, err error) {
 				err = io.ErrShortWrite
 				break
 			}
+			if c.wrote != nil {
+				c.wrote(written)
+			}
+			if nr == len(c.b) && c.maxBuf > len(c.b) {
+				c.tune()
+			}
 			// wait for rate limiter
 			if c.lim != nil {
+				c.limited.Store(true)
 				err = c.lim.WaitN(c.ctx, nr)
+				c.limited.Store(false)
 				if err != nil {
 					return
 				}
@@ -79,7 +157,40 @@ func (c *transfer) copy() (written int64, err error) {
 			break
 		}
 	}
-	return written, err
+	if c.raw != nil {
+		received = atomic.LoadInt64(&c.raw.n)
+		atomic.StoreInt64(&c.n, received)
+	}
+	return received, err
+}
+
+// tune doubles the size of the buffer, up to maxBuf, if the rate measured in
+// the last completed interval shows that the buffer is filled more than
+// bufferFillsPerSecond times per second. The buffer is grown at most once per
+// interval, so that its effect can be measured.
+func (c *transfer) tune() {
+	intervals, bps := c.rates.last()
+	if intervals == c.tuned {
+		return
+	}
+	c.tuned = intervals
+	if bps < float64(len(c.b))*bufferFillsPerSecond {
+		return
+	}
+	size := min(2*len(c.b), c.maxBuf)
+	if c.grow != nil && !c.grow(size-len(c.b)) {
+		return
+	}
+	c.b = make([]byte, size)
+}
+
+// peakBPS returns the highest transfer rate in bytes per second measured over
+// one second.
+func (c *transfer) peakBPS() float64 {
+	if c == nil {
+		return 0
+	}
+	return c.rates.peakBPS()
 }
 
 // N returns the number of bytes transferred.
@@ -91,8 +202,13 @@ func (c *transfer) N() (n int64) {
 	return
 }
 
-// BPS returns the current bytes per second transfer rate using a simple moving
-// average.
+// waiting reports whether the transfer is waiting for its rate limiter.
+func (c *transfer) waiting() bool {
+	return c != nil && c.limited.Load()
+}
+
+// BPS returns the current bytes per second transfer rate, measured by the
+// gauge of the transfer.
 func (c *transfer) BPS() (bps float64) {
 	if c == nil || c.gauge == nil {
 		return 0
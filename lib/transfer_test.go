@@ -370,6 +370,41 @@ func TestTransfer_N_AfterCopy(t *testing.T) {
 	}
 }
 
+func TestTransfer_Copy_Filter(t *testing.T) {
+	ctx := context.Background()
+	src := strings.NewReader("HEADERpayload")
+	dst := &bytes.Buffer{}
+
+	transfer := newTransfer(ctx, nil, dst, src, nil)
+	transfer.filter([]Filter{
+		func(r io.Reader) io.Reader {
+			// strip the 6 byte header
+			_, _ = io.CopyN(io.Discard, r, 6)
+			return r
+		},
+		func(r io.Reader) io.Reader {
+			return strings.NewReader(strings.ToUpper(mustReadAll(r)))
+		},
+	})
+
+	received, err := transfer.copy()
+	if err != nil {
+		t.Fatalf("copy() failed: %v", err)
+	}
+	if dst.String() != "PAYLOAD" {
+		t.Errorf("Expected filtered content %q, got %q", "PAYLOAD", dst.String())
+	}
+	if received != 13 || transfer.N() != 13 {
+		t.Errorf("Expected progress measured before filtering (13 bytes), got %d and N() %d", received, transfer.N())
+	}
+}
+
+// mustReadAll returns the remaining content of the given Reader.
+func mustReadAll(r io.Reader) string {
+	b, _ := io.ReadAll(r)
+	return string(b)
+}
+
 func TestTransfer_N_Nil(t *testing.T) {
 	var transfer *transfer = nil
 
@@ -526,3 +561,58 @@ func BenchmarkTransfer_N(b *testing.B) {
 		_ = transfer.N()
 	}
 }
+
+// zeroReader fills every read with zero bytes until a read with a buffer of
+// at least until bytes, or until the deadline passes.
+type zeroReader struct {
+	until    int
+	deadline time.Time
+}
+
+func (r zeroReader) Read(p []byte) (int, error) {
+	if (r.until > 0 && len(p) >= r.until) || time.Now().After(r.deadline) {
+		return 0, io.EOF
+	}
+	clear(p)
+	return len(p), nil
+}
+
+func TestTransfer_AutoTuneBuffer(t *testing.T) {
+	t.Run("fast transfer grows buffer", func(t *testing.T) {
+		src := zeroReader{until: 16 * 1024, deadline: time.Now().Add(5 * time.Second)}
+		transfer := newTransfer(context.Background(), nil, io.Discard, src, make([]byte, 1024))
+		transfer.rates = newRateWindow(time.Millisecond, 2)
+		transfer.maxBuf = 16 * 1024
+		if _, err := transfer.copy(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(transfer.b) != transfer.maxBuf {
+			t.Errorf("Expected buffer to grow to %d bytes, got %d", transfer.maxBuf, len(transfer.b))
+		}
+	})
+
+	t.Run("slow transfer keeps buffer", func(t *testing.T) {
+		src := &mockReader{data: make([]byte, 20*1024), readDelay: 5 * time.Millisecond}
+		transfer := newTransfer(context.Background(), nil, io.Discard, src, make([]byte, 1024))
+		transfer.rates = newRateWindow(20*time.Millisecond, 2)
+		transfer.maxBuf = 16 * 1024
+		if _, err := transfer.copy(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(transfer.b) != 1024 {
+			t.Errorf("Expected buffer to keep 1024 bytes, got %d", len(transfer.b))
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		src := zeroReader{deadline: time.Now().Add(50 * time.Millisecond)}
+		transfer := newTransfer(context.Background(), nil, io.Discard, src, make([]byte, 1024))
+		transfer.rates = newRateWindow(time.Millisecond, 2)
+		if _, err := transfer.copy(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(transfer.b) != 1024 {
+			t.Errorf("Expected fixed buffer of 1024 bytes, got %d", len(transfer.b))
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// A TransportOption configures the http.Transport of a Client created with
+// NewClient.
+type TransportOption func(*http.Transport)
+
+// newTransport returns the default http.Transport used by NewClient, with the
+// given options applied. The defaults match those of http.DefaultTransport.
+func newTransport(opts ...TransportOption) *http.Transport {
+	t := &http.Transport{
+		Proxy:                 proxyFunc([]ProxyRule{EnvironmentProxy()}),
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	registerTorrent(t)
+	registerFTP(t)
+	registerData(t)
+	return t
+}
+
+// WithResponseHeaderTimeout limits the time to wait for the response headers
+// of the remote server after the request has been sent. Servers which are slow
+// to respond fail quickly, while the transfer of the response body remains
+// unbounded.
+func WithResponseHeaderTimeout(d time.Duration) TransportOption {
+	return func(t *http.Transport) {
+		t.ResponseHeaderTimeout = d
+	}
+}
+
+// WithMaxIdleConns limits the number of idle connections kept open for reuse
+// across all hosts. Zero means no limit. Default: 100.
+func WithMaxIdleConns(n int) TransportOption {
+	return func(t *http.Transport) {
+		t.MaxIdleConns = n
+	}
+}
+
+// WithMaxIdleConnsPerHost limits the number of idle connections kept open for
+// reuse for each host. Batches of many downloads from the same host should set
+// this to at least the number of workers. Default: 2.
+func WithMaxIdleConnsPerHost(n int) TransportOption {
+	return func(t *http.Transport) {
+		t.MaxIdleConnsPerHost = n
+	}
+}
+
+// WithIdleConnTimeout specifies how long an idle connection is kept open for
+// reuse. Zero means no limit. Default: 90 seconds.
+func WithIdleConnTimeout(d time.Duration) TransportOption {
+	return func(t *http.Transport) {
+		t.IdleConnTimeout = d
+	}
+}
+
+// WithTLSHandshakeTimeout limits the time to wait for a TLS handshake. Zero
+// means no limit. Default: 10 seconds.
+func WithTLSHandshakeTimeout(d time.Duration) TransportOption {
+	return func(t *http.Transport) {
+		t.TLSHandshakeTimeout = d
+	}
+}
+
+// WithForceAttemptHTTP2 specifies whether HTTP/2 is attempted for HTTPS
+// connections. Default: true.
+func WithForceAttemptHTTP2(b bool) TransportOption {
+	return func(t *http.Transport) {
+		t.ForceAttemptHTTP2 = b
+	}
+}
+
+// WithProxy specifies a function which returns the proxy to use for a given
+// request, or nil for a direct connection. Use WithProxyRules instead to make
+// the choice of proxy explainable. Default: EnvironmentProxy.
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) TransportOption {
+	return func(t *http.Transport) {
+		t.Proxy = proxy
+	}
+}
+
+// WithProxyURL routes all requests through the proxy at the given URL. If u is
+// nil, requests connect directly, ignoring any proxy environment variables.
+func WithProxyURL(u *url.URL) TransportOption {
+	if u == nil {
+		return WithProxy(nil)
+	}
+	return WithProxyRules(ExplicitProxy(u, "set by WithProxyURL"))
+}
+
+// A Protocol selects the HTTP protocol versions used by a Client.
+type Protocol int
+
+const (
+	// ProtocolAuto uses HTTP/2 for HTTPS servers which support it and
+	// HTTP/1.1 otherwise.
+	ProtocolAuto Protocol = iota
+
+	// ProtocolHTTP1 uses HTTP/1.1 only, for servers which misbehave under
+	// HTTP/2.
+	ProtocolHTTP1
+
+	// ProtocolHTTP2 uses HTTP/2 over TLS only. Requests fail if the server
+	// does not support HTTP/2, or if the URL is not HTTPS.
+	ProtocolHTTP2
+
+	// ProtocolHTTP2PriorKnowledge uses HTTP/2 only, including unencrypted
+	// HTTP/2 without an upgrade for URLs which are not HTTPS, as for servers
+	// known to support it.
+	ProtocolHTTP2PriorKnowledge
+)
+
+// String returns the name of the protocol selection.
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolAuto:
+		return "auto"
+	case ProtocolHTTP1:
+		return "HTTP/1.1"
+	case ProtocolHTTP2:
+		return "HTTP/2"
+	case ProtocolHTTP2PriorKnowledge:
+		return "HTTP/2 (prior knowledge)"
+	}
+	return fmt.Sprintf("Protocol(%d)", int(p))
+}
+
+// WithProtocol forces the given HTTP protocol versions. Default: ProtocolAuto.
+func WithProtocol(p Protocol) TransportOption {
+	return func(t *http.Transport) {
+		protocols := new(http.Protocols)
+		switch p {
+		case ProtocolHTTP1:
+			protocols.SetHTTP1(true)
+		case ProtocolHTTP2:
+			protocols.SetHTTP2(true)
+		case ProtocolHTTP2PriorKnowledge:
+			protocols.SetHTTP2(true)
+			protocols.SetUnencryptedHTTP2(true)
+		default:
+			t.Protocols = nil
+			return
+		}
+		t.Protocols = protocols
+	}
+}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// clientTransport returns the http.Transport of a Client created with
+// NewClient.
+func clientTransport(t *testing.T, c *Client) *http.Transport {
+	t.Helper()
+	hc, ok := c.HTTPClient.(*http.Client)
+	if !ok {
+		t.Fatalf("Expected *http.Client, got %T", c.HTTPClient)
+	}
+	tr, ok := hc.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Expected *http.Transport, got %T", hc.Transport)
+	}
+	return tr
+}
+
+func TestNewClient_DefaultTransport(t *testing.T) {
+	tr := clientTransport(t, NewClient())
+	if tr.Proxy == nil {
+		t.Error("Expected default transport to use proxy from environment")
+	}
+	if tr.ResponseHeaderTimeout != 0 {
+		t.Errorf("Expected no response header timeout, got %v", tr.ResponseHeaderTimeout)
+	}
+	if !tr.ForceAttemptHTTP2 {
+		t.Error("Expected HTTP/2 to be attempted by default")
+	}
+	if tr.MaxIdleConns != 100 || tr.IdleConnTimeout != 90*time.Second || tr.TLSHandshakeTimeout != 10*time.Second {
+		t.Errorf("Expected defaults of http.DefaultTransport, got MaxIdleConns %d, IdleConnTimeout %v, TLSHandshakeTimeout %v",
+			tr.MaxIdleConns, tr.IdleConnTimeout, tr.TLSHandshakeTimeout)
+	}
+}
+
+func TestWithResponseHeaderTimeout(t *testing.T) {
+	tr := clientTransport(t, NewClient(WithResponseHeaderTimeout(5*time.Second)))
+	if tr.ResponseHeaderTimeout != 5*time.Second {
+		t.Errorf("Expected response header timeout 5s, got %v", tr.ResponseHeaderTimeout)
+	}
+}
+
+func TestTransportOptions(t *testing.T) {
+	proxyURL, _ := url.Parse("http://proxy.example.com:3128")
+	tr := clientTransport(t, NewClient(
+		WithMaxIdleConns(10),
+		WithMaxIdleConnsPerHost(8),
+		WithIdleConnTimeout(time.Minute),
+		WithTLSHandshakeTimeout(3*time.Second),
+		WithForceAttemptHTTP2(false),
+		WithProxyURL(proxyURL),
+	))
+	if tr.MaxIdleConns != 10 {
+		t.Errorf("Expected MaxIdleConns 10, got %d", tr.MaxIdleConns)
+	}
+	if tr.MaxIdleConnsPerHost != 8 {
+		t.Errorf("Expected MaxIdleConnsPerHost 8, got %d", tr.MaxIdleConnsPerHost)
+	}
+	if tr.IdleConnTimeout != time.Minute {
+		t.Errorf("Expected IdleConnTimeout 1m, got %v", tr.IdleConnTimeout)
+	}
+	if tr.TLSHandshakeTimeout != 3*time.Second {
+		t.Errorf("Expected TLSHandshakeTimeout 3s, got %v", tr.TLSHandshakeTimeout)
+	}
+	if tr.ForceAttemptHTTP2 {
+		t.Error("Expected ForceAttemptHTTP2 to be disabled")
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/file", nil)
+	if u, err := tr.Proxy(req); err != nil || u.String() != proxyURL.String() {
+		t.Errorf("Expected proxy %v, got %v (%v)", proxyURL, u, err)
+	}
+
+	tr = clientTransport(t, NewClient(WithProxyURL(nil)))
+	if tr.Proxy != nil {
+		t.Error("Expected no proxy")
+	}
+}
+
+func TestWithProtocol(t *testing.T) {
+	tests := []struct {
+		protocol    Protocol
+		server      func(*http.Protocols)
+		tls         bool
+		expectMajor int
+	}{
+		{ProtocolAuto, func(p *http.Protocols) { p.SetHTTP1(true); p.SetHTTP2(true) }, true, 2},
+		{ProtocolHTTP1, func(p *http.Protocols) { p.SetHTTP1(true); p.SetHTTP2(true) }, true, 1},
+		{ProtocolHTTP2, func(p *http.Protocols) { p.SetHTTP1(true); p.SetHTTP2(true) }, true, 2},
+		{ProtocolHTTP2PriorKnowledge, func(p *http.Protocols) { p.SetUnencryptedHTTP2(true) }, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.protocol.String(), func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, "content")
+			}))
+			srv.Config.Protocols = new(http.Protocols)
+			tt.server(srv.Config.Protocols)
+			if tt.tls {
+				srv.EnableHTTP2 = true
+				srv.StartTLS()
+			} else {
+				srv.Start()
+			}
+			defer srv.Close()
+
+			client := NewClient(WithProtocol(tt.protocol))
+			tr := clientTransport(t, client)
+			if tt.tls {
+				tr.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+			}
+			req, _ := NewRequest("", srv.URL+"/file")
+			req.NoStore = true
+			resp := client.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if resp.HTTPResponse.ProtoMajor != tt.expectMajor {
+				t.Errorf("Expected HTTP/%d, got %s", tt.expectMajor, resp.HTTPResponse.Proto)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package lib
+
+import "net/http"
+
+// setUnmodifiedSince asks the server to refuse the Request with 412
+// Precondition Failed if the remote file was modified after
+// Request.UnmodifiedSince.
+func setUnmodifiedSince(req *Request) {
+	if req.UnmodifiedSince.IsZero() {
+		return
+	}
+	req.HTTPRequest.Header.Set("If-Unmodified-Since", req.UnmodifiedSince.UTC().Format(http.TimeFormat))
+}
+
+// checkUnmodified returns a ModifiedError if the response of the server shows
+// that the remote file was modified after Request.UnmodifiedSince.
+func checkUnmodified(resp *Response) error {
+	since := resp.Request.UnmodifiedSince
+	if since.IsZero() || resp.HTTPResponse == nil {
+		return nil
+	}
+	if resp.HTTPResponse.StatusCode == http.StatusPreconditionFailed {
+		return &ModifiedError{Since: since}
+	}
+	t, err := http.ParseTime(resp.HTTPResponse.Header.Get("Last-Modified"))
+	if err != nil || !t.After(since) {
+		return nil
+	}
+	return &ModifiedError{Since: since, LastModified: t}
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_Do_UnmodifiedSince(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ignored" {
+			// a server which ignores If-Unmodified-Since
+			w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+			_, _ = w.Write([]byte("content"))
+			return
+		}
+		http.ServeContent(w, r, "", modTime, strings.NewReader("content"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		since    time.Time
+		wantErr  bool
+		wantTime time.Time
+	}{
+		{"unmodified", "/file", modTime.Add(time.Hour), false, time.Time{}},
+		{"precondition failed", "/file", modTime.Add(-time.Hour), true, time.Time{}},
+		{"last modified", "/ignored", modTime.Add(-time.Hour), true, modTime},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := NewRequest(filepath.Join(t.TempDir(), "file.txt"), server.URL+test.path)
+			req.UnmodifiedSince = test.since
+			err := NewClient().Do(req).Err()
+			if !test.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var modErr *ModifiedError
+			if !errors.As(err, &modErr) || !errors.Is(err, ErrModified) {
+				t.Fatalf("Expected ModifiedError, got %v", err)
+			}
+			if !modErr.LastModified.Equal(test.wantTime) || !modErr.Since.Equal(test.since) {
+				t.Errorf("Unexpected error: %+v", modErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// modulePath is the import path of the grab module.
+const modulePath = "github.com/sebrandon1/grab"
+
+// projectURL is the home page of the grab project.
+const projectURL = "https://" + modulePath
+
+// Version returns the version of the grab module compiled into the running
+// binary, or "devel" if it cannot be determined.
+func Version() string {
+	return buildVersion()
+}
+
+// buildVersion reads the version returned by Version from the build
+// information of the binary once.
+var buildVersion = sync.OnceValue(func() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+	version := bi.Main.Version
+	if bi.Main.Path != modulePath {
+		version = ""
+		for _, dep := range bi.Deps {
+			if dep.Path == modulePath {
+				version = dep.Version
+				break
+			}
+		}
+	}
+	if version == "" || version == "(devel)" {
+		return "devel"
+	}
+	return version
+})
+
+// expandUserAgent expands the placeholders in the given User-Agent template:
+//
+//	{version}  the version of grab, as returned by Version
+//	{os}       the operating system, as in runtime.GOOS
+//	{arch}     the architecture, as in runtime.GOARCH
+//	{url}      the home page of the grab project
+//
+// Text without placeholders is returned unchanged.
+func expandUserAgent(tmpl string) string {
+	if !strings.Contains(tmpl, "{") {
+		return tmpl
+	}
+	return strings.NewReplacer(
+		"{version}", Version(),
+		"{os}", runtime.GOOS,
+		"{arch}", runtime.GOARCH,
+		"{url}", projectURL,
+	).Replace(tmpl)
+}
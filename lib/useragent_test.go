@@ -0,0 +1,31 @@
+package lib
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	if Version() == "" {
+		t.Error("Expected non-empty version")
+	}
+}
+
+func TestExpandUserAgent(t *testing.T) {
+	tests := []struct {
+		tmpl   string
+		expect string
+	}{
+		{"grab", "grab"},
+		{"", ""},
+		{"grab/{version}", "grab/" + Version()},
+		{"grab ({os}/{arch})", "grab (" + runtime.GOOS + "/" + runtime.GOARCH + ")"},
+		{"grab +{url}", "grab +https://github.com/sebrandon1/grab"},
+		{"grab {unknown}", "grab {unknown}"},
+	}
+	for _, tt := range tests {
+		if got := expandUserAgent(tt.tmpl); got != tt.expect {
+			t.Errorf("expandUserAgent(%q) = %q, expected %q", tt.tmpl, got, tt.expect)
+		}
+	}
+}
@@ -8,25 +8,8 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
-	"time"
 )
 
-// setLastModified sets the last modified timestamp of a local file according to
-// the Last-Modified header returned by a remote server.
-func setLastModified(resp *http.Response, filename string) error {
-	// https://tools.ietf.org/html/rfc7232#section-2.2
-	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Last-Modified
-	header := resp.Header.Get("Last-Modified")
-	if header == "" {
-		return nil
-	}
-	lastmod, err := time.Parse(http.TimeFormat, header)
-	if err != nil {
-		return nil
-	}
-	return os.Chtimes(filename, lastmod, lastmod)
-}
-
 // mkdirp creates all missing parent directories for the destination file path.
 func mkdirp(path string) error {
 	dir := filepath.Dir(path)
@@ -43,6 +26,27 @@ func mkdirp(path string) error {
 	return nil
 }
 
+// writeFilePrivate replaces the named file with b by renaming a temporary file
+// over it, so that readers never see a partial file. The file is only
+// readable by its owner.
+func writeFilePrivate(name string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
 // guessFilename returns a filename for the given http.Response. If none can be
 // determined ErrNoFilename is returned.
 func guessFilename(resp *http.Response) (string, error) {
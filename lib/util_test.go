@@ -10,8 +10,8 @@ import (
 	"time"
 )
 
-func TestSetLastModified_Success(t *testing.T) {
-	setupTestDirectoryWithCleanup(t, "util_setlastmod_success")
+func TestApplyMetadata_Success(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "util_applymetadata_success")
 
 	// Create a test file
 	testFile := "test_lastmod.txt"
@@ -28,10 +28,10 @@ func TestSetLastModified_Success(t *testing.T) {
 	}
 	resp.Header.Set("Last-Modified", lastModTime.Format(http.TimeFormat))
 
-	// Test setLastModified
-	err = setLastModified(resp, testFile)
+	// Test applyMetadata
+	err = applyMetadata(testFile, metadataFromHeader(resp.Header))
 	if err != nil {
-		t.Errorf("setLastModified() returned error: %v", err)
+		t.Errorf("applyMetadata() returned error: %v", err)
 	}
 
 	// Verify the file timestamp was updated
@@ -49,8 +49,8 @@ func TestSetLastModified_Success(t *testing.T) {
 	}
 }
 
-func TestSetLastModified_NoHeader(t *testing.T) {
-	setupTestDirectoryWithCleanup(t, "util_setlastmod_noheader")
+func TestApplyMetadata_NoHeader(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "util_applymetadata_noheader")
 
 	// Create a test file
 	testFile := "test_noheader.txt"
@@ -72,10 +72,10 @@ func TestSetLastModified_NoHeader(t *testing.T) {
 		Header: make(http.Header),
 	}
 
-	// Test setLastModified (should be no-op)
-	err = setLastModified(resp, testFile)
+	// Test applyMetadata (should be no-op)
+	err = applyMetadata(testFile, metadataFromHeader(resp.Header))
 	if err != nil {
-		t.Errorf("setLastModified() should not return error when no header present: %v", err)
+		t.Errorf("applyMetadata() should not return error when no header present: %v", err)
 	}
 
 	// Verify the file timestamp was not changed
@@ -89,8 +89,8 @@ func TestSetLastModified_NoHeader(t *testing.T) {
 	}
 }
 
-func TestSetLastModified_InvalidHeader(t *testing.T) {
-	setupTestDirectoryWithCleanup(t, "util_setlastmod_invalid")
+func TestApplyMetadata_InvalidHeader(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "util_applymetadata_invalid")
 
 	// Create a test file
 	testFile := "test_invalid.txt"
@@ -106,15 +106,15 @@ func TestSetLastModified_InvalidHeader(t *testing.T) {
 	}
 	resp.Header.Set("Last-Modified", "invalid-date-format")
 
-	// Test setLastModified (should handle gracefully)
-	err = setLastModified(resp, testFile)
+	// Test applyMetadata (should handle gracefully)
+	err = applyMetadata(testFile, metadataFromHeader(resp.Header))
 	if err != nil {
-		t.Errorf("setLastModified() should handle invalid date gracefully: %v", err)
+		t.Errorf("applyMetadata() should handle invalid date gracefully: %v", err)
 	}
 }
 
-func TestSetLastModified_NonExistentFile(t *testing.T) {
-	setupTestDirectoryWithCleanup(t, "util_setlastmod_nonexistent")
+func TestApplyMetadata_NonExistentFile(t *testing.T) {
+	setupTestDirectoryWithCleanup(t, "util_applymetadata_nonexistent")
 
 	// Create HTTP response with Last-Modified header
 	lastModTime := time.Date(2023, 10, 15, 12, 30, 45, 0, time.UTC)
@@ -123,10 +123,10 @@ func TestSetLastModified_NonExistentFile(t *testing.T) {
 	}
 	resp.Header.Set("Last-Modified", lastModTime.Format(http.TimeFormat))
 
-	// Test setLastModified on non-existent file
-	err := setLastModified(resp, "nonexistent.txt")
+	// Test applyMetadata on non-existent file
+	err := applyMetadata("nonexistent.txt", metadataFromHeader(resp.Header))
 	if err == nil {
-		t.Error("setLastModified() should return error for non-existent file")
+		t.Error("applyMetadata() should return error for non-existent file")
 	}
 }
 
@@ -432,8 +432,8 @@ func TestGuessFilename_PathTraversal(t *testing.T) {
 }
 
 // Benchmark tests
-func BenchmarkSetLastModified(b *testing.B) {
-	setupBenchmarkDirectory(b, "util_bench_setlastmod")
+func BenchmarkApplyMetadata(b *testing.B) {
+	setupBenchmarkDirectory(b, "util_bench_applymetadata")
 
 	// Create a test file
 	testFile := "bench_file.txt"
@@ -452,7 +452,7 @@ func BenchmarkSetLastModified(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = setLastModified(resp, testFile)
+		_ = applyMetadata(testFile, metadataFromHeader(resp.Header))
 	}
 }
 
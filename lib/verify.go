@@ -0,0 +1,276 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A VerifyResult describes whether a local file is a current and intact copy
+// of a remote file, as reported by Client.Verify.
+type VerifyResult struct {
+	// Filename is the path of the local file.
+	Filename string
+
+	// Exists reports whether the local file exists.
+	Exists bool
+
+	// LocalSize and RemoteSize are the sizes of the local and remote files in
+	// bytes. RemoteSize is -1 if the server did not report it.
+	LocalSize  int64
+	RemoteSize int64
+
+	// LocalModTime and RemoteModTime are the modification times of the local
+	// and remote files. RemoteModTime is zero if the server did not report
+	// it.
+	LocalModTime  time.Time
+	RemoteModTime time.Time
+
+	// ETag is the entity tag of the remote file, if any.
+	ETag string
+
+	// Current reports whether the size, modification time and ETag of the
+	// local file, as far as they are known, match the remote file.
+	Current bool
+
+	// Checksummed reports whether the content of the local file was compared
+	// with a checksum, given by Request.SetChecksum, Request.BlockManifest or
+	// the headers of the remote server.
+	Checksummed bool
+
+	// Intact reports whether the local file passed all checksums which were
+	// compared. It is true if no checksum was compared and the file exists.
+	Intact bool
+
+	// Problems describes each mismatch which was found.
+	Problems []string
+}
+
+// OK reports whether the local file is both current and intact.
+func (r *VerifyResult) OK() bool {
+	return r.Current && r.Intact
+}
+
+func (r *VerifyResult) problemf(format string, a ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, a...))
+}
+
+// Verify checks whether the local file of the given Request is a current and
+// intact copy of the remote file, without writing to it.
+//
+// The metadata of the remote file is requested with a HEAD request, or a
+// ranged GET request if the server rejects HEAD requests or Client.NoHEAD is
+// set, and compared with the local file: its size, its modification time
+// unless Request.IgnoreRemoteTime is set, and its ETag if one was stored by
+// the Sidecar of the Client. The content of the local file is compared with
+// the checksum set by Request.SetChecksum or Request.SetChecksumURL, or else
+// any checksum advertised by the server unless Request.IgnoreRemoteChecksum is
+// set, and the blocks of Request.BlockManifest, if set. Checksums are cached
+// by the ChecksumCache of the Client, if set.
+//
+// An error is only returned if the remote file could not be examined or the
+// local file could not be read; mismatches are reported by the result.
+func (c *Client) Verify(req *Request) (*VerifyResult, error) {
+	hresp, err := c.verifyRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &VerifyResult{
+		Filename:   req.Filename,
+		RemoteSize: hresp.ContentLength,
+		ETag:       hresp.Header.Get("ETag"),
+	}
+	if result.Filename == "" || isDirName(result.Filename) || isDir(result.Filename) {
+		name, err := guessFilename(hresp)
+		if err != nil {
+			return nil, err
+		}
+		name += c.contentTypeExtension(name, hresp)
+		result.Filename = filepath.Join(req.Filename, name)
+	}
+	md := metadataFromHeader(hresp.Header)
+	result.RemoteModTime = md.ModTime
+
+	fi, err := os.Stat(result.Filename)
+	if os.IsNotExist(err) {
+		result.problemf("%s does not exist", result.Filename)
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Exists = true
+	result.LocalSize = fi.Size()
+	result.LocalModTime = fi.ModTime()
+
+	result.Current = true
+	if result.RemoteSize >= 0 && result.RemoteSize != result.LocalSize {
+		result.Current = false
+		result.problemf("size is %d bytes, remote file is %d bytes", result.LocalSize, result.RemoteSize)
+	}
+	if !req.IgnoreRemoteTime && !md.ModTime.IsZero() && !fi.ModTime().Truncate(time.Second).Equal(md.ModTime) {
+		result.Current = false
+		result.problemf("modified %v, remote file modified %v", fi.ModTime().UTC().Format(time.RFC3339), md.ModTime.UTC().Format(time.RFC3339))
+	}
+	if c.Sidecar != nil && result.ETag != "" {
+		if stored, err := c.Sidecar.Read(result.Filename); err == nil && stored[MetadataETag] != "" && stored[MetadataETag] != result.ETag {
+			result.Current = false
+			result.problemf("ETag is %s, remote file ETag is %s", stored[MetadataETag], result.ETag)
+		}
+	}
+
+	result.Intact = true
+	algorithm, h, sum := req.hashAlgorithm, req.hash, req.checksum
+	if req.checksumURL != "" {
+		if sum, err = c.checksumFromURL(req); err != nil {
+			return nil, err
+		}
+	}
+	if h == nil && !req.IgnoreRemoteChecksum {
+		algorithm, h, sum = remoteChecksum(hresp, req.VerifyServerDigest, false)
+	}
+	if h != nil {
+		result.Checksummed = true
+		local, err := c.fileChecksum(result.Filename, algorithm, h)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(local, sum) {
+			result.Intact = false
+			result.problemf("checksum is %x, expected %x", local, sum)
+		}
+	}
+	if m := req.BlockManifest; m != nil {
+		if err := m.validate(); err != nil {
+			return nil, err
+		}
+		result.Checksummed = true
+		if err := verifyBlocks(result, m); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// verifyRequest requests the metadata of the remote file of the given Request
+// and returns the response, whose body is closed. The ContentLength of the
+// response is the size of the remote file, or -1 if unknown.
+func (c *Client) verifyRequest(req *Request) (*http.Response, error) {
+	if !c.NoHEAD {
+		hreq := req.HTTPRequest.Clone(req.Context())
+		hreq.Method = http.MethodHead
+		hresp, err := c.doHTTPRequest(hreq)
+		if err != nil {
+			return nil, err
+		}
+		_ = hresp.Body.Close()
+		if !headRejected(hresp.StatusCode) {
+			if hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+				return nil, StatusCodeError(hresp.StatusCode)
+			}
+			return hresp, nil
+		}
+	}
+
+	hreq := req.HTTPRequest.Clone(req.Context())
+	hreq.Header.Set("Range", "bytes=0-0")
+	hresp, err := c.doHTTPRequest(hreq)
+	if err != nil {
+		return nil, err
+	}
+	_ = hresp.Body.Close()
+	switch hresp.StatusCode {
+	case http.StatusPartialContent:
+		size, err := contentRangeSize(hresp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		hresp.ContentLength = size
+	case http.StatusOK:
+	default:
+		return nil, StatusCodeError(hresp.StatusCode)
+	}
+	return hresp, nil
+}
+
+// verifyBlocks compares each block of the local file of the result with the
+// given manifest.
+func verifyBlocks(result *VerifyResult, m *BlockManifest) error {
+	f, err := os.Open(result.Filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var corrupt []int
+	for i := range m.Blocks {
+		ok, err := m.verify(f, i)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			corrupt = append(corrupt, i)
+		}
+	}
+	if result.LocalSize > m.Size {
+		result.Intact = false
+		result.problemf("file is %d bytes longer than its block manifest", result.LocalSize-m.Size)
+	}
+	if len(corrupt) > 0 {
+		result.Intact = false
+		result.problemf("%d of %d blocks do not match the block manifest", len(corrupt), len(m.Blocks))
+	}
+	return nil
+}
+
+// fileChecksum returns the checksum of the named file computed with h, using
+// and updating the ChecksumCache of the client if set and the algorithm of h
+// is named.
+func (c *Client) fileChecksum(name, algorithm string, h hash.Hash) ([]byte, error) {
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	cache := c.ChecksumCache
+	if algorithm == "" {
+		cache = nil
+	}
+	if cache != nil {
+		if sum, ok := cache.get(name, algorithm, fi); ok {
+			return sum, nil
+		}
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h.Reset()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	sum := h.Sum(nil)
+	if cache != nil {
+		cache.put(name, algorithm, fi, sum)
+	}
+	return sum, nil
+}
+
+// isDir reports whether name is an existing directory.
+func isDir(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.IsDir()
+}
@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_Verify(t *testing.T) {
+	content := "verified content"
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sum := sha256.Sum256([]byte(content))
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file.txt", modTime, strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "file.txt")
+	write := func(s string) {
+		if err := os.WriteFile(filename, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verify := func(configure func(*Request)) *VerifyResult {
+		req, _ := NewRequest(dir, server.URL+"/file.txt")
+		if configure != nil {
+			configure(req)
+		}
+		result, err := NewClient().Verify(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	result := verify(nil)
+	if result.Exists || result.OK() || result.Filename != filename {
+		t.Errorf("Expected missing file, got %+v", result)
+	}
+
+	write(content)
+	result = verify(func(req *Request) { req.SetChecksum(sha256.New(), sum[:], false) })
+	if !result.OK() || !result.Checksummed || len(result.Problems) != 0 {
+		t.Errorf("Expected current and intact file, got %+v", result)
+	}
+	if result.RemoteSize != int64(len(content)) || result.ETag != `"v1"` || !result.RemoteModTime.Equal(modTime) {
+		t.Errorf("Unexpected remote metadata: %+v", result)
+	}
+	for _, m := range methods {
+		if m != http.MethodHead {
+			t.Errorf("Expected only HEAD requests, got %s", m)
+		}
+	}
+
+	// same size and time, but different content
+	write(strings.ToUpper(content))
+	result = verify(func(req *Request) { req.SetChecksum(sha256.New(), sum[:], false) })
+	if !result.Current || result.Intact || len(result.Problems) != 1 {
+		t.Errorf("Expected checksum mismatch, got %+v", result)
+	}
+	manifest, _ := NewBlockManifest(strings.NewReader(content), "sha256", 4)
+	result = verify(func(req *Request) { req.BlockManifest = manifest })
+	if result.Intact || !strings.Contains(result.Problems[0], "4 of 4 blocks") {
+		t.Errorf("Expected block mismatch, got %+v", result)
+	}
+
+	// stale file
+	write(content + " v0")
+	if err := os.Chtimes(filename, modTime.Add(-time.Hour), modTime.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	result = verify(nil)
+	if result.Current || !result.Intact || result.Checksummed || len(result.Problems) != 2 {
+		t.Errorf("Expected stale file, got %+v", result)
+	}
+	if data, _ := os.ReadFile(filename); string(data) != content+" v0" {
+		t.Error("Verify modified the local file")
+	}
+}
+
+func TestClient_Verify_NoHEAD(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer server.Close()
+
+	filename := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(filename, []byte("01234"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := NewRequest(filename, server.URL+"/file.txt")
+	result, err := NewClient().Verify(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RemoteSize != 10 || result.Current {
+		t.Errorf("Expected size mismatch from probe, got %+v", result)
+	}
+
+	req, _ = NewRequest(filename, server.URL+"/missing")
+	server.Config.Handler = http.NotFoundHandler()
+	if _, err := NewClient().Verify(req); err == nil {
+		t.Error("Expected error for missing remote file")
+	}
+}
@@ -0,0 +1,33 @@
+package lib
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+)
+
+// workerKey is the context key of the ID of a batch worker.
+type workerKey struct{}
+
+// WithWorkerID returns a copy of ctx which identifies the batch worker with the
+// given ID, for callers which run their own workers with DoChannel. The
+// workers of DoBatch and DoBatchWithOptions are numbered from one.
+func WithWorkerID(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, workerKey{}, id)
+}
+
+// WorkerID returns the ID of the batch worker identified by ctx, such as the
+// context of a Request passed to a hook, or zero if the request was not sent
+// by a batch worker.
+func WorkerID(ctx context.Context) int {
+	id, _ := ctx.Value(workerKey{}).(int)
+	return id
+}
+
+// runWorker calls f as the batch worker with the given ID, which is set in
+// the context passed to f and as the pprof label "grab_worker" of its
+// goroutines, so that the goroutines of a stuck worker can be found in a
+// goroutine profile.
+func runWorker(ctx context.Context, id int, f func(ctx context.Context)) {
+	pprof.Do(WithWorkerID(ctx, id), pprof.Labels("grab_worker", strconv.Itoa(id)), f)
+}
@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClient_DoBatch_WorkerID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	for _, ordered := range []bool{false, true} {
+		t.Run("Ordered="+strconv.FormatBool(ordered), func(t *testing.T) {
+			var mu sync.Mutex
+			labels := make(map[*Request]string)
+			dir := t.TempDir()
+			requests := make([]*Request, 6)
+			for i := range requests {
+				requests[i], _ = NewRequest(dir, server.URL+"/file"+strconv.Itoa(i))
+				requests[i].BeforeCopy = func(resp *Response) error {
+					label, _ := pprof.Label(resp.Request.Context(), "grab_worker")
+					if WorkerID(resp.Request.Context()) != resp.Worker() {
+						t.Errorf("Expected WorkerID of context to match %d", resp.Worker())
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					labels[resp.Request] = label
+					return nil
+				}
+			}
+
+			opts := BatchOptions{Workers: 2, Ordered: ordered}
+			for resp := range NewClient().DoBatchWithOptions(context.Background(), opts, requests...) {
+				if err := resp.Err(); err != nil {
+					t.Fatal(err)
+				}
+				id := resp.Worker()
+				if id < 1 || id > 2 {
+					t.Errorf("Expected worker 1 or 2, got %d", id)
+				}
+				mu.Lock()
+				if label := labels[resp.Request]; label != strconv.Itoa(id) {
+					t.Errorf("Expected pprof label %d, got %q", id, label)
+				}
+				mu.Unlock()
+				for _, e := range resp.Events() {
+					if e.Worker != id || !strings.Contains(e.String(), "[worker "+strconv.Itoa(id)+"]") {
+						t.Errorf("Expected event of worker %d, got %v", id, e)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestResponse_Worker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	req, _ := NewRequest(t.TempDir(), server.URL+"/file")
+	resp := NewClient().Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Worker() != 0 {
+		t.Errorf("Expected no worker, got %d", resp.Worker())
+	}
+
+	req, _ = NewRequest(t.TempDir(), server.URL+"/file")
+	resp = NewClient().Do(req.WithContext(WithWorkerID(context.Background(), 7)))
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Worker() != 7 {
+		t.Errorf("Expected worker 7, got %d", resp.Worker())
+	}
+}